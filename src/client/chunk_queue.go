@@ -0,0 +1,135 @@
+package client
+
+import (
+    "sort"
+    "sync"
+)
+
+// The chunks of a file which are still waiting to be downloaded, in the order
+// in which they will be downloaded.
+// The order can change while the download is in progress, e.g. to fetch a
+// chunk which a consumer needs urgently.
+// If the queue has a window, chunks more than window past the consumer's
+// position are held back until the consumer gets closer.
+// A chunkQueue is safe for concurrent use.
+type chunkQueue struct {
+    mut sync.Mutex
+    chunks []int
+
+    // The number of chunks at the front of the queue which were prioritized,
+    // and which ranking leaves where they are.
+    urgent int
+
+    // How many chunks past position may be taken. 0 if the queue has no
+    // window.
+    window int
+
+    // The chunk which the consumer of the file last read from.
+    position int
+
+    // Signalled whenever chunks may have become ready to take.
+    ready *sync.Cond
+}
+
+// newChunkQueue creates a queue which will yield the given chunk numbers in
+// order, holding back chunks more than window past the consumer's position
+// (see Advance), unless window is 0.
+func newChunkQueue(chunks []int, window int) *chunkQueue {
+    q := & chunkQueue {chunks: chunks, window: window}
+    q.ready = sync.NewCond(& q.mut)
+    return q
+}
+
+// Next removes the chunk which should be downloaded next from the queue, and
+// returns its number: the first chunk which is prioritized, or inside the
+// window. If every chunk left is outside the window, it waits until one is
+// not.
+// If no chunks are left, ok is false.
+func (q *chunkQueue) Next() (chunkNum int, ok bool) {
+    q.mut.Lock()
+    defer q.mut.Unlock()
+
+    for len(q.chunks) > 0 {
+        for i, queued := range q.chunks {
+            if i < q.urgent || q.window == 0 || queued <= q.position + q.window {
+                // Shift the chunks ahead of this one back into its place.
+                copy(q.chunks[1:i + 1], q.chunks[:i])
+                q.chunks = q.chunks[1:]
+                if i < q.urgent {
+                    q.urgent--
+                }
+                return queued, true
+            }
+        }
+        q.ready.Wait()
+    }
+    return 0, false
+}
+
+// Advance records that the consumer of the file has read from the given
+// chunk, which moves the window to end window chunks past it.
+func (q *chunkQueue) Advance(chunkNum int) {
+    q.mut.Lock()
+    defer q.mut.Unlock()
+
+    q.position = chunkNum
+    q.ready.Broadcast()
+}
+
+// Prioritize moves the given chunk to the front of the queue, so that it is
+// downloaded next.
+// It returns false if the chunk is not waiting in the queue (e.g. it has
+// already been downloaded).
+func (q *chunkQueue) Prioritize(chunkNum int) bool {
+    q.mut.Lock()
+    defer q.mut.Unlock()
+
+    for i, queued := range q.chunks {
+        if queued == chunkNum {
+            // Shift the chunks ahead of this one back by one place.
+            copy(q.chunks[1:i + 1], q.chunks[:i])
+            q.chunks[0] = chunkNum
+            if i >= q.urgent {
+                q.urgent++
+            }
+            q.ready.Broadcast()
+            return true
+        }
+    }
+    return false
+}
+
+// Rank reorders the queue so that chunks with lower counts come first, where
+// counts holds a count for every chunk number (e.g. how many peers have each
+// chunk). Chunks with equal counts keep their order, as do prioritized chunks,
+// which stay at the front.
+func (q *chunkQueue) Rank(counts []int) {
+    q.mut.Lock()
+    defer q.mut.Unlock()
+
+    ranked := q.chunks[q.urgent:]
+    sort.SliceStable(ranked, func(i, j int) bool {
+        return counts[ranked[i]] < counts[ranked[j]]
+    })
+}
+
+// Add puts the given chunk at the back of the queue, e.g. because a copy of it
+// which was thought to be good turned out to be corrupt.
+func (q *chunkQueue) Add(chunkNum int) {
+    q.mut.Lock()
+    defer q.mut.Unlock()
+
+    q.chunks = append(q.chunks, chunkNum)
+    q.ready.Broadcast()
+}
+
+// Clear removes every chunk from the queue, e.g. because the download has
+// failed. Calls to Next which are waiting return at once.
+func (q *chunkQueue) Clear() {
+    q.mut.Lock()
+    defer q.mut.Unlock()
+
+    q.chunks = nil
+    q.urgent = 0
+    q.ready.Broadcast()
+}
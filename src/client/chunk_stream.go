@@ -0,0 +1,185 @@
+package client
+
+import (
+    "context"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+
+    "torrent"
+    "torrent/torrentproto"
+)
+
+// Chunks larger than a Client's stream threshold are streamed between peers
+// over plain HTTP on CHUNK_PATH, next to the RPC server, rather than sent in
+// one RPC reply. The serving Client copies the chunk from its file to the
+// connection, and the downloading Client copies it from the connection to its
+// file, hashing it on the way, so that neither holds the whole chunk in memory.
+// A request may ask for only the tail of a chunk, from the byte given by its
+// offset parameter on, so that a download whose connection drops part way
+// through a chunk fetches only the rest of it.
+
+// serveChunk streams a chunk of a local file to a peer, or the tail of the
+// chunk from the request's offset on.
+// It answers 404 if this Client does not have the chunk, or will not serve
+// it, and 416 if the offset is not inside the chunk. A chunk which this Client finds it has lost, because its file has been
+// truncated or (if this Client checks the chunks it serves) the chunk does not
+// match its hash, is refreshed in the background, as GetChunk does; if this
+// Client repairs the chunks it serves, it then fetches the chunk again, but
+// this peer is not sent the repaired chunk.
+func (c *client) serveChunk(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    hash, err := hex.DecodeString(query.Get("hash"))
+    if err != nil {
+        http.Error(w, "Bad torrent hash", http.StatusBadRequest)
+        return
+    }
+    chunkNum, err := strconv.Atoi(query.Get("chunk"))
+    if err != nil {
+        http.Error(w, "Bad chunk number", http.StatusBadRequest)
+        return
+    }
+    skip := 0
+    if offset := query.Get("offset"); offset != "" {
+        if skip, err = strconv.Atoi(offset); err != nil {
+            http.Error(w, "Bad offset", http.StatusBadRequest)
+            return
+        }
+    }
+    chunkID := torrentproto.NewChunkID(torrentproto.ID {
+        Name: query.Get("name"),
+        Hash: string(hash)}, chunkNum)
+
+    replyChan := make(chan *LookupResult)
+    select {
+    case c.streamQueries <- & StreamQuery {ChunkID: chunkID, Reply: replyChan}:
+    case <- c.closed:
+        http.Error(w, "Client is closed", http.StatusServiceUnavailable)
+        return
+    }
+    result := <-replyChan
+    if !result.Found {
+        // This Client does not have the chunk, or withholds it.
+        http.NotFound(w, r)
+        return
+    }
+
+    file, err := os.Open(result.Path)
+    if err != nil {
+        // The Client thought that it had the requested chunk, but cannot
+        // open the file containing the chunk.
+        http.NotFound(w, r)
+        return
+    }
+    defer file.Close()
+    start, length, err := torrent.ChunkBounds(result.Torrent, chunkNum)
+    if err != nil {
+        // The chunk number is not valid for the Torrent.
+        http.NotFound(w, r)
+        return
+    }
+    if fi, err := file.Stat(); err != nil || int64(start + length) > fi.Size() {
+        // The file has been truncated since this Client recorded the chunk.
+        go c.restoreChunks(result.Torrent, result.Path, []int{chunkNum})
+        http.NotFound(w, r)
+        return
+    }
+    if c.servePolicy != ServeTrust && !readerMatches(result.Torrent, file, chunkNum) {
+        // The chunk on disk is corrupt.
+        go c.restoreChunks(result.Torrent, result.Path, []int{chunkNum})
+        http.NotFound(w, r)
+        return
+    }
+
+    if skip < 0 || skip >= length {
+        http.Error(w, "Offset is not inside the chunk", http.StatusRequestedRangeNotSatisfiable)
+        return
+    }
+
+    chunk, length, err := torrent.ChunkTailReader(result.Torrent, file, chunkNum, skip)
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/octet-stream")
+    w.Header().Set("Content-Length", strconv.Itoa(length))
+    // If the copy fails part way, the peer sees the chunk end early.
+    io.Copy(w, chunk)
+}
+
+// streamChunkFromPeer streams the chunk of t with the given number from the
+// Client at hostPort into its place in file, and reports whether it matched
+// its hash. A chunk which did not match has been written to file all the same.
+// If received is not 0, that many bytes of the chunk are already in file, so
+// only the rest is asked for; the bytes in file are hashed along with the
+// rest, so that the whole chunk is checked.
+// It also returns how many bytes of the chunk are now in file, which is less
+// than the chunk's length if the stream was cut off, so that the caller can
+// ask this or another peer for the rest.
+// This counts as one of this Client's chunk transfers while it runs, and is
+// abandoned if this Client is closed.
+func (c *client) streamChunkFromPeer(hostPort string, t torrentproto.Torrent, file *os.File, chunkNum int, received int) (int, bool, error) {
+    c.acquireTransfer()
+    defer c.releaseTransfer()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go func() {
+        select {
+        case <- c.closed:
+            cancel()
+        case <- ctx.Done():
+        }
+    }()
+
+    query := url.Values {}
+    query.Set("name", t.ID.Name)
+    query.Set("hash", hex.EncodeToString([]byte(t.ID.Hash)))
+    query.Set("chunk", strconv.Itoa(chunkNum))
+    if received > 0 {
+        query.Set("offset", strconv.Itoa(received))
+    }
+
+    // Hash the part of the chunk which is already in the file.
+    h, err := torrent.NewHash(t)
+    if err != nil {
+        return received, false, err
+    }
+    if received > 0 {
+        head, _, err := torrent.ChunkReader(t, file, chunkNum)
+        if err != nil {
+            return received, false, err
+        } else if _, err := io.CopyN(h, head, int64(received)); err != nil {
+            // The part in the file cannot be read back, so start over.
+            return 0, false, err
+        }
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "GET", "http://" + hostPort + CHUNK_PATH + "?" + query.Encode(), nil)
+    if err != nil {
+        return received, false, err
+    }
+    resp, err := c.streams.Do(req)
+    if err != nil {
+        // Failed to connect, or the peer hung up.
+        return received, false, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        // Peer does not have the chunk, or will not send it.
+        return received, false, fmt.Errorf("Peer did not send chunk: %s", resp.Status)
+    }
+
+    written, err := torrent.WriteChunkTailFrom(t, file, chunkNum, received, io.TeeReader(resp.Body, h))
+    received += written
+    if err != nil {
+        // The stream was cut off, or the chunk was the wrong length, or could
+        // not be written.
+        return received, false, err
+    }
+    return received, string(h.Sum(nil)) == t.ChunkHashes[chunkNum], nil
+}
@@ -1,8 +1,11 @@
 package client
 
 import (
+    "io"
+
     "client/clientproto"
     "torrent/torrentproto"
+    "tracker/trackerproto"
 )
 
 // The ByteTorrent Client API.
@@ -16,7 +19,16 @@ type Client interface {
     // Returns Status:
     // - OK: If the reply contains the requested chunk.
     // - ChunkNotFound: If the Client does not contain the requested chunk for
-    //   the requested file.
+    //   the requested file, or (if the Client checks the chunks it serves)
+    //   its copy of the chunk is corrupt, and could not be repaired.
+    //   If the file has been truncated since the Client recorded the chunk,
+    //   the Client drops every chunk past the file's new end, and reports
+    //   them missing to the Tracker.
+    // - ChunkTooLarge: If the requested chunk is larger than the Client's
+    //   maximum chunk size.
+    // Clients fetch chunks larger than their stream threshold from peers over
+    // HTTP on CHUNK_PATH instead, a piece at a time, and those are not limited
+    // by the maximum chunk size (see ClientConfig.StreamThreshold).
     GetChunk(*clientproto.GetArgs, *clientproto.GetReply) error
 
     // OfferFile associates a local file with a Torrent within the Client.
@@ -24,21 +36,181 @@ type Client interface {
     // posesses the file.
     // After this function is called, other clients will be able to get chunks
     // of this file from this Client.
-    // Does not check if the torrent or file are valid.
+    // Does not check if the torrent or file are valid, unless the Client
+    // verifies offers (see ClientConfig.VerifyOffers). Then it throws an error
+    // naming the first chunk which does not match its hash, and does not
+    // offer the file.
     // Throws an error if the Client cannot inform trackerNodes that it
-    // possesses this file (e.g. it cannot reach trackerNodes, or trackerNodes
-    // do not know about this torrent).
+    // possesses this file (e.g. it cannot reach trackerNodes, trackerNodes
+    // do not know about this torrent, or they refuse the Client's updates
+    // because of its token or its update rate).
+    // If the Client has an offer timeout, and it passes before every chunk has
+    // been confirmed, throws an *OfferTimeoutError saying how many were.
+    // If the Client has a state file (see ClientConfig.StatePath), and stops
+    // before every chunk has been confirmed, it confirms the rest when it is
+    // next started with the same state file.
     OfferFile(torrentproto.Torrent, string) error
 
+    // CreateAndOffer publishes the file at the given path.
+    // It creates a Torrent for the file, named after the file, which splits it
+    // into chunks of the given number of bytes (or torrent.DEFAULT_CHUNK_SIZE,
+    // if this is 0) and lists the given trackerNodes.
+    // It then registers the Torrent with the Tracker and offers the file, as
+    // OfferFile does.
+    // If the Tracker already has this Torrent (i.e. the same file was
+    // registered under the same name), the file is still offered, so long as
+    // the registered Torrent has the same chunks (see torrent.Digest).
+    // Returns the Torrent, so that it can be shared with other Clients.
+    // Throws an error if the file cannot be read, the Tracker cannot be
+    // reached or rejects the Torrent, or the file cannot be offered.
+    CreateAndOffer(string, int, []torrentproto.TrackerNode) (torrentproto.Torrent, error)
+
     // DownloadFile downloads the file with the given Torrent, and stores it at
     // the given path.
     // Blocks until the file has completely downloaded.
+    // A chunk's hash in the torrent is checked against the Tracker. If the
+    // Tracker node which is asked disagrees, the other nodes are asked too, so
+    // that a single faulty node does not fail the download.
+    // If an earlier download of the same Torrent to the same path did not
+    // finish, the chunks it got are kept, and only the rest are downloaded.
+    // The kept chunks are checked against their hashes first, and any which
+    // have changed on disk are downloaded again.
     // Throws an error if:
-    // - the given torrent is not valid
+    // - the given torrent is not valid (i.e. a majority of the Tracker nodes
+    //   disagree with one of its chunk hashes)
+    // - the given torrent uses a hash algorithm which the Client does not know
     // - the given path is not valid
+    // - the Client verifies downloads, and the downloaded file does not match
+    //   the torrent (if the Client also repairs downloads, even after
+    //   fetching the chunks which do not match their hashes again)
     DownloadFile(torrentproto.Torrent, string) error
 
+    // DownloadFileProgress starts downloading the file with the given Torrent
+    // to the given path, as DownloadFile does, but returns at once.
+    // A Progress is sent on the first channel as each chunk arrives, and it is
+    // closed once the download is over. The Client never waits for the
+    // reader: if PROGRESS_BUFFER events are already waiting to be read,
+    // further events are dropped until there is room.
+    // The second channel then yields the error DownloadFile would return, or
+    // nil if the download succeeded.
+    DownloadFileProgress(torrentproto.Torrent, string) (<-chan clientproto.Progress, <-chan error)
+
+    // PlanDownload reports what downloading the file with the given Torrent
+    // would involve, without transferring any data or changing the Client, so
+    // that a caller can decide whether to go ahead.
+    // The plan lists how many peers the Tracker knows for each chunk, and
+    // which chunks this Client already has (e.g. from an earlier download
+    // which did not finish). The download is feasible if every other chunk
+    // has a peer.
+    // Throws an error if the Tracker cannot be reached, or does not know the
+    // torrent.
+    PlanDownload(torrentproto.Torrent) (clientproto.DownloadPlan, error)
+
+    // PrioritizeChunk asks a download in progress for the Torrent with the
+    // given ID to fetch the chunk with the given number next, before any other
+    // chunks which are still waiting.
+    // This lets a consumer which reads the file while it downloads get the
+    // chunk it needs soonest.
+    // Throws an error if:
+    // - no download is in progress for the Torrent
+    // - the chunk is not waiting to be downloaded (e.g. it has already arrived)
+    PrioritizeChunk(torrentproto.ID, int) error
+
+    // Progress reports how many chunks of the local file for the Torrent with
+    // the given ID this Client has, and how many chunks the file has in all,
+    // e.g. for showing how far along a download is.
+    // Throws an error if the Client has no local file for the Torrent.
+    Progress(torrentproto.ID) (downloaded int, total int, err error)
+
+    // RefreshChunk re-reads the chunk with the given number of the local file
+    // for the Torrent with the given ID, and checks it against its hash.
+    // If it is valid, the Client records that it has the chunk, and confirms
+    // it to the Tracker again. Otherwise (e.g. it was corrupted or the file
+    // was truncated), the Client stops serving the chunk, and reports to the
+    // Tracker that it is missing.
+    // A valid chunk which the Client withholds (see SetServableChunks) is
+    // still reported missing.
+    // Throws an error if:
+    // - the Client has no local file for the Torrent
+    // - the chunk number is out of range for the Torrent
+    // - the Tracker cannot be reached, or does not accept the update
+    RefreshChunk(torrentproto.ID, int) error
+
+    // SetServableChunks limits which chunks of the local file for the Torrent
+    // with the given ID this Client serves to other Clients, e.g. to save
+    // bandwidth by seeding only part of a file.
+    // GetChunk replies ChunkNotFound for any other chunk, even if this Client
+    // has it, and the Client reports those chunks to the Tracker as missing,
+    // so that peers are not sent to get them. The chunks which are served and
+    // which this Client has are confirmed to the Tracker.
+    // If chunks is nil, the Client serves every chunk it has again.
+    // The limit lasts until the file is offered or downloaded again.
+    // Throws an error if:
+    // - the Client has no local file for the Torrent
+    // - a chunk number is out of range for the Torrent
+    // - the Tracker cannot be reached, or does not accept an update
+    SetServableChunks(torrentproto.ID, []int) error
+
+    // SetConcurrency sets how many chunks of each file the Client downloads
+    // at once (see ClientConfig.DownloadWorkers).
+    // Downloads which are already in progress keep the concurrency they
+    // started with; the new one applies to downloads started afterwards.
+    // Throws an error if n is not positive.
+    SetConcurrency(n int) error
+
+    // TorrentReaderAt returns a reader over the data of the local file for the
+    // Torrent with the given ID, e.g. for serving byte ranges of a file while
+    // it downloads.
+    // Reads only succeed for bytes in chunks which this Client has. A read
+    // which reaches a chunk this Client does not have yet returns the bytes
+    // before that chunk, and an error. A read which reaches the end of the
+    // data returns the bytes before the end, and io.EOF.
+    // A read which starts in a chunk this Client does not have reads nothing.
+    // Reading while the file downloads paces the download, if the Client has
+    // a prefetch window (see ClientConfig.PrefetchWindow), and has a chunk
+    // the read needs fetched next.
+    // Throws an error if the Client has no local file for the Torrent, or if
+    // the Torrent's chunk size is not positive.
+    TorrentReaderAt(torrentproto.ID) (io.ReaderAt, error)
+
+    // TrackedTorrents reports which Tracker nodes this Client uses.
+    // It maps the host:port of each Tracker node listed by a Torrent with a
+    // local file on this Client to the IDs of those Torrents.
+    // Torrents may be registered with different Tracker clusters, so this lets
+    // work which involves every Tracker find the right nodes for each Torrent.
+    TrackedTorrents() map[string][]torrentproto.ID
+
+    // ListTorrents asks the Tracker with the given trackerNodes which
+    // torrents are registered with it, so that they can be browsed.
+    // Returns the ID, file size and chunk size of each, sorted by ID.
+    // Throws an error if no Tracker node responds.
+    ListTorrents([]torrentproto.TrackerNode) ([]trackerproto.TorrentSummary, error)
+
+    // RemoveFile stops this Client serving or tracking the local file for the
+    // Torrent with the given ID, and tells its LocalFileListener with a
+    // LocalFileRemove change. The file itself is left on disk.
+    // The Client then reports each chunk it had to the Tracker as missing, so
+    // that peers are no longer sent to it for them.
+    // Throws an error if:
+    // - the Client has no local file for the Torrent
+    // - a download for the Torrent is in progress
+    // - the Tracker cannot be reached, or does not accept an update (the file
+    //   is removed from the Client either way)
+    RemoveFile(torrentproto.ID) error
+
+    // StatusJSON returns a JSON document describing this Client's local files,
+    // for tools which watch the Client without importing this package.
+    // The document is a clientproto.ClientStatus, listing each file's torrent
+    // ID, path, how many of its chunks this Client has, whether it is
+    // complete or still downloading, and why the Tracker was not told of its
+    // chunks again in the last round (see ClientConfig.AnnounceInterval), if
+    // it was not.
+    // Throws an error if the status cannot be encoded.
+    StatusJSON() ([]byte, error)
+
     // Close shuts down this Client in an orderly manner.
+    // It stops confirming chunks to the Tracker again in the background (see
+    // ClientConfig.AnnounceInterval).
     // It writes the Client's state out to a file.
     // Close throws an error if it is not able to write the Client's state to a
     // file.
@@ -0,0 +1,188 @@
+package client
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "client/clientproto"
+    "torrent/torrentproto"
+)
+
+// ClientConfig holds the settings for a Client.
+// The zero value of each field is a sensible default, so a config only needs
+// HostPort and the fields which differ from their defaults.
+type ClientConfig struct {
+    // The files the Client starts out with, by torrent ID. If it is nil, the
+    // Client starts with none.
+    LocalFiles map[torrentproto.ID]*clientproto.LocalFile
+
+    // Told whenever the Client's local files change. If it is nil, changes
+    // are not reported.
+    Listener LocalFileListener
+
+    // The host:port the Client serves chunks on. Required.
+    HostPort string
+
+    // If not "", the path of a file which the Client keeps a copy of its
+    // local files in, rewriting it whenever they change, so that a Client
+    // restarted with the same StatePath carries on serving the chunks it
+    // held. Files saved there are loaded alongside LocalFiles (which take
+    // precedence for the same torrent), except those whose file is no
+    // longer at its Path.
+    // The file also records which chunks the Tracker has confirmed of each
+    // offer in progress, so that a Client restarted part way through an
+    // offer confirms the rest of the chunks, rather than every chunk again.
+    StatePath string
+
+    // The token the Client gives the Tracker with every update, for Trackers
+    // which require one (see tracker.TrackerConfig.ClientToken). If it is "",
+    // updates carry no token.
+    TrackerToken string
+
+    // Whether each downloaded file is hashed in its entirety and checked
+    // against its Torrent's ID once all of its chunks have arrived, and
+    // whether a file which does not match is repaired.
+    // This catches errors that per-chunk hashes cannot (e.g. chunks written to
+    // the wrong place, or changed on disk since), but is expensive for large
+    // files.
+    Verify VerifyMode
+
+    // Whether OfferFile checks every chunk of a file against its hash before
+    // offering it, and refuses to offer a file which does not match, so that
+    // the Client never advertises chunks it would serve corrupt. Checking
+    // reads the whole file, so this is expensive for large files.
+    VerifyOffers bool
+
+    // How many chunks the Client may be fetching from peers at once, across
+    // all of its downloads. If it is 0, there is no limit.
+    MaxTransfers int
+
+    // Decides which Tracker nodes the Client contacts first. If it is nil,
+    // nodes with the highest Weight are tried first, and nodes of equal Weight
+    // in the order they are listed in each Torrent.
+    Selector TrackerSelector
+
+    // The largest chunk, in bytes, that the Client will read into memory to
+    // serve to another Client. This protects the Client from torrents crafted
+    // with huge chunks. If it is 0, DEFAULT_MAX_CHUNK_SIZE is used.
+    MaxChunkSize int
+
+    // The largest chunk, in bytes, that the Client fetches from a peer in one
+    // RPC reply. Larger chunks are streamed from the peer over HTTP and
+    // written to the file as they arrive, so that neither Client holds the
+    // whole chunk in memory; streamed chunks are not limited by MaxChunkSize.
+    // If it is 0, DEFAULT_STREAM_THRESHOLD is used.
+    StreamThreshold int
+
+    // The order in which the Client downloads the chunks of each file.
+    ChunkOrder ChunkOrder
+
+    // If it is not 0, downloads are paced for a consumer which reads the file
+    // through a TorrentReaderAt as it downloads: only chunks up to this many
+    // past the chunk the consumer last read from (chunk 0, until it reads)
+    // are fetched, in ChunkOrder. A chunk the consumer reaches before it has
+    // arrived is fetched next, as if it were prioritized. A download which
+    // nothing reads stops once it has the first chunks, so this is only for
+    // files which are streamed. If it is 0, every chunk is fetched as soon as
+    // a worker is free.
+    PrefetchWindow int
+
+    // How many chunks of each file the Client downloads at once. The Client
+    // starts this many goroutines per download, however many chunks the file
+    // has. If it is 0, DEFAULT_DOWNLOAD_WORKERS is used.
+    DownloadWorkers int
+
+    // How many more times the Client asks the Tracker for a chunk's peers and
+    // tries them, once none of the peers it has tried has sent the chunk,
+    // before failing the download. Peers come and go, so a chunk which no peer
+    // sends now is often sent shortly after. If it is 0, the Client does not
+    // retry.
+    ChunkRetries int
+
+    // How long the Client waits before its first retry of a chunk. The wait
+    // doubles before each retry after that. If it is 0,
+    // DEFAULT_RETRY_DELAY milliseconds is used.
+    RetryDelay time.Duration
+
+    // Bounds how long OfferFile spends confirming a file's chunks to the
+    // Tracker, so that a slow Tracker cannot hold up an offer indefinitely. If
+    // it is 0, there is no limit.
+    OfferTimeout time.Duration
+
+    // How often the Client confirms every chunk it serves to the Tracker
+    // again, so that it stays listed as a peer by Trackers which forget
+    // peers that have not confirmed a chunk for a while (see
+    // tracker.TrackerConfig.PeerTTL). This should be well under the
+    // Tracker's TTL. If it is 0, chunks are only confirmed as they are
+    // offered or downloaded.
+    AnnounceInterval time.Duration
+
+    // If not nil, told as downloads find, fetch chunks from, and give up on
+    // peers.
+    PeerListener PeerEventListener
+
+    // Whether the Client checks chunks against their hashes before serving
+    // them, and what it does about chunks which do not match.
+    ServePolicy ServePolicy
+}
+
+// Ignores every change, for Clients which are not given a LocalFileListener.
+type nopListener struct{}
+
+func (l *nopListener) OnChange(change *clientproto.LocalFileChange) {}
+
+// WithDefaults returns cfg with the defaults filled in for its zero fields.
+// Returns an error describing the first invalid field, if there is one.
+func (cfg ClientConfig) WithDefaults() (ClientConfig, error) {
+    if cfg.HostPort == "" {
+        return cfg, errors.New("A Client needs a host:port to serve on")
+    } else if cfg.Verify < VerifyNone || cfg.Verify > VerifyAndRepair {
+        return cfg, fmt.Errorf("Unknown verify mode %d", cfg.Verify)
+    } else if cfg.ServePolicy < ServeTrust || cfg.ServePolicy > ServeVerifyAndRepair {
+        return cfg, fmt.Errorf("Unknown serve policy %d", cfg.ServePolicy)
+    } else if cfg.ChunkOrder < OrderRandom || cfg.ChunkOrder > OrderRarestFirst {
+        return cfg, fmt.Errorf("Unknown chunk order %d", cfg.ChunkOrder)
+    } else if cfg.MaxTransfers < 0 {
+        return cfg, fmt.Errorf("MaxTransfers must not be negative, not %d", cfg.MaxTransfers)
+    } else if cfg.MaxChunkSize < 0 {
+        return cfg, fmt.Errorf("MaxChunkSize must not be negative, not %d", cfg.MaxChunkSize)
+    } else if cfg.StreamThreshold < 0 {
+        return cfg, fmt.Errorf("StreamThreshold must not be negative, not %d", cfg.StreamThreshold)
+    } else if cfg.DownloadWorkers < 0 {
+        return cfg, fmt.Errorf("DownloadWorkers must not be negative, not %d", cfg.DownloadWorkers)
+    } else if cfg.PrefetchWindow < 0 {
+        return cfg, fmt.Errorf("PrefetchWindow must not be negative, not %d", cfg.PrefetchWindow)
+    } else if cfg.ChunkRetries < 0 {
+        return cfg, fmt.Errorf("ChunkRetries must not be negative, not %d", cfg.ChunkRetries)
+    } else if cfg.RetryDelay < 0 {
+        return cfg, fmt.Errorf("RetryDelay must not be negative, not %v", cfg.RetryDelay)
+    } else if cfg.OfferTimeout < 0 {
+        return cfg, fmt.Errorf("OfferTimeout must not be negative, not %v", cfg.OfferTimeout)
+    } else if cfg.AnnounceInterval < 0 {
+        return cfg, fmt.Errorf("AnnounceInterval must not be negative, not %v", cfg.AnnounceInterval)
+    }
+
+    if cfg.LocalFiles == nil {
+        cfg.LocalFiles = make(map[torrentproto.ID]*clientproto.LocalFile)
+    }
+    if cfg.Listener == nil {
+        cfg.Listener = & nopListener {}
+    }
+    if cfg.Selector == nil {
+        cfg.Selector = NewListSelector()
+    }
+    if cfg.MaxChunkSize == 0 {
+        cfg.MaxChunkSize = DEFAULT_MAX_CHUNK_SIZE
+    }
+    if cfg.StreamThreshold == 0 {
+        cfg.StreamThreshold = DEFAULT_STREAM_THRESHOLD
+    }
+    if cfg.DownloadWorkers == 0 {
+        cfg.DownloadWorkers = DEFAULT_DOWNLOAD_WORKERS
+    }
+    if cfg.RetryDelay == 0 {
+        cfg.RetryDelay = time.Millisecond * time.Duration(DEFAULT_RETRY_DELAY)
+    }
+    return cfg, nil
+}
@@ -1,13 +1,18 @@
 package client
 
 import (
-    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
     "errors"
+    "fmt"
+    "io"
     "math/rand"
     "net"
     "net/http"
     "net/rpc"
     "os"
+    "path/filepath"
+    "sort"
     "time"
 
     "client/clientproto"
@@ -16,6 +21,145 @@ import (
     "torrent/torrentproto"
 )
 
+const (
+    // The largest chunk a Client serves if it is not given a limit, in bytes.
+    DEFAULT_MAX_CHUNK_SIZE int = 16 * 1000000
+
+    // The largest chunk a Client fetches from peers over RPC if it is not given
+    // a threshold, in bytes. Larger chunks are streamed instead (see
+    // CHUNK_PATH).
+    DEFAULT_STREAM_THRESHOLD int = 1000000
+
+    // The HTTP path on which a Client streams chunks to peers. The chunk is
+    // named by the query parameters "name", "hash" (in hex) and "chunk".
+    CHUNK_PATH string = "/_bytetorrent_chunk"
+
+    // How long to wait for a Tracker node to accept a connection when probing
+    // it, in milliseconds.
+    PROBE_TIMEOUT int = 2000
+
+    // How long to wait for a Tracker node to answer a ping once it has
+    // accepted a connection, in milliseconds. A node which takes longer is
+    // treated as dead, e.g. because it is stuck.
+    PING_TIMEOUT int = 1000
+
+    // The number of chunks of one file a Client downloads at once if it is not
+    // given a number.
+    DEFAULT_DOWNLOAD_WORKERS int = 4
+
+    // The number of progress events a download buffers for a consumer which
+    // has fallen behind. Further events are dropped until there is room.
+    PROGRESS_BUFFER int = 64
+
+    // The number of peer events a Client buffers for its PeerEventListener.
+    // Further events are dropped until there is room.
+    PEER_EVENT_BUFFER int = 256
+
+    // The number of connections to peers a Client keeps open once it has no
+    // transfers from them, so that it need not dial them again for the next
+    // chunk.
+    PEER_POOL_SIZE int = 16
+
+    // The number of times a Client which repairs downloads checks the chunks
+    // of a file which does not match its torrent, and fetches the bad ones
+    // again, before giving up.
+    REPAIR_ATTEMPTS int = 3
+
+    // The most chunks an offer confirms to the Tracker in one call. Larger
+    // files are confirmed in several calls, so that an offer timeout can
+    // stop between them.
+    OFFER_BATCH int = 100
+
+    // How long a Client waits before sending a batch of chunks which it
+    // confirms again to the Tracker once more, after the Tracker says that
+    // the Client is sending updates too fast, in milliseconds. Trackers
+    // count each client's updates over a second.
+    ANNOUNCE_RATE_WAIT int = 1000
+
+    // The number of times a Client sends a batch of chunks which it confirms
+    // again to the Tracker once more, if the Tracker says that the Client is
+    // sending updates too fast, before it gives up until the next round.
+    ANNOUNCE_RATE_RETRIES int = 3
+
+    // The most peers a Client asks the Tracker for when it fetches a chunk.
+    // The Tracker picks them at random, so popular chunks still spread
+    // their load across every peer, but each reply stays small.
+    REQUEST_PEERS int = 50
+
+    // How often a download which fetches the rarest chunks first asks the
+    // Tracker again how many peers each chunk has, in milliseconds.
+    AVAILABILITY_REFRESH int = 5000
+
+    // How long a Client which retries chunks waits before its first retry of a
+    // chunk, in milliseconds, if it is not given a delay. The wait doubles
+    // before each retry after that.
+    DEFAULT_RETRY_DELAY int = 500
+)
+
+// How a Client checks each file it downloads once all of its chunks have
+// arrived.
+type VerifyMode int
+
+const (
+    // Trust the per-chunk hashes, and do not check the whole file.
+    VerifyNone VerifyMode = iota
+
+    // Hash the whole file, and fail the download if it does not match the
+    // torrent's ID.
+    VerifyFile
+
+    // Hash the whole file, and if it does not match the torrent's ID, check
+    // each chunk and fetch any which do not match their hash again, up to
+    // REPAIR_ATTEMPTS times, before failing the download.
+    VerifyAndRepair
+)
+
+// The order in which a Client downloads the chunks of a file.
+type ChunkOrder int
+
+const (
+    // Download the chunks in a random order, to spread load across peers.
+    OrderRandom ChunkOrder = iota
+
+    // Download the chunks which the fewest peers have first, and chunks which
+    // as many peers have in a random order. This keeps rare chunks from
+    // disappearing from the swarm. The Tracker is asked how many peers each
+    // chunk has as the download starts, and every AVAILABILITY_REFRESH
+    // milliseconds while it runs.
+    OrderRarestFirst
+)
+
+// How a Client checks the chunks which it serves to other Clients.
+type ServePolicy int
+
+const (
+    // Serve chunks as they are on disk. Downloaders check every chunk they
+    // get, so a corrupt chunk costs them a retry with another peer.
+    ServeTrust ServePolicy = iota
+
+    // Check each chunk against its hash before serving it. A chunk which does
+    // not match is not served, and is reported to the Tracker as missing.
+    ServeVerify
+
+    // Check each chunk as ServeVerify does, but when one does not match, try
+    // to download a good copy from other peers, and serve that instead.
+    // Chunks lost because their file was truncated are downloaded again too.
+    ServeVerifyAndRepair
+)
+
+// An OfferTimeoutError is returned by OfferFile when the Client's offer timeout
+// passes before every chunk of the file has been confirmed to the Tracker.
+// The chunks which were confirmed stay registered with the Tracker, and
+// offering the file again confirms the rest.
+type OfferTimeoutError struct {
+    Confirmed int // The number of chunks confirmed before the timeout
+    Total int // The number of chunks in the file
+}
+
+func (e *OfferTimeoutError) Error() string {
+    return fmt.Sprintf("Offer timed out after confirming %d of %d chunks", e.Confirmed, e.Total)
+}
+
 // The client's representation of a request to get a chunk.
 type Get struct {
     Args *clientproto.GetArgs
@@ -36,6 +180,11 @@ type Offer struct {
     // The local path to the file being offered.
     Path string
 
+    // Whether this carries on an offer of a local file which was in progress
+    // when the Client's state was last saved, rather than offering a file
+    // afresh. Path is not used.
+    Resume bool
+
     // The client passes back any error involved with offering on this channel.
     Reply chan error
 }
@@ -50,6 +199,164 @@ type Download struct {
     
     // The client passes back any error involved with downloading on this channel.
     Reply chan error
+
+    // If not nil, the client sends progress events on this channel as chunks
+    // arrive, and closes it once the download is over.
+    Progress chan clientproto.Progress
+
+    // The chunks of the file which are still waiting to be downloaded.
+    queue *chunkQueue
+
+    // The number of chunks of the file to download at once.
+    workers int
+
+    // The order in which to download the chunks of the file.
+    order ChunkOrder
+
+    // The chunks which an earlier download of the file to the same path left
+    // behind, and which are not downloaded again unless they have changed.
+    // nil if the download starts afresh.
+    resumed []int
+}
+
+// The client's representation of a request to download a chunk of a file
+// before the file's other chunks.
+type Prioritize struct {
+    // The ID of the Torrent for the file being downloaded.
+    ID torrentproto.ID
+
+    // The number of the chunk to download next.
+    ChunkNum int
+
+    // The client passes back any error involved with prioritizing on this
+    // channel.
+    Reply chan error
+}
+
+// The client's representation of the result of re-checking a chunk of a
+// local file.
+type Refresh struct {
+    // The chunk which was checked.
+    torrentproto.ChunkID
+
+    // Whether the chunk on disk matches its hash.
+    Valid bool
+
+    // The client passes back what it recorded on this channel.
+    Reply chan *RefreshResult
+}
+
+// What the client recorded after re-checking a chunk of a local file.
+type RefreshResult struct {
+    // Whether the client has every chunk of the file, and serves all of them.
+    Complete bool
+
+    // Whether the client is willing to serve the chunk (see
+    // SetServableChunks).
+    Servable bool
+}
+
+// The client's representation of a request to limit which chunks of a local
+// file it serves.
+type Restrict struct {
+    // The ID of the Torrent for the file.
+    ID torrentproto.ID
+
+    // The chunks to serve, or nil to serve every chunk.
+    Servable map[int]struct{}
+
+    // The client passes back any error involved with restricting on this
+    // channel.
+    Reply chan error
+}
+
+// The client's representation of a request to stop serving a local file.
+type Remove struct {
+    // The ID of the Torrent for the file.
+    ID torrentproto.ID
+
+    // The client passes back the outcome of the removal on this channel.
+    Reply chan *RemoveResult
+}
+
+// The outcome of removing a local file.
+type RemoveResult struct {
+    // The removed file, and the chunk numbers it had, if it was removed.
+    LocalFile *clientproto.LocalFile
+    Chunks []int
+
+    // Why the file could not be removed, if it was not.
+    Err error
+}
+
+// The client's representation of a request to change how many chunks of each
+// file it downloads at once.
+type Concurrency struct {
+    // The number of chunks to download at once.
+    Workers int
+
+    // The client replies on this channel once the change has been made.
+    Reply chan error
+}
+
+// The client's representation of a request to look up a local file.
+type Lookup struct {
+    // The ID of the Torrent for the file.
+    ID torrentproto.ID
+
+    // The range of chunks, [First, Last], to check this Client has.
+    // If Last < First, no chunks are checked.
+    First int
+    Last int
+
+    // Whether the lookup is for a read of the chunks through a
+    // TorrentReaderAt. A read moves the window of a download of the file to
+    // First, and has a chunk it is missing fetched next.
+    Reading bool
+
+    // The client passes back what it found on this channel.
+    Reply chan *LookupResult
+}
+
+// The client's representation of a request for a chunk to stream to a peer.
+type StreamQuery struct {
+    // The chunk wanted.
+    torrentproto.ChunkID
+
+    // The client passes back what it found on this channel. Found is only set
+    // if the client has the chunk and serves it.
+    Reply chan *LookupResult
+}
+
+// The client's representation of a request for the Tracker nodes it uses.
+type TrackedQuery struct {
+    // The client passes back a map from Tracker node host:port to the IDs of
+    // the Torrents which list that node on this channel.
+    Reply chan map[string][]torrentproto.ID
+}
+
+// The client's representation of a request for the state of its local files.
+type StatusQuery struct {
+    // The client passes back a snapshot of its local files on this channel.
+    Reply chan *clientproto.ClientStatus
+}
+
+// The result of looking up a local file.
+type LookupResult struct {
+    // Whether the Client knows about a local file for the Torrent.
+    // If not, the other fields are not set.
+    Found bool
+
+    // The Torrent and local path for the file.
+    Torrent torrentproto.Torrent
+    Path string
+
+    // The first chunk in the requested range which this Client does not
+    // have, or -1 if it has all of them.
+    Missing int
+
+    // The number of chunks of the file which this Client has.
+    Chunks int
 }
 
 // A ByteTorrent Client implementation.
@@ -57,6 +364,15 @@ type client struct {
     // A map from Torrent IDs to associated local file states
     localFiles map[torrentproto.ID]*clientproto.LocalFile
 
+    // The chunks the Tracker has confirmed of each offer in progress, by
+    // Torrent ID. These are kept in the state file, so that a Client which
+    // restarts part way through an offer only confirms the rest.
+    offerProgress map[torrentproto.ID]map[int]struct{}
+
+    // Writes localFiles and offerProgress to the state file. nil if the
+    // Client has no state file.
+    state *persistingListener
+
     // Requests to get chunks from this client.
     gets chan *Get
 
@@ -69,6 +385,51 @@ type client struct {
     // Push to this channel to request that the client offer a file.
     offers chan *Offer
 
+    // Push to this channel to request that a download fetch a chunk next.
+    prioritizes chan *Prioritize
+
+    // Push to this channel to look up a local file.
+    lookups chan *Lookup
+
+    // Push to this channel to look up a chunk to stream to a peer.
+    streamQueries chan *StreamQuery
+
+    // Push to this channel to ask which Tracker nodes this client uses.
+    trackedQueries chan *TrackedQuery
+
+    // Push to this channel to ask for the state of this client's local files.
+    statusQueries chan *StatusQuery
+
+    // Push to this channel to record whether a chunk of a local file is valid.
+    refreshes chan *Refresh
+
+    // Push to this channel to limit which chunks of a local file are served.
+    restricts chan *Restrict
+
+    // Push to this channel to stop serving a local file.
+    removes chan *Remove
+
+    // Go routines pass requests to change the download concurrency to the
+    // eventHandler via this channel.
+    concurrencies chan *Concurrency
+
+    // The chunks this client serves, for local files which it does not serve
+    // in full, by Torrent ID.
+    servable map[torrentproto.ID]map[int]struct{}
+
+    // Downloads which are in progress, by Torrent ID.
+    downloading map[torrentproto.ID]*Download
+
+    // The Tracker nodes for each Torrent with a local file.
+    trackers *trackerIndex
+
+    // Connections to the peers this Client fetches chunks from.
+    peers *peerPool
+
+    // Go routines pass downloads which have finished to the eventHandler via
+    // this channel.
+    finishedDownloads chan *Download
+
     // Go routines pass the IDs of successfully downloaded chunks to the
     // eventHandler via this channel.
     downloadedChunks chan torrentproto.ChunkID
@@ -78,34 +439,220 @@ type client struct {
 
     // A listener which the Client will update when it changes local file.
     lfl LocalFileListener
+
+    // Events for this Client's PeerEventListener, which are passed on by a
+    // goroutine of their own until the Client closes. nil if there is no
+    // listener.
+    peerEvents chan *clientproto.PeerEvent
+
+    // Whether to check the hash of each whole file once it has downloaded,
+    // and to repair files which do not match.
+    verifyDownloads VerifyMode
+
+    // Whether to check every chunk of a file against its hash before offering
+    // it.
+    verifyOffers bool
+
+    // Holds a token for each chunk transfer in progress, across all downloads.
+    // Its capacity limits the number of simultaneous transfers.
+    // nil if there is no limit.
+    transfers chan struct{}
+
+    // Decides which Tracker nodes this Client contacts first.
+    selector TrackerSelector
+
+    // The largest chunk, in bytes, which this Client will serve to others.
+    maxChunkSize int
+
+    // The largest chunk, in bytes, which this Client fetches over RPC rather
+    // than streaming it, and the HTTP client it streams chunks with.
+    streamThreshold int
+    streams *http.Client
+
+    // The number of chunks of one file which this Client downloads at once.
+    // Only read and changed by the eventHandler, which copies it into each
+    // Download as it starts.
+    downloadWorkers int
+
+    // The order in which this Client downloads the chunks of each file.
+    chunkOrder ChunkOrder
+
+    // How many chunks past a consumer's position each download may fetch, or
+    // 0 if downloads are not paced by their consumers.
+    prefetchWindow int
+
+    // The longest an offer may spend confirming chunks, or 0 for no limit.
+    offerTimeout time.Duration
+
+    // The token this Client gives the Tracker with every update.
+    trackerToken string
+
+    // How many more times to ask for a chunk's peers and try them, once none
+    // of them has sent it, and how long to wait before the first retry.
+    chunkRetries int
+    retryDelay time.Duration
+
+    // How this Client checks the chunks it serves.
+    servePolicy ServePolicy
+
+    // How often to confirm every chunk this Client serves to the Tracker
+    // again, or 0 to never do so.
+    announceInterval time.Duration
+
+    // Whether chunks are being confirmed again now. Only read and changed by
+    // the eventHandler.
+    announcing bool
+
+    // Sent why confirming each file's chunks failed when a round of
+    // confirming chunks again is over. Only one round runs at a time, so
+    // sending never blocks.
+    announced chan map[torrentproto.ID]string
+
+    // Why confirming each file's chunks failed in the last round, if it did.
+    // Only read and changed by the eventHandler.
+    announceErrors map[torrentproto.ID]string
+
+    // Closed once this Client has been closed, to stop work in the
+    // background.
+    closed chan struct{}
+}
+
+// The chunks of a local file which this Client confirms to the Tracker again.
+type announcement struct {
+    t torrentproto.Torrent
+    chunks []int
+
+    // Whether this Client has every chunk of the file, and serves all of them.
+    complete bool
 }
 
-// New creates and starts a new ByteTorrent Client.
+// New creates and starts a new ByteTorrent Client serving localFiles at
+// hostPort, with defaults for everything else (see ClientConfig).
 func NewClient(localFiles map[torrentproto.ID]*clientproto.LocalFile, lfl LocalFileListener, hostPort string) (Client, error) {
+    return NewClientWithConfig(ClientConfig {
+        LocalFiles: localFiles,
+        Listener: lfl,
+        HostPort: hostPort})
+}
+
+// NewClientWithConfig creates and starts a new ByteTorrent Client configured
+// by cfg.
+// Returns an error if cfg is not valid (see ClientConfig.WithDefaults).
+func NewClientWithConfig(cfg ClientConfig) (Client, error) {
+    cfg, err := cfg.WithDefaults()
+    if err != nil {
+        return nil, err
+    }
+    var transfers chan struct{}
+    if cfg.MaxTransfers > 0 {
+        transfers = make(chan struct{}, cfg.MaxTransfers)
+    }
+    var peerEvents chan *clientproto.PeerEvent
+    if cfg.PeerListener != nil {
+        peerEvents = make(chan *clientproto.PeerEvent, PEER_EVENT_BUFFER)
+    }
+    localFiles, hostPort := cfg.LocalFiles, cfg.HostPort
+    lfl := cfg.Listener
+    offerProgress := make(map[torrentproto.ID]map[int]struct{})
+    var state *persistingListener
+    var resumes []torrentproto.Torrent
+    if cfg.StatePath != "" {
+        saved, offers, err := loadState(cfg.StatePath)
+        if err != nil {
+            return nil, err
+        }
+        for torrentID, localFile := range saved {
+            if _, ok := localFiles[torrentID]; !ok {
+                localFiles[torrentID] = localFile
+            }
+        }
+        for torrentID, confirmed := range offers {
+            offerProgress[torrentID] = confirmed
+            resumes = append(resumes, localFiles[torrentID].Torrent)
+        }
+        // Save at once, so that files which were dropped stay dropped.
+        if err := saveState(cfg.StatePath, localFiles, offerProgress); err != nil {
+            return nil, err
+        }
+        state = & persistingListener {
+            next: lfl,
+            path: cfg.StatePath,
+            localFiles: localFiles,
+            offers: offerProgress}
+        lfl = state
+    }
+
     c := & client {
         localFiles: localFiles,
+        offerProgress: offerProgress,
+        state: state,
+        transfers: transfers,
+        selector: cfg.Selector,
+        maxChunkSize: cfg.MaxChunkSize,
+        streamThreshold: cfg.StreamThreshold,
+        streams: & http.Client {Transport: & http.Transport {}},
+        downloadWorkers: cfg.DownloadWorkers,
+        chunkOrder: cfg.ChunkOrder,
+        prefetchWindow: cfg.PrefetchWindow,
+        offerTimeout: cfg.OfferTimeout,
+        trackerToken: cfg.TrackerToken,
+        chunkRetries: cfg.ChunkRetries,
+        retryDelay: cfg.RetryDelay,
+        servePolicy: cfg.ServePolicy,
+        announceInterval: cfg.AnnounceInterval,
+        announced: make(chan map[torrentproto.ID]string, 1),
+        closed: make(chan struct{}),
         lfl: lfl,
+        peerEvents: peerEvents,
+        verifyDownloads: cfg.Verify,
+        verifyOffers: cfg.VerifyOffers,
         gets: make(chan *Get),
         closes: make(chan *Close),
         offers: make(chan *Offer),
         downloads: make(chan *Download),
+        prioritizes: make(chan *Prioritize),
+        lookups: make(chan *Lookup),
+        streamQueries: make(chan *StreamQuery),
+        trackedQueries: make(chan *TrackedQuery),
+        statusQueries: make(chan *StatusQuery),
+        refreshes: make(chan *Refresh),
+        restricts: make(chan *Restrict),
+        removes: make(chan *Remove),
+        concurrencies: make(chan *Concurrency),
+        servable: make(map[torrentproto.ID]map[int]struct{}),
+        downloading: make(map[torrentproto.ID]*Download),
+        trackers: newTrackerIndex(),
+        peers: newPeerPool(PEER_POOL_SIZE),
+        finishedDownloads: make(chan *Download),
         downloadedChunks: make(chan torrentproto.ChunkID),
         hostPort: hostPort}
+    for _, localFile := range localFiles {
+        c.trackers.add(localFile.Torrent)
+    }
 
     // Configure this Client to receive RPCs on RemoteClient at hostPort.
+    // The Client gets its own RPC server and HTTP mux, rather than the global
+    // defaults, so that other servers can run in the same process.
+    srv := rpc.NewServer()
     if ln, err := net.Listen("tcp", hostPort); err != nil {
         // Failed to listen on the given host:port.
         return nil, err
-    } else if err := rpc.RegisterName("RemoteClient", Wrap(c)); err != nil {
+    } else if err := srv.RegisterName("RemoteClient", Wrap(c)); err != nil {
         // Failed to register this Client for RPCs as a RemoteClient.
         return nil, err
     } else {
         // Successfully registered to receive RPCs.
         // Handle these RPCs and other Client events.
         // Return the started Client.
-        rpc.HandleHTTP()
-        go http.Serve(ln, nil)
+        mux := http.NewServeMux()
+        mux.Handle(rpc.DefaultRPCPath, srv)
+        mux.HandleFunc(CHUNK_PATH, c.serveChunk)
+        go http.Serve(ln, mux)
         go c.eventHandler()
+        go c.resumeOffers(resumes)
+        if cfg.PeerListener != nil {
+            go c.passPeerEvents(cfg.PeerListener)
+        }
         return c, nil
     }
 }
@@ -121,6 +668,20 @@ func (c *client) GetChunk(args *clientproto.GetArgs, reply *clientproto.GetReply
 }
 
 func (c *client) OfferFile(t torrentproto.Torrent, path string) error {
+    // Check the file before recording it, so that a file which does not match
+    // is never served.
+    if c.verifyOffers {
+        file, err := os.Open(path)
+        if err != nil {
+            return err
+        }
+        err = torrent.VerifyFile(t, file)
+        file.Close()
+        if err != nil {
+            return fmt.Errorf("Refusing to offer %s: %v", path, err)
+        }
+    }
+
     replyChan := make(chan error)
     offer := & Offer {
         Torrent: t,
@@ -130,6 +691,64 @@ func (c *client) OfferFile(t torrentproto.Torrent, path string) error {
     return <- replyChan
 }
 
+func (c *client) CreateAndOffer(path string, chunkSize int, trackerNodes []torrentproto.TrackerNode) (torrentproto.Torrent, error) {
+    if chunkSize <= 0 {
+        chunkSize = torrent.DEFAULT_CHUNK_SIZE
+    }
+    t, err := torrent.NewWithChunkSize(path, filepath.Base(path), trackerNodes, chunkSize)
+    if err != nil {
+        return torrentproto.Torrent{}, err
+    }
+
+    // Register the Torrent with the Tracker.
+    trackerConn, err := c.newTrackerConn(t)
+    if err != nil {
+        return torrentproto.Torrent{}, err
+    }
+    args := & trackerproto.CreateArgs {
+        Torrent: t,
+        HostPort: c.hostPort,
+        Token: c.trackerToken}
+    reply := & trackerproto.UpdateReply {}
+    err = trackerConn.Call("RemoteTracker.CreateEntry", args, reply)
+    trackerConn.Close()
+    if err != nil {
+        // Every Tracker node has failed.
+        return torrentproto.Torrent{}, err
+    }
+    switch reply.Status {
+    case trackerproto.OK:
+        // Successfully created Torrent on Tracker.
+    case trackerproto.InvalidID:
+        // The ID is made from the name and the hash of the whole file, so
+        // this file has already been registered under this name.
+        // Offer it anyway, unless it was split into different chunks, as
+        // then our chunks would not match the registered hashes.
+        if reply.Digest != torrent.Digest(t) {
+            return torrentproto.Torrent{}, errors.New("Torrent is registered with different chunks")
+        }
+    case trackerproto.InvalidTrackers:
+        return torrentproto.Torrent{}, errors.New("Invalid trackers")
+    case trackerproto.TooManyTorrents:
+        return torrentproto.Torrent{}, errors.New("Too many torrents")
+    case trackerproto.TooManyChunks:
+        return torrentproto.Torrent{}, errors.New("Too many chunks")
+    case trackerproto.InvalidTorrent:
+        return torrentproto.Torrent{}, errors.New("Invalid torrent")
+    case trackerproto.NotAuthorized:
+        return torrentproto.Torrent{}, errors.New("Tracker did not accept this Client's token")
+    case trackerproto.RateLimited:
+        return torrentproto.Torrent{}, errors.New("Tracker is limiting this Client's updates")
+    default:
+        return torrentproto.Torrent{}, errors.New("Could not register Torrent")
+    }
+
+    if err := c.OfferFile(t, path); err != nil {
+        return torrentproto.Torrent{}, err
+    }
+    return t, nil
+}
+
 func (c *client) DownloadFile(t torrentproto.Torrent, path string) error {
     replyChan := make(chan error)
     download := & Download {
@@ -140,243 +759,1795 @@ func (c *client) DownloadFile(t torrentproto.Torrent, path string) error {
     return <-replyChan
 }
 
-func (c *client) Close() error {
-    replyChan := make(chan error)
-    cl := & Close {
-        Reply: replyChan}
-    c.closes <- cl
-    return <-replyChan
+func (c *client) DownloadFileProgress(t torrentproto.Torrent, path string) (<-chan clientproto.Progress, <-chan error) {
+    // Buffer the reply, so that the download does not wait for the caller to
+    // finish reading progress events.
+    replyChan := make(chan error, 1)
+    progressChan := make(chan clientproto.Progress, PROGRESS_BUFFER)
+    download := & Download {
+        Torrent: t,
+        Path: path,
+        Reply: replyChan,
+        Progress: progressChan}
+    c.downloads <- download
+    return progressChan, replyChan
 }
 
-// eventHandler synchronizes all events on this Client.
-func (c *client) eventHandler() {
-    for {
-        select {
+func (c *client) PlanDownload(t torrentproto.Torrent) (clientproto.DownloadPlan, error) {
+    numChunks := torrent.NumChunks(t)
+    plan := clientproto.DownloadPlan {
+        Chunks: make([]clientproto.ChunkPlan, numChunks),
+        TotalBytes: t.FileSize,
+        Feasible: true}
 
-        // The user has supplied a torrent and requested a download.
-        // Service the download asynchronously, and respond to the user
-        // when done.
-        // The IDs of successfully downloaded chunks will be passed back to
-        // the eventHandler as they arrive.
-        case download := <- c.downloads:
-            // Create an entry for this torrent ID.
-            localFile := & clientproto.LocalFile {
-                Torrent: download.Torrent,
-                Path: download.Path,
-                Chunks: make(map[int]struct{})}
-            c.localFiles[download.Torrent.ID] = localFile
-
-            // Inform this Client's LocalFileListener that local files have
-            // been added.
-            c.lfl.OnChange(& clientproto.LocalFileChange {
-                LocalFile: localFile,
-                Operation: clientproto.LocalFileAdd})
+    // Find the chunks this Client already has, skipping past each chunk it
+    // is missing.
+    local := make(map[int]struct{})
+    for first := 0; first < numChunks; {
+        result := c.lookup(t.ID, first, numChunks - 1)
+        if !result.Found {
+            break
+        }
+        last := result.Missing - 1
+        if result.Missing == -1 {
+            last = numChunks - 1
+        }
+        for chunkNum := first; chunkNum <= last; chunkNum++ {
+            local[chunkNum] = struct{}{}
+        }
+        if result.Missing == -1 {
+            break
+        }
+        first = result.Missing + 1
+    }
 
-            // Asynchronously download chunks of the file for this torrent.
-            go c.downloadFile(download)
+    // Ask the Tracker who has each chunk.
+    trackerConn, err := c.newTrackerConn(t)
+    if err != nil {
+        // Unable to get a responsive Tracker node.
+        return clientproto.DownloadPlan {}, err
+    }
+    defer trackerConn.Close()
+    for chunkNum, chunkID := range torrent.AllChunkIDs(t) {
+        args := & trackerproto.RequestArgs {Chunk: chunkID}
+        reply := & trackerproto.RequestReply {}
+        if err := trackerConn.Call("RemoteTracker.RequestChunk", args, reply); err != nil {
+            // Every Tracker node has failed.
+            return clientproto.DownloadPlan {}, err
+        } else if reply.Status == trackerproto.FileNotFound {
+            return clientproto.DownloadPlan {}, errors.New("Torrent is not registered with Tracker")
+        } else if reply.Status != trackerproto.OK {
+            return clientproto.DownloadPlan {}, errors.New("Tracker could not list peers for chunk")
+        }
 
-        // Another Client has requested a chunk.
-        case get := <- c.gets:
-            torrentID, chunkNum := get.Args.ChunkID.ID, get.Args.ChunkID.ChunkNum
-            if localFile, ok := c.localFiles[torrentID]; !ok {
-                // This Client does not know about a local file which
-                // corresponds to the requested Torrent ID.
-                get.Reply <- & clientproto.GetReply {
-                    Status: clientproto.ChunkNotFound,
-                    Chunk: nil}
-            } else if _, ok := localFile.Chunks[chunkNum]; !ok {
-                // This Client knows about the requested file,
-                // but does not have the requested chunk.
-                get.Reply <- & clientproto.GetReply {
-                    Status: clientproto.ChunkNotFound,
-                    Chunk: nil}
-            } else if file, err := os.Open(localFile.Path); err != nil {
-                // The Client thought that it had the requested chunk,
-                // but cannot open the file containing the chunk.
-                get.Reply <- & clientproto.GetReply {
-                    Status: clientproto.ChunkNotFound,
-                    Chunk: nil}
-            } else if chunk, err := torrent.ReadChunk(localFile.Torrent, file, chunkNum); err != nil {
-                // The Client could not get the requested chunk from the file.
-                get.Reply <- & clientproto.GetReply {
-                    Status: clientproto.ChunkNotFound,
-                    Chunk: nil}
-            } else {
-                // Got the requested chunk. Send it back to the requesting
-                // client.
-                get.Reply <- & clientproto.GetReply {
-                    Status: clientproto.OK,
-                    Chunk: chunk}
+        chunkPlan := clientproto.ChunkPlan {ChunkNum: chunkNum}
+        for _, hostPort := range reply.Peers {
+            if hostPort != c.hostPort {
+                chunkPlan.Peers++
             }
-
-        // Close the client.
-        case cl := <- c.closes:
-            cl.Reply <- nil
-            return
-
-        // The user wants to offer a file to a Tracker.
-        // Record on the Client that this file is available.
-        // Then, inform the relevant Tracker.
-        case offer := <- c.offers:
-            // Record that this client has these chunks.
-            // Note that we do not check a chunk's hash here to see if it
-            // is valid. This is a task for the Client receiving the chunk.
-            localFile := & clientproto.LocalFile {
-                Torrent: offer.Torrent,
-                Path: offer.Path,
-                Chunks: make(map[int]struct{})}
-            c.localFiles[offer.Torrent.ID] = localFile
-            for chunkNum := 0; chunkNum < torrent.NumChunks(offer.Torrent); chunkNum++ {
-                localFile.Chunks[chunkNum] = struct{}{}
-            }
-
-            // Inform this Client's LocalFileListener that local files have
-            // been updated.
-            c.lfl.OnChange(& clientproto.LocalFileChange {
-                LocalFile: localFile,
-                Operation: clientproto.LocalFileUpdate})
-
-            // Offer this file to a Tracker.
-            if trackerConn, err := getResponsiveTrackerNode(offer.Torrent); err != nil {
-                // Unable to get a responsive Tracker node.
-                offer.Reply <- nil
-                return
-            } else {
-                // Confirm to the Tracker that this client has all chunks associated with
-                // the Torrent.
-                for chunkNum := 0; chunkNum < torrent.NumChunks(offer.Torrent); chunkNum++ {
-                    args := & trackerproto.ConfirmArgs{
-                        Chunk: torrentproto.ChunkID {
-                            ID: offer.Torrent.ID,
-                            ChunkNum: chunkNum},
-                        HostPort: c.hostPort}
-                    reply := & trackerproto.UpdateReply{}
-                    if err := trackerConn.Call("RemoteTracker.ConfirmChunk", args, reply); err != nil {
-                        // Previously responsive Tracker has failed.
-                        offer.Reply <- err
-                        return
-                    }
-                    if reply.Status == trackerproto.FileNotFound {
-                        // Torrent refers to a file which does not exist on the Tracker.
-                        offer.Reply <- errors.New("Tried to offer file which does not exist on Tracker")
-                        return
-                    }
-                }
+        }
+        if _, chunkPlan.Local = local[chunkNum]; !chunkPlan.Local {
+            if _, length, err := torrent.ChunkBounds(t, chunkNum); err == nil {
+                plan.FetchBytes += length
             }
-
-            // Inform the user that this offer completed without error.
-            offer.Reply <- nil
-
-        // Record that this client has this chunk.
-        // Note that we do not check the chunk's hash here to see if it
-        // is valid. This is a task for the Client receiving the chunk.
-        case chunkID := <- c.downloadedChunks:
-            // Record that this client has this chunk.
-            if localFile, ok := c.localFiles[chunkID.ID]; !ok {
-                // There is no entry for this file.
-                // It must have been removed. Do nothing.
-            } else {
-                localFile.Chunks[chunkID.ChunkNum] = struct{}{}
-
-                // Inform this Client's LocalFileListener that local files have
-                // been updated.
-                c.lfl.OnChange(& clientproto.LocalFileChange {
-                    LocalFile: localFile,
-                    Operation: clientproto.LocalFileUpdate})
+            if chunkPlan.Peers == 0 {
+                plan.Feasible = false
             }
         }
+        plan.Chunks[chunkNum] = chunkPlan
     }
+    return plan, nil
 }
 
-// getResponsiveTrackerNode gets a live connection to a Tracker node.
-// However, there is no guarantee that this connection won't die immediately.
-func getResponsiveTrackerNode(t torrentproto.Torrent) (*rpc.Client, error) {
-    for _, trackerNode := range t.TrackerNodes {
-        if conn, err := rpc.DialHTTP("tcp", trackerNode.HostPort); err == nil {
-            // Found a live node.
-            return conn, nil;
-        }
-    }
+func (c *client) PrioritizeChunk(id torrentproto.ID, chunkNum int) error {
+    replyChan := make(chan error)
+    prioritize := & Prioritize {
+        ID: id,
+        ChunkNum: chunkNum,
+        Reply: replyChan}
+    c.prioritizes <- prioritize
+    return <-replyChan
+}
+
+func (c *client) RefreshChunk(id torrentproto.ID, chunkNum int) error {
+    result := c.lookup(id, 0, -1)
+    if !result.Found {
+        return errors.New("No local file for torrent")
+    } else if chunkNum < 0 || chunkNum >= torrent.NumChunks(result.Torrent) {
+        return errors.New("Chunk number out of range")
+    }
+
+    // Check the chunk on disk, and record the result, so that this Client
+    // stops serving the chunk before telling the Tracker it is missing.
+    chunkID := torrentproto.NewChunkID(id, chunkNum)
+    valid := chunkValid(result.Torrent, result.Path, chunkNum)
+    replyChan := make(chan *RefreshResult)
+    c.refreshes <- & Refresh {
+        ChunkID: chunkID,
+        Valid: valid,
+        Reply: replyChan}
+    refreshed := <-replyChan
+
+    // Tell the Tracker.
+    trackerConn, err := c.newTrackerConn(result.Torrent)
+    if err != nil {
+        // Unable to get a responsive Tracker node.
+        return err
+    }
+    defer trackerConn.Close()
+    // A chunk which this Client withholds is reported missing, even if it
+    // is valid, so that peers are not sent to get it.
+    reply := & trackerproto.UpdateReply {}
+    if valid && refreshed.Servable {
+        args := & trackerproto.ConfirmArgs {
+            Chunk: chunkID,
+            HostPort: c.hostPort,
+            Complete: refreshed.Complete,
+            Token: c.trackerToken}
+        err = trackerConn.Call("RemoteTracker.ConfirmChunk", args, reply)
+    } else {
+        args := & trackerproto.ReportArgs {
+            Chunk: chunkID,
+            HostPort: c.hostPort,
+            Token: c.trackerToken}
+        err = trackerConn.Call("RemoteTracker.ReportMissing", args, reply)
+    }
+    if err != nil {
+        // Every Tracker node has failed.
+        return err
+    } else if reply.Status != trackerproto.OK {
+        return errors.New("Tracker did not accept chunk update")
+    }
+    return nil
+}
+
+func (c *client) SetServableChunks(id torrentproto.ID, chunks []int) error {
+    result := c.lookup(id, 0, -1)
+    if !result.Found {
+        return errors.New("No local file for torrent")
+    }
+    numChunks := torrent.NumChunks(result.Torrent)
+    var servable map[int]struct{}
+    if chunks != nil {
+        servable = make(map[int]struct{})
+        for _, chunkNum := range chunks {
+            if chunkNum < 0 || chunkNum >= numChunks {
+                return errors.New("Chunk number out of range")
+            }
+            servable[chunkNum] = struct{}{}
+        }
+    }
+
+    // Stop serving withheld chunks before telling the Tracker about them.
+    replyChan := make(chan error)
+    c.restricts <- & Restrict {
+        ID: id,
+        Servable: servable,
+        Reply: replyChan}
+    if err := <-replyChan; err != nil {
+        return err
+    }
+
+    // Tell the Tracker which chunks this Client now serves.
+    trackerConn, err := c.newTrackerConn(result.Torrent)
+    if err != nil {
+        // Unable to get a responsive Tracker node.
+        return err
+    }
+    defer trackerConn.Close()
+    complete := servable == nil && c.lookup(id, 0, numChunks - 1).Missing == -1
+    for _, chunkID := range torrent.AllChunkIDs(result.Torrent) {
+        reply := & trackerproto.UpdateReply {}
+        if _, ok := servable[chunkID.ChunkNum]; servable != nil && !ok {
+            args := & trackerproto.ReportArgs {
+                Chunk: chunkID,
+                HostPort: c.hostPort,
+                Token: c.trackerToken}
+            err = trackerConn.Call("RemoteTracker.ReportMissing", args, reply)
+        } else if c.lookup(id, chunkID.ChunkNum, chunkID.ChunkNum).Missing == -1 {
+            args := & trackerproto.ConfirmArgs {
+                Chunk: chunkID,
+                HostPort: c.hostPort,
+                Complete: complete,
+                Token: c.trackerToken}
+            err = trackerConn.Call("RemoteTracker.ConfirmChunk", args, reply)
+        } else {
+            // This Client does not have the chunk, so there is nothing to
+            // tell.
+            continue
+        }
+        if err != nil {
+            // Every Tracker node has failed.
+            return err
+        } else if reply.Status != trackerproto.OK {
+            return errors.New("Tracker did not accept chunk update")
+        }
+    }
+    return nil
+}
+
+func (c *client) RemoveFile(id torrentproto.ID) error {
+    replyChan := make(chan *RemoveResult)
+    c.removes <- & Remove {
+        ID: id,
+        Reply: replyChan}
+    result := <-replyChan
+    if result.Err != nil {
+        return result.Err
+    }
+
+    // Stop the Tracker sending peers here for the chunks this Client had.
+    trackerConn, err := c.newTrackerConn(result.LocalFile.Torrent)
+    if err != nil {
+        // Unable to get a responsive Tracker node.
+        return err
+    }
+    defer trackerConn.Close()
+    for _, chunkNum := range result.Chunks {
+        args := & trackerproto.ReportArgs {
+            Chunk: torrentproto.NewChunkID(id, chunkNum),
+            HostPort: c.hostPort,
+            Token: c.trackerToken}
+        reply := & trackerproto.UpdateReply {}
+        if err := trackerConn.Call("RemoteTracker.ReportMissing", args, reply); err != nil {
+            // Every Tracker node has failed.
+            return err
+        } else if reply.Status != trackerproto.OK {
+            return errors.New("Tracker did not accept missing chunk")
+        }
+    }
+    return nil
+}
+
+func (c *client) SetConcurrency(n int) error {
+    if n <= 0 {
+        return fmt.Errorf("Concurrency must be positive, not %d", n)
+    }
+    replyChan := make(chan error)
+    c.concurrencies <- & Concurrency {
+        Workers: n,
+        Reply: replyChan}
+    return <- replyChan
+}
+
+// chunkValid reports whether the chunk with the given number of the file at
+// path matches its hash in t.
+// A chunk which cannot be read is not valid.
+func chunkValid(t torrentproto.Torrent, path string, chunkNum int) bool {
+    file, err := os.Open(path)
+    if err != nil {
+        return false
+    }
+    defer file.Close()
+
+    return readerMatches(t, file, chunkNum)
+}
+
+// chunkMatches reports whether chunk matches the hash in t of the chunk with
+// the given number.
+func chunkMatches(t torrentproto.Torrent, chunkNum int, chunk []byte) bool {
+    h, err := torrent.NewHash(t)
+    if err != nil {
+        return false
+    }
+    h.Write(chunk)
+    return string(h.Sum(nil)) == t.ChunkHashes[chunkNum]
+}
+
+// readerMatches reports whether the chunk with the given number of file
+// matches its hash in t, hashing it a piece at a time rather than reading it
+// into memory.
+// A chunk which cannot be read in full is not valid.
+func readerMatches(t torrentproto.Torrent, file *os.File, chunkNum int) bool {
+    h, err := torrent.NewHash(t)
+    if err != nil {
+        return false
+    }
+    r, length, err := torrent.ChunkReader(t, file, chunkNum)
+    if err != nil {
+        return false
+    } else if n, err := io.Copy(h, r); err != nil || n != int64(length) {
+        return false
+    }
+    return string(h.Sum(nil)) == t.ChunkHashes[chunkNum]
+}
+
+// repairChunk replaces a corrupt chunk of t in the local file at path with a
+// good copy from another peer, and serves it on reply.
+// The chunk is then refreshed, so that this Client records that it has the
+// chunk and confirms it to the Tracker again. If no peer sends a good copy,
+// the refresh reports the chunk missing instead, and ChunkNotFound is sent on
+// reply.
+func (c *client) repairChunk(t torrentproto.Torrent, path string, chunkNum int, reply chan *clientproto.GetReply) {
+    chunk, err := c.fetchChunk(t, path, chunkNum)
+    c.RefreshChunk(t.ID, chunkNum)
+    if err != nil {
+        reply <- & clientproto.GetReply {
+            Status: clientproto.ChunkNotFound,
+            Chunk: nil}
+    } else {
+        reply <- & clientproto.GetReply {
+            Status: clientproto.OK,
+            Chunk: chunk}
+    }
+}
+
+// truncatedChunks returns the numbers of the chunks which this Client has
+// recorded for localFile, but which end past size, the length of its file on
+// disk, in order.
+func truncatedChunks(localFile *clientproto.LocalFile, size int64) []int {
+    lost := make([]int, 0)
+    for chunkNum := range localFile.Chunks {
+        if start, length, err := torrent.ChunkBounds(localFile.Torrent, chunkNum); err != nil || int64(start + length) > size {
+            lost = append(lost, chunkNum)
+        }
+    }
+    sort.Ints(lost)
+    return lost
+}
+
+// restoreChunks tells the Tracker that this Client no longer has the chunks of
+// t with the given numbers, which the local file at path has lost.
+// If this Client repairs the chunks it serves, it then downloads each of them
+// again, and confirms those it gets.
+func (c *client) restoreChunks(t torrentproto.Torrent, path string, chunkNums []int) {
+    for _, chunkNum := range chunkNums {
+        // The chunk cannot be read, so refreshing it reports it missing.
+        c.RefreshChunk(t.ID, chunkNum)
+    }
+    if c.servePolicy != ServeVerifyAndRepair {
+        return
+    }
+    for _, chunkNum := range chunkNums {
+        if _, err := c.fetchChunk(t, path, chunkNum); err == nil {
+            c.RefreshChunk(t.ID, chunkNum)
+        }
+    }
+}
+
+// fetchChunk downloads the chunk of t with the given number from its peers,
+// writes it to the local file at path, and returns it.
+func (c *client) fetchChunk(t torrentproto.Torrent, path string, chunkNum int) ([]byte, error) {
+    trackerConn, err := c.newTrackerConn(t)
+    if err != nil {
+        // Unable to get a responsive Tracker node.
+        return nil, err
+    }
+    defer trackerConn.Close()
+
+    file, err := os.OpenFile(path, os.O_RDWR, 0644)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    r := rand.New(rand.NewSource(time.Now().UnixNano()))
+    peersFor := func() ([]string, error) {
+        args := & trackerproto.RequestArgs {
+            Chunk: torrentproto.NewChunkID(t.ID, chunkNum),
+            MaxPeers: REQUEST_PEERS}
+        reply := & trackerproto.RequestReply {}
+        if err := trackerConn.Call("RemoteTracker.RequestChunk", args, reply); err != nil {
+            // Every Tracker node has failed.
+            return nil, err
+        } else if reply.Status != trackerproto.OK {
+            return nil, errors.New("Tracker did not list peers for chunk")
+        }
+        return orderPeers(reply, r), nil
+    }
+    if err := c.retryChunk(& Download {Torrent: t}, file, chunkNum, peersFor); err != nil {
+        return nil, err
+    }
+    return torrent.ReadChunk(t, file, chunkNum)
+}
+
+func (c *client) TorrentReaderAt(id torrentproto.ID) (io.ReaderAt, error) {
+    if result := c.lookup(id, 0, -1); !result.Found {
+        return nil, errors.New("No local file for torrent")
+    } else if result.Torrent.ChunkSize <= 0 {
+        // The reader could not work out which chunk an offset is in.
+        return nil, errors.New("Torrent has no chunk size")
+    } else {
+        return & torrentReader {
+            c: c,
+            t: result.Torrent}, nil
+    }
+}
+
+// lookup asks the eventHandler about the local file for the Torrent with the
+// given ID, and whether this Client has chunks first through last of it.
+func (c *client) lookup(id torrentproto.ID, first, last int) *LookupResult {
+    replyChan := make(chan *LookupResult)
+    lookup := & Lookup {
+        ID: id,
+        First: first,
+        Last: last,
+        Reply: replyChan}
+    c.lookups <- lookup
+    return <-replyChan
+}
+
+func (c *client) Progress(id torrentproto.ID) (int, int, error) {
+    result := c.lookup(id, 0, -1)
+    if !result.Found {
+        return 0, 0, errors.New("No local file for torrent")
+    }
+    return result.Chunks, torrent.NumChunks(result.Torrent), nil
+}
+
+func (c *client) TrackedTorrents() map[string][]torrentproto.ID {
+    replyChan := make(chan map[string][]torrentproto.ID)
+    c.trackedQueries <- & TrackedQuery {Reply: replyChan}
+    return <-replyChan
+}
+
+func (c *client) ListTorrents(trackerNodes []torrentproto.TrackerNode) ([]trackerproto.TorrentSummary, error) {
+    // Any Torrent of the Tracker will do to find a responsive node.
+    trackerConn, err := c.newTrackerConn(torrentproto.Torrent {TrackerNodes: trackerNodes})
+    if err != nil {
+        // Unable to get a responsive Tracker node.
+        return nil, err
+    }
+    defer trackerConn.Close()
+
+    reply := & trackerproto.ListReply {}
+    if err := trackerConn.Call("RemoteTracker.ListTorrents", & trackerproto.ListArgs {}, reply); err != nil {
+        // Every Tracker node has failed.
+        return nil, err
+    } else if reply.Status != trackerproto.OK {
+        return nil, errors.New("Tracker did not list torrents")
+    }
+    return reply.Torrents, nil
+}
+
+func (c *client) StatusJSON() ([]byte, error) {
+    replyChan := make(chan *clientproto.ClientStatus)
+    c.statusQueries <- & StatusQuery {Reply: replyChan}
+    return json.Marshal(<-replyChan)
+}
+
+func (c *client) Close() error {
+    replyChan := make(chan error)
+    cl := & Close {
+        Reply: replyChan}
+    c.closes <- cl
+    return <-replyChan
+}
+
+// eventHandler synchronizes all events on this Client.
+func (c *client) eventHandler() {
+    // Confirm the chunks this Client serves again every announceInterval, so
+    // that the Tracker does not forget this Client while it is still serving.
+    var announce <-chan time.Time
+    if c.announceInterval > 0 {
+        ticker := time.NewTicker(c.announceInterval)
+        defer ticker.Stop()
+        announce = ticker.C
+    }
+
+    for {
+        select {
+
+        // It is time to confirm every chunk this Client serves again.
+        // Rounds which are due while the last is still running are skipped,
+        // so that a slow Tracker does not pile them up.
+        case <- announce:
+            if !c.announcing {
+                c.announcing = true
+                go c.announce(c.announcements())
+            }
+
+        // A round of confirming chunks again is over.
+        case errs := <- c.announced:
+            c.announcing = false
+            c.announceErrors = errs
+
+        // The user has supplied a torrent and requested a download.
+        // Service the download asynchronously, and respond to the user
+        // when done.
+        // The IDs of successfully downloaded chunks will be passed back to
+        // the eventHandler as they arrive.
+        case download := <- c.downloads:
+            localFile, ok := c.localFiles[download.Torrent.ID]
+            if ok && localFile.Path == download.Path && torrent.Digest(localFile.Torrent) == torrent.Digest(download.Torrent) {
+                // An earlier download of the same chunks to the same path
+                // did not finish. Keep the chunks it recorded.
+                download.resumed = make([]int, 0, len(localFile.Chunks))
+                for chunkNum := range localFile.Chunks {
+                    download.resumed = append(download.resumed, chunkNum)
+                }
+                localFile.Torrent = download.Torrent
+            } else {
+                // Create an entry for this torrent ID.
+                localFile = & clientproto.LocalFile {
+                    Torrent: download.Torrent,
+                    Path: download.Path,
+                    Chunks: make(map[int]struct{})}
+                c.localFiles[download.Torrent.ID] = localFile
+
+                // Inform this Client's LocalFileListener that local files
+                // have been added.
+                c.lfl.OnChange(& clientproto.LocalFileChange {
+                    LocalFile: localFile,
+                    Operation: clientproto.LocalFileAdd})
+            }
+            c.trackers.add(download.Torrent)
+            delete(c.servable, download.Torrent.ID)
+
+            // Download the missing chunks for this file in a random order, to
+            // help provide load-balancing. Downloads of the rarest chunks
+            // first start from this order too, so that chunks which as many
+            // peers have stay shuffled. PrioritizeChunk may change this
+            // order.
+            r := rand.New(rand.NewSource(time.Now().UnixNano()))
+            missing := make([]int, 0, torrent.NumChunks(download.Torrent) - len(localFile.Chunks))
+            for _, chunkNum := range r.Perm(torrent.NumChunks(download.Torrent)) {
+                if _, ok := localFile.Chunks[chunkNum]; !ok {
+                    missing = append(missing, chunkNum)
+                }
+            }
+            download.queue = newChunkQueue(missing, c.prefetchWindow)
+            download.workers = c.downloadWorkers
+            download.order = c.chunkOrder
+            c.downloading[download.Torrent.ID] = download
+
+            // Asynchronously download chunks of the file for this torrent.
+            go c.downloadFile(download)
+
+        // A download has finished, successfully or not.
+        case download := <- c.finishedDownloads:
+            // A later download of the same torrent may have replaced this one.
+            if c.downloading[download.Torrent.ID] == download {
+                delete(c.downloading, download.Torrent.ID)
+            }
+
+            // No more chunks will arrive for this download.
+            if download.Progress != nil {
+                close(download.Progress)
+            }
+
+        // The user needs a chunk of a file which is downloading as soon as
+        // possible.
+        case prioritize := <- c.prioritizes:
+            if download, ok := c.downloading[prioritize.ID]; !ok {
+                prioritize.Reply <- errors.New("No download in progress for torrent")
+            } else if !download.queue.Prioritize(prioritize.ChunkNum) {
+                // The chunk has already been downloaded, is being downloaded
+                // now, or is not in the file.
+                prioritize.Reply <- errors.New("Chunk is not waiting to be downloaded")
+            } else {
+                prioritize.Reply <- nil
+            }
+
+        // Another Client has requested a chunk.
+        case get := <- c.gets:
+            torrentID, chunkNum := get.Args.ChunkID.ID, get.Args.ChunkID.ChunkNum
+            if localFile, ok := c.localFiles[torrentID]; !ok {
+                // This Client does not know about a local file which
+                // corresponds to the requested Torrent ID.
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.ChunkNotFound,
+                    Chunk: nil}
+            } else if _, ok := localFile.Chunks[chunkNum]; !ok {
+                // This Client knows about the requested file,
+                // but does not have the requested chunk.
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.ChunkNotFound,
+                    Chunk: nil}
+            } else if !c.serves(torrentID, chunkNum) {
+                // This Client has the requested chunk, but has been told to
+                // withhold it.
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.ChunkNotFound,
+                    Chunk: nil}
+            } else if start, length, err := torrent.ChunkBounds(localFile.Torrent, chunkNum); err != nil {
+                // The chunk number is not valid for the Torrent.
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.ChunkNotFound,
+                    Chunk: nil}
+            } else if length > c.maxChunkSize {
+                // Reading the chunk would take more memory than this Client
+                // is willing to spend on one request.
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.ChunkTooLarge,
+                    Chunk: nil}
+            } else if file, err := os.Open(localFile.Path); err != nil {
+                // The Client thought that it had the requested chunk,
+                // but cannot open the file containing the chunk.
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.ChunkNotFound,
+                    Chunk: nil}
+            } else if fi, err := file.Stat(); err == nil && int64(start + length) > fi.Size() {
+                // The file has been truncated since this Client recorded the
+                // chunk. Stop serving every chunk past the new end at once,
+                // then tell the Tracker, and restore them if this Client
+                // repairs the chunks it serves.
+                lost := truncatedChunks(localFile, fi.Size())
+                for _, lostNum := range lost {
+                    delete(localFile.Chunks, lostNum)
+                }
+                c.lfl.OnChange(& clientproto.LocalFileChange {
+                    LocalFile: localFile,
+                    Operation: clientproto.LocalFileUpdate})
+                go c.restoreChunks(localFile.Torrent, localFile.Path, lost)
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.ChunkNotFound,
+                    Chunk: nil}
+            } else if chunk, err := torrent.ReadChunk(localFile.Torrent, file, chunkNum); err != nil {
+                // The Client could not get the requested chunk from the file.
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.ChunkNotFound,
+                    Chunk: nil}
+            } else if c.servePolicy != ServeTrust && !chunkMatches(localFile.Torrent, chunkNum, chunk) {
+                // The chunk on disk is corrupt. Stop serving it at once, so
+                // that other requests do not read it too.
+                delete(localFile.Chunks, chunkNum)
+                c.lfl.OnChange(& clientproto.LocalFileChange {
+                    LocalFile: localFile,
+                    Operation: clientproto.LocalFileUpdate})
+                if c.servePolicy == ServeVerifyAndRepair {
+                    // Get a good copy from the swarm, and serve that.
+                    go c.repairChunk(localFile.Torrent, localFile.Path, chunkNum, get.Reply)
+                } else {
+                    // Refreshing the chunk tells the Tracker it is missing.
+                    go c.RefreshChunk(torrentID, chunkNum)
+                    get.Reply <- & clientproto.GetReply {
+                        Status: clientproto.ChunkNotFound,
+                        Chunk: nil}
+                }
+            } else {
+                // Got the requested chunk. Send it back to the requesting
+                // client.
+                get.Reply <- & clientproto.GetReply {
+                    Status: clientproto.OK,
+                    Chunk: chunk}
+            }
+
+        // Someone wants to know about a local file, e.g. to read it.
+        case lookup := <- c.lookups:
+            if localFile, ok := c.localFiles[lookup.ID]; !ok {
+                lookup.Reply <- & LookupResult {Found: false}
+            } else {
+                missing := -1
+                for chunkNum := lookup.First; chunkNum <= lookup.Last; chunkNum++ {
+                    if _, ok := localFile.Chunks[chunkNum]; !ok {
+                        missing = chunkNum
+                        break
+                    }
+                }
+                if download, ok := c.downloading[lookup.ID]; ok && lookup.Reading {
+                    download.queue.Advance(lookup.First)
+                    if missing != -1 {
+                        download.queue.Prioritize(missing)
+                    }
+                }
+                lookup.Reply <- & LookupResult {
+                    Found: true,
+                    Torrent: localFile.Torrent,
+                    Path: localFile.Path,
+                    Missing: missing,
+                    Chunks: len(localFile.Chunks)}
+            }
+
+        // A peer wants a chunk streamed to it.
+        // The chunk is read outside the eventHandler, so only say where it is.
+        case query := <- c.streamQueries:
+            if localFile, ok := c.localFiles[query.ID]; !ok {
+                query.Reply <- & LookupResult {Found: false}
+            } else if _, ok := localFile.Chunks[query.ChunkNum]; !ok {
+                query.Reply <- & LookupResult {Found: false}
+            } else if !c.serves(query.ID, query.ChunkNum) {
+                query.Reply <- & LookupResult {Found: false}
+            } else {
+                query.Reply <- & LookupResult {
+                    Found: true,
+                    Torrent: localFile.Torrent,
+                    Path: localFile.Path,
+                    Missing: -1,
+                    Chunks: len(localFile.Chunks)}
+            }
+
+        // Someone wants to know which Tracker nodes this client uses.
+        case query := <- c.trackedQueries:
+            query.Reply <- c.trackers.byNode()
+
+        // A chunk of a local file has been re-checked.
+        // Record whether this client has it.
+        case refresh := <- c.refreshes:
+            if localFile, ok := c.localFiles[refresh.ID]; !ok {
+                // The file has been removed since it was checked.
+                refresh.Reply <- & RefreshResult {}
+            } else {
+                _, had := localFile.Chunks[refresh.ChunkNum]
+                if refresh.Valid {
+                    localFile.Chunks[refresh.ChunkNum] = struct{}{}
+                } else {
+                    delete(localFile.Chunks, refresh.ChunkNum)
+                }
+
+                // Inform this Client's LocalFileListener if the local file
+                // has changed.
+                if had != refresh.Valid {
+                    c.lfl.OnChange(& clientproto.LocalFileChange {
+                        LocalFile: localFile,
+                        Operation: clientproto.LocalFileUpdate})
+                }
+                _, restricted := c.servable[refresh.ID]
+                refresh.Reply <- & RefreshResult {
+                    Complete: len(localFile.Chunks) == torrent.NumChunks(localFile.Torrent) && !restricted,
+                    Servable: c.serves(refresh.ID, refresh.ChunkNum)}
+            }
+
+        // The user wants to limit which chunks of a local file are served.
+        case restrict := <- c.restricts:
+            if _, ok := c.localFiles[restrict.ID]; !ok {
+                // The file has been removed since it was looked up.
+                restrict.Reply <- errors.New("No local file for torrent")
+            } else {
+                if restrict.Servable == nil {
+                    delete(c.servable, restrict.ID)
+                } else {
+                    c.servable[restrict.ID] = restrict.Servable
+                }
+                restrict.Reply <- nil
+            }
+
+        // The user wants to stop serving a local file.
+        // Forget it, and pass back the chunks it had, so that they can be
+        // reported missing to the Tracker.
+        case remove := <- c.removes:
+            if localFile, ok := c.localFiles[remove.ID]; !ok {
+                remove.Reply <- & RemoveResult {
+                    Err: errors.New("No local file for torrent")}
+            } else if _, ok := c.downloading[remove.ID]; ok {
+                remove.Reply <- & RemoveResult {
+                    Err: errors.New("Download in progress for torrent")}
+            } else {
+                chunks := make([]int, 0, len(localFile.Chunks))
+                for chunkNum := range localFile.Chunks {
+                    chunks = append(chunks, chunkNum)
+                }
+                sort.Ints(chunks)
+                delete(c.localFiles, remove.ID)
+                delete(c.servable, remove.ID)
+                delete(c.offerProgress, remove.ID)
+                c.trackers.remove(remove.ID)
+
+                // Inform this Client's LocalFileListener that local files
+                // have been updated.
+                c.lfl.OnChange(& clientproto.LocalFileChange {
+                    LocalFile: localFile,
+                    Operation: clientproto.LocalFileRemove})
+                remove.Reply <- & RemoveResult {
+                    LocalFile: localFile,
+                    Chunks: chunks}
+            }
+
+        // The user wants to change how many chunks of each file are
+        // downloaded at once.
+        case concurrency := <- c.concurrencies:
+            c.downloadWorkers = concurrency.Workers
+            concurrency.Reply <- nil
+
+        // Someone wants a snapshot of this client's local files.
+        case query := <- c.statusQueries:
+            query.Reply <- c.status()
+
+        // Close the client.
+        case cl := <- c.closes:
+            close(c.closed)
+            c.peers.close()
+            c.streams.CloseIdleConnections()
+            cl.Reply <- nil
+            return
+
+        // The user wants to offer a file to a Tracker.
+        // Record on the Client that this file is available.
+        // Then, inform the relevant Tracker.
+        case offer := <- c.offers:
+            if offer.Resume {
+                // The file was recorded when it was first offered, but it
+                // may have been removed since.
+                if _, ok := c.localFiles[offer.Torrent.ID]; !ok {
+                    delete(c.offerProgress, offer.Torrent.ID)
+                    offer.Reply <- errors.New("No local file for torrent")
+                } else {
+                    offer.Reply <- c.confirmOffer(offer.Torrent)
+                }
+            } else {
+                // Record that this client has these chunks.
+                // Note that we do not check a chunk's hash here to see if it
+                // is valid, unless the Client verifies offers (which
+                // OfferFile did before passing the offer on). Otherwise, this
+                // is a task for the Client receiving the chunk.
+                localFile := & clientproto.LocalFile {
+                    Torrent: offer.Torrent,
+                    Path: offer.Path,
+                    Chunks: make(map[int]struct{})}
+                c.localFiles[offer.Torrent.ID] = localFile
+                c.trackers.add(offer.Torrent)
+                delete(c.servable, offer.Torrent.ID)
+                for chunkNum := 0; chunkNum < torrent.NumChunks(offer.Torrent); chunkNum++ {
+                    localFile.Chunks[chunkNum] = struct{}{}
+                }
+                // The Tracker has confirmed none of these chunks for this
+                // offer yet.
+                c.offerProgress[offer.Torrent.ID] = make(map[int]struct{})
+
+                // Inform this Client's LocalFileListener that local files
+                // have been updated.
+                c.lfl.OnChange(& clientproto.LocalFileChange {
+                    LocalFile: localFile,
+                    Operation: clientproto.LocalFileUpdate})
+
+                // Offer this file to a Tracker, and inform the user how it
+                // went.
+                offer.Reply <- c.confirmOffer(offer.Torrent)
+            }
+
+        // Record that this client has this chunk.
+        // Note that we do not check the chunk's hash here to see if it
+        // is valid. This is a task for the Client receiving the chunk.
+        case chunkID := <- c.downloadedChunks:
+            // Record that this client has this chunk.
+            if localFile, ok := c.localFiles[chunkID.ID]; !ok {
+                // There is no entry for this file.
+                // It must have been removed. Do nothing.
+            } else {
+                localFile.Chunks[chunkID.ChunkNum] = struct{}{}
+
+                // Inform this Client's LocalFileListener that local files have
+                // been updated.
+                c.lfl.OnChange(& clientproto.LocalFileChange {
+                    LocalFile: localFile,
+                    Operation: clientproto.LocalFileUpdate})
+
+                // Inform anyone watching the download, unless they have
+                // fallen too far behind.
+                if download, ok := c.downloading[chunkID.ID]; ok && download.Progress != nil {
+                    select {
+                    case download.Progress <- clientproto.Progress {
+                        ChunkNum: chunkID.ChunkNum,
+                        Chunks: len(localFile.Chunks),
+                        TotalChunks: torrent.NumChunks(localFile.Torrent)}:
+                    default:
+                    }
+                }
+            }
+        }
+    }
+}
+
+// confirmOffer confirms to the Tracker that this Client has every chunk of t
+// which the Tracker has not confirmed for the offer in progress yet,
+// OFFER_BATCH chunks at a time.
+// The chunks in each batch are recorded in offerProgress (and the state file)
+// as soon as the Tracker confirms them, and the offer is forgotten once every
+// chunk has been confirmed.
+// If the Client has an offer timeout, it gives up once the timeout passes,
+// and returns an *OfferTimeoutError.
+// It returns a non-nil error if the offer failed.
+// It should only be called by the eventHandler.
+func (c *client) confirmOffer(t torrentproto.Torrent) error {
+    var deadline time.Time
+    if c.offerTimeout > 0 {
+        deadline = time.Now().Add(c.offerTimeout)
+    }
+
+    trackerConn, err := c.newTrackerConn(t)
+    if err != nil {
+        // Unable to get a responsive Tracker node.
+        return err
+    }
+    defer trackerConn.Close()
+
+    numChunks := torrent.NumChunks(t)
+    confirmed := c.offerProgress[t.ID]
+    var chunks []int
+    for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+        if _, ok := confirmed[chunkNum]; !ok {
+            chunks = append(chunks, chunkNum)
+        }
+    }
+    for first := 0; first < len(chunks); first += OFFER_BATCH {
+        args := & trackerproto.ConfirmChunksArgs{
+            ID: t.ID,
+            HostPort: c.hostPort,
+            Complete: true,
+            Token: c.trackerToken}
+        for i := first; i < len(chunks) && i < first + OFFER_BATCH; i++ {
+            args.ChunkNums = append(args.ChunkNums, chunks[i])
+        }
+        reply := & trackerproto.UpdateReply{}
+        if err := trackerConn.CallBefore(deadline, "RemoteTracker.ConfirmChunks", args, reply); err == errDeadline {
+            // Ran out of time.
+            return & OfferTimeoutError {
+                Confirmed: numChunks - len(chunks) + first,
+                Total: numChunks}
+        } else if err != nil {
+            // Every Tracker node has failed.
+            return err
+        }
+        if err := updateError(reply.Status); err != nil {
+            return err
+        }
+
+        if confirmed != nil {
+            for _, chunkNum := range args.ChunkNums {
+                confirmed[chunkNum] = struct{}{}
+            }
+            c.saveState()
+        }
+    }
+    delete(c.offerProgress, t.ID)
+    c.saveState()
+    return nil
+}
+
+// saveState writes this Client's local files and the progress of its offers
+// to its state file, if it has one. Like saving on each local file change,
+// this is best effort.
+// It should only be called by the eventHandler.
+func (c *client) saveState() {
+    if c.state != nil {
+        c.state.save()
+    }
+}
+
+// resumeOffers carries on the offers of the given Torrents which were in
+// progress when this Client's state was last saved, confirming only the
+// chunks which the Tracker had not confirmed yet.
+// An offer which fails again stays in the state file, to be resumed the next
+// time the Client starts.
+func (c *client) resumeOffers(resumes []torrentproto.Torrent) {
+    for _, t := range resumes {
+        replyChan := make(chan error)
+        offer := & Offer {
+            Torrent: t,
+            Resume: true,
+            Reply: replyChan}
+        select {
+        case c.offers <- offer:
+            <- replyChan
+        case <- c.closed:
+            return
+        }
+    }
+}
+
+// updateError returns the error to give for a Tracker's answer to an update
+// about a file's chunks, or nil if the Tracker accepted the update.
+func updateError(status trackerproto.Status) error {
+    switch status {
+    case trackerproto.OK:
+        return nil
+    case trackerproto.FileNotFound:
+        // Torrent refers to a file which does not exist on the Tracker.
+        return errors.New("Tried to offer file which does not exist on Tracker")
+    case trackerproto.NotAuthorized:
+        return errors.New("Tracker did not accept this Client's token")
+    case trackerproto.RateLimited:
+        return errors.New("Tracker is limiting this Client's updates")
+    default:
+        return errors.New("Tracker did not accept chunk update")
+    }
+}
+
+// serves reports whether this Client is willing to serve the chunk with the
+// given number of the local file for the Torrent with the given ID, if it has
+// the chunk.
+// It should only be called by the eventHandler.
+func (c *client) serves(id torrentproto.ID, chunkNum int) bool {
+    servable, ok := c.servable[id]
+    if !ok {
+        // This Client serves every chunk of the file.
+        return true
+    }
+    _, ok = servable[chunkNum]
+    return ok
+}
+
+// announcements lists the chunks of each local file which this Client has
+// and serves, to be confirmed to the Tracker again.
+// It should only be called by the eventHandler.
+func (c *client) announcements() []announcement {
+    announcements := make([]announcement, 0, len(c.localFiles))
+    for id, localFile := range c.localFiles {
+        chunks := make([]int, 0, len(localFile.Chunks))
+        for chunkNum := range localFile.Chunks {
+            if c.serves(id, chunkNum) {
+                chunks = append(chunks, chunkNum)
+            }
+        }
+        _, restricted := c.servable[id]
+        announcements = append(announcements, announcement {
+            t: localFile.Torrent,
+            chunks: chunks,
+            complete: len(localFile.Chunks) == torrent.NumChunks(localFile.Torrent) && !restricted})
+    }
+    return announcements
+}
+
+// announce confirms the given chunks to the Tracker again, OFFER_BATCH chunks
+// of a file at a time, then tells the eventHandler why confirming each file's
+// chunks failed, if it did.
+// A file whose Tracker cannot be reached, or does not accept a batch, is left
+// until the next round. A batch which the Tracker turns away because this
+// Client is sending updates too fast is sent again, ANNOUNCE_RATE_WAIT later,
+// up to ANNOUNCE_RATE_RETRIES times. It stops early if this Client is closed.
+func (c *client) announce(announcements []announcement) {
+    errs := make(map[torrentproto.ID]string)
+    defer func() {
+        c.announced <- errs
+    }()
+
+    for _, a := range announcements {
+        trackerConn, err := c.newTrackerConn(a.t)
+        if err != nil {
+            // Unable to get a responsive Tracker node. Try again next round.
+            errs[a.t.ID] = err.Error()
+            continue
+        }
+        err = c.announceFile(trackerConn, a)
+        trackerConn.Close()
+        if err == errClosed {
+            return
+        } else if err != nil {
+            errs[a.t.ID] = err.Error()
+        }
+    }
+}
+
+// Returned by announceFile and downloadWorker when this Client is closed part
+// way.
+var errClosed = errors.New("Client is closed")
+
+// announceFile confirms the chunks of one local file to the Tracker again,
+// as announce does, over trackerConn.
+// It returns errClosed if this Client was closed part way.
+func (c *client) announceFile(trackerConn *trackerConn, a announcement) error {
+    for first := 0; first < len(a.chunks); first += OFFER_BATCH {
+        last := first + OFFER_BATCH
+        if last > len(a.chunks) {
+            last = len(a.chunks)
+        }
+        args := & trackerproto.ConfirmChunksArgs {
+            ID: a.t.ID,
+            ChunkNums: a.chunks[first:last],
+            HostPort: c.hostPort,
+            Complete: a.complete,
+            Token: c.trackerToken}
+        for retries := 0; ; retries++ {
+            select {
+            case <- c.closed:
+                return errClosed
+            default:
+            }
+            reply := & trackerproto.UpdateReply {}
+            if err := trackerConn.Call("RemoteTracker.ConfirmChunks", args, reply); err != nil {
+                // Every Tracker node has failed.
+                return err
+            }
+            if reply.Status != trackerproto.RateLimited || retries == ANNOUNCE_RATE_RETRIES {
+                if err := updateError(reply.Status); err != nil {
+                    return err
+                }
+                break
+            }
+            // Wait for the Tracker's count of this Client's updates to
+            // drop.
+            select {
+            case <- c.closed:
+                return errClosed
+            case <- time.After(time.Duration(ANNOUNCE_RATE_WAIT) * time.Millisecond):
+            }
+        }
+    }
+    return nil
+}
+
+// status takes a snapshot of the state of this Client's local files.
+// It should only be called by the eventHandler.
+func (c *client) status() *clientproto.ClientStatus {
+    status := & clientproto.ClientStatus {
+        Files: make([]clientproto.FileStatus, 0, len(c.localFiles))}
+    for id, localFile := range c.localFiles {
+        _, downloading := c.downloading[id]
+        totalChunks := torrent.NumChunks(localFile.Torrent)
+        status.Files = append(status.Files, clientproto.FileStatus {
+            Name: id.Name,
+            Hash: hex.EncodeToString([]byte(id.Hash)),
+            Path: localFile.Path,
+            Chunks: len(localFile.Chunks),
+            TotalChunks: totalChunks,
+            Complete: len(localFile.Chunks) == totalChunks,
+            Downloading: downloading,
+            AnnounceError: c.announceErrors[id]})
+    }
+
+    // Sort the files, so that the same state always gives the same JSON.
+    sort.Slice(status.Files, func(i, j int) bool {
+        a, b := status.Files[i], status.Files[j]
+        return a.Name < b.Name || (a.Name == b.Name && a.Hash < b.Hash)
+    })
+    return status
+}
+
+// getResponsiveTrackerNode gets a live connection to a Tracker node, and
+// returns the node's host:port.
+// Nodes are tried in the order chosen by this Client's TrackerSelector,
+// skipping the nodes in failed (which may be nil), e.g. because they accepted
+// a connection but then failed an RPC.
+// Each node is pinged, so a node which accepts connections but does not
+// answer within PING_TIMEOUT milliseconds is skipped. So is a node which is
+// catching up with the rest of its cluster, unless no other node answers.
+// However, there is no guarantee that this connection won't die immediately.
+func (c *client) getResponsiveTrackerNode(t torrentproto.Torrent, failed map[string]struct{}) (*rpc.Client, string, error) {
+    var behind *rpc.Client
+    behindHostPort := ""
+    for _, trackerNode := range c.selector.Order(t) {
+        if _, ok := failed[trackerNode.HostPort]; ok {
+            continue
+        }
+        start := time.Now()
+        conn, err := rpc.DialHTTP("tcp", trackerNode.HostPort)
+        var reply *trackerproto.PingReply
+        if err == nil {
+            if reply, err = pingTracker(conn); err != nil {
+                conn.Close()
+            }
+        }
+        c.selector.Observe(trackerNode.HostPort, time.Since(start), err)
+        if err != nil {
+            continue
+        }
+        if !reply.CatchingUp {
+            // Found a live node which is up to date.
+            if behind != nil {
+                behind.Close()
+            }
+            return conn, trackerNode.HostPort, nil;
+        }
+        // Keep the first node which is behind, in case no node is up to date.
+        if behind == nil {
+            behind, behindHostPort = conn, trackerNode.HostPort
+        } else {
+            conn.Close()
+        }
+    }
+    if behind != nil {
+        return behind, behindHostPort, nil
+    }
 
     // Didn't find any live nodes on one pass.
-    return nil, errors.New("Could not find a responsive Tracker")
+    return nil, "", errors.New("Could not find a responsive Tracker")
+}
+
+// pingTracker pings the Tracker node at the other end of conn, giving up
+// after PING_TIMEOUT milliseconds.
+func pingTracker(conn *rpc.Client) (*trackerproto.PingReply, error) {
+    args := &trackerproto.PingArgs{}
+    reply := &trackerproto.PingReply{}
+    call := conn.Go("RemoteTracker.Ping", args, reply, nil)
+    timer := time.NewTimer(time.Duration(PING_TIMEOUT) * time.Millisecond)
+    defer timer.Stop()
+    select {
+    case <-call.Done:
+        if call.Error != nil {
+            return nil, call.Error
+        } else if reply.Status != trackerproto.OK {
+            return nil, errors.New("Tracker did not answer a ping")
+        }
+        return reply, nil
+    case <-timer.C:
+        return nil, errors.New("Timed out pinging Tracker")
+    }
+}
+
+// ProbeTrackers reports which of the Tracker nodes for t are reachable, by
+// host:port.
+// Nodes are dialed concurrently, and a node which does not accept a connection
+// within PROBE_TIMEOUT milliseconds is reported as unreachable.
+// Note that a node which is reachable may still be too slow or too far behind
+// to be useful.
+func ProbeTrackers(t torrentproto.Torrent) map[string]bool {
+    type probe struct {
+        hostPort string
+        reachable bool
+    }
+
+    probes := make(chan probe)
+    timeout := time.Duration(PROBE_TIMEOUT) * time.Millisecond
+    for _, trackerNode := range t.TrackerNodes {
+        go func(hostPort string) {
+            conn, err := net.DialTimeout("tcp", hostPort, timeout)
+            if err == nil {
+                conn.Close()
+            }
+            probes <- probe {hostPort: hostPort, reachable: err == nil}
+        }(trackerNode.HostPort)
+    }
+
+    reachable := make(map[string]bool)
+    for i := 0; i < len(t.TrackerNodes); i++ {
+        p := <- probes
+        reachable[p.hostPort] = p.reachable
+    }
+    return reachable
+}
+
+// trackerConn is a connection to one node of the Tracker for a Torrent.
+// If that node fails, it fails over to another node.
+type trackerConn struct {
+    c *client
+    t torrentproto.Torrent
+    conn *rpc.Client
+
+    // The host:port of the node conn is connected to.
+    hostPort string
+}
+
+// newTrackerConn connects to a responsive node of the Tracker for t.
+func (c *client) newTrackerConn(t torrentproto.Torrent) (*trackerConn, error) {
+    if conn, hostPort, err := c.getResponsiveTrackerNode(t, nil); err != nil {
+        return nil, err
+    } else {
+        return & trackerConn {c: c, t: t, conn: conn, hostPort: hostPort}, nil
+    }
+}
+
+// Returned by trackerConn.CallBefore when the deadline passes.
+var errDeadline = errors.New("Tracker did not respond in time")
+
+// Call makes an RPC to the connected Tracker node.
+// If the RPC fails, it finds another responsive node and tries again, making
+// up to one attempt per node in the Torrent. A node which fails is not tried
+// again during the same call, even if it still accepts connections. The
+// connection to the node which answers is kept for later calls.
+// It returns a non-nil error if every attempt fails.
+func (tc *trackerConn) Call(method string, args interface{}, reply interface{}) error {
+    return tc.CallBefore(time.Time{}, method, args, reply)
+}
+
+// CallBefore makes an RPC like Call, but gives up if it has not succeeded by
+// deadline, and returns errDeadline. If deadline is zero, it never gives up.
+// Once it has given up, reply must not be used.
+func (tc *trackerConn) CallBefore(deadline time.Time, method string, args interface{}, reply interface{}) error {
+    var timeout <-chan time.Time
+    if !deadline.IsZero() {
+        timer := time.NewTimer(time.Until(deadline))
+        defer timer.Stop()
+        timeout = timer.C
+    }
+
+    // The nodes which have failed this call.
+    failed := make(map[string]struct{})
+    var err error
+    for attempt := 0; attempt < len(tc.t.TrackerNodes); attempt++ {
+        if tc.conn == nil {
+            if tc.conn, tc.hostPort, err = tc.c.getResponsiveTrackerNode(tc.t, failed); err != nil {
+                // No nodes are left to try.
+                return err
+            }
+        }
+
+        start := time.Now()
+        select {
+        case call := <-tc.conn.Go(method, args, reply, make(chan *rpc.Call, 1)).Done:
+            if err = call.Error; err == nil {
+                return nil
+            }
+            tc.c.selector.Observe(tc.hostPort, time.Since(start), err)
+            failed[tc.hostPort] = struct{}{}
+        case <-timeout:
+            // Drop the connection, since the RPC may still be running.
+            tc.conn.Close()
+            tc.conn = nil
+            return errDeadline
+        }
+
+        // The node has failed. Connect to another one on the next attempt.
+        tc.conn.Close()
+        tc.conn = nil
+    }
+    return err
+}
+
+// Close closes the connection to the current Tracker node, if there is one.
+func (tc *trackerConn) Close() {
+    if tc.conn != nil {
+        tc.conn.Close()
+        tc.conn = nil
+    }
 }
 
 // downloadFile gets all chunks of a file from Clients which have them.
 // If the chunk is not available, sends a non-nil error to the user.
 // As the chunks are downloaded, it informs the Client that they have arrived
 // and offers them to the Tracker.
+// If the Client verifies downloads, the whole file is checked once all chunks
+// have arrived.
+// Chunks are downloaded by a fixed number of workers, however many chunks the
+// file has. Workers take chunks in the order given by the download's queue.
 func (c *client) downloadFile(download *Download) {
-    // Create a file to hold this chunk.
-    if file, err := os.Create(download.Path); err != nil {
+    // Inform the Client when this download is over. If the Client has been
+    // closed, nothing else will send progress, so close it here.
+    defer func() {
+        select {
+        case c.finishedDownloads <- download:
+        case <- c.closed:
+            if download.Progress != nil {
+                close(download.Progress)
+            }
+        }
+    }()
+
+    // Check that this Client can verify the Torrent's hashes.
+    if _, err := torrent.NewHash(download.Torrent); err != nil {
+        download.Reply <- err
+        return
+    }
+
+    // Create a file to hold the chunks, or open the file an earlier download
+    // left behind.
+    flag := os.O_RDWR | os.O_CREATE
+    if download.resumed == nil {
+        flag |= os.O_TRUNC
+    }
+    file, err := os.OpenFile(download.Path, flag, 0666)
+    if err != nil {
         // Failed to create file at given path.
         download.Reply <- err
         return
-    } else if trackerConn, err := getResponsiveTrackerNode(download.Torrent); err != nil {
-        // Could not contact a tracker.
+    }
+    defer file.Close()
+
+    // The file may have changed since the earlier download, so check the
+    // chunks it left behind. Any which no longer match their hashes are
+    // reported missing, and downloaded again.
+    for _, chunkNum := range download.resumed {
+        chunk, err := torrent.ReadChunk(download.Torrent, file, chunkNum)
+        if err == nil && chunkMatches(download.Torrent, chunkNum, chunk) {
+            continue
+        }
+        if err := c.RefreshChunk(download.Torrent.ID, chunkNum); err != nil {
+            download.Reply <- err
+            return
+        }
+        download.queue.Add(chunkNum)
+    }
+
+    // Put the rarest chunks first, and keep them first as peers come and go,
+    // until the workers are done.
+    if download.order == OrderRarestFirst {
+        c.rankByAvailability(download)
+        done := make(chan struct{})
+        defer close(done)
+        go func() {
+            ticker := time.NewTicker(time.Millisecond * time.Duration(AVAILABILITY_REFRESH))
+            defer ticker.Stop()
+            for {
+                select {
+                case <- ticker.C:
+                    c.rankByAvailability(download)
+                case <- done:
+                    return
+                }
+            }
+        }()
+    }
+
+    // Start the workers.
+    workers := download.workers
+    if numChunks := torrent.NumChunks(download.Torrent); numChunks < workers {
+        workers = numChunks
+    }
+    errs := make(chan error, workers)
+    for i := 0; i < workers; i++ {
+        go func() {
+            errs <- c.downloadWorker(download, file)
+        }()
+    }
+
+    // Workers may be waiting for the consumer to read further into the file
+    // (see ClientConfig.PrefetchWindow), so release them if this Client
+    // closes.
+    stopped := make(chan struct{})
+    defer close(stopped)
+    go func() {
+        select {
+        case <- c.closed:
+            download.queue.Clear()
+        case <- stopped:
+        }
+    }()
+
+    // Wait for every worker to finish.
+    // If one fails, the others stop once they finish their current chunk.
+    for i := 0; i < workers; i++ {
+        if workerErr := <-errs; workerErr != nil && err == nil {
+            err = workerErr
+            download.queue.Clear()
+        }
+    }
+    if err == nil {
+        // Workers which were released by the Client closing leave chunks
+        // behind.
+        select {
+        case <- c.closed:
+            err = errClosed
+        default:
+        }
+    }
+    if err != nil {
         download.Reply <- err
         return
-    } else {
-        // Create a new random number generator to help provide load-balancing
-        // for this download.
-        r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-        // Download the chunks for this file in a random order.
-        for _, chunkNum := range r.Perm(torrent.NumChunks(download.Torrent)) {
-            chunkID := torrentproto.ChunkID {
-                ID: download.Torrent.ID,
-                ChunkNum: chunkNum}
-            trackerArgs := & trackerproto.RequestArgs {Chunk: chunkID}
+    }
+
+    // Check that the chunks add up to the file the torrent describes.
+    for attempt := 0; c.verifyDownloads != VerifyNone; attempt++ {
+        if hash, err := torrent.FileHash(download.Torrent, file); err != nil {
+            // Failed to read back the downloaded file.
+            download.Reply <- err
+            return
+        } else if hash == download.Torrent.ID.Hash {
+            break
+        } else if c.verifyDownloads != VerifyAndRepair || attempt == REPAIR_ATTEMPTS {
+            download.Reply <- errors.New("Downloaded file does not match torrent")
+            return
+        } else if err := c.repairDownload(download, file); err != nil {
+            download.Reply <- err
+            return
+        }
+    }
+
+    // Successfully downloaded and wrote all chunks.
+    download.Reply <- nil
+}
+
+// rankByAvailability asks the Tracker how many peers each chunk of the
+// download's file has, and reorders the download's queue so that the chunks
+// with the fewest peers come first.
+// Availability is only a hint, so if the Tracker cannot say, the queue is left
+// as it is.
+func (c *client) rankByAvailability(download *Download) {
+    trackerConn, err := c.newTrackerConn(download.Torrent)
+    if err != nil {
+        // Unable to get a responsive Tracker node.
+        return
+    }
+    defer trackerConn.Close()
+
+    args := & trackerproto.AvailabilityArgs {ID: download.Torrent.ID}
+    reply := & trackerproto.AvailabilityReply {}
+    if err := trackerConn.Call("RemoteTracker.Availability", args, reply); err != nil {
+        return
+    } else if reply.Status != trackerproto.OK || len(reply.Replication) != torrent.NumChunks(download.Torrent) {
+        return
+    }
+    download.queue.Rank(reply.Replication)
+}
+
+// repairDownload checks each chunk of a downloaded file which does not match
+// its torrent, and fetches any chunk which does not match its hash again.
+// The file's hash cannot tell which chunks are bad, so every chunk is checked.
+// It returns a non-nil error if it fails to fetch a chunk.
+func (c *client) repairDownload(download *Download, file *os.File) error {
+    for _, chunkID := range torrent.AllChunkIDs(download.Torrent) {
+        chunk, err := torrent.ReadChunk(download.Torrent, file, chunkID.ChunkNum)
+        if err == nil && chunkMatches(download.Torrent, chunkID.ChunkNum, chunk) {
+            continue
+        }
+        if _, err := c.fetchChunk(download.Torrent, download.Path, chunkID.ChunkNum); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// downloadWorker downloads chunks from the download's queue into file, until
+// the queue is empty.
+// It returns a non-nil error if it fails to download a chunk, and errClosed if
+// this Client is closed before it is done.
+func (c *client) downloadWorker(download *Download, file *os.File) error {
+    trackerConn, err := c.newTrackerConn(download.Torrent)
+    if err != nil {
+        // Could not contact a tracker.
+        return err
+    }
+    defer trackerConn.Close()
+
+    // Create a new random number generator to help provide load-balancing
+    // for this download.
+    r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+    for chunkNum, ok := download.queue.Next(); ok; chunkNum, ok = download.queue.Next() {
+        chunkID := torrentproto.NewChunkID(download.Torrent.ID, chunkNum)
+        peersFor := func() ([]string, error) {
+            trackerArgs := & trackerproto.RequestArgs {
+                Chunk: chunkID,
+                MaxPeers: REQUEST_PEERS}
             trackerReply := & trackerproto.RequestReply {}
             if err := trackerConn.Call("RemoteTracker.RequestChunk", trackerArgs, trackerReply); err != nil {
-                // Failed to make RPC.
-                download.Reply <- err
-                return
-            } else if trackerReply.ChunkHash != download.Torrent.ChunkHashes[chunkNum] {
-                // This torrent is fake or corrupted.
+                // Failed to make RPC, even after failing over to other nodes.
+                return nil, err
+            }
+            if trackerReply.ChunkHash != download.Torrent.ChunkHashes[chunkNum] {
                 // The hash in the torrent for this chunkNum and torrent ID
                 // (i.e. this ChunkID) does not match the hash for this ChunkID
-                // on the Tracker.
-                // Since the Tracker associates exactly one hash with each
-                // chunkNum and torrentID when a torrent is first registered,
-                // we will get this error if and only if the torrent contains
-                // a bad hash for this chunk.
-                download.Reply <- errors.New("Bad torrent file")
-                return
-            } else if err := downloadChunk(download, file, chunkNum, trackerReply.Peers, r); err != nil {
-                // Failed to download this chunk.
-                download.Reply <- err
-                return
-            } else {
-                // Successfully downloaded and wrote this chunk.
-                // Inform the Client.
-                c.downloadedChunks <- chunkID
+                // on this Tracker node.
+                // Either the torrent is fake or corrupted, or the node is
+                // lagging or faulty, so let the other nodes decide.
+                var err error
+                if trackerReply, err = c.confirmChunkHash(download.Torrent, chunkID); err != nil {
+                    return nil, err
+                }
             }
+            return orderPeers(trackerReply, r), nil
+        }
+        if err := c.retryChunk(download, file, chunkNum, peersFor); err != nil {
+            // Failed to download this chunk.
+            return err
+        }
+
+        // Successfully downloaded and wrote this chunk.
+        // Inform the Client.
+        select {
+        case c.downloadedChunks <- chunkID:
+        case <- c.closed:
+            return errClosed
         }
     }
+    return nil
+}
 
-    // Successfully downloaded and wrote all chunks.
-    download.Reply <- nil
+// confirmChunkHash asks every node of the Tracker for t for the hash of a
+// chunk, once one node has answered with a hash which does not match t.
+// Since the Tracker associates exactly one hash with each chunkNum and
+// torrentID when a torrent is first registered, the nodes should agree; a node
+// which does not is lagging or faulty.
+// If a majority of the nodes agree with t, the reply of one of them is
+// returned, so that the chunk's peers come from a node which agrees with t.
+// Otherwise it returns a non-nil error. Nodes which cannot be reached have no
+// say, but a node which does not know the torrent disagrees with it.
+func (c *client) confirmChunkHash(t torrentproto.Torrent, chunkID torrentproto.ChunkID) (*trackerproto.RequestReply, error) {
+    quorum := len(t.TrackerNodes) / 2 + 1
+    agree, disagree := 0, 0
+    var agreed *trackerproto.RequestReply
+    for _, trackerNode := range t.TrackerNodes {
+        conn, err := rpc.DialHTTP("tcp", trackerNode.HostPort)
+        if err != nil {
+            // Could not contact this node.
+            continue
+        }
+        args := & trackerproto.RequestArgs {Chunk: chunkID}
+        reply := & trackerproto.RequestReply {}
+        err = conn.Call("RemoteTracker.RequestChunk", args, reply)
+        conn.Close()
+        if err != nil {
+            // Failed to make RPC.
+            continue
+        }
+
+        if reply.Status == trackerproto.OK && reply.ChunkHash == t.ChunkHashes[chunkID.ChunkNum] {
+            agree++
+            agreed = reply
+        } else {
+            disagree++
+        }
+    }
+
+    if agree >= quorum {
+        return agreed, nil
+    } else if disagree >= quorum {
+        // This torrent is fake or corrupted.
+        return nil, errors.New("Bad torrent file")
+    } else {
+        return nil, errors.New("Too few Tracker nodes responded to check chunk hash")
+    }
+}
+
+// orderPeers returns the peers in a Tracker's reply in the order in which they
+// should be tried.
+// Complete seeders are tried before partial holders, since they are more
+// likely to stay around. Each group is randomized to help balance load across
+// peers.
+// If the Tracker did not say which peers are seeders, all peers are tried in a
+// random order.
+func orderPeers(reply *trackerproto.RequestReply, r *rand.Rand) []string {
+    groups := [][]string{reply.Seeders, reply.Partial}
+    if len(reply.Seeders) + len(reply.Partial) == 0 {
+        groups = [][]string{reply.Peers}
+    }
+
+    peers := make([]string, 0)
+    for _, group := range groups {
+        for _, peerNum := range r.Perm(len(group)) {
+            peers = append(peers, group[peerNum])
+        }
+    }
+    return peers
+}
+
+// acquireTransfer blocks until this Client may start another chunk transfer.
+func (c *client) acquireTransfer() {
+    if c.transfers != nil {
+        c.transfers <- struct{}{}
+    }
+}
+
+// releaseTransfer records that a chunk transfer has finished.
+func (c *client) releaseTransfer() {
+    if c.transfers != nil {
+        <- c.transfers
+    }
+}
+
+// getChunkFromPeer fetches a chunk from the Client at hostPort, over this
+// Client's pooled connection to it.
+// This counts as one of this Client's chunk transfers while it runs.
+func (c *client) getChunkFromPeer(hostPort string, args *clientproto.GetArgs, reply *clientproto.GetReply) error {
+    c.acquireTransfer()
+    defer c.releaseTransfer()
+
+    return c.peers.call(hostPort, "RemoteClient.GetChunk", args, reply)
+}
+
+// peerEvent passes an event to this Client's PeerEventListener, if it has one.
+// It never blocks: the event is dropped if the listener has fallen behind.
+func (c *client) peerEvent(chunkID torrentproto.ChunkID, hostPort string, outcome clientproto.PeerOutcome, reason string) {
+    if c.peerEvents == nil {
+        return
+    }
+    select {
+    case c.peerEvents <- & clientproto.PeerEvent {
+        ChunkID: chunkID,
+        HostPort: hostPort,
+        Outcome: outcome,
+        Reason: reason}:
+    default:
+    }
+}
+
+// passPeerEvents passes the events for this Client's PeerEventListener on to
+// it, one at a time, until the Client is closed. Events still waiting then
+// are dropped.
+func (c *client) passPeerEvents(pel PeerEventListener) {
+    for {
+        select {
+        case event := <- c.peerEvents:
+            pel.OnPeerEvent(event)
+        case <- c.closed:
+            return
+        }
+    }
+}
+
+// retryChunk downloads and locally writes one chunk, from the peers returned
+// by peersFor, as downloadChunk does.
+// If no peer sends the chunk, it calls peersFor again, since peers come and
+// go, and tries those, up to this Client's chunkRetries more times. It waits
+// retryDelay before the first retry, and twice as long as the last time before
+// each retry after that.
+// If it fails, it returns a non-nil error. Errors from peersFor are returned
+// at once, without retrying.
+func (c *client) retryChunk(download *Download, file *os.File, chunkNum int, peersFor func() ([]string, error)) error {
+    delay := c.retryDelay
+    for attempt := 0; ; attempt++ {
+        peers, err := peersFor()
+        if err != nil {
+            return err
+        }
+        err = c.downloadChunk(download, file, chunkNum, peers)
+        if err == nil || attempt >= c.chunkRetries {
+            return err
+        }
+        select {
+        case <- time.After(delay):
+        case <- c.closed:
+            // Do not hold up closing this Client.
+            return err
+        }
+        delay *= 2
+    }
+}
+
+// reportBadChunk tells the Tracker for t that the peer at hostPort sent this
+// Client a copy of a chunk which did not match the chunk's hash.
+// Reporting is best effort, so failures are ignored: the download goes on
+// with other peers either way.
+func (c *client) reportBadChunk(t torrentproto.Torrent, chunkID torrentproto.ChunkID, hostPort string) {
+    trackerConn, err := c.newTrackerConn(t)
+    if err != nil {
+        // Could not contact a tracker.
+        return
+    }
+    defer trackerConn.Close()
+
+    args := & trackerproto.BadChunkArgs {
+        Chunk: chunkID,
+        HostPort: hostPort,
+        Reporter: c.hostPort,
+        Token: c.trackerToken}
+    trackerConn.Call("RemoteTracker.ReportBadChunk", args, & trackerproto.UpdateReply {})
 }
 
 // downloadChunk attemps to download and locally write one chunk.
+// Peers are tried in the given order. Chunks larger than this Client's stream
+// threshold are streamed from them, and smaller chunks fetched over RPC.
+// This Client is skipped if it appears among the peers, since it does not
+// have the chunk yet.
 // If it fails, it returns a non-nil error.
-func downloadChunk(download *Download, file *os.File, chunkNum int, peers []string, r *rand.Rand) error {
+func (c *client) downloadChunk(download *Download, file *os.File, chunkNum int, peers []string) error {
     // Try peers until one responds with chunk.
-    // Randomize order to help balance load across peers.
     peerArgs := & clientproto.GetArgs{
-        ChunkID: torrentproto.ChunkID {
-            ID: download.Torrent.ID,
-            ChunkNum: chunkNum}}
+        ChunkID: torrentproto.NewChunkID(download.Torrent.ID, chunkNum)}
     peerReply := & clientproto.GetReply{}
-    h := sha1.New()
-    for _, peerNum := range r.Perm(len(peers)) {
-        hostPort := peers[peerNum]
-        if peer, err := rpc.DialHTTP("tcp", hostPort); err != nil {
-            // Failed to connect.
+    h, err := torrent.NewHash(download.Torrent)
+    if err != nil {
+        return err
+    }
+    _, length, err := torrent.ChunkBounds(download.Torrent, chunkNum)
+    if err != nil {
+        return err
+    }
+    for _, hostPort := range peers {
+        if hostPort != c.hostPort {
+            c.peerEvent(peerArgs.ChunkID, hostPort, clientproto.PeerFound, "")
+        }
+    }
+    // The number of bytes at the start of a streamed chunk which are already
+    // in the file, from streams which were cut off.
+    received := 0
+    for _, hostPort := range peers {
+        if hostPort == c.hostPort {
+            // Do not dial this Client.
             continue
-        } else if err := peer.Call("RemoteClient.GetChunk", peerArgs, peerReply); err != nil {
-            // Failed to make RPC.
+        }
+        if length > c.streamThreshold {
+            // Stream the chunk straight into the file, rather than holding it
+            // all in memory. A stream which is cut off is taken up where it
+            // stopped, from the same peer while it keeps sending more of the
+            // chunk, and then from the next peer.
+            var matched bool
+            var err error
+            started := received
+            for {
+                before := received
+                if received, matched, err = c.streamChunkFromPeer(hostPort, download.Torrent, file, chunkNum, received); err == nil || received <= before {
+                    break
+                }
+            }
+            if received == length && err != nil {
+                // The peer sent too much, so none of the chunk can be trusted.
+                received = 0
+            }
+            if err != nil {
+                // Failed to connect, or the peer did not send the chunk.
+                c.peerEvent(peerArgs.ChunkID, hostPort, clientproto.PeerFailed, err.Error())
+                continue
+            } else if !matched {
+                // Chunk had bad hash. It is written again by the next peer.
+                // Only a peer which sent the whole chunk is known to be bad.
+                received = 0
+                c.peerEvent(peerArgs.ChunkID, hostPort, clientproto.PeerFailed, "Peer sent chunk with bad hash")
+                if started == 0 {
+                    go c.reportBadChunk(download.Torrent, peerArgs.ChunkID, hostPort)
+                }
+                continue
+            }
+            // Successfully downloaded and wrote chunk.
+            c.peerEvent(peerArgs.ChunkID, hostPort, clientproto.PeerSent, "")
+            return nil
+        }
+        if err := c.getChunkFromPeer(hostPort, peerArgs, peerReply); err != nil {
+            // Failed to connect or to make RPC.
+            c.peerEvent(peerArgs.ChunkID, hostPort, clientproto.PeerFailed, err.Error())
+            continue
+        }
+
+        if peerReply.Status != clientproto.OK {
+            // Peer does not have the chunk, or will not send it.
+            c.peerEvent(peerArgs.ChunkID, hostPort, clientproto.PeerFailed, "Peer did not send chunk")
             continue
         }
 
@@ -385,8 +2556,14 @@ func downloadChunk(download *Download, file *os.File, chunkNum int, peers []stri
         h.Write(chunk)
         if string(h.Sum(nil)) != download.Torrent.ChunkHashes[chunkNum] {
             // Chunk had bad hash.
+            // Tell the Tracker, so that it stops handing out this peer
+            // once enough Clients agree.
+            c.peerEvent(peerArgs.ChunkID, hostPort, clientproto.PeerFailed, "Peer sent chunk with bad hash")
+            go c.reportBadChunk(download.Torrent, peerArgs.ChunkID, hostPort)
             continue
-        } else if err := torrent.WriteChunk(download.Torrent, file, chunkNum, chunk); err != nil {
+        }
+        c.peerEvent(peerArgs.ChunkID, hostPort, clientproto.PeerSent, "")
+        if err := torrent.WriteChunk(download.Torrent, file, chunkNum, chunk); err != nil {
             // Failed to write chunk locally.
             continue
         } else {
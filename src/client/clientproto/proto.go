@@ -10,6 +10,11 @@ const (
     LocalFileAdd Operation = iota + 1
     LocalFileDelete
     LocalFileUpdate
+
+    // A file which the Client has stopped serving (see Client.RemoveFile).
+    // This is the same operation as LocalFileDelete, so that listeners
+    // which handle that already handle removals.
+    LocalFileRemove = LocalFileDelete
 )
 
 // Statuses for client RPCs.
@@ -17,6 +22,7 @@ type Status int
 const (
     OK        Status = iota + 1 // RPC was a success
     ChunkNotFound               // The requested chunk is not available
+    ChunkTooLarge               // The requested chunk is bigger than the Client will serve
 )
 
 // Local representation of a torrented/torrentable file.
@@ -35,6 +41,46 @@ type LocalFileChange struct {
     *LocalFile
 }
 
+// An event in a download, sent when a chunk arrives.
+// The counts are as of this event, so a consumer which misses some events
+// still learns how far the download has got from the next one.
+type Progress struct {
+    ChunkNum int // The chunk which arrived
+    Chunks int // The number of chunks of the file which this Client has
+    TotalChunks int // The number of chunks in the file
+}
+
+// What became of a peer which a download tried to get a chunk from.
+type PeerOutcome int
+const (
+    PeerFound PeerOutcome = iota + 1 // The Tracker listed the peer as having the chunk
+    PeerSent // The peer sent the chunk, and it matched its hash
+    PeerFailed // The peer could not be reached, or did not send the chunk
+)
+
+// An event in a download, sent to a Client's PeerEventListener.
+type PeerEvent struct {
+    torrentproto.ChunkID // The chunk being downloaded
+    HostPort string // The peer's host:port
+    Outcome PeerOutcome
+    Reason string // Why the peer failed, for PeerFailed events
+}
+
+// What downloading a file would involve, as reported by Client.PlanDownload.
+type DownloadPlan struct {
+    Chunks []ChunkPlan // One for each chunk of the file, by chunk number
+    TotalBytes int // The size of the file
+    FetchBytes int // The bytes in chunks which this Client does not have
+    Feasible bool // Whether every chunk this Client does not have has a peer
+}
+
+// What downloading one chunk of a file would involve.
+type ChunkPlan struct {
+    ChunkNum int
+    Peers int // The number of peers, other than this Client, with the chunk
+    Local bool // Whether this Client already has the chunk
+}
+
 // Information about a GetChunks RPC
 type GetArgs struct {
     torrentproto.ChunkID // ID and chunk number for the relevant torrent chunk
@@ -45,3 +91,21 @@ type GetReply struct {
     Status Status
     Chunk []byte
 }
+
+// The state of a Client's local files, as reported by Client.StatusJSON.
+// The JSON field names are part of the Client's API, and should not change.
+type ClientStatus struct {
+    Files []FileStatus `json:"files"` // Sorted by torrent name, then hash
+}
+
+// The state of one local file.
+type FileStatus struct {
+    Name string `json:"name"` // The name in the Torrent's ID
+    Hash string `json:"hash"` // The hash in the Torrent's ID, in hexadecimal
+    Path string `json:"path"` // Path to the local copy of the file
+    Chunks int `json:"chunks"` // The number of chunks this Client has
+    TotalChunks int `json:"total_chunks"` // The number of chunks in the file
+    Complete bool `json:"complete"` // Whether this Client has every chunk
+    Downloading bool `json:"downloading"` // Whether a download is in progress
+    AnnounceError string `json:"announce_error,omitempty"` // Why the Tracker was not told of the chunks again in the last round, if it was not
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+    "encoding/gob"
+    "fmt"
+    "os"
+
+    "client/clientproto"
+    "torrent/torrentproto"
+)
+
+// The format of state files written by this version of ByteTorrent. Bump this
+// whenever the format changes, so that loadLocalFiles refuses files it would
+// misread.
+const STATE_VERSION int = 2
+
+// The progress of an offer which was in progress when a state file was saved.
+// The Tracker has confirmed Confirmed, and not yet the other chunks.
+type offerRecord struct {
+    ID torrentproto.ID
+    Confirmed map[int]struct{}
+}
+
+// A persistingListener writes a Client's local files to a state file whenever
+// they change, then passes the change on, so that a Client restarted with the
+// same state file knows which chunks it holds.
+// It is only called by the Client's eventHandler, which owns localFiles and
+// offers.
+type persistingListener struct {
+    next LocalFileListener
+    path string
+    localFiles map[torrentproto.ID]*clientproto.LocalFile
+
+    // The chunks the Tracker has confirmed of each offer in progress, by
+    // Torrent ID.
+    offers map[torrentproto.ID]map[int]struct{}
+}
+
+// Saving is best effort: if the state file cannot be written, the Client
+// carries on, and the next change tries again.
+func (l *persistingListener) OnChange(change *clientproto.LocalFileChange) {
+    l.save()
+    l.next.OnChange(change)
+}
+
+// save writes the local files and offers to the state file, as best effort.
+func (l *persistingListener) save() {
+    saveState(l.path, l.localFiles, l.offers)
+}
+
+// saveState writes localFiles, then the progress of offers, to the state file
+// at path, preceded by STATE_VERSION.
+// The state is written to a temporary file which then replaces the state
+// file, so that a crash part way through leaves the old state file whole.
+func saveState(path string, localFiles map[torrentproto.ID]*clientproto.LocalFile, offers map[torrentproto.ID]map[int]struct{}) error {
+    files := make([]*clientproto.LocalFile, 0, len(localFiles))
+    for _, localFile := range localFiles {
+        files = append(files, localFile)
+    }
+    records := make([]offerRecord, 0, len(offers))
+    for id, confirmed := range offers {
+        records = append(records, offerRecord {ID: id, Confirmed: confirmed})
+    }
+
+    tmpPath := path + ".tmp"
+    file, err := os.Create(tmpPath)
+    if err != nil {
+        return err
+    }
+    encoder := gob.NewEncoder(file)
+    if err := encoder.Encode(STATE_VERSION); err != nil {
+        file.Close()
+        return err
+    } else if err := encoder.Encode(files); err != nil {
+        file.Close()
+        return err
+    } else if err := encoder.Encode(records); err != nil {
+        file.Close()
+        return err
+    } else if err := file.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmpPath, path)
+}
+
+// loadState reads the local files saved in the state file at path, and the
+// chunks the Tracker had confirmed of each offer in progress, both by torrent
+// ID.
+// Files which are no longer at their Path are dropped, along with their
+// offers. A state file which does not exist yet holds no files.
+// Returns an error if the file was written in a format other than
+// STATE_VERSION.
+func loadState(path string) (map[torrentproto.ID]*clientproto.LocalFile, map[torrentproto.ID]map[int]struct{}, error) {
+    localFiles := make(map[torrentproto.ID]*clientproto.LocalFile)
+    offers := make(map[torrentproto.ID]map[int]struct{})
+    file, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return localFiles, offers, nil
+    } else if err != nil {
+        return nil, nil, err
+    }
+    defer file.Close()
+
+    var version int
+    var files []*clientproto.LocalFile
+    var records []offerRecord
+    decoder := gob.NewDecoder(file)
+    if err := decoder.Decode(&version); err != nil {
+        return nil, nil, fmt.Errorf("%s is not a state file: %v", path, err)
+    } else if version != STATE_VERSION {
+        return nil, nil, fmt.Errorf("%s is in state file format %d, not %d", path, version, STATE_VERSION)
+    } else if err := decoder.Decode(&files); err != nil {
+        return nil, nil, err
+    } else if err := decoder.Decode(&records); err != nil {
+        return nil, nil, err
+    }
+
+    for _, localFile := range files {
+        if _, err := os.Stat(localFile.Path); err != nil {
+            // The file has gone, so there is nothing left to serve.
+            continue
+        }
+        if localFile.Chunks == nil {
+            // gob leaves a file without chunks with a nil set.
+            localFile.Chunks = make(map[int]struct{})
+        }
+        localFiles[localFile.Torrent.ID] = localFile
+    }
+    for _, record := range records {
+        if _, ok := localFiles[record.ID]; !ok {
+            continue
+        }
+        if record.Confirmed == nil {
+            record.Confirmed = make(map[int]struct{})
+        }
+        offers[record.ID] = record.Confirmed
+    }
+    return localFiles, offers, nil
+}
@@ -0,0 +1,17 @@
+package client
+
+import (
+    "client/clientproto"
+)
+
+// Applications should implement this interface if they wish to know how a
+// torrent Client gets on with the peers it downloads chunks from, e.g. to show
+// the state of the swarm.
+// Events are passed to a PeerEventListener by a goroutine of its own, so that
+// a slow listener never holds up a download. If PEER_EVENT_BUFFER events are
+// already waiting for the listener, further events are dropped.
+type PeerEventListener interface {
+    // OnPeerEvent notifies a PeerEventListener when a download finds,
+    // fetches a chunk from, or gives up on a peer.
+    OnPeerEvent(*clientproto.PeerEvent)
+}
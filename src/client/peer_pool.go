@@ -0,0 +1,163 @@
+package client
+
+import (
+    "errors"
+    "net/rpc"
+    "sync"
+    "time"
+)
+
+// A peerPool keeps connections to the peers a Client fetches chunks from, so
+// that a download pulling many chunks from the same few peers dials each of
+// them once, rather than once per chunk.
+// Each peer has at most one connection, which every transfer from that peer
+// shares. A connection which fails is closed and forgotten, and the next
+// transfer from that peer dials again.
+// A peerPool is safe for concurrent use.
+type peerPool struct {
+    mut sync.Mutex
+
+    // Maps peer host:port -> the connection to that peer.
+    conns map[string]*pooledConn
+
+    // The number of connections kept once no transfer is using them. The
+    // connections idle the longest are closed first.
+    size int
+
+    // Whether the pool has been closed, after which it dials no more peers.
+    closed bool
+}
+
+// A connection to one peer, and how it is being used.
+type pooledConn struct {
+    conn *rpc.Client
+
+    // The number of transfers using conn.
+    users int
+
+    // When the last transfer using conn finished.
+    lastUsed time.Time
+}
+
+// newPeerPool creates a pool which keeps up to size idle connections.
+func newPeerPool(size int) *peerPool {
+    return & peerPool {
+        conns: make(map[string]*pooledConn),
+        size: size}
+}
+
+// call calls method on the peer at hostPort, over the pooled connection to
+// that peer if there is one.
+// If a pooled connection turns out to have died since it was last used (e.g.
+// because the peer restarted), the peer is dialed again and the call retried
+// once.
+func (p *peerPool) call(hostPort, method string, args interface{}, reply interface{}) error {
+    for retried := false; ; retried = true {
+        conn, reused, err := p.get(hostPort)
+        if err != nil {
+            return err
+        }
+        err = conn.Call(method, args, reply)
+
+        // An error from the peer itself leaves the connection healthy.
+        _, remote := err.(rpc.ServerError)
+        p.put(hostPort, conn, err == nil || remote)
+        if err == nil || remote || !reused || retried {
+            return err
+        }
+    }
+}
+
+// get returns a connection to the peer at hostPort, and whether it was pooled
+// rather than just dialed.
+// The connection must be given back with put.
+func (p *peerPool) get(hostPort string) (*rpc.Client, bool, error) {
+    p.mut.Lock()
+    if p.closed {
+        p.mut.Unlock()
+        return nil, false, errors.New("Client is closed")
+    }
+    if pooled, ok := p.conns[hostPort]; ok {
+        pooled.users++
+        p.mut.Unlock()
+        return pooled.conn, true, nil
+    }
+    p.mut.Unlock()
+
+    // Dial without holding the lock, so that a slow peer does not hold up
+    // transfers from the others.
+    conn, err := rpc.DialHTTP("tcp", hostPort)
+    if err != nil {
+        return nil, false, err
+    }
+
+    p.mut.Lock()
+    defer p.mut.Unlock()
+    if p.closed {
+        conn.Close()
+        return nil, false, errors.New("Client is closed")
+    }
+    if pooled, ok := p.conns[hostPort]; ok {
+        // Another transfer dialed this peer first, so share its connection.
+        conn.Close()
+        pooled.users++
+        return pooled.conn, true, nil
+    }
+    p.conns[hostPort] = & pooledConn {conn: conn, users: 1}
+    p.evict()
+    return conn, false, nil
+}
+
+// put gives back a connection which get returned. If the connection is not
+// healthy, it is closed and forgotten.
+func (p *peerPool) put(hostPort string, conn *rpc.Client, healthy bool) {
+    p.mut.Lock()
+    defer p.mut.Unlock()
+    pooled, ok := p.conns[hostPort]
+    if !ok || pooled.conn != conn {
+        // Already forgotten, after another transfer found it dead, or when
+        // the pool was closed.
+        if !healthy {
+            conn.Close()
+        }
+        return
+    }
+    pooled.users--
+    pooled.lastUsed = time.Now()
+    if !healthy {
+        delete(p.conns, hostPort)
+        conn.Close()
+    }
+    p.evict()
+}
+
+// evict closes the connections idle the longest until the pool holds no more
+// than size connections, or every connection left is in use.
+// Must be called with mut held.
+func (p *peerPool) evict() {
+    for len(p.conns) > p.size {
+        oldest := ""
+        for hostPort, pooled := range p.conns {
+            if pooled.users == 0 && (oldest == "" || pooled.lastUsed.Before(p.conns[oldest].lastUsed)) {
+                oldest = hostPort
+            }
+        }
+        if oldest == "" {
+            return
+        }
+        p.conns[oldest].conn.Close()
+        delete(p.conns, oldest)
+    }
+}
+
+// close closes every pooled connection, and stops the pool dialing any more.
+// Transfers still using a connection fail.
+func (p *peerPool) close() {
+    p.mut.Lock()
+    defer p.mut.Unlock()
+    p.closed = true
+    for hostPort, pooled := range p.conns {
+        pooled.conn.Close()
+        delete(p.conns, hostPort)
+    }
+}
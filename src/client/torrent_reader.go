@@ -0,0 +1,87 @@
+package client
+
+import (
+    "errors"
+    "io"
+    "os"
+
+    "torrent"
+    "torrent/torrentproto"
+)
+
+// Reads the data of a local file, but only from chunks which its Client has.
+// The file is opened for each read, so that the reader does not need closing.
+type torrentReader struct {
+    c *client
+    t torrentproto.Torrent
+}
+
+// ReadAt reads len(p) bytes starting at offset off in the Torrent's data.
+// If the read would include bytes of a chunk which the Client does not have,
+// it reads only the bytes before that chunk, and returns an error.
+// If the read would go past the end of the data, it reads only the bytes
+// before the end, and returns io.EOF.
+func (r *torrentReader) ReadAt(p []byte, off int64) (int, error) {
+    if off < 0 {
+        return 0, errors.New("Negative offset")
+    }
+
+    // Trim the read to the end of the data.
+    var eof error
+    size := int64(r.t.FileSize)
+    if off >= size {
+        return 0, io.EOF
+    } else if off + int64(len(p)) > size {
+        p = p[:size - off]
+        eof = io.EOF
+    }
+    if len(p) == 0 {
+        return 0, eof
+    }
+
+    // Find the chunks covered by the read, and make sure the Client has them.
+    first := int(off / int64(r.t.ChunkSize))
+    last := int((off + int64(len(p)) - 1) / int64(r.t.ChunkSize))
+    // This also moves the window of a download of the file along, and has a
+    // missing chunk fetched next (see ClientConfig.PrefetchWindow).
+    missingErr := error(nil)
+    replyChan := make(chan *LookupResult)
+    lookup := & Lookup {
+        ID: r.t.ID,
+        First: first,
+        Last: last,
+        Reading: true,
+        Reply: replyChan}
+    select {
+    case r.c.lookups <- lookup:
+    case <- r.c.closed:
+        return 0, errClosed
+    }
+    result := <-replyChan
+    if !result.Found {
+        return 0, errors.New("No local file for torrent")
+    } else if result.Missing != -1 {
+        // Read only up to the missing chunk, which may be the chunk the read
+        // starts in.
+        missingAt := torrent.ChunkOffset(r.t, result.Missing)
+        if missingAt <= off {
+            return 0, errors.New("Chunk has not been downloaded")
+        }
+        p = p[:missingAt - off]
+        missingErr = errors.New("Chunk has not been downloaded")
+    }
+
+    file, err := os.Open(result.Path)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+
+    n, err := file.ReadAt(p, off)
+    if err != nil {
+        return n, err
+    } else if missingErr != nil {
+        return n, missingErr
+    }
+    return n, eof
+}
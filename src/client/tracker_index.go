@@ -0,0 +1,63 @@
+package client
+
+import (
+    "torrent/torrentproto"
+)
+
+// A trackerIndex records which Tracker nodes a Client talks to on behalf of
+// each of its Torrents.
+// Torrents may be registered with different Tracker clusters, so work which
+// involves every Tracker the Client uses (e.g. re-confirming chunks, or telling
+// Trackers that the Client is leaving) must go to the right nodes for each
+// Torrent.
+// A trackerIndex is owned by the Client's eventHandler, and is not safe for
+// concurrent use.
+type trackerIndex struct {
+    // Maps Torrent ID -> the Tracker nodes listed in that Torrent.
+    nodes map[torrentproto.ID][]torrentproto.TrackerNode
+
+    // Maps Tracker node host:port -> IDs of Torrents which list that node.
+    torrents map[string](map[torrentproto.ID]struct{})
+}
+
+func newTrackerIndex() *trackerIndex {
+    return & trackerIndex {
+        nodes: make(map[torrentproto.ID][]torrentproto.TrackerNode),
+        torrents: make(map[string](map[torrentproto.ID]struct{}))}
+}
+
+// add records the Tracker nodes for t, replacing any nodes recorded for a
+// Torrent with the same ID.
+func (ti *trackerIndex) add(t torrentproto.Torrent) {
+    ti.remove(t.ID)
+    ti.nodes[t.ID] = t.TrackerNodes
+    for _, trackerNode := range t.TrackerNodes {
+        if _, ok := ti.torrents[trackerNode.HostPort]; !ok {
+            ti.torrents[trackerNode.HostPort] = make(map[torrentproto.ID]struct{})
+        }
+        ti.torrents[trackerNode.HostPort][t.ID] = struct{}{}
+    }
+}
+
+// remove forgets the Tracker nodes for the Torrent with the given ID.
+func (ti *trackerIndex) remove(id torrentproto.ID) {
+    for _, trackerNode := range ti.nodes[id] {
+        delete(ti.torrents[trackerNode.HostPort], id)
+        if len(ti.torrents[trackerNode.HostPort]) == 0 {
+            delete(ti.torrents, trackerNode.HostPort)
+        }
+    }
+    delete(ti.nodes, id)
+}
+
+// byNode returns a copy of the index, mapping each Tracker node's host:port
+// to the IDs of the Torrents which list it.
+func (ti *trackerIndex) byNode() map[string][]torrentproto.ID {
+    byNode := make(map[string][]torrentproto.ID)
+    for hostPort, ids := range ti.torrents {
+        for id, _ := range ids {
+            byNode[hostPort] = append(byNode[hostPort], id)
+        }
+    }
+    return byNode
+}
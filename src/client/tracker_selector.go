@@ -0,0 +1,99 @@
+package client
+
+import (
+    "math/rand"
+    "sort"
+    "sync"
+    "time"
+
+    "torrent/torrentproto"
+)
+
+// Tries the Tracker nodes of a Torrent with the highest Weight first, and
+// nodes of equal Weight in the order they are listed.
+// Every Client sharing a Torrent will contact the same first node.
+type listSelector struct {}
+
+// NewListSelector creates a TrackerSelector which tries nodes by Weight, then
+// in list order.
+func NewListSelector() TrackerSelector {
+    return & listSelector {}
+}
+
+func (s *listSelector) Order(t torrentproto.Torrent) []torrentproto.TrackerNode {
+    nodes := make([]torrentproto.TrackerNode, len(t.TrackerNodes))
+    copy(nodes, t.TrackerNodes)
+    sort.SliceStable(nodes, func(i, j int) bool {
+        return nodes[i].Weight > nodes[j].Weight
+    })
+    return nodes
+}
+
+func (s *listSelector) Observe(hostPort string, rtt time.Duration, err error) {}
+
+// Tries the Tracker nodes of a Torrent in a random order, which spreads the
+// load of many Clients across the Tracker.
+type randomSelector struct {
+    // Guards r, which is not safe for concurrent use.
+    mut sync.Mutex
+    r *rand.Rand
+}
+
+// NewRandomSelector creates a TrackerSelector which tries nodes in a random
+// order.
+func NewRandomSelector() TrackerSelector {
+    return & randomSelector {
+        r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *randomSelector) Order(t torrentproto.Torrent) []torrentproto.TrackerNode {
+    s.mut.Lock()
+    perm := s.r.Perm(len(t.TrackerNodes))
+    s.mut.Unlock()
+
+    nodes := make([]torrentproto.TrackerNode, len(perm))
+    for i, nodeNum := range perm {
+        nodes[i] = t.TrackerNodes[nodeNum]
+    }
+    return nodes
+}
+
+func (s *randomSelector) Observe(hostPort string, rtt time.Duration, err error) {}
+
+// Tries the Tracker nodes of a Torrent starting from a different node each
+// time, cycling through the nodes in list order.
+// Each Torrent is cycled through separately.
+type roundRobinSelector struct {
+    // Guards next.
+    mut sync.Mutex
+
+    // Maps Torrent IDs to the index of the node to try first next time.
+    next map[torrentproto.ID]int
+}
+
+// NewRoundRobinSelector creates a TrackerSelector which rotates the node it
+// tries first.
+func NewRoundRobinSelector() TrackerSelector {
+    return & roundRobinSelector {
+        next: make(map[torrentproto.ID]int)}
+}
+
+func (s *roundRobinSelector) Order(t torrentproto.Torrent) []torrentproto.TrackerNode {
+    numNodes := len(t.TrackerNodes)
+    if numNodes == 0 {
+        return t.TrackerNodes
+    }
+
+    s.mut.Lock()
+    first := s.next[t.ID] % numNodes
+    s.next[t.ID] = (first + 1) % numNodes
+    s.mut.Unlock()
+
+    nodes := make([]torrentproto.TrackerNode, numNodes)
+    for i := range nodes {
+        nodes[i] = t.TrackerNodes[(first + i) % numNodes]
+    }
+    return nodes
+}
+
+func (s *roundRobinSelector) Observe(hostPort string, rtt time.Duration, err error) {}
@@ -0,0 +1,21 @@
+package client
+
+import (
+    "time"
+
+    "torrent/torrentproto"
+)
+
+// A TrackerSelector decides which nodes of a Torrent's Tracker a Client
+// contacts first.
+// Its methods may be called from several goroutines at once.
+type TrackerSelector interface {
+    // Order returns the Tracker nodes of t in the order in which they should be
+    // tried.
+    Order(t torrentproto.Torrent) []torrentproto.TrackerNode
+
+    // Observe informs a TrackerSelector that contacting the node at hostPort
+    // took rtt. err is non-nil if contacting the node failed.
+    // Selectors which prefer faster nodes can use this to measure them.
+    Observe(hostPort string, rtt time.Duration, err error)
+}
@@ -6,9 +6,13 @@
 package dummytracker
 
 import (
+    "math/rand"
     "net"
     "net/http"
     "net/rpc"
+    "sort"
+    "sync"
+    "time"
 
     "torrent"
     "torrent/torrentproto"
@@ -25,58 +29,131 @@ type Confirm struct {
     Reply chan *trackerproto.UpdateReply
 }
 
+type ConfirmMany struct {
+    Args  *trackerproto.ConfirmChunksArgs
+    Reply chan *trackerproto.UpdateReply
+}
+
 type Report struct {
     Args  *trackerproto.ReportArgs
     Reply chan *trackerproto.UpdateReply
 }
 
+type BadChunk struct {
+    Args  *trackerproto.BadChunkArgs
+    Reply chan *trackerproto.UpdateReply
+}
+
 type Create struct {
     Args  *trackerproto.CreateArgs
     Reply chan *trackerproto.UpdateReply
 }
 
+type Has struct {
+    Args  *trackerproto.HasArgs
+    Reply chan *trackerproto.HasReply
+}
+
+type AvailabilityQuery struct {
+    Args  *trackerproto.AvailabilityArgs
+    Reply chan *trackerproto.AvailabilityReply
+}
+
 type GetTrackers struct {
     Args  *trackerproto.TrackersArgs
     Reply chan *trackerproto.TrackersReply
 }
 
+type List struct {
+    Args  *trackerproto.ListArgs
+    Reply chan *trackerproto.ListReply
+}
+
+type Ping struct {
+    Args  *trackerproto.PingArgs
+    Reply chan *trackerproto.PingReply
+}
+
 type dummyTracker struct {
     // Set-up
     hostPort    string
+    ln          net.Listener
+    closed      chan struct{}
+    closeOnce   sync.Once
 
     // Channels for rpc calls
     requests    chan *Request
     confirms    chan *Confirm
+    confirmManys chan *ConfirmMany
     reports     chan *Report
+    badChunks   chan *BadChunk
     creates     chan *Create
+    hases       chan *Has
+    availQueries chan *AvailabilityQuery
     getTrackers chan *GetTrackers
+    lists       chan *List
+    pings       chan *Ping
+
+    // The number of changes made so far, reported like a real Tracker
+    // node's SeqNum
+    seqNum     int
 
     // Actual data storage
     torrents   map[torrentproto.ID]torrentproto.Torrent              // Map the torrentID to the Torrent information
     peers      map[torrentproto.ChunkID](map[string](struct{})) // Maps chunk info -> list of host:port with that chunk
+    seeders    map[torrentproto.ID](map[string](struct{}))      // Maps torrent ID -> list of host:port with every chunk
 }
 
+// New starts a dummy Tracker which listens on the given host:port.
 func New(hostPort string) (DummyTracker, error) {
+    if ln, err := net.Listen("tcp", hostPort); err != nil {
+        return nil, err
+    } else {
+        return serve(hostPort, ln)
+    }
+}
+
+// NewWithListener starts a dummy Tracker which serves RPCs on connections
+// accepted by ln, e.g. a listener on port 0 which a test opened.
+// The dummy Tracker reports the address of ln as its host:port.
+func NewWithListener(ln net.Listener) (DummyTracker, error) {
+    return serve(ln.Addr().String(), ln)
+}
+
+// serve starts a dummy Tracker which reports the given host:port, and serves
+// RPCs on connections accepted by ln.
+func serve(hostPort string, ln net.Listener) (DummyTracker, error) {
     dt := & dummyTracker{
         hostPort:             hostPort,
+        ln:                   ln,
+        closed:               make(chan struct{}),
         requests:             make(chan *Request),
         confirms:             make(chan *Confirm),
+        confirmManys:         make(chan *ConfirmMany),
         reports:              make(chan *Report),
+        badChunks:            make(chan *BadChunk),
         creates:              make(chan *Create),
+        hases:                make(chan *Has),
+        availQueries:         make(chan *AvailabilityQuery),
         getTrackers:          make(chan *GetTrackers),
+        lists:                make(chan *List),
+        pings:                make(chan *Ping),
         torrents:             make(map[torrentproto.ID]torrentproto.Torrent),
-        peers:                make(map[torrentproto.ChunkID](map[string](struct{})))}
+        peers:                make(map[torrentproto.ChunkID](map[string](struct{}))),
+        seeders:              make(map[torrentproto.ID](map[string](struct{})))}
 
-    // Attempt to service connections on the given port.
-    // Then, configure this TrackerServer to receive RPCs over HTTP on a
+    // Configure this TrackerServer to receive RPCs over HTTP on a
     // tracker.Tracker interface.
-    if ln, lnErr := net.Listen("tcp", hostPort); lnErr != nil {
-        return nil, lnErr
-    } else if regErr := rpc.RegisterName("RemoteTracker", Wrap(dt)); regErr != nil {
+    // It uses its own RPC server and HTTP mux, so that other servers can run
+    // in the same process.
+    srv := rpc.NewServer()
+    if regErr := srv.RegisterName("RemoteTracker", Wrap(dt)); regErr != nil {
+        ln.Close()
         return nil, regErr
     } else {
-        rpc.HandleHTTP()
-        go http.Serve(ln, nil)
+        mux := http.NewServeMux()
+        mux.Handle(rpc.DefaultRPCPath, srv)
+        go http.Serve(ln, mux)
 
         // Start this TrackerServer's eventHandler, which will respond to RPCs,
         // and return it.
@@ -95,6 +172,16 @@ func (dt *dummyTracker) ReportMissing(args *trackerproto.ReportArgs, reply *trac
     return nil
 }
 
+func (dt *dummyTracker) ReportBadChunk(args *trackerproto.BadChunkArgs, reply *trackerproto.UpdateReply) error {
+    replyChan := make(chan *trackerproto.UpdateReply)
+    bad := &BadChunk{
+        Args:  args,
+        Reply: replyChan}
+    dt.badChunks <- bad
+    *reply = *(<-replyChan)
+    return nil
+}
+
 func (dt *dummyTracker) ConfirmChunk(args *trackerproto.ConfirmArgs, reply *trackerproto.UpdateReply) error {
     replyChan := make(chan *trackerproto.UpdateReply)
     confirm := &Confirm{
@@ -105,6 +192,16 @@ func (dt *dummyTracker) ConfirmChunk(args *trackerproto.ConfirmArgs, reply *trac
     return nil
 }
 
+func (dt *dummyTracker) ConfirmChunks(args *trackerproto.ConfirmChunksArgs, reply *trackerproto.UpdateReply) error {
+    replyChan := make(chan *trackerproto.UpdateReply)
+    confirm := &ConfirmMany{
+        Args:  args,
+        Reply: replyChan}
+    dt.confirmManys <- confirm
+    *reply = *(<-replyChan)
+    return nil
+}
+
 func (dt *dummyTracker) CreateEntry(args *trackerproto.CreateArgs, reply *trackerproto.UpdateReply) error {
     replyChan := make(chan *trackerproto.UpdateReply)
     create := &Create{
@@ -125,6 +222,26 @@ func (dt *dummyTracker) RequestChunk(args *trackerproto.RequestArgs, reply *trac
     return nil
 }
 
+func (dt *dummyTracker) PeerHasChunk(args *trackerproto.HasArgs, reply *trackerproto.HasReply) error {
+    replyChan := make(chan *trackerproto.HasReply)
+    has := &Has{
+        Args:  args,
+        Reply: replyChan}
+    dt.hases <- has
+    *reply = *(<-replyChan)
+    return nil
+}
+
+func (dt *dummyTracker) Availability(args *trackerproto.AvailabilityArgs, reply *trackerproto.AvailabilityReply) error {
+    replyChan := make(chan *trackerproto.AvailabilityReply)
+    query := &AvailabilityQuery{
+        Args:  args,
+        Reply: replyChan}
+    dt.availQueries <- query
+    *reply = *(<-replyChan)
+    return nil
+}
+
 func (dt *dummyTracker) GetTrackers(args *trackerproto.TrackersArgs, reply *trackerproto.TrackersReply) error {
     replyChan := make(chan *trackerproto.TrackersReply)
     trackers := &GetTrackers{
@@ -135,9 +252,38 @@ func (dt *dummyTracker) GetTrackers(args *trackerproto.TrackersArgs, reply *trac
     return nil
 }
 
+func (dt *dummyTracker) ListTorrents(args *trackerproto.ListArgs, reply *trackerproto.ListReply) error {
+    replyChan := make(chan *trackerproto.ListReply)
+    list := &List{
+        Args:  args,
+        Reply: replyChan}
+    dt.lists <- list
+    *reply = *(<-replyChan)
+    return nil
+}
+
+func (dt *dummyTracker) Ping(args *trackerproto.PingArgs, reply *trackerproto.PingReply) error {
+    replyChan := make(chan *trackerproto.PingReply)
+    ping := &Ping{
+        Args:  args,
+        Reply: replyChan}
+    dt.pings <- ping
+    *reply = *(<-replyChan)
+    return nil
+}
+
+func (dt *dummyTracker) Close() {
+    dt.closeOnce.Do(func() {
+        close(dt.closed)
+        dt.ln.Close()
+    })
+}
+
 func (dt *dummyTracker) eventHandler() {
     for {
         select {
+        case <-dt.closed:
+            return
         case rep := <-dt.reports:
             // A client has reported that it does not have a chunk
             if tor, ok := dt.torrents[rep.Args.Chunk.ID]; !ok {
@@ -148,9 +294,32 @@ func (dt *dummyTracker) eventHandler() {
                 rep.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OutOfRange}
             } else {
                 // Remove this torrent from the client's record.
+                // A client missing a chunk is no longer a complete seeder.
                 delete(dt.peers[rep.Args.Chunk], rep.Args.HostPort)
+                delete(dt.seeders[rep.Args.Chunk.ID], rep.Args.HostPort)
+                dt.seqNum++
                 rep.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OK}
             }
+        case bad := <-dt.badChunks:
+            // A client has reported that a peer sent it a bad copy of a chunk
+            if tor, ok := dt.torrents[bad.Args.Chunk.ID]; !ok {
+                // File does not exist
+                bad.Reply <- &trackerproto.UpdateReply{Status: trackerproto.FileNotFound}
+            } else if bad.Args.Chunk.ChunkNum < 0 || bad.Args.Chunk.ChunkNum >= torrent.NumChunks(tor) {
+                // ChunkNum is not right for this file
+                bad.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OutOfRange}
+            } else {
+                // Unlike a real Tracker, trust the first report by another
+                // client, and drop the peer from the chunk at once.
+                reply := &trackerproto.UpdateReply{Status: trackerproto.OK}
+                if _, ok := dt.peers[bad.Args.Chunk][bad.Args.HostPort]; ok && bad.Args.Reporter != bad.Args.HostPort {
+                    delete(dt.peers[bad.Args.Chunk], bad.Args.HostPort)
+                    delete(dt.seeders[bad.Args.Chunk.ID], bad.Args.HostPort)
+                    dt.seqNum++
+                    reply.Removed = 1
+                }
+                bad.Reply <- reply
+            }
         case conf := <-dt.confirms:
             // A client has confirmed that it has a chunk
             if tor, ok := dt.torrents[conf.Args.Chunk.ID]; !ok {
@@ -160,23 +329,46 @@ func (dt *dummyTracker) eventHandler() {
                 // ChunkNum is not right for this file
                 conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OutOfRange}
             } else {
-                // Mark that the client has this chunk, creating the map for this
-                // chunk if necessary.
-                if _, ok := dt.peers[conf.Args.Chunk]; !ok {
-                    dt.peers[conf.Args.Chunk] = make(map[string]struct{})
+                dt.addPeer(conf.Args.Chunk, conf.Args.HostPort, conf.Args.Complete)
+                dt.seqNum++
+                conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OK}
+            }
+        case conf := <-dt.confirmManys:
+            // A client has confirmed that it has several chunks
+            tor, ok := dt.torrents[conf.Args.ID]
+            inRange := true
+            for _, chunkNum := range conf.Args.ChunkNums {
+                inRange = inRange && chunkNum >= 0 && chunkNum < torrent.NumChunks(tor)
+            }
+            if !ok {
+                // File does not exist
+                conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.FileNotFound}
+            } else if !inRange {
+                // Confirm none of the chunks if any is wrong for this file
+                conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OutOfRange}
+            } else {
+                for _, chunkNum := range conf.Args.ChunkNums {
+                    dt.addPeer(torrentproto.NewChunkID(conf.Args.ID, chunkNum), conf.Args.HostPort, conf.Args.Complete)
+                    dt.seqNum++
                 }
-                dt.peers[conf.Args.Chunk][conf.Args.HostPort] = struct{}{}
                 conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OK}
             }
         case cre := <-dt.creates:
             // A client has requested to create a new file
-            if _, ok := dt.torrents[cre.Args.Torrent.ID]; !ok {
+            tor := cre.Args.Torrent
+            if tor.ChunkSize <= 0 || tor.FileSize < 0 || len(tor.ChunkHashes) != torrent.NumChunks(tor) {
+                // The torrent's sizes and chunk hashes do not agree
+                cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.InvalidTorrent}
+            } else if _, ok := dt.torrents[cre.Args.Torrent.ID]; !ok {
                 // ID not in use, so add an entry for it.
                 dt.torrents[cre.Args.Torrent.ID] = cre.Args.Torrent
+                dt.seqNum++
                 cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OK}
             } else {
                 // File already exists, so tell the client that this ID is invalid
-                cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.InvalidID}
+                cre.Reply <- &trackerproto.UpdateReply{
+                    Status: trackerproto.InvalidID,
+                    Digest: torrent.Digest(dt.torrents[cre.Args.Torrent.ID])}
             }
         case req := <-dt.requests:
             // A client has requested a list of users with a certain chunk
@@ -187,21 +379,117 @@ func (dt *dummyTracker) eventHandler() {
                 // ChunkNum is not right for this file
                 req.Reply <- &trackerproto.RequestReply{Status: trackerproto.OutOfRange}
             } else {
-                // Get a list of all peers, then respond
+                // Get a list of all peers, keep as many as were asked for,
+                // and split them into complete seeders and partial holders,
+                // then respond
                 peers := make([]string, 0)
                 for k, _ := range dt.peers[req.Args.Chunk] {
                     peers = append(peers, k)
                 }
+                if req.Args.Order == trackerproto.Sorted {
+                    sort.Strings(peers)
+                } else {
+                    rand.Shuffle(len(peers), func(i, j int) {
+                        peers[i], peers[j] = peers[j], peers[i]
+                    })
+                }
+                if req.Args.MaxPeers > 0 && len(peers) > req.Args.MaxPeers {
+                    peers = peers[:req.Args.MaxPeers]
+                }
+                seeders := make([]string, 0)
+                partial := make([]string, 0)
+                for _, k := range peers {
+                    if _, ok := dt.seeders[req.Args.Chunk.ID][k]; ok {
+                        seeders = append(seeders, k)
+                    } else {
+                        partial = append(partial, k)
+                    }
+                }
                 req.Reply <- &trackerproto.RequestReply{
                     Status: trackerproto.OK,
                     Peers:  peers,
-                    ChunkHash: tor.ChunkHashes[req.Args.Chunk.ChunkNum]}
+                    Seeders: seeders,
+                    Partial: partial,
+                    ChunkHash: tor.ChunkHashes[req.Args.Chunk.ChunkNum],
+                    SeqNum: dt.seqNum}
+            }
+        case has := <-dt.hases:
+            // A client has asked whether a peer has a certain chunk
+            if tor, ok := dt.torrents[has.Args.Chunk.ID]; !ok {
+                // File does not exist
+                has.Reply <- &trackerproto.HasReply{Status: trackerproto.FileNotFound}
+            } else if has.Args.Chunk.ChunkNum < 0 || has.Args.Chunk.ChunkNum >= torrent.NumChunks(tor) {
+                // ChunkNum is not right for this file
+                has.Reply <- &trackerproto.HasReply{Status: trackerproto.OutOfRange}
+            } else {
+                _, ok := dt.peers[has.Args.Chunk][has.Args.HostPort]
+                has.Reply <- &trackerproto.HasReply{
+                    Status: trackerproto.OK,
+                    Has:    ok}
+            }
+        case q := <-dt.availQueries:
+            // A client has asked how many peers each chunk of a torrent has
+            if tor, ok := dt.torrents[q.Args.ID]; !ok {
+                // File does not exist
+                q.Reply <- &trackerproto.AvailabilityReply{Status: trackerproto.FileNotFound}
+            } else {
+                reply := &trackerproto.AvailabilityReply{
+                    Status:      trackerproto.OK,
+                    TotalChunks: torrent.NumChunks(tor),
+                    Replication: make([]int, torrent.NumChunks(tor))}
+                for i, chunkID := range torrent.AllChunkIDs(tor) {
+                    owners := len(dt.peers[chunkID])
+                    reply.Replication[i] = owners
+                    if owners > 0 {
+                        reply.ChunksWithPeers++
+                    }
+                    if i == 0 || owners < reply.MinReplication {
+                        reply.MinReplication = owners
+                    }
+                }
+                q.Reply <- reply
             }
+        case p := <-dt.pings:
+            // A single node is never behind.
+            p.Reply <- &trackerproto.PingReply{
+                Status: trackerproto.OK,
+                SeqNum: dt.seqNum,
+                Time:   time.Now().UnixNano()}
         case gt := <-dt.getTrackers:
             // Reply with only this node's host:port.
             gt.Reply <- &trackerproto.TrackersReply{
                 Status:    trackerproto.OK,
                 HostPorts: []string{dt.hostPort}}
+        case li := <-dt.lists:
+            // Summarize every torrent, sorted by ID.
+            summaries := make([]trackerproto.TorrentSummary, 0, len(dt.torrents))
+            for _, tor := range dt.torrents {
+                summaries = append(summaries, trackerproto.TorrentSummary{
+                    ID:        tor.ID,
+                    FileSize:  tor.FileSize,
+                    ChunkSize: tor.ChunkSize})
+            }
+            sort.Slice(summaries, func(i, j int) bool {
+                return summaries[i].ID.Less(summaries[j].ID)
+            })
+            li.Reply <- &trackerproto.ListReply{
+                Status:   trackerproto.OK,
+                Torrents: summaries}
+        }
+    }
+}
+
+// Mark that the client at hostPort has the chunk, creating the map for this
+// chunk if necessary.
+func (dt *dummyTracker) addPeer(chunk torrentproto.ChunkID, hostPort string, complete bool) {
+    if _, ok := dt.peers[chunk]; !ok {
+        dt.peers[chunk] = make(map[string]struct{})
+    }
+    dt.peers[chunk][hostPort] = struct{}{}
+    if complete {
+        if _, ok := dt.seeders[chunk.ID]; !ok {
+            dt.seeders[chunk.ID] = make(map[string]struct{})
         }
+        dt.seeders[chunk.ID][hostPort] = struct{}{}
     }
 }
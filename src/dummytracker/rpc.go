@@ -7,10 +7,19 @@ import (
 // Dummy Trackers will handle RPCs on this interface.
 type DummyTracker interface {
     ReportMissing(*trackerproto.ReportArgs, *trackerproto.UpdateReply) error
+    ReportBadChunk(*trackerproto.BadChunkArgs, *trackerproto.UpdateReply) error
     ConfirmChunk(*trackerproto.ConfirmArgs, *trackerproto.UpdateReply) error
+    ConfirmChunks(*trackerproto.ConfirmChunksArgs, *trackerproto.UpdateReply) error
     RequestChunk(*trackerproto.RequestArgs, *trackerproto.RequestReply) error
+    PeerHasChunk(*trackerproto.HasArgs, *trackerproto.HasReply) error
+    Availability(*trackerproto.AvailabilityArgs, *trackerproto.AvailabilityReply) error
     CreateEntry(*trackerproto.CreateArgs, *trackerproto.UpdateReply) error
     GetTrackers(*trackerproto.TrackersArgs, *trackerproto.TrackersReply) error
+    ListTorrents(*trackerproto.ListArgs, *trackerproto.ListReply) error
+    Ping(*trackerproto.PingArgs, *trackerproto.PingReply) error
+
+    // Close stops the dummy Tracker, and stops listening for connections.
+    Close()
 }
 
 type WrappedDummyTracker struct {
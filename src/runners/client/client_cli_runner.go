@@ -30,6 +30,7 @@ var (
         "\tOFFER <file_path> <torrent_path>",
         "\tDOWNLOAD <file_path> <torrent_path>",
         "\tREAD <torrent_path>",
+        "\tLIST",
         "\tEXIT",
         ""}, "\n")
     WELCOME string = strings.Join([]string{
@@ -57,8 +58,8 @@ func (cfl *clientFileListener) OnChange(change *clientproto.LocalFileChange) {
     switch change.Operation {
     case clientproto.LocalFileAdd:
         fmt.Println("Added file:", changeToString(change))
-    case clientproto.LocalFileDelete:
-        fmt.Println("Deleted file:", changeToString(change))
+    case clientproto.LocalFileRemove:
+        fmt.Println("Removed file:", changeToString(change))
     case clientproto.LocalFileUpdate:
         fmt.Println("Updated file:", changeToString(change))
     }
@@ -152,6 +153,17 @@ func processInputs(c client.Client, localFiles map[torrentproto.ID]*clientproto.
                 fmt.Println(torrent.String(t))
             }
 
+        case "LIST":
+            // List the torrents registered with the tracker.
+            if summaries, err := c.ListTorrents(trackerNodes); err != nil {
+                fmt.Println("Could not list torrents:", err)
+            } else {
+                for _, summary := range summaries {
+                    fmt.Printf("%s (%d bytes, %d byte chunks)\n", summary.ID, summary.FileSize, summary.ChunkSize)
+                }
+                fmt.Println("Listed", len(summaries), "torrents")
+            }
+
         case "EXIT":
             // Exit the client.
             fmt.Println("Exiting")
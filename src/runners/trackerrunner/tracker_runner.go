@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 
@@ -12,7 +14,10 @@ import (
 var (
 	USAGE string = strings.Join([]string{
 		"Usage:",
-		"\t<program_name> <tracker port> <tracker numNodes> <tracker nodeID> <optional master hostPort>",
+		"\t<program_name> <tracker [host:]port> <tracker numNodes> <tracker nodeID> <optional master hostPort>",
+		"A host (e.g. this machine's name, or an IP address, with an IPv6 address in",
+		"brackets) is advertised to the rest of the cluster, and the tracker listens on",
+		"every interface. Otherwise the tracker is only reachable on localhost.",
 		""}, "\n")
 )
 
@@ -22,7 +27,12 @@ func main() {
 		fmt.Println(USAGE)
 		return
 	}
-	port, _ := strconv.Atoi(os.Args[1])
+	var host string
+	portArg := os.Args[1]
+	if h, p, err := net.SplitHostPort(portArg); err == nil {
+		host, portArg = h, p
+	}
+	port, _ := strconv.Atoi(portArg)
 	numNodes, _ := strconv.Atoi(os.Args[2])
 	nodeID, _ := strconv.Atoi(os.Args[3])
 	var master string
@@ -33,23 +43,43 @@ func main() {
 	}
 
 	// Start tracker on given hostport.
-	if t, err := tracker.NewTrackerServer(master, numNodes, port, nodeID); err != nil {
+	cfg := tracker.TrackerConfig{
+		MasterHostPort: master,
+		NumNodes:       numNodes,
+		NodeID:         nodeID,
+		Port:           port,
+		Host:           host}
+	if t, err := tracker.NewTrackerServerWithConfig(cfg); err != nil {
 		fmt.Println("Failed to start tracker", err)
 	} else {
-		fmt.Println("Started tracker with hostPort =", port)
+		fmt.Println("Started tracker with hostPort =", os.Args[1])
+
+		// Close the tracker cleanly when interrupted, so that the updates it
+		// is handling are committed first.
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt)
+		go func() {
+			<-sigs
+			if err := t.Close(); err != nil {
+				fmt.Println("Failed to close tracker cleanly", err)
+			}
+			os.Exit(0)
+		}()
 
 		// Continually get a number of seconds to stall from stdin,
 		// and instruct the tracker to stall for that many seconds.
 		for {
 			var stallSeconds int
 			if n, _ := fmt.Scanln(&stallSeconds); n != 0 {
-				t.DebugStall(stallSeconds)
-
 				// Note that a stall time of 0 seconds will cause the tracker
 				// to close. In this case, the runner will exit as well.
-				if stallSeconds == 0 {
+				if stallSeconds <= 0 {
+					if err := t.Close(); err != nil {
+						fmt.Println("Failed to close tracker cleanly", err)
+					}
 					return
 				}
+				t.DebugStall(stallSeconds)
 			}
 		}
 	}
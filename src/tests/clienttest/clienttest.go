@@ -0,0 +1,5907 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"client"
+	"client/clientproto"
+	"crypto/rand"
+	"crypto/sha1"
+	"dummytracker"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"torrent"
+	"torrent/torrentproto"
+	"tracker"
+	"tracker/trackerproto"
+)
+
+var LOGE = log.New(os.Stderr, "", log.Lshortfile|log.Lmicroseconds)
+
+// Ignores changes to a Client's local files
+type nopListener struct{}
+
+func (l *nopListener) OnChange(change *clientproto.LocalFileChange) {}
+
+// Records the operations of the local file changes a client reports
+type changeRecorder struct {
+	mut        sync.Mutex
+	operations []clientproto.Operation
+}
+
+func (r *changeRecorder) OnChange(change *clientproto.LocalFileChange) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.operations = append(r.operations, change.Operation)
+}
+
+func (r *changeRecorder) last() clientproto.Operation {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if len(r.operations) == 0 {
+		return 0
+	}
+	return r.operations[len(r.operations)-1]
+}
+
+// Records the peer events a client reports
+type peerEventRecorder struct {
+	mut    sync.Mutex
+	events []clientproto.PeerEvent
+}
+
+func (r *peerEventRecorder) OnPeerEvent(event *clientproto.PeerEvent) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.events = append(r.events, *event)
+}
+
+// Waits up to a second for an event matching match to be recorded, since
+// events reach the recorder asynchronously
+func (r *peerEventRecorder) waitFor(match func(clientproto.PeerEvent) bool) bool {
+	for i := 0; i < 20; i++ {
+		r.mut.Lock()
+		for _, event := range r.events {
+			if match(event) {
+				r.mut.Unlock()
+				return true
+			}
+		}
+		r.mut.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+// Starts a dummy tracker on a free port
+func createTracker() (dummytracker.DummyTracker, []torrentproto.TrackerNode, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	dt, err := dummytracker.NewWithListener(ln)
+	if err != nil {
+		return nil, nil, err
+	}
+	trackerNodes := []torrentproto.TrackerNode{{HostPort: ln.Addr().String()}}
+	return dt, trackerNodes, nil
+}
+
+// A tracker which takes delay to answer each ConfirmChunk or ConfirmChunks,
+// and knows every torrent
+type slowTracker struct {
+	delay time.Duration
+}
+
+func (st *slowTracker) ConfirmChunk(args *trackerproto.ConfirmArgs, reply *trackerproto.UpdateReply) error {
+	time.Sleep(st.delay)
+	reply.Status = trackerproto.OK
+	return nil
+}
+
+func (st *slowTracker) ConfirmChunks(args *trackerproto.ConfirmChunksArgs, reply *trackerproto.UpdateReply) error {
+	time.Sleep(st.delay)
+	reply.Status = trackerproto.OK
+	return nil
+}
+
+func (st *slowTracker) Ping(args *trackerproto.PingArgs, reply *trackerproto.PingReply) error {
+	reply.Status = trackerproto.OK
+	reply.Time = time.Now().UnixNano()
+	return nil
+}
+
+// Starts a slow tracker on a free port.
+// Closing the returned listener stops it.
+func createSlowTracker(delay time.Duration) (net.Listener, []torrentproto.TrackerNode, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteTracker", &slowTracker{delay: delay}); err != nil {
+		ln.Close()
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(ln, mux)
+	trackerNodes := []torrentproto.TrackerNode{{HostPort: ln.Addr().String()}}
+	return ln, trackerNodes, nil
+}
+
+// A tracker which knows every torrent, and records the chunks each peer
+// confirms with ConfirmChunks. It answers the first answered calls at once,
+// then holds the rest until release is closed, closing stalled when it first
+// holds one
+type stallingTracker struct {
+	mut       sync.Mutex
+	answered  int
+	calls     int
+	stalled   chan struct{}
+	release   chan struct{}
+	confirmed map[string][]int
+}
+
+func (st *stallingTracker) ConfirmChunks(args *trackerproto.ConfirmChunksArgs, reply *trackerproto.UpdateReply) error {
+	st.mut.Lock()
+	st.calls++
+	if st.calls == st.answered+1 {
+		close(st.stalled)
+	}
+	held := st.calls > st.answered
+	st.mut.Unlock()
+	if held {
+		<-st.release
+	}
+
+	st.mut.Lock()
+	st.confirmed[args.HostPort] = append(st.confirmed[args.HostPort], args.ChunkNums...)
+	st.mut.Unlock()
+	reply.Status = trackerproto.OK
+	return nil
+}
+
+func (st *stallingTracker) Ping(args *trackerproto.PingArgs, reply *trackerproto.PingReply) error {
+	reply.Status = trackerproto.OK
+	reply.Time = time.Now().UnixNano()
+	return nil
+}
+
+// Returns the chunks peer has confirmed, in the order it confirmed them.
+func (st *stallingTracker) confirmedBy(peer string) []int {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	return append([]int(nil), st.confirmed[peer]...)
+}
+
+// Starts a stalling tracker on a free port, which answers answered calls
+// before it stalls.
+// Closing the returned listener stops it.
+func createStallingTracker(answered int) (net.Listener, *stallingTracker, []torrentproto.TrackerNode, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	st := &stallingTracker{
+		answered:  answered,
+		stalled:   make(chan struct{}),
+		release:   make(chan struct{}),
+		confirmed: make(map[string][]int)}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteTracker", st); err != nil {
+		ln.Close()
+		return nil, nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(ln, mux)
+	trackerNodes := []torrentproto.TrackerNode{{HostPort: ln.Addr().String()}}
+	return ln, st, trackerNodes, nil
+}
+
+// A tracker which accepts connections, but fails every RPC
+type brokenTracker struct{}
+
+func (bt *brokenTracker) RequestChunk(args *trackerproto.RequestArgs, reply *trackerproto.RequestReply) error {
+	return errors.New("Broken tracker")
+}
+
+func (bt *brokenTracker) Ping(args *trackerproto.PingArgs, reply *trackerproto.PingReply) error {
+	reply.Status = trackerproto.OK
+	reply.Time = time.Now().UnixNano()
+	return nil
+}
+
+// Starts a broken tracker on a free port.
+// Closing the returned listener stops it.
+func createBrokenTracker() (net.Listener, string, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, "", err
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteTracker", &brokenTracker{}); err != nil {
+		ln.Close()
+		return nil, "", err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(ln, mux)
+	return ln, ln.Addr().String(), nil
+}
+
+// A tracker which lists no torrents, and counts how often it is asked to
+type countingTracker struct {
+	listed int32
+}
+
+func (ct *countingTracker) Ping(args *trackerproto.PingArgs, reply *trackerproto.PingReply) error {
+	reply.Status = trackerproto.OK
+	reply.Time = time.Now().UnixNano()
+	return nil
+}
+
+func (ct *countingTracker) ListTorrents(args *trackerproto.ListArgs, reply *trackerproto.ListReply) error {
+	atomic.AddInt32(&ct.listed, 1)
+	reply.Status = trackerproto.OK
+	return nil
+}
+
+// Starts a counting tracker on a free port.
+// Closing the returned listener stops it.
+func createCountingTracker(ct *countingTracker) (net.Listener, string, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, "", err
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteTracker", ct); err != nil {
+		ln.Close()
+		return nil, "", err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(ln, mux)
+	return ln, ln.Addr().String(), nil
+}
+
+// A tracker which accepts connections, but does not answer pings until
+// release is closed, like a node whose eventHandler is stuck
+type stuckTracker struct {
+	release chan struct{}
+}
+
+func (st *stuckTracker) Ping(args *trackerproto.PingArgs, reply *trackerproto.PingReply) error {
+	<-st.release
+	return errors.New("Stuck tracker")
+}
+
+// Starts a stuck tracker on a free port.
+// Closing the returned listener and release stops it.
+func createStuckTracker(release chan struct{}) (net.Listener, string, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, "", err
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteTracker", &stuckTracker{release: release}); err != nil {
+		ln.Close()
+		return nil, "", err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(ln, mux)
+	return ln, ln.Addr().String(), nil
+}
+
+// A peer which serves chunks of data, but holds back every chunk except the
+// first until release is closed
+type gatedPeer struct {
+	data      []byte
+	chunkSize int
+	release   chan struct{}
+}
+
+func (p *gatedPeer) GetChunk(args *clientproto.GetArgs, reply *clientproto.GetReply) error {
+	if args.ChunkID.ChunkNum != 0 {
+		<-p.release
+	}
+	start := args.ChunkID.ChunkNum * p.chunkSize
+	end := start + p.chunkSize
+	if end > len(p.data) {
+		end = len(p.data)
+	}
+	reply.Status = clientproto.OK
+	reply.Chunk = p.data[start:end]
+	return nil
+}
+
+// Starts a gated peer for data on a free port.
+// Closing the returned listener stops it.
+func createGatedPeer(data []byte, chunkSize int) (net.Listener, *gatedPeer, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	peer := &gatedPeer{
+		data:      data,
+		chunkSize: chunkSize,
+		release:   make(chan struct{})}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteClient", peer); err != nil {
+		ln.Close()
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(ln, mux)
+	return ln, peer, nil
+}
+
+// A listener which counts the connections it accepts
+type countingListener struct {
+	net.Listener
+	mut      sync.Mutex
+	accepted int
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mut.Lock()
+		l.accepted++
+		l.mut.Unlock()
+	}
+	return conn, err
+}
+
+func (l *countingListener) count() int {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.accepted
+}
+
+// Starts a peer which serves every chunk of data, on a free port, and counts
+// the connections made to it.
+// Closing the returned listener stops it.
+func createCountingPeer(data []byte, chunkSize int) (*countingListener, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	counting := &countingListener{Listener: ln}
+	peer := &gatedPeer{
+		data:      data,
+		chunkSize: chunkSize,
+		release:   make(chan struct{})}
+	close(peer.release)
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteClient", peer); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(counting, mux)
+	return counting, nil
+}
+
+// A peer which serves chunks of several files, taking delay to send each, and
+// records the most chunks it has been sending at once
+type busyPeer struct {
+	files     map[torrentproto.ID][]byte
+	chunkSize int
+	delay     time.Duration
+	mut       sync.Mutex
+	sending   int
+	most      int
+}
+
+func (p *busyPeer) GetChunk(args *clientproto.GetArgs, reply *clientproto.GetReply) error {
+	p.mut.Lock()
+	p.sending++
+	if p.sending > p.most {
+		p.most = p.sending
+	}
+	p.mut.Unlock()
+	defer func() {
+		p.mut.Lock()
+		p.sending--
+		p.mut.Unlock()
+	}()
+
+	time.Sleep(p.delay)
+	data, ok := p.files[args.ChunkID.ID]
+	if !ok {
+		reply.Status = clientproto.ChunkNotFound
+		return nil
+	}
+	start := args.ChunkID.ChunkNum * p.chunkSize
+	end := start + p.chunkSize
+	if end > len(data) {
+		end = len(data)
+	}
+	reply.Status = clientproto.OK
+	reply.Chunk = data[start:end]
+	return nil
+}
+
+// Returns the most chunks p has been sending at once
+func (p *busyPeer) mostSending() int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.most
+}
+
+// Starts p on a free port.
+// Closing the returned listener stops it.
+func startBusyPeer(p *busyPeer) (net.Listener, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteClient", p); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(ln, mux)
+	return ln, nil
+}
+
+// A proxy in front of a peer, which passes chunk stream requests on and
+// records their queries. It cuts off its first connection once it has passed
+// on cut bytes of the peer's replies, and counts the bytes of replies it
+// passes on altogether
+type cuttingProxy struct {
+	peer    string
+	cut     int
+	mut     sync.Mutex
+	conns   int
+	queries []url.Values
+	sent    int
+}
+
+// Passes requests from conn on to the peer, and the peer's replies back
+func (p *cuttingProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	peerConn, err := net.Dial("tcp", p.peer)
+	if err != nil {
+		return
+	}
+	defer peerConn.Close()
+	p.mut.Lock()
+	p.conns++
+	cutting := p.conns == 1
+	p.mut.Unlock()
+
+	go func() {
+		requests := bufio.NewReader(conn)
+		for {
+			req, err := http.ReadRequest(requests)
+			if err != nil {
+				peerConn.Close()
+				return
+			}
+			p.mut.Lock()
+			p.queries = append(p.queries, req.URL.Query())
+			p.mut.Unlock()
+			if err := req.Write(peerConn); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 4096)
+	for passed := 0; ; {
+		n, err := peerConn.Read(buf)
+		if cutting && passed+n > p.cut {
+			n = p.cut - passed
+		}
+		if n > 0 {
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return
+			}
+			passed += n
+			p.mut.Lock()
+			p.sent += n
+			p.mut.Unlock()
+		}
+		if err != nil || (cutting && passed == p.cut) {
+			return
+		}
+	}
+}
+
+// Starts a cutting proxy for the peer at hostPort on a free port.
+// Closing the returned listener stops it.
+func startCuttingProxy(hostPort string, cut int) (net.Listener, *cuttingProxy, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &cuttingProxy{peer: hostPort, cut: cut}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return ln, p, nil
+}
+
+// Finds a host:port which nothing is listening on
+func freeHostPort() (string, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
+
+// Starts numClients clients on consecutive ports
+// Clients are never closed, so earlier tests' clients still hold their ports;
+// if a port is taken, start again from another one.
+func createClients(numClients int) ([]client.Client, []string, error) {
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		basePort := 9091 + 41*(r.Int()%300)
+		clients := make([]client.Client, numClients)
+		hostPorts := make([]string, numClients)
+		for i := range clients {
+			hostPorts[i] = net.JoinHostPort("localhost", strconv.Itoa(basePort+17*i))
+			clients[i], err = client.NewClientWithConfig(client.ClientConfig{
+				Listener: &nopListener{},
+				HostPort: hostPorts[i],
+				Verify:   client.VerifyFile})
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return clients, hostPorts, nil
+		}
+	}
+	return nil, nil, err
+}
+
+// Makes an RPC to the tracker node at hostPort
+func callTracker(hostPort, method string, args, reply interface{}) error {
+	conn, err := rpc.DialHTTP("tcp", hostPort)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Call(method, args, reply)
+}
+
+// Writes size random bytes to a new file with the given name in dir
+func createFile(dir, name string, size int) (string, []byte, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", nil, err
+	}
+	return path, data, nil
+}
+
+// One client publishes a file, and another downloads it.
+// Then check that the tracker knows the publisher has the file,
+// and that the file arrived intact.
+func testOfferDownload() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, hostPorts, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	if torrent.NumChunks(t) != 3 {
+		LOGE.Println("Wrong number of chunks: ", torrent.NumChunks(t))
+		return false
+	}
+
+	conn, err := rpc.DialHTTP("tcp", trackerNodes[0].HostPort)
+	if err != nil {
+		LOGE.Println("Could not connect to tracker")
+		return false
+	}
+	defer conn.Close()
+	args := &trackerproto.HasArgs{
+		Chunk:    torrentproto.ChunkID{ID: t.ID, ChunkNum: 2},
+		HostPort: hostPorts[0]}
+	reply := &trackerproto.HasReply{}
+	if err := conn.Call("RemoteTracker.PeerHasChunk", args, reply); err != nil || reply.Status != trackerproto.OK || !reply.Has {
+		LOGE.Println("Peer Has Chunk: publisher should have chunk")
+		return false
+	}
+
+	LOGE.Println("Downloading file")
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+	return true
+}
+
+// Publish a file with CreateAndOffer, then publish copies of it from a second
+// client: the copy with the same chunks is offered alongside the first, and
+// one split into different chunks is refused
+func testCreateAndOffer() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, hostPorts, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	copyDir := filepath.Join(dir, "copy")
+	if err := os.Mkdir(copyDir, 0755); err != nil {
+		LOGE.Println("Could not create directory: ", err)
+		return false
+	}
+	copyPath := filepath.Join(copyDir, "data")
+	if err := ioutil.WriteFile(copyPath, data, 0644); err != nil {
+		LOGE.Println("Could not copy file: ", err)
+		return false
+	}
+
+	LOGE.Println("Publishing file")
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	if t.ID.Name != "data" || t.ChunkSize != 1000 || len(t.TrackerNodes) != len(trackerNodes) {
+		LOGE.Println("Wrong torrent: ", t)
+		return false
+	}
+
+	LOGE.Println("Publishing copy of file")
+	copied, err := clients[1].CreateAndOffer(copyPath, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer of registered file failed: ", err)
+		return false
+	}
+	if !torrent.Equal(copied, t) {
+		LOGE.Println("Copy has a different torrent: ", copied)
+		return false
+	}
+	for i, hostPort := range hostPorts {
+		has, err := peerHasChunk(trackerNodes[0].HostPort, torrentproto.NewChunkID(t.ID, 2), hostPort)
+		if err != nil || !has {
+			LOGE.Println("Client ", i, " is not listed for the file: ", err)
+			return false
+		}
+	}
+
+	LOGE.Println("Publishing copy of file with different chunks")
+	if _, err := clients[1].CreateAndOffer(copyPath, 0, trackerNodes); err == nil {
+		LOGE.Println("Published file with different chunks")
+		return false
+	}
+
+	LOGE.Println("Publishing missing file")
+	if _, err := clients[0].CreateAndOffer(filepath.Join(dir, "missing"), 1000, trackerNodes); err == nil {
+		LOGE.Println("Published missing file")
+		return false
+	}
+	return true
+}
+
+// Download a file while the tracker lists the downloader itself as a seeder,
+// e.g. because it confirmed the file earlier and then lost it
+func testDownloadListedSelf() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, hostPorts, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Listing downloader as a seeder")
+	conn, err := rpc.DialHTTP("tcp", trackerNodes[0].HostPort)
+	if err != nil {
+		LOGE.Println("Could not connect to tracker")
+		return false
+	}
+	defer conn.Close()
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		args := &trackerproto.ConfirmArgs{
+			Chunk:    chunkID,
+			HostPort: hostPorts[1],
+			Complete: true}
+		reply := &trackerproto.UpdateReply{}
+		if err := conn.Call("RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			return false
+		}
+	}
+
+	LOGE.Println("Downloading file")
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+	return true
+}
+
+// Download a file with many small chunks, and check that the number of
+// goroutines stays bounded while it downloads
+func testManyChunks(numChunks int) bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 10*numChunks)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	t, err := clients[0].CreateAndOffer(path, 10, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	// Count goroutines until the download finishes
+	before := runtime.NumGoroutine()
+	most := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-done:
+				most <- n
+				return
+			case <-time.After(time.Millisecond):
+				if g := runtime.NumGoroutine(); g > n {
+					n = g
+				}
+			}
+		}
+	}()
+
+	LOGE.Println("Downloading file")
+	start := time.Now()
+	downloadPath := filepath.Join(dir, "download")
+	err = clients[1].DownloadFile(t, downloadPath)
+	close(done)
+	extra := <-most - before
+	if err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	LOGE.Println("Downloaded ", numChunks, " chunks in ", time.Since(start), " with at most ", extra, " extra goroutines")
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+	if extra > numChunks/10 {
+		LOGE.Println("Too many goroutines")
+		return false
+	}
+	return true
+}
+
+// One client offers two files whose torrents are registered with
+// different trackers, then check that it knows which tracker each uses
+func testTwoTrackers() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	ids := make(map[string]torrentproto.ID)
+	for i := 0; i < 2; i++ {
+		dt, trackerNodes, err := createTracker()
+		if err != nil {
+			LOGE.Println("Could not create tracker: ", err)
+			return false
+		}
+		defer dt.Close()
+
+		path, _, err := createFile(dir, "data"+strconv.Itoa(i), 100)
+		if err != nil {
+			LOGE.Println("Could not create file: ", err)
+			return false
+		}
+
+		LOGE.Println("Offering file ", i)
+		t, err := clients[0].CreateAndOffer(path, 10, trackerNodes)
+		if err != nil {
+			LOGE.Println("Create And Offer failed: ", err)
+			return false
+		}
+		ids[trackerNodes[0].HostPort] = t.ID
+	}
+
+	tracked := clients[0].TrackedTorrents()
+	if len(tracked) != len(ids) {
+		LOGE.Println("Wrong trackers: ", tracked)
+		return false
+	}
+	for hostPort, id := range ids {
+		if len(tracked[hostPort]) != 1 || tracked[hostPort][0] != id {
+			LOGE.Println("Wrong torrents for ", hostPort, ": ", tracked[hostPort])
+			return false
+		}
+	}
+	return true
+}
+
+// Publish and download a file with each hash algorithm,
+// saving and loading its torrent in between
+func testHashAlgos() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	for _, algo := range []torrentproto.HashAlgo{torrentproto.SHA1, torrentproto.SHA256} {
+		name := "data" + strconv.Itoa(int(algo))
+		path, data, err := createFile(dir, name, 2500)
+		if err != nil {
+			LOGE.Println("Could not create file: ", err)
+			return false
+		}
+		t, err := torrent.NewWithHashAlgo(path, name, trackerNodes, 1000, algo)
+		if err != nil {
+			LOGE.Println("Could not create torrent: ", err)
+			return false
+		}
+
+		torrentPath := filepath.Join(dir, name+".torrent")
+		if err := torrent.Save(t, torrentPath); err != nil {
+			LOGE.Println("Could not save torrent: ", err)
+			return false
+		}
+		loaded, err := torrent.Load(torrentPath)
+		if err != nil || !torrent.Equal(loaded, t) {
+			LOGE.Println("Loaded torrent does not match")
+			return false
+		}
+
+		LOGE.Println("Offering file with algorithm ", algo)
+		if err := torrent.Register(loaded); err != nil {
+			LOGE.Println("Could not register torrent: ", err)
+			return false
+		}
+		if err := clients[0].OfferFile(loaded, path); err != nil {
+			LOGE.Println("Could not offer file: ", err)
+			return false
+		}
+
+		LOGE.Println("Downloading file with algorithm ", algo)
+		downloadPath := filepath.Join(dir, name+".download")
+		if err := clients[1].DownloadFile(loaded, downloadPath); err != nil {
+			LOGE.Println("Download failed: ", err)
+			return false
+		}
+		downloaded, err := ioutil.ReadFile(downloadPath)
+		if err != nil || !bytes.Equal(downloaded, data) {
+			LOGE.Println("Downloaded file does not match")
+			return false
+		}
+	}
+
+	LOGE.Println("Downloading file with unknown algorithm")
+	path, _, err := createFile(dir, "unknown", 100)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.New(path, "unknown", trackerNodes)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	t.HashAlgo = torrentproto.SHA256 + 1
+	if err := clients[1].DownloadFile(t, filepath.Join(dir, "unknown.download")); err == nil {
+		LOGE.Println("Download with unknown algorithm succeeded")
+		return false
+	}
+	return true
+}
+
+// Try to download a file whose torrent was never registered
+func testDownloadUnregistered() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, _, err := createFile(dir, "data", 100)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.New(path, "data", trackerNodes)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading file")
+	if err := clients[0].DownloadFile(t, filepath.Join(dir, "download")); err == nil {
+		LOGE.Println("Download of unregistered torrent succeeded")
+		return false
+	}
+	return true
+}
+
+// Registers t on the tracker nodes in trackerNodes, except that the nodes
+// listed in bad get a copy of t with a different hash for chunk 1
+func registerWithBadNodes(t torrentproto.Torrent, trackerNodes []torrentproto.TrackerNode, bad map[int]bool) error {
+	badTorrent := t
+	badTorrent.ChunkHashes = make(map[int]string)
+	for chunkNum, hash := range t.ChunkHashes {
+		badTorrent.ChunkHashes[chunkNum] = hash
+	}
+	badTorrent.ChunkHashes[1] = "not the hash"
+
+	for i, trackerNode := range trackerNodes {
+		args := &trackerproto.CreateArgs{Torrent: t}
+		if bad[i] {
+			args.Torrent = badTorrent
+		}
+		reply := &trackerproto.UpdateReply{}
+		if err := callTracker(trackerNode.HostPort, "RemoteTracker.CreateEntry", args, reply); err != nil {
+			return err
+		} else if reply.Status != trackerproto.OK {
+			return errors.New("Create Entry: Status not OK")
+		}
+	}
+	return nil
+}
+
+// Start three tracker nodes which do not share state, and give the first
+// node a bad hash for one chunk of a file.
+// The download should still succeed, since the other nodes outvote it.
+// Then give two of the nodes a bad hash for another file, whose download
+// should fail.
+func testFaultyTrackerNode() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	trackerNodes := make([]torrentproto.TrackerNode, 3)
+	for i := range trackerNodes {
+		dt, nodes, err := createTracker()
+		if err != nil {
+			LOGE.Println("Could not create tracker: ", err)
+			return false
+		}
+		defer dt.Close()
+		trackerNodes[i] = nodes[0]
+	}
+
+	clients, hostPorts, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	if err := registerWithBadNodes(t, trackerNodes, map[int]bool{0: true}); err != nil {
+		LOGE.Println("Could not register torrent: ", err)
+		return false
+	}
+
+	// The publisher confirms its chunks with the first node, so tell the
+	// other nodes too, since they do not share state
+	LOGE.Println("Offering file")
+	if err := clients[0].OfferFile(t, path); err != nil {
+		LOGE.Println("Offer failed: ", err)
+		return false
+	}
+	for _, trackerNode := range trackerNodes[1:] {
+		for _, chunkID := range torrent.AllChunkIDs(t) {
+			args := &trackerproto.ConfirmArgs{
+				Chunk:    chunkID,
+				HostPort: hostPorts[0]}
+			reply := &trackerproto.UpdateReply{}
+			if err := callTracker(trackerNode.HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk: Status not OK")
+				return false
+			}
+		}
+	}
+
+	LOGE.Println("Downloading file with one bad node")
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+
+	path, _, err = createFile(dir, "other", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err = torrent.NewWithChunkSize(path, "other", trackerNodes, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	if err := registerWithBadNodes(t, trackerNodes, map[int]bool{0: true, 1: true}); err != nil {
+		LOGE.Println("Could not register torrent: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading file with two bad nodes")
+	if err := clients[1].DownloadFile(t, filepath.Join(dir, "other.download")); err == nil {
+		LOGE.Println("Download with a bad hash on most nodes succeeded")
+		return false
+	}
+	return true
+}
+
+// Decodes a client's status, and checks that it encodes back to the same JSON
+func getStatus(c client.Client) (*clientproto.ClientStatus, error) {
+	data, err := c.StatusJSON()
+	if err != nil {
+		return nil, err
+	}
+	status := &clientproto.ClientStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	if again, err := json.Marshal(status); err != nil {
+		return nil, err
+	} else if !bytes.Equal(again, data) {
+		return nil, errors.New("Status does not round-trip: " + string(data))
+	}
+	return status, nil
+}
+
+// One client publishes a file, and another downloads it.
+// Check each client's status before and after
+func testStatusJSON() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, _, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+
+	status, err := getStatus(clients[1])
+	if err != nil || len(status.Files) != 0 {
+		LOGE.Println("Wrong status before download: ", status, err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	expected := clientproto.FileStatus{
+		Name:        t.ID.Name,
+		Hash:        hex.EncodeToString([]byte(t.ID.Hash)),
+		Path:        path,
+		Chunks:      3,
+		TotalChunks: 3,
+		Complete:    true}
+	status, err = getStatus(clients[0])
+	if err != nil || len(status.Files) != 1 || status.Files[0] != expected {
+		LOGE.Println("Wrong status for publisher: ", status, err)
+		return false
+	}
+
+	LOGE.Println("Downloading file")
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+
+	// The download may not have been marked finished yet
+	expected.Path = downloadPath
+	status, err = getStatus(clients[1])
+	if err != nil || len(status.Files) != 1 {
+		LOGE.Println("Wrong status for downloader: ", status, err)
+		return false
+	}
+	status.Files[0].Downloading = false
+	if status.Files[0] != expected {
+		LOGE.Println("Wrong status for downloader: ", status)
+		return false
+	}
+	return true
+}
+
+// Download a file while reading its progress, then a file whose torrent was
+// never registered. Each progress channel should close once its download is
+// over, after an event for every chunk of the successful download.
+func testDownloadProgress() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 2000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	t, err := clients[0].CreateAndOffer(path, 100, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading file")
+	downloadPath := filepath.Join(dir, "download")
+	progress, errs := clients[1].DownloadFileProgress(t, downloadPath)
+	seen := make(map[int]struct{})
+	var last clientproto.Progress
+	for p := range progress {
+		seen[p.ChunkNum] = struct{}{}
+		last = p
+	}
+	if err := <-errs; err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	if len(seen) != 20 || last.Chunks != 20 || last.TotalChunks != 20 {
+		LOGE.Println("Wrong progress: ", len(seen), " chunks seen, last event ", last)
+		return false
+	}
+	if _, ok := <-progress; ok {
+		LOGE.Println("Progress channel reopened")
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+
+	path, _, err = createFile(dir, "unregistered", 100)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err = torrent.New(path, "unregistered", trackerNodes)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading unregistered file")
+	progress, errs = clients[1].DownloadFileProgress(t, filepath.Join(dir, "unregistered.download"))
+	for p := range progress {
+		LOGE.Println("Progress for unregistered file: ", p)
+		return false
+	}
+	if err := <-errs; err == nil {
+		LOGE.Println("Download of unregistered torrent succeeded")
+		return false
+	}
+	return true
+}
+
+// Asks the tracker node at hostPort whether the peer at peer has a chunk
+func peerHasChunk(hostPort string, chunk torrentproto.ChunkID, peer string) (bool, error) {
+	args := &trackerproto.HasArgs{
+		Chunk:    chunk,
+		HostPort: peer}
+	reply := &trackerproto.HasReply{}
+	if err := callTracker(hostPort, "RemoteTracker.PeerHasChunk", args, reply); err != nil {
+		return false, err
+	} else if reply.Status != trackerproto.OK {
+		return false, errors.New("Peer Has Chunk: Status not OK")
+	}
+	return reply.Has, nil
+}
+
+// Publish a file, corrupt one of its chunks on disk, and refresh that chunk
+// and a good one, then repair the file and refresh the bad chunk again.
+// Also refresh chunks which are out of range, or of an unknown torrent.
+func testRefreshChunk() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, hostPorts, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	// Checks the client's refresh of a chunk, and what it and the tracker
+	// then think it has
+	check := func(chunkNum int, has bool, chunks int) bool {
+		if err := clients[0].RefreshChunk(t.ID, chunkNum); err != nil {
+			LOGE.Println("Refresh Chunk failed: ", err)
+			return false
+		}
+		chunk := torrentproto.NewChunkID(t.ID, chunkNum)
+		if trackerHas, err := peerHasChunk(trackerNodes[0].HostPort, chunk, hostPorts[0]); err != nil || trackerHas != has {
+			LOGE.Println("Tracker is wrong about chunk ", chunkNum, err)
+			return false
+		}
+		if status, err := getStatus(clients[0]); err != nil || status.Files[0].Chunks != chunks {
+			LOGE.Println("Client is wrong about chunks: ", status, err)
+			return false
+		}
+		return true
+	}
+
+	LOGE.Println("Refreshing good chunk")
+	if !check(0, true, 3) {
+		return false
+	}
+
+	LOGE.Println("Refreshing corrupt chunk")
+	corrupt := make([]byte, len(data))
+	copy(corrupt, data)
+	corrupt[1500] ^= 0xff
+	if err := ioutil.WriteFile(path, corrupt, 0644); err != nil {
+		LOGE.Println("Could not corrupt file: ", err)
+		return false
+	}
+	if !check(1, false, 2) {
+		return false
+	}
+
+	LOGE.Println("Refreshing repaired chunk")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		LOGE.Println("Could not repair file: ", err)
+		return false
+	}
+	if !check(1, true, 3) {
+		return false
+	}
+
+	LOGE.Println("Refreshing chunks out of range")
+	for _, chunkNum := range []int{-1, 3} {
+		if err := clients[0].RefreshChunk(t.ID, chunkNum); err == nil {
+			LOGE.Println("Refresh of chunk ", chunkNum, " succeeded")
+			return false
+		}
+	}
+	if err := clients[0].RefreshChunk(torrentproto.ID{Name: "unknown"}, 0); err == nil {
+		LOGE.Println("Refresh of unknown torrent succeeded")
+		return false
+	}
+	return true
+}
+
+// Offer a file to a slow tracker, with a timeout which only leaves time to
+// confirm some of its chunks
+func testOfferTimeout() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	ln, trackerNodes, err := createSlowTracker(100 * time.Millisecond)
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer ln.Close()
+
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	hostPort := net.JoinHostPort("localhost", strconv.Itoa(9091+41*(r.Int()%300)))
+	localFiles := make(map[torrentproto.ID]*clientproto.LocalFile)
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		LocalFiles:   localFiles,
+		Listener:     &nopListener{},
+		HostPort:     hostPort,
+		Verify:       client.VerifyFile,
+		OfferTimeout: 350 * time.Millisecond})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	// Offers confirm chunks in batches, so the file needs several batches
+	total := 10 * client.OFFER_BATCH
+	path, _, err := createFile(dir, "data", total)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	start := time.Now()
+	err = c.OfferFile(t, path)
+	elapsed := time.Since(start)
+	timeoutErr, ok := err.(*client.OfferTimeoutError)
+	if !ok {
+		LOGE.Println("Offer did not time out: ", err)
+		return false
+	}
+	LOGE.Println(err, " in ", elapsed)
+	if timeoutErr.Total != total || timeoutErr.Confirmed < 1 || timeoutErr.Confirmed >= total {
+		LOGE.Println("Wrong number of chunks confirmed")
+		return false
+	}
+	if elapsed > time.Second {
+		LOGE.Println("Offer took too long")
+		return false
+	}
+
+	// The client should still be running, and should still serve the file
+	if status, err := getStatus(c); err != nil || len(status.Files) != 1 || !status.Files[0].Complete {
+		LOGE.Println("Wrong status after offer: ", status, err)
+		return false
+	}
+	return true
+}
+
+// Plan the download of a file one of whose chunks has no peers, before and
+// after a download of it fails part way, then once the chunk has a peer again
+func testPlanDownload() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	// Corrupt the last chunk, so that its only peer stops offering it
+	corrupt := make([]byte, len(data))
+	copy(corrupt, data)
+	corrupt[2200] ^= 0xff
+	if err := ioutil.WriteFile(path, corrupt, 0644); err != nil {
+		LOGE.Println("Could not corrupt file: ", err)
+		return false
+	}
+	if err := clients[0].RefreshChunk(t.ID, 2); err != nil {
+		LOGE.Println("Refresh Chunk failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Planning download with a missing chunk")
+	plan, err := clients[1].PlanDownload(t)
+	if err != nil {
+		LOGE.Println("Plan Download failed: ", err)
+		return false
+	}
+	if plan.Feasible || plan.TotalBytes != 2500 || plan.FetchBytes != 2500 || len(plan.Chunks) != 3 ||
+		plan.Chunks[0].Peers != 1 || plan.Chunks[1].Peers != 1 || plan.Chunks[2].Peers != 0 {
+		LOGE.Println("Wrong plan: ", plan)
+		return false
+	}
+	if status, err := getStatus(clients[1]); err != nil || len(status.Files) != 0 {
+		LOGE.Println("Planning changed the client: ", status, err)
+		return false
+	}
+
+	LOGE.Println("Downloading file")
+	if err := clients[1].DownloadFile(t, filepath.Join(dir, "download")); err == nil {
+		LOGE.Println("Download of file with a missing chunk succeeded")
+		return false
+	}
+
+	LOGE.Println("Planning download of partial file")
+	plan, err = clients[1].PlanDownload(t)
+	if err != nil {
+		LOGE.Println("Plan Download failed: ", err)
+		return false
+	}
+	status, err := getStatus(clients[1])
+	if err != nil || len(status.Files) != 1 {
+		LOGE.Println("Wrong status after download: ", status, err)
+		return false
+	}
+	local := 0
+	fetchBytes := 500
+	for _, chunkPlan := range plan.Chunks[:2] {
+		if chunkPlan.Local {
+			local++
+		} else {
+			fetchBytes += 1000
+		}
+	}
+	if plan.Feasible || plan.Chunks[2].Local || local != status.Files[0].Chunks || plan.FetchBytes != fetchBytes {
+		LOGE.Println("Wrong plan: ", plan, " with ", status.Files[0].Chunks, " chunks downloaded")
+		return false
+	}
+	LOGE.Println("Chunks to skip: ", local)
+
+	LOGE.Println("Planning download once every chunk has a peer")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		LOGE.Println("Could not repair file: ", err)
+		return false
+	}
+	if err := clients[0].RefreshChunk(t.ID, 2); err != nil {
+		LOGE.Println("Refresh Chunk failed: ", err)
+		return false
+	}
+	if plan, err = clients[1].PlanDownload(t); err != nil || !plan.Feasible {
+		LOGE.Println("Wrong plan: ", plan, err)
+		return false
+	}
+
+	path, _, err = createFile(dir, "unregistered", 100)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err = torrent.New(path, "unregistered", trackerNodes)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	if _, err := clients[1].PlanDownload(t); err == nil {
+		LOGE.Println("Plan of unregistered torrent succeeded")
+		return false
+	}
+	return true
+}
+
+// Publish a file to two trackers which both know its torrent, giving the
+// second tracker a higher weight. The client should only tell the second.
+func testTrackerWeights() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	trackerNodes := make([]torrentproto.TrackerNode, 2)
+	for i := range trackerNodes {
+		dt, nodes, err := createTracker()
+		if err != nil {
+			LOGE.Println("Could not create tracker: ", err)
+			return false
+		}
+		defer dt.Close()
+		trackerNodes[i] = nodes[0]
+	}
+	trackerNodes[1].Weight = 10
+
+	clients, hostPorts, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, _, err := createFile(dir, "data", 100)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 10)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	for _, trackerNode := range trackerNodes {
+		reply := &trackerproto.UpdateReply{}
+		if err := callTracker(trackerNode.HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry failed: ", err)
+			return false
+		}
+	}
+
+	LOGE.Println("Offering file")
+	if err := clients[0].OfferFile(t, path); err != nil {
+		LOGE.Println("Offer failed: ", err)
+		return false
+	}
+	chunk := torrentproto.ChunkID{ID: t.ID, ChunkNum: 0}
+	if has, err := peerHasChunk(trackerNodes[1].HostPort, chunk, hostPorts[0]); err != nil || !has {
+		LOGE.Println("Preferred tracker was not told: ", err)
+		return false
+	}
+	if has, err := peerHasChunk(trackerNodes[0].HostPort, chunk, hostPorts[0]); err != nil || has {
+		LOGE.Println("Other tracker was told: ", err)
+		return false
+	}
+	return true
+}
+
+// Write and read chunks which lie outside a torrent's file, either because the
+// chunk number is out of range, the final chunk is too big, or the torrent is
+// inconsistent. None of them should touch the file.
+func testChunkBounds() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", nil, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	file, err := os.Create(filepath.Join(dir, "download"))
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	defer file.Close()
+
+	// Checks that a write of chunk is refused, and leaves the file empty
+	refused := func(t torrentproto.Torrent, chunkNum int, chunk []byte) bool {
+		if err := torrent.WriteChunk(t, file, chunkNum, chunk); err == nil {
+			LOGE.Println("Write of chunk ", chunkNum, " succeeded")
+			return false
+		} else {
+			LOGE.Println("Refused: ", err)
+		}
+		if fi, err := file.Stat(); err != nil || fi.Size() != 0 {
+			LOGE.Println("Refused write changed the file")
+			return false
+		}
+		return true
+	}
+
+	LOGE.Println("Writing chunks out of range")
+	if !refused(t, 3, data[2000:]) || !refused(t, -1, data[:1000]) {
+		return false
+	}
+
+	LOGE.Println("Writing oversized final chunk")
+	oversized := make([]byte, 1000)
+	copy(oversized, data[2000:])
+	if !refused(t, 2, oversized) {
+		return false
+	}
+
+	LOGE.Println("Writing chunks of inconsistent torrents")
+	short := t
+	short.FileSize = 1500
+	if !refused(short, 1, data[1000:2000]) {
+		return false
+	}
+	for _, chunkSize := range []int{0, -1000} {
+		bad := t
+		bad.ChunkSize = chunkSize
+		if !refused(bad, 0, data[:1000]) {
+			return false
+		}
+		if _, err := torrent.ReadChunk(bad, file, 0); err == nil {
+			LOGE.Println("Read of chunk with chunk size ", chunkSize, " succeeded")
+			return false
+		}
+	}
+
+	LOGE.Println("Writing final chunk")
+	if err := torrent.WriteChunk(t, file, 2, data[2000:]); err != nil {
+		LOGE.Println("Write of final chunk failed: ", err)
+		return false
+	}
+	if chunk, err := torrent.ReadChunk(t, file, 2); err != nil || !bytes.Equal(chunk, data[2000:]) {
+		LOGE.Println("Read of final chunk failed: ", err)
+		return false
+	}
+	if _, err := torrent.ReadChunk(t, file, 3); err == nil {
+		LOGE.Println("Read of chunk out of range succeeded")
+		return false
+	}
+	if fi, err := file.Stat(); err != nil || fi.Size() != 2500 {
+		LOGE.Println("File is the wrong size")
+		return false
+	}
+	return true
+}
+
+// For files of many sizes around multiples of their chunk size, write each
+// chunk alone into an empty file and check that it lands at ChunkOffset, and
+// that the offsets of consecutive chunks leave no gaps
+func testChunkOffsets() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := os.Create(filepath.Join(dir, "download"))
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	defer file.Close()
+
+	for _, chunkSize := range []int{1, 7, 64, 1000} {
+		for _, size := range []int{1, chunkSize - 1, chunkSize, chunkSize + 1, 5*chunkSize - 1, 5 * chunkSize, 5*chunkSize + 3} {
+			if size < 1 {
+				continue
+			}
+			path, data, err := createFile(dir, "data", size)
+			if err != nil {
+				LOGE.Println("Could not create file: ", err)
+				return false
+			}
+			t, err := torrent.NewWithChunkSize(path, "data", nil, chunkSize)
+			if err != nil {
+				LOGE.Println("Could not create torrent: ", err)
+				return false
+			}
+
+			// Where the previous chunk ended
+			var end int64
+			for chunkNum := 0; chunkNum < torrent.NumChunks(t); chunkNum++ {
+				offset := torrent.ChunkOffset(t, chunkNum)
+				start, length, err := torrent.ChunkBounds(t, chunkNum)
+				if err != nil || int64(start) != offset {
+					LOGE.Println("Chunk Bounds disagrees with Chunk Offset for chunk ", chunkNum, " of ", size, " bytes: ", err)
+					return false
+				} else if offset != end {
+					LOGE.Println("Chunk ", chunkNum, " of ", size, " bytes starts at ", offset, " rather than ", end)
+					return false
+				}
+				end = offset + int64(length)
+				chunk := data[start : start+length]
+
+				if err := file.Truncate(0); err != nil {
+					LOGE.Println("Could not empty file: ", err)
+					return false
+				}
+				if err := torrent.WriteChunk(t, file, chunkNum, chunk); err != nil {
+					LOGE.Println("Write Chunk failed: ", err)
+					return false
+				}
+				written := make([]byte, length)
+				if fi, err := file.Stat(); err != nil || fi.Size() != end {
+					LOGE.Println("Chunk ", chunkNum, " of ", size, " bytes does not end where expected")
+					return false
+				} else if _, err := file.ReadAt(written, offset); err != nil || !bytes.Equal(written, chunk) {
+					LOGE.Println("Chunk ", chunkNum, " of ", size, " bytes was not written at ", offset)
+					return false
+				}
+				if read, err := torrent.ReadChunk(t, file, chunkNum); err != nil || !bytes.Equal(read, chunk) {
+					LOGE.Println("Read Chunk did not read chunk ", chunkNum, " back: ", err)
+					return false
+				}
+			}
+			if end != int64(size) {
+				LOGE.Println("Chunks of ", size, " bytes do not cover the file")
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Download a file whose only listed peer is unreachable, then again once a
+// real peer has it, and check the peer events the downloader reports
+func testPeerEvents() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, hostPorts, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	recorder := &peerEventRecorder{}
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	hostPort := net.JoinHostPort("localhost", strconv.Itoa(9091+41*(r.Int()%300)+7))
+	localFiles := make(map[torrentproto.ID]*clientproto.LocalFile)
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		LocalFiles:   localFiles,
+		Listener:     &nopListener{},
+		HostPort:     hostPort,
+		Verify:       client.VerifyFile,
+		PeerListener: recorder})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	path, _, err := createFile(dir, "data", 300)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 100)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	// Nothing listens on this port
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	deadPeer := ln.Addr().String()
+	ln.Close()
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		args := &trackerproto.ConfirmArgs{
+			Chunk:    chunkID,
+			HostPort: deadPeer}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk failed: ", err)
+			return false
+		}
+	}
+
+	LOGE.Println("Downloading from unreachable peer")
+	if err := c.DownloadFile(t, filepath.Join(dir, "download")); err == nil {
+		LOGE.Println("Download from unreachable peer succeeded")
+		return false
+	}
+	for _, outcome := range []clientproto.PeerOutcome{clientproto.PeerFound, clientproto.PeerFailed} {
+		if !recorder.waitFor(func(event clientproto.PeerEvent) bool {
+			return event.ID == t.ID && event.HostPort == deadPeer && event.Outcome == outcome &&
+				(outcome != clientproto.PeerFailed || event.Reason != "")
+		}) {
+			LOGE.Println("No event with outcome ", outcome, " for unreachable peer: ", recorder.events)
+			return false
+		}
+	}
+
+	LOGE.Println("Downloading from live peer")
+	if err := clients[0].OfferFile(t, path); err != nil {
+		LOGE.Println("Offer failed: ", err)
+		return false
+	}
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		args := &trackerproto.ReportArgs{
+			Chunk:    chunkID,
+			HostPort: deadPeer}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ReportMissing", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Report Missing failed: ", err)
+			return false
+		}
+	}
+	if err := c.DownloadFile(t, filepath.Join(dir, "download")); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	for chunkNum := 0; chunkNum < 3; chunkNum++ {
+		if !recorder.waitFor(func(event clientproto.PeerEvent) bool {
+			return event.ID == t.ID && event.ChunkNum == chunkNum && event.HostPort == hostPorts[0] && event.Outcome == clientproto.PeerSent
+		}) {
+			LOGE.Println("No event for chunk ", chunkNum, " sent by live peer: ", recorder.events)
+			return false
+		}
+	}
+	return true
+}
+
+// Check where ChunkLocation places the chunks of a single-file torrent, whose
+// final chunk is short, and of a multi-file torrent, whose chunks straddle
+// files and skip an empty one, and that a multi-file torrent's chunks are
+// written to and read from its data in one file
+func testChunkLocation() bool {
+	type location struct {
+		offset int64
+		length int
+		spans  []torrent.FileSpan
+	}
+	// Checks the location of each chunk of t against expected
+	check := func(t torrentproto.Torrent, expected []location) bool {
+		if torrent.NumChunks(t) != len(expected) {
+			LOGE.Println("Torrent has ", torrent.NumChunks(t), " chunks, not ", len(expected))
+			return false
+		}
+		for chunkNum, loc := range expected {
+			offset, length, spans, err := torrent.ChunkLocation(t, chunkNum)
+			if err != nil || offset != loc.offset || length != loc.length || len(spans) != len(loc.spans) {
+				LOGE.Println("Chunk ", chunkNum, " is at ", offset, length, spans, err)
+				return false
+			}
+			for i, span := range spans {
+				if span != loc.spans[i] {
+					LOGE.Println("Chunk ", chunkNum, " has span ", span, ", not ", loc.spans[i])
+					return false
+				}
+			}
+		}
+		if _, _, _, err := torrent.ChunkLocation(t, len(expected)); err == nil {
+			LOGE.Println("Chunk past the end has a location")
+			return false
+		}
+		return true
+	}
+
+	LOGE.Println("Locating chunks of a single file")
+	single := torrentproto.Torrent{ChunkSize: 1000, FileSize: 2500}
+	if !check(single, []location{
+		{0, 1000, []torrent.FileSpan{{File: 0, Offset: 0, Length: 1000}}},
+		{1000, 1000, []torrent.FileSpan{{File: 0, Offset: 1000, Length: 1000}}},
+		{2000, 500, []torrent.FileSpan{{File: 0, Offset: 2000, Length: 500}}}}) {
+		return false
+	}
+
+	LOGE.Println("Locating chunks of several files")
+	multi := torrentproto.Torrent{
+		ChunkSize: 1000,
+		FileSize:  2500,
+		Files: []torrentproto.File{
+			{Path: "a", Size: 600},
+			{Path: "empty", Size: 0},
+			{Path: "b", Size: 600},
+			{Path: "c", Size: 1300}}}
+	if !check(multi, []location{
+		{0, 1000, []torrent.FileSpan{{File: 0, Offset: 0, Length: 600}, {File: 2, Offset: 0, Length: 400}}},
+		{1000, 1000, []torrent.FileSpan{{File: 2, Offset: 400, Length: 200}, {File: 3, Offset: 0, Length: 800}}},
+		{2000, 500, []torrent.FileSpan{{File: 3, Offset: 800, Length: 500}}}}) {
+		return false
+	}
+	mismatched := multi
+	mismatched.FileSize = 2600
+	if _, _, _, err := torrent.ChunkLocation(mismatched, 0); err == nil || torrent.FilesMatch(mismatched) {
+		LOGE.Println("Files which do not add up to the file size were accepted")
+		return false
+	}
+
+	LOGE.Println("Writing and reading chunks of several files")
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+	file, err := os.Create(filepath.Join(dir, "data"))
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	defer file.Close()
+	data := make([]byte, multi.FileSize)
+	rand.Read(data)
+	for chunkNum := 0; chunkNum < torrent.NumChunks(multi); chunkNum++ {
+		offset, length, _, _ := torrent.ChunkLocation(multi, chunkNum)
+		if err := torrent.WriteChunk(multi, file, chunkNum, data[offset:offset+int64(length)]); err != nil {
+			LOGE.Println("Write Chunk failed: ", err)
+			return false
+		}
+	}
+	for chunkNum := 0; chunkNum < torrent.NumChunks(multi); chunkNum++ {
+		offset, length, _, _ := torrent.ChunkLocation(multi, chunkNum)
+		if chunk, err := torrent.ReadChunk(multi, file, chunkNum); err != nil || !bytes.Equal(chunk, data[offset:offset+int64(length)]) {
+			LOGE.Println("Read Chunk did not return chunk ", chunkNum, ": ", err)
+			return false
+		}
+	}
+	return true
+}
+
+// Write every chunk of a file into a new file, then corrupt a byte of one
+// chunk, and check that both the whole-file hash which a verifying client
+// checks downloads against and VerifyFile catch the corruption
+func testDetectCorruption() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", nil, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	file, err := os.Create(filepath.Join(dir, "download"))
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	defer file.Close()
+	for chunkNum := 0; chunkNum < torrent.NumChunks(t); chunkNum++ {
+		start, length, _ := torrent.ChunkBounds(t, chunkNum)
+		if err := torrent.WriteChunk(t, file, chunkNum, data[start:start+length]); err != nil {
+			LOGE.Println("Write Chunk failed: ", err)
+			return false
+		}
+	}
+	if hash, err := torrent.FileHash(t, file); err != nil || hash != t.ID.Hash {
+		LOGE.Println("Written file does not match its hash: ", err)
+		return false
+	}
+	if err := torrent.VerifyFile(t, file); err != nil {
+		LOGE.Println("Written file failed verification: ", err)
+		return false
+	}
+
+	LOGE.Println("Corrupting chunk 1")
+	if _, err := file.WriteAt([]byte{^data[1500]}, 1500); err != nil {
+		LOGE.Println("Could not corrupt file: ", err)
+		return false
+	}
+	if hash, err := torrent.FileHash(t, file); err != nil || hash == t.ID.Hash {
+		LOGE.Println("Corrupt file matches its hash: ", err)
+		return false
+	}
+	if err := torrent.VerifyFile(t, file); err == nil || !strings.Contains(err.Error(), "Chunk 1 ") {
+		LOGE.Println("Verification did not find the corrupt chunk: ", err)
+		return false
+	}
+	return true
+}
+
+// Run several downloads at once, with several workers each, from a peer which
+// is slow to send chunks, through a client which limits its transfers, and
+// check that the transfers of all the downloads together never went over the
+// limit, while still reaching it
+func testMaxTransfers() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	maxTransfers, numFiles := 3, 4
+	peer := &busyPeer{
+		files:     make(map[torrentproto.ID][]byte),
+		chunkSize: 100,
+		delay:     50 * time.Millisecond}
+	ln, err := startBusyPeer(peer)
+	if err != nil {
+		LOGE.Println("Could not create peer: ", err)
+		return false
+	}
+	defer ln.Close()
+
+	torrents := make([]torrentproto.Torrent, numFiles)
+	for i := range torrents {
+		name := "data" + strconv.Itoa(i)
+		path, data, err := createFile(dir, name, 800)
+		if err != nil {
+			LOGE.Println("Could not create file: ", err)
+			return false
+		}
+		t, err := torrent.NewWithChunkSize(path, name, trackerNodes, peer.chunkSize)
+		if err != nil {
+			LOGE.Println("Could not create torrent: ", err)
+			return false
+		}
+		reply := &trackerproto.UpdateReply{}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry failed: ", err)
+			return false
+		}
+		for _, chunkID := range torrent.AllChunkIDs(t) {
+			args := &trackerproto.ConfirmArgs{
+				Chunk:    chunkID,
+				HostPort: ln.Addr().String()}
+			if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk failed: ", err)
+				return false
+			}
+		}
+		peer.files[t.ID] = data
+		torrents[i] = t
+	}
+
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener:        &nopListener{},
+		HostPort:        hostPort,
+		DownloadWorkers: 4,
+		MaxTransfers:    maxTransfers})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer c.Close()
+
+	LOGE.Println("Downloading ", numFiles, " files at once")
+	errs := make(chan error, numFiles)
+	for i, t := range torrents {
+		go func(t torrentproto.Torrent, path string) {
+			errs <- c.DownloadFile(t, path)
+		}(t, filepath.Join(dir, "download"+strconv.Itoa(i)))
+	}
+	for range torrents {
+		if err := <-errs; err != nil {
+			LOGE.Println("Download failed: ", err)
+			return false
+		}
+	}
+	most := peer.mostSending()
+	LOGE.Println("At most ", most, " transfers at once")
+	if most != maxTransfers {
+		LOGE.Println("Transfers did not stay at or reach the limit of ", maxTransfers)
+		return false
+	}
+	return true
+}
+
+// List the torrents of a tracker of several nodes many times through clients
+// with each selector, and check that the list selector always asks the first
+// node, while the random and round-robin selectors spread the calls across
+// every node
+func testTrackerLoadSpread() bool {
+	numNodes, calls := 3, 60
+	// Lists the torrents calls times through a client with selector, and
+	// returns how many times each node was asked
+	spread := func(selector client.TrackerSelector) ([]int, bool) {
+		trackers := make([]*countingTracker, numNodes)
+		trackerNodes := make([]torrentproto.TrackerNode, numNodes)
+		for i := range trackers {
+			trackers[i] = &countingTracker{}
+			ln, hostPort, err := createCountingTracker(trackers[i])
+			if err != nil {
+				LOGE.Println("Could not create tracker: ", err)
+				return nil, false
+			}
+			defer ln.Close()
+			trackerNodes[i] = torrentproto.TrackerNode{HostPort: hostPort}
+		}
+
+		hostPort, err := freeHostPort()
+		if err != nil {
+			LOGE.Println("Could not find a free port: ", err)
+			return nil, false
+		}
+		c, err := client.NewClientWithConfig(client.ClientConfig{
+			Listener: &nopListener{},
+			HostPort: hostPort,
+			Selector: selector})
+		if err != nil {
+			LOGE.Println("Could not create client: ", err)
+			return nil, false
+		}
+		defer c.Close()
+		for i := 0; i < calls; i++ {
+			if _, err := c.ListTorrents(trackerNodes); err != nil {
+				LOGE.Println("List Torrents failed: ", err)
+				return nil, false
+			}
+		}
+
+		counts := make([]int, numNodes)
+		for i, ct := range trackers {
+			counts[i] = int(atomic.LoadInt32(&ct.listed))
+		}
+		return counts, true
+	}
+
+	LOGE.Println("Listing through the list selector")
+	if counts, ok := spread(client.NewListSelector()); !ok {
+		return false
+	} else if counts[0] != calls {
+		LOGE.Println("List selector did not always ask the first node: ", counts)
+		return false
+	}
+
+	LOGE.Println("Listing through the round-robin selector")
+	if counts, ok := spread(client.NewRoundRobinSelector()); !ok {
+		return false
+	} else {
+		for _, count := range counts {
+			if count != calls/numNodes {
+				LOGE.Println("Round-robin selector did not ask each node in turn: ", counts)
+				return false
+			}
+		}
+	}
+
+	LOGE.Println("Listing through the random selector")
+	if counts, ok := spread(client.NewRandomSelector()); !ok {
+		return false
+	} else {
+		// Each node is expected to be asked calls/numNodes times; allow
+		// plenty of room for chance
+		for _, count := range counts {
+			if count < calls/numNodes/3 {
+				LOGE.Println("Random selector did not spread calls across nodes: ", counts)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Write every chunk of a file into one file handle from its own goroutine at
+// once, then read them all back the same way, and check that the file, and
+// every chunk read, matches
+func testParallelWrites() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	path, data, err := createFile(dir, "data", 200*97+31)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", nil, 97)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	file, err := os.Create(filepath.Join(dir, "download"))
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	defer file.Close()
+
+	// Runs op on every chunk at once, in a random order, and returns how many
+	// failed
+	parallel := func(op func(chunkNum int) bool) int32 {
+		var failed int32
+		var wg sync.WaitGroup
+		for _, chunkNum := range mrand.Perm(torrent.NumChunks(t)) {
+			wg.Add(1)
+			go func(chunkNum int) {
+				defer wg.Done()
+				if !op(chunkNum) {
+					atomic.AddInt32(&failed, 1)
+				}
+			}(chunkNum)
+		}
+		wg.Wait()
+		return failed
+	}
+	chunkOf := func(chunkNum int) []byte {
+		start, length, _ := torrent.ChunkBounds(t, chunkNum)
+		return data[start : start+length]
+	}
+
+	LOGE.Println("Writing ", torrent.NumChunks(t), " chunks at once")
+	if failed := parallel(func(chunkNum int) bool {
+		return torrent.WriteChunk(t, file, chunkNum, chunkOf(chunkNum)) == nil
+	}); failed > 0 {
+		LOGE.Println("Write Chunk failed for ", failed, " chunks")
+		return false
+	}
+	if written, err := ioutil.ReadFile(file.Name()); err != nil || !bytes.Equal(written, data) {
+		LOGE.Println("Written file does not match: ", err)
+		return false
+	}
+
+	LOGE.Println("Reading chunks at once")
+	if failed := parallel(func(chunkNum int) bool {
+		chunk, err := torrent.ReadChunk(t, file, chunkNum)
+		return err == nil && bytes.Equal(chunk, chunkOf(chunkNum))
+	}); failed > 0 {
+		LOGE.Println("Read Chunk did not return ", failed, " chunks")
+		return false
+	}
+	return true
+}
+
+// Check that a prioritized chunk is downloaded before the chunks which were
+// waiting ahead of it.
+// A single worker downloads from a peer which holds back every chunk but the
+// first, so the download cannot get past the chunk it has in flight until the
+// peer is released
+func testPrioritizeChunk() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	path, data, err := createFile(dir, "data", 10000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	ln, peer, err := createGatedPeer(data, 1000)
+	if err != nil {
+		LOGE.Println("Could not create peer: ", err)
+		return false
+	}
+	defer ln.Close()
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		args := &trackerproto.ConfirmArgs{
+			Chunk:    chunkID,
+			HostPort: ln.Addr().String()}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk failed: ", err)
+			return false
+		}
+	}
+
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener:        &nopListener{},
+		HostPort:        hostPort,
+		DownloadWorkers: 1})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer c.Close()
+
+	if err := c.PrioritizeChunk(t.ID, 0); err == nil {
+		LOGE.Println("Prioritized a chunk with no download in progress")
+		return false
+	}
+
+	progress, errs := c.DownloadFileProgress(t, filepath.Join(dir, "download"))
+
+	// Prioritize the last chunk which is still waiting. At most one chunk
+	// other than the first can have been taken from the queue by now.
+	prioritized := -1
+	for deadline := time.Now().Add(2 * time.Second); prioritized < 0 && time.Now().Before(deadline); {
+		for chunkNum := torrent.NumChunks(t) - 1; chunkNum > 0; chunkNum-- {
+			if c.PrioritizeChunk(t.ID, chunkNum) == nil {
+				prioritized = chunkNum
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if prioritized < 0 {
+		LOGE.Println("Could not prioritize a chunk")
+		return false
+	}
+	LOGE.Println("Prioritized chunk ", prioritized)
+	close(peer.release)
+
+	// Apart from the first chunk, only the chunk which was in flight may
+	// arrive before the prioritized one.
+	ahead := 0
+	for p := range progress {
+		if p.ChunkNum == prioritized {
+			break
+		} else if p.ChunkNum != 0 {
+			ahead++
+		}
+	}
+	if ahead > 1 {
+		LOGE.Println(ahead, " chunks arrived before the prioritized chunk")
+		return false
+	}
+	for range progress {
+	}
+	if err := <-errs; err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	if err := c.PrioritizeChunk(t.ID, prioritized); err == nil {
+		LOGE.Println("Prioritized a chunk after the download finished")
+		return false
+	}
+	return true
+}
+
+// Stream a file from a client with a prefetch window to a consumer which reads
+// one chunk at a time, pausing between chunks. Check that the client only
+// fetches the chunks inside the window, both before the consumer starts and
+// as it reads, and that the whole file arrives
+func testPrefetchWindow() bool {
+	const window = 3
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	seeders, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	defer seeders[0].Close()
+	path, data, err := createFile(dir, "data", 20000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := seeders[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		HostPort:       hostPort,
+		PrefetchWindow: window})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer c.Close()
+
+	downloadPath := filepath.Join(dir, "download")
+	_, errs := c.DownloadFileProgress(t, downloadPath)
+	numChunks := torrent.NumChunks(t)
+
+	// Checks that the client gets the chunks up to window past position, and
+	// then stops
+	paced := func(position int) bool {
+		want := position + window + 1
+		if want > numChunks {
+			want = numChunks
+		}
+		have := 0
+		for deadline := time.Now().Add(2 * time.Second); have < want && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			if have, _, err = c.Progress(t.ID); err != nil {
+				LOGE.Println("Progress failed: ", err)
+				return false
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		if have, _, _ = c.Progress(t.ID); have != want {
+			LOGE.Println("Client has ", have, " chunks with the consumer at chunk ", position, ", not ", want)
+			return false
+		}
+		return true
+	}
+
+	LOGE.Println("Waiting for the first chunks")
+	if !paced(0) {
+		return false
+	}
+
+	reader, err := c.TorrentReaderAt(t.ID)
+	if err != nil {
+		LOGE.Println("Torrent Reader At failed: ", err)
+		return false
+	}
+	LOGE.Println("Reading file slowly")
+	for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+		chunk := make([]byte, t.ChunkSize)
+		if n, err := reader.ReadAt(chunk, torrent.ChunkOffset(t, chunkNum)); err != nil && err != io.EOF {
+			LOGE.Println("Could not read chunk ", chunkNum, ": ", err)
+			return false
+		} else if !bytes.Equal(chunk[:n], data[chunkNum*t.ChunkSize:chunkNum*t.ChunkSize+n]) {
+			LOGE.Println("Read wrong data for chunk ", chunkNum)
+			return false
+		}
+		if !paced(chunkNum) {
+			return false
+		}
+	}
+
+	if err := <-errs; err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+	return true
+}
+
+// Check that the goroutine which passes events to a client's PeerEventListener
+// stops when the client is closed
+func testPeerListenerStops() bool {
+	// Counts the goroutines passing on peer events
+	passing := func() int {
+		stacks := make([]byte, 1<<20)
+		return strings.Count(string(stacks[:runtime.Stack(stacks, true)]), "passPeerEvents")
+	}
+	before := passing()
+
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		HostPort:     hostPort,
+		PeerListener: &peerEventRecorder{}})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	for deadline := time.Now().Add(time.Second); passing() != before+1; {
+		if time.Now().After(deadline) {
+			LOGE.Println("No goroutine is passing on peer events for the client")
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.Close(); err != nil {
+		LOGE.Println("Close failed: ", err)
+		return false
+	}
+
+	for deadline := time.Now().Add(time.Second); passing() != before; {
+		if time.Now().After(deadline) {
+			LOGE.Println("Peer events are still passed on after the client closed")
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Check that AllChunkIDs lists chunks 0 to NumChunks-1 of a torrent, in order,
+// for files which do and do not fill their final chunk
+func testAllChunkIDs() bool {
+	id := torrentproto.ID{Name: "data", Hash: "hash"}
+	for _, fileSize := range []int{0, 1, 999, 1000, 1001, 2500} {
+		t := torrentproto.Torrent{ID: id, FileSize: fileSize, ChunkSize: 1000}
+		chunkIDs := torrent.AllChunkIDs(t)
+		if len(chunkIDs) != torrent.NumChunks(t) {
+			LOGE.Println("Wrong number of chunk IDs for ", fileSize, " bytes: ", len(chunkIDs))
+			return false
+		}
+		for chunkNum, chunkID := range chunkIDs {
+			if chunkID != torrentproto.NewChunkID(id, chunkNum) || chunkID.ID != id || chunkID.ChunkNum != chunkNum {
+				LOGE.Println("Wrong chunk ID for ", fileSize, " bytes: ", chunkID)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Limits which chunks a client serves, and checks that the withheld chunks
+// are neither served nor advertised to the tracker
+func testServableChunks() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, hostPorts, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, _, err := createFile(dir, "data", 3000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	// Checks whether the client serves each chunk, and whether the tracker
+	// lists it as a peer for each chunk
+	check := func(served []bool) bool {
+		for chunkNum, want := range served {
+			chunk := torrentproto.NewChunkID(t.ID, chunkNum)
+			reply := &clientproto.GetReply{}
+			if err := clients[0].GetChunk(&clientproto.GetArgs{ChunkID: chunk}, reply); err != nil {
+				LOGE.Println("Get Chunk failed: ", err)
+				return false
+			}
+			if (reply.Status == clientproto.OK) != want {
+				LOGE.Println("Client is wrong about serving chunk ", chunkNum, ": ", reply.Status)
+				return false
+			}
+			if has, err := peerHasChunk(trackerNodes[0].HostPort, chunk, hostPorts[0]); err != nil || has != want {
+				LOGE.Println("Tracker is wrong about chunk ", chunkNum, err)
+				return false
+			}
+		}
+		return true
+	}
+
+	LOGE.Println("Withholding chunk 1")
+	if err := clients[0].SetServableChunks(t.ID, []int{0, 2}); err != nil {
+		LOGE.Println("Set Servable Chunks failed: ", err)
+		return false
+	}
+	if !check([]bool{true, false, true}) {
+		return false
+	}
+
+	LOGE.Println("Refreshing withheld chunk")
+	if err := clients[0].RefreshChunk(t.ID, 1); err != nil {
+		LOGE.Println("Refresh Chunk failed: ", err)
+		return false
+	}
+	if !check([]bool{true, false, true}) {
+		return false
+	}
+
+	LOGE.Println("Serving every chunk again")
+	if err := clients[0].SetServableChunks(t.ID, nil); err != nil {
+		LOGE.Println("Set Servable Chunks failed: ", err)
+		return false
+	}
+	if !check([]bool{true, true, true}) {
+		return false
+	}
+
+	LOGE.Println("Withholding bad chunks")
+	if err := clients[0].SetServableChunks(t.ID, []int{3}); err == nil {
+		LOGE.Println("Serving chunk out of range succeeded")
+		return false
+	}
+	if err := clients[0].SetServableChunks(torrentproto.ID{Name: "unknown"}, nil); err == nil {
+		LOGE.Println("Serving chunks of unknown torrent succeeded")
+		return false
+	}
+	return true
+}
+
+// Serve a chunk which is corrupt on disk under each serve policy, and check
+// that it is served as it is, withheld and reported missing, or repaired from
+// another peer
+func testServePolicies() bool {
+	for _, policy := range []client.ServePolicy{client.ServeTrust, client.ServeVerify, client.ServeVerifyAndRepair} {
+		LOGE.Println("Serving corrupt chunk with policy ", policy)
+		if !servePolicy(policy) {
+			return false
+		}
+	}
+	return true
+}
+
+// Offers a file on a good client and on a client with the given serve policy,
+// corrupts one chunk of the latter's copy, and asks it for that chunk
+func servePolicy(policy client.ServePolicy) bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	localFiles := make(map[torrentproto.ID]*clientproto.LocalFile)
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		LocalFiles:  localFiles,
+		Listener:    &nopListener{},
+		HostPort:    hostPort,
+		Verify:      client.VerifyFile,
+		ServePolicy: policy})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 3000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	corrupt := make([]byte, len(data))
+	copy(corrupt, data)
+	corrupt[1500] ^= 0xff
+	corruptPath := filepath.Join(dir, "corrupt")
+	if err := ioutil.WriteFile(corruptPath, corrupt, 0644); err != nil {
+		LOGE.Println("Could not write corrupt file: ", err)
+		return false
+	}
+	if err := c.OfferFile(t, corruptPath); err != nil {
+		LOGE.Println("Offer File failed: ", err)
+		return false
+	}
+
+	chunk := torrentproto.NewChunkID(t.ID, 1)
+	reply := &clientproto.GetReply{}
+	if err := c.GetChunk(&clientproto.GetArgs{ChunkID: chunk}, reply); err != nil {
+		LOGE.Println("Get Chunk failed: ", err)
+		return false
+	}
+
+	// Whether the tracker should still list the client for the chunk, and
+	// what should be on disk
+	listed, onDisk := true, corrupt
+	switch policy {
+	case client.ServeTrust:
+		if reply.Status != clientproto.OK || !bytes.Equal(reply.Chunk, corrupt[1000:2000]) {
+			LOGE.Println("Trusting client did not serve chunk as it is: ", reply.Status)
+			return false
+		}
+	case client.ServeVerify:
+		if reply.Status != clientproto.ChunkNotFound {
+			LOGE.Println("Verifying client served corrupt chunk: ", reply.Status)
+			return false
+		}
+		listed = false
+	case client.ServeVerifyAndRepair:
+		if reply.Status != clientproto.OK || !bytes.Equal(reply.Chunk, data[1000:2000]) {
+			LOGE.Println("Repairing client did not serve good chunk: ", reply.Status)
+			return false
+		}
+		onDisk = data
+	}
+
+	// The tracker is told in the background, so give it a moment
+	for i := 0; ; i++ {
+		has, err := peerHasChunk(trackerNodes[0].HostPort, chunk, hostPort)
+		if err == nil && has == listed {
+			break
+		} else if i == 20 {
+			LOGE.Println("Tracker is wrong about chunk: ", has, err)
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if contents, err := ioutil.ReadFile(corruptPath); err != nil || !bytes.Equal(contents, onDisk) {
+		LOGE.Println("Wrong file contents after serving chunk: ", err)
+		return false
+	}
+	return true
+}
+
+// Download a file whose first chunk is corrupted on disk after it is written,
+// and check that a client which verifies downloads fails, while one which
+// also repairs them fetches the chunk again
+func testRepairDownload() bool {
+	for _, mode := range []client.VerifyMode{client.VerifyFile, client.VerifyAndRepair} {
+		LOGE.Println("Downloading with verify mode ", mode)
+		if !repairDownload(mode) {
+			return false
+		}
+	}
+	return true
+}
+
+// Downloads a file from a gated peer with the given verify mode, corrupting
+// the first chunk once it has arrived, before the peer sends the rest
+func repairDownload(mode client.VerifyMode) bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	path, data, err := createFile(dir, "data", 3000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	ln, peer, err := createGatedPeer(data, 1000)
+	if err != nil {
+		LOGE.Println("Could not create peer: ", err)
+		return false
+	}
+	defer ln.Close()
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		args := &trackerproto.ConfirmArgs{
+			Chunk:    chunkID,
+			HostPort: ln.Addr().String()}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk failed: ", err)
+			return false
+		}
+	}
+
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	localFiles := make(map[torrentproto.ID]*clientproto.LocalFile)
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		LocalFiles: localFiles,
+		Listener:   &nopListener{},
+		HostPort:   hostPort,
+		Verify:     mode})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	downloadPath := filepath.Join(dir, "download")
+	progress, errs := c.DownloadFileProgress(t, downloadPath)
+	if event, ok := <-progress; !ok || event.ChunkNum != 0 {
+		LOGE.Println("First chunk to arrive was not chunk 0: ", event, ok)
+		return false
+	}
+	file, err := os.OpenFile(downloadPath, os.O_RDWR, 0644)
+	if err != nil {
+		LOGE.Println("Could not open download: ", err)
+		return false
+	}
+	_, err = file.WriteAt([]byte{^data[500]}, 500)
+	file.Close()
+	if err != nil {
+		LOGE.Println("Could not corrupt download: ", err)
+		return false
+	}
+	close(peer.release)
+
+	err = <-errs
+	if mode == client.VerifyFile {
+		if err == nil {
+			LOGE.Println("Corrupt download succeeded")
+			return false
+		}
+		return true
+	}
+	if err != nil {
+		LOGE.Println("Download was not repaired: ", err)
+		return false
+	}
+	if contents, err := ioutil.ReadFile(downloadPath); err != nil || !bytes.Equal(contents, data) {
+		LOGE.Println("Repaired download does not match file: ", err)
+		return false
+	}
+	return true
+}
+
+// Close a client while it is downloading a file from a gated peer, then let
+// the peer send the rest, and check that the download ends and closes its
+// progress channel rather than hanging
+func testCloseMidDownload() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	path, data, err := createFile(dir, "data", 3000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	ln, peer, err := createGatedPeer(data, 1000)
+	if err != nil {
+		LOGE.Println("Could not create peer: ", err)
+		return false
+	}
+	defer ln.Close()
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		args := &trackerproto.ConfirmArgs{
+			Chunk:    chunkID,
+			HostPort: ln.Addr().String()}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk failed: ", err)
+			return false
+		}
+	}
+
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener: &nopListener{},
+		HostPort: hostPort})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	progress, errs := c.DownloadFileProgress(t, filepath.Join(dir, "download"))
+	if _, ok := <-progress; !ok {
+		LOGE.Println("Download ended before the first chunk arrived")
+		return false
+	}
+	LOGE.Println("Closing client mid download")
+	if err := c.Close(); err != nil {
+		LOGE.Println("Close failed: ", err)
+		return false
+	}
+	close(peer.release)
+
+	timeout := time.After(2 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-progress:
+		case <-timeout:
+			LOGE.Println("Progress was not closed after the client closed")
+			return false
+		}
+	}
+	select {
+	case <-errs:
+	case <-timeout:
+		LOGE.Println("Download did not end after the client closed")
+		return false
+	}
+	return true
+}
+
+// Check that a ClientConfig fills in its defaults and rejects invalid
+// settings, and that a Client given nothing but a host:port starts
+func testClientConfig() bool {
+	cfg, err := client.ClientConfig{HostPort: "localhost:9091"}.WithDefaults()
+	if err != nil {
+		LOGE.Println("Config was rejected: ", err)
+		return false
+	}
+	if cfg.LocalFiles == nil || cfg.Listener == nil || cfg.Selector == nil ||
+		cfg.MaxChunkSize != client.DEFAULT_MAX_CHUNK_SIZE || cfg.StreamThreshold != client.DEFAULT_STREAM_THRESHOLD || cfg.DownloadWorkers != client.DEFAULT_DOWNLOAD_WORKERS ||
+		cfg.Verify != client.VerifyNone || cfg.ServePolicy != client.ServeTrust || cfg.ChunkOrder != client.OrderRandom || cfg.MaxTransfers != 0 || cfg.OfferTimeout != 0 ||
+		cfg.ChunkRetries != 0 || cfg.RetryDelay != time.Millisecond*time.Duration(client.DEFAULT_RETRY_DELAY) {
+		LOGE.Println("Defaults not filled in: ", cfg)
+		return false
+	}
+
+	invalid := map[string]client.ClientConfig{
+		"no host:port":             client.ClientConfig{},
+		"negative MaxTransfers":    client.ClientConfig{HostPort: "localhost:9091", MaxTransfers: -1},
+		"negative MaxChunkSize":    client.ClientConfig{HostPort: "localhost:9091", MaxChunkSize: -1},
+		"negative StreamThreshold": client.ClientConfig{HostPort: "localhost:9091", StreamThreshold: -1},
+		"negative DownloadWorkers": client.ClientConfig{HostPort: "localhost:9091", DownloadWorkers: -1},
+		"negative PrefetchWindow":  client.ClientConfig{HostPort: "localhost:9091", PrefetchWindow: -1},
+		"negative OfferTimeout":    client.ClientConfig{HostPort: "localhost:9091", OfferTimeout: -time.Second},
+		"negative ChunkRetries":    client.ClientConfig{HostPort: "localhost:9091", ChunkRetries: -1},
+		"negative RetryDelay":      client.ClientConfig{HostPort: "localhost:9091", RetryDelay: -time.Second},
+		"unknown verify mode":      client.ClientConfig{HostPort: "localhost:9091", Verify: client.VerifyMode(7)},
+		"unknown serve policy":     client.ClientConfig{HostPort: "localhost:9091", ServePolicy: client.ServePolicy(-1)},
+		"unknown chunk order":      client.ClientConfig{HostPort: "localhost:9091", ChunkOrder: client.ChunkOrder(2)},
+	}
+	for name, cfg := range invalid {
+		if _, err := cfg.WithDefaults(); err == nil {
+			LOGE.Println("Config with ", name, " was accepted")
+			return false
+		}
+		if c, err := client.NewClientWithConfig(cfg); err == nil {
+			LOGE.Println("Client with ", name, " was started")
+			c.Close()
+			return false
+		}
+	}
+
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	hostPort := net.JoinHostPort("localhost", strconv.Itoa(9091+41*(r.Int()%300)+11))
+	c, err := client.NewClient(nil, nil, hostPort)
+	if err != nil {
+		LOGE.Println("Could not start client with the default config: ", err)
+		return false
+	}
+	defer c.Close()
+	if tracked := c.TrackedTorrents(); len(tracked) != 0 {
+		LOGE.Println("New client is tracking torrents: ", tracked)
+		return false
+	}
+	return true
+}
+
+// Truncate the file out from under a client which seeds it, and ask it for a
+// chunk past the new end. It should stop serving and advertising the lost
+// chunks, and a client which repairs the chunks it serves should then
+// download them again
+func testTruncatedSeeder() bool {
+	for _, policy := range []client.ServePolicy{client.ServeTrust, client.ServeVerifyAndRepair} {
+		LOGE.Println("Serving truncated file with policy ", policy)
+		if !truncatedSeeder(policy) {
+			return false
+		}
+	}
+	return true
+}
+
+// Offers a file on a good client and on a client with the given serve policy,
+// truncates the latter's copy partway through its second chunk, and asks it
+// for its last chunk
+func truncatedSeeder(policy client.ServePolicy) bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener:    &nopListener{},
+		HostPort:    hostPort,
+		ServePolicy: policy})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer c.Close()
+
+	path, data, err := createFile(dir, "data", 3000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	copyPath := filepath.Join(dir, "copy")
+	if err := ioutil.WriteFile(copyPath, data, 0644); err != nil {
+		LOGE.Println("Could not write copy of file: ", err)
+		return false
+	}
+	if err := c.OfferFile(t, copyPath); err != nil {
+		LOGE.Println("Offer File failed: ", err)
+		return false
+	}
+
+	if err := os.Truncate(copyPath, 1500); err != nil {
+		LOGE.Println("Could not truncate file: ", err)
+		return false
+	}
+	reply := &clientproto.GetReply{}
+	if err := c.GetChunk(&clientproto.GetArgs{ChunkID: torrentproto.NewChunkID(t.ID, 2)}, reply); err != nil || reply.Status != clientproto.ChunkNotFound {
+		LOGE.Println("Get Chunk: truncated chunk was served: ", reply.Status, err)
+		return false
+	}
+
+	// Whether the tracker should end up listing the client for each chunk,
+	// and what should end up on disk
+	listed, onDisk := []bool{true, false, false}, data[:1500]
+	if policy == client.ServeVerifyAndRepair {
+		listed, onDisk = []bool{true, true, true}, data
+	}
+
+	// The tracker is told, and the chunks restored, in the background, so
+	// give them a moment
+	for i := 0; ; i++ {
+		done := true
+		for chunkNum, want := range listed {
+			has, err := peerHasChunk(trackerNodes[0].HostPort, torrentproto.NewChunkID(t.ID, chunkNum), hostPort)
+			done = done && err == nil && has == want
+		}
+		contents, err := ioutil.ReadFile(copyPath)
+		done = done && err == nil && bytes.Equal(contents, onDisk)
+		if done {
+			break
+		} else if i == 40 {
+			LOGE.Println("Tracker or file is wrong after truncation")
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The chunk before the truncation is still served, and so are the
+	// others if they were restored
+	for chunkNum, want := range listed {
+		reply := &clientproto.GetReply{}
+		err := c.GetChunk(&clientproto.GetArgs{ChunkID: torrentproto.NewChunkID(t.ID, chunkNum)}, reply)
+		if err != nil || (reply.Status == clientproto.OK) != want {
+			LOGE.Println("Get Chunk: wrong status for chunk ", chunkNum, ": ", reply.Status, err)
+			return false
+		}
+		if want && !bytes.Equal(reply.Chunk, data[chunkNum*1000:(chunkNum+1)*1000]) {
+			LOGE.Println("Get Chunk: wrong data for chunk ", chunkNum)
+			return false
+		}
+	}
+	return true
+}
+
+// Publish files with different chunk sizes, and browse the tracker for them
+func testListTorrents() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	// Published out of order, so that the list must sort them
+	torrents := make([]torrentproto.Torrent, 0)
+	for i, name := range []string{"b", "a"} {
+		path, _, err := createFile(dir, name, 2500)
+		if err != nil {
+			LOGE.Println("Could not create file: ", err)
+			return false
+		}
+		t, err := clients[0].CreateAndOffer(path, 1000*(i+1), trackerNodes)
+		if err != nil {
+			LOGE.Println("Create And Offer failed: ", err)
+			return false
+		}
+		torrents = append([]torrentproto.Torrent{t}, torrents...)
+	}
+
+	summaries, err := clients[0].ListTorrents(trackerNodes)
+	if err != nil || len(summaries) != len(torrents) {
+		LOGE.Println("List Torrents: wrong torrents: ", summaries, err)
+		return false
+	}
+	for i, summary := range summaries {
+		if summary.ID != torrents[i].ID || summary.FileSize != torrents[i].FileSize || summary.ChunkSize != torrents[i].ChunkSize {
+			LOGE.Println("List Torrents: wrong torrent ", summary, " at ", i)
+			return false
+		}
+	}
+
+	// With no tracker nodes, there is nobody to ask
+	if _, err := clients[0].ListTorrents(nil); err == nil {
+		LOGE.Println("List Torrents: listed torrents without a tracker")
+		return false
+	}
+	return true
+}
+
+// Check that SetConcurrency rejects non-positive concurrencies, and that
+// downloads started after it is changed still fetch every chunk intact, one
+// at a time or many at once
+func testConcurrency() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	for _, n := range []int{0, -1} {
+		if err := clients[1].SetConcurrency(n); err == nil {
+			LOGE.Println("Set Concurrency accepted ", n)
+			return false
+		}
+	}
+
+	path, data, err := createFile(dir, "data", 2050)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 100, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	for _, n := range []int{1, 8} {
+		LOGE.Println("Downloading ", n, " chunks at once")
+		if err := clients[1].SetConcurrency(n); err != nil {
+			LOGE.Println("Set Concurrency failed: ", err)
+			return false
+		}
+		downloadPath := filepath.Join(dir, "download"+strconv.Itoa(n))
+		if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+			LOGE.Println("Download failed: ", err)
+			return false
+		}
+		downloaded, err := ioutil.ReadFile(downloadPath)
+		if err != nil || !bytes.Equal(downloaded, data) {
+			LOGE.Println("Downloaded file does not match")
+			return false
+		}
+	}
+	return true
+}
+
+// Download a file which a seeder only serves half of, then download it again
+// to the same path, and check that the chunks the first download got are kept,
+// and that a kept chunk which was corrupted on disk is downloaded again
+func testResumeDownload() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 1000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 100, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading with odd chunks withheld")
+	if err := clients[0].SetServableChunks(t.ID, []int{0, 2, 4, 6, 8}); err != nil {
+		LOGE.Println("Set Servable Chunks failed: ", err)
+		return false
+	}
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, downloadPath); err == nil {
+		LOGE.Println("Download succeeded without odd chunks")
+		return false
+	}
+	plan, err := clients[1].PlanDownload(t)
+	if err != nil {
+		LOGE.Println("Plan Download failed: ", err)
+		return false
+	}
+	for _, chunk := range plan.Chunks {
+		if chunk.Local && chunk.ChunkNum%2 == 1 {
+			LOGE.Println("Client has withheld chunk ", chunk.ChunkNum)
+			return false
+		}
+	}
+
+	LOGE.Println("Resuming with every chunk served")
+	if err := clients[0].SetServableChunks(t.ID, nil); err != nil {
+		LOGE.Println("Set Servable Chunks failed: ", err)
+		return false
+	}
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Resumed download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Resumed download does not match")
+		return false
+	}
+
+	// The seeder now only serves chunk 3, so downloading any other chunk
+	// again would fail
+	LOGE.Println("Resuming with a corrupt chunk")
+	file, err := os.OpenFile(downloadPath, os.O_RDWR, 0644)
+	if err != nil {
+		LOGE.Println("Could not open download: ", err)
+		return false
+	}
+	_, err = file.WriteAt([]byte{^data[300]}, 300)
+	file.Close()
+	if err != nil {
+		LOGE.Println("Could not corrupt download: ", err)
+		return false
+	}
+	if err := clients[0].SetServableChunks(t.ID, []int{3}); err != nil {
+		LOGE.Println("Set Servable Chunks failed: ", err)
+		return false
+	}
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Resumed download failed: ", err)
+		return false
+	}
+	downloaded, err = ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Resumed download does not match")
+		return false
+	}
+	return true
+}
+
+// Offer a file which does not match its torrent from a client which verifies
+// offers, and check that the offer is refused and nothing is served, and that
+// the file is offered once it matches again
+func testVerifyOffers() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	hostPort := net.JoinHostPort("localhost", strconv.Itoa(9091+41*(r.Int()%300)+11))
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener:     &nopListener{},
+		HostPort:     hostPort,
+		VerifyOffers: true})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 1000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 100)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	// Checks that an offer of the file as it now is fails, and that the
+	// client does not serve chunk 4
+	refused := func() bool {
+		if err := c.OfferFile(t, path); err == nil {
+			LOGE.Println("Offer of mismatched file succeeded")
+			return false
+		}
+		getReply := &clientproto.GetReply{}
+		if err := c.GetChunk(&clientproto.GetArgs{ChunkID: torrentproto.NewChunkID(t.ID, 4)}, getReply); err != nil || getReply.Status != clientproto.ChunkNotFound {
+			LOGE.Println("Client serves chunk of refused file: ", getReply.Status, err)
+			return false
+		}
+		return true
+	}
+
+	LOGE.Println("Offering corrupt file")
+	corrupt := append([]byte{}, data...)
+	corrupt[450] ^= 0xff
+	if err := ioutil.WriteFile(path, corrupt, 0644); err != nil {
+		LOGE.Println("Could not corrupt file: ", err)
+		return false
+	}
+	if !refused() {
+		return false
+	}
+
+	LOGE.Println("Offering truncated file")
+	if err := ioutil.WriteFile(path, data[:900], 0644); err != nil {
+		LOGE.Println("Could not truncate file: ", err)
+		return false
+	}
+	if !refused() {
+		return false
+	}
+
+	LOGE.Println("Offering good file")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		LOGE.Println("Could not restore file: ", err)
+		return false
+	}
+	if err := c.OfferFile(t, path); err != nil {
+		LOGE.Println("Offer failed: ", err)
+		return false
+	}
+	return true
+}
+
+// Create torrents for files which do and do not fill their final chunk, and
+// check their IDs and chunk hashes against sha1 hashes of the files' contents
+func testNewTorrent() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	sum := func(data []byte) string {
+		hash := sha1.Sum(data)
+		return string(hash[:])
+	}
+	for _, fileSize := range []int{0, 1, 999, 1000, 2500} {
+		path, data, err := createFile(dir, "data"+strconv.Itoa(fileSize), fileSize)
+		if err != nil {
+			LOGE.Println("Could not create file: ", err)
+			return false
+		}
+		t, err := torrent.NewWithChunkSize(path, "data", nil, 1000)
+		if err != nil {
+			LOGE.Println("Could not create torrent: ", err)
+			return false
+		}
+		if t.FileSize != fileSize || t.ID.Hash != sum(data) {
+			LOGE.Println("Wrong size or hash for ", fileSize, " bytes: ", t)
+			return false
+		}
+		if len(t.ChunkHashes) != torrent.NumChunks(t) {
+			LOGE.Println("Wrong number of chunk hashes for ", fileSize, " bytes: ", len(t.ChunkHashes))
+			return false
+		}
+		for chunkNum := 0; chunkNum*1000 < fileSize; chunkNum++ {
+			end := (chunkNum + 1) * 1000
+			if end > fileSize {
+				end = fileSize
+			}
+			if t.ChunkHashes[chunkNum] != sum(data[chunkNum*1000:end]) {
+				LOGE.Println("Wrong hash for chunk ", chunkNum, " of ", fileSize, " bytes")
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Save a torrent and load it back, and check that torrent files in another
+// format version, or from before files were versioned, are rejected
+func testTorrentFileVersion() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	path, _, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	trackerNodes := []torrentproto.TrackerNode{{HostPort: "localhost:9091"}, {HostPort: "localhost:9092", Weight: 2}}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+
+	torrentPath := filepath.Join(dir, "data.torrent")
+	if err := torrent.Save(t, torrentPath); err != nil {
+		LOGE.Println("Could not save torrent: ", err)
+		return false
+	}
+	if loaded, err := torrent.Load(torrentPath); err != nil || !torrent.Equal(loaded, t) {
+		LOGE.Println("Loaded torrent does not match: ", loaded, err)
+		return false
+	}
+
+	// Writes the given values to the torrent file, as gobs
+	write := func(values ...interface{}) bool {
+		file, err := os.Create(torrentPath)
+		if err != nil {
+			LOGE.Println("Could not create torrent file: ", err)
+			return false
+		}
+		defer file.Close()
+		encoder := gob.NewEncoder(file)
+		for _, value := range values {
+			if err := encoder.Encode(value); err != nil {
+				LOGE.Println("Could not write torrent file: ", err)
+				return false
+			}
+		}
+		return true
+	}
+
+	LOGE.Println("Loading torrent file from a newer version")
+	if !write(torrent.FILE_VERSION+1, t) {
+		return false
+	}
+	if _, err := torrent.Load(torrentPath); err == nil {
+		LOGE.Println("Loaded torrent file from a newer version")
+		return false
+	}
+
+	LOGE.Println("Loading unversioned torrent file")
+	if !write(t) {
+		return false
+	}
+	if _, err := torrent.Load(torrentPath); err == nil {
+		LOGE.Println("Loaded unversioned torrent file")
+		return false
+	}
+	return true
+}
+
+// Offer a file from a client which announces its chunks, make the tracker
+// forget one of them, and check that the client confirms it again, until it
+// is closed
+func testAnnounce() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	hostPort := net.JoinHostPort("localhost", strconv.Itoa(9091+41*(r.Int()%300)+13))
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener:         &nopListener{},
+		HostPort:         hostPort,
+		AnnounceInterval: 200 * time.Millisecond})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	path, _, err := createFile(dir, "data", 400)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := c.CreateAndOffer(path, 100, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	// Makes the tracker forget that the client has chunk 1
+	chunk := torrentproto.NewChunkID(t.ID, 1)
+	forget := func() bool {
+		args := &trackerproto.ReportArgs{
+			Chunk:    chunk,
+			HostPort: hostPort}
+		reply := &trackerproto.UpdateReply{}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ReportMissing", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Report Missing failed: ", err)
+			return false
+		}
+		return true
+	}
+
+	LOGE.Println("Waiting for forgotten chunk to be announced")
+	if !forget() {
+		return false
+	}
+	announced := false
+	for deadline := time.Now().Add(2 * time.Second); !announced && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		if announced, err = peerHasChunk(trackerNodes[0].HostPort, chunk, hostPort); err != nil {
+			LOGE.Println("Peer Has Chunk failed: ", err)
+			return false
+		}
+	}
+	if !announced {
+		LOGE.Println("Client did not announce forgotten chunk")
+		return false
+	}
+
+	LOGE.Println("Closing client")
+	if err := c.Close(); err != nil {
+		LOGE.Println("Close failed: ", err)
+		return false
+	}
+	time.Sleep(300 * time.Millisecond)
+	if !forget() {
+		return false
+	}
+	time.Sleep(600 * time.Millisecond)
+	if has, err := peerHasChunk(trackerNodes[0].HostPort, chunk, hostPort); err != nil || has {
+		LOGE.Println("Closed client announced chunk: ", err)
+		return false
+	}
+	return true
+}
+
+// Offer a file of many chunks from a client which announces its chunks to a
+// tracker which limits how many updates each client sends, and forgets peers
+// which have not confirmed their chunks for a while. Check that the client
+// stays listed for every chunk, and that it reports why it cannot announce a
+// file once the tracker has forgotten the file
+func testAnnounceRateLimit() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	basePort := 9091 + 41*(r.Int()%300)
+	tr, err := tracker.NewTrackerServerWithConfig(tracker.TrackerConfig{
+		Port:          basePort + 29,
+		MaxUpdateRate: 2,
+		PeerTTL:       2 * time.Second})
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer tr.Close()
+	trackerHostPort := net.JoinHostPort("localhost", strconv.Itoa(basePort+29))
+	trackerNodes := []torrentproto.TrackerNode{{HostPort: trackerHostPort}}
+
+	hostPort := net.JoinHostPort("localhost", strconv.Itoa(basePort+30))
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener:         &nopListener{},
+		HostPort:         hostPort,
+		AnnounceInterval: 300 * time.Millisecond})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer c.Close()
+
+	path, _, err := createFile(dir, "data", 1000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := c.CreateAndOffer(path, 100, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Waiting for several peer TTLs")
+	time.Sleep(5 * time.Second)
+	for chunkNum := 0; chunkNum < torrent.NumChunks(t); chunkNum++ {
+		if has, err := peerHasChunk(trackerHostPort, torrentproto.NewChunkID(t.ID, chunkNum), hostPort); err != nil || !has {
+			LOGE.Println("Client is not listed for chunk ", chunkNum, ": ", err)
+			return false
+		}
+	}
+	status, err := getStatus(c)
+	if err != nil || len(status.Files) != 1 || status.Files[0].AnnounceError != "" {
+		LOGE.Println("Client reported an announce error: ", status, err)
+		return false
+	}
+
+	LOGE.Println("Deleting the file from the tracker")
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerHostPort, "RemoteTracker.DeleteEntry", &trackerproto.DeleteArgs{ID: t.ID}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Delete Entry failed: ", err)
+		return false
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if status, err = getStatus(c); err == nil && len(status.Files) == 1 && status.Files[0].AnnounceError != "" {
+			return true
+		}
+	}
+	LOGE.Println("Client did not report that the tracker refused its chunks")
+	return false
+}
+
+// Offer and download a file whose torrent lists a tracker node which accepts
+// connections but fails every RPC first, and check that the clients fail over
+// to the working node rather than trying the broken one again
+func testTrackerFailover() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	ln, brokenHostPort, err := createBrokenTracker()
+	if err != nil {
+		LOGE.Println("Could not create broken tracker: ", err)
+		return false
+	}
+	defer ln.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 1000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	// The broken node has the higher weight, so it is tried first
+	nodes := []torrentproto.TrackerNode{{HostPort: brokenHostPort, Weight: 2}, trackerNodes[0]}
+	t, err := torrent.NewWithChunkSize(path, "data", nodes, 100)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	if err := clients[0].OfferFile(t, path); err != nil {
+		LOGE.Println("Offer failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading file")
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+	return true
+}
+
+// Check the progress a client reports for a file it offers, for a download
+// which could only get some chunks, and for a torrent it does not know
+func testProgress() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, _, err := createFile(dir, "data", 550)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 100, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	if downloaded, total, err := clients[0].Progress(t.ID); err != nil || downloaded != 6 || total != 6 {
+		LOGE.Println("Wrong progress for offered file: ", downloaded, total, err)
+		return false
+	}
+
+	LOGE.Println("Downloading with chunk 5 withheld")
+	if err := clients[0].SetServableChunks(t.ID, []int{0, 1, 2, 3, 4}); err != nil {
+		LOGE.Println("Set Servable Chunks failed: ", err)
+		return false
+	}
+	if err := clients[1].SetConcurrency(1); err != nil {
+		LOGE.Println("Set Concurrency failed: ", err)
+		return false
+	}
+	if err := clients[1].DownloadFile(t, filepath.Join(dir, "download")); err == nil {
+		LOGE.Println("Download succeeded without chunk 5")
+		return false
+	}
+	if downloaded, total, err := clients[1].Progress(t.ID); err != nil || downloaded >= 6 || total != 6 {
+		LOGE.Println("Wrong progress for unfinished download: ", downloaded, total, err)
+		return false
+	}
+
+	if _, _, err := clients[1].Progress(torrentproto.ID{Name: "unknown"}); err == nil {
+		LOGE.Println("Progress for unknown torrent succeeded")
+		return false
+	}
+	return true
+}
+
+// Offer a file to a dummy tracker, then download it with a second client which
+// offers it too, and check that the tracker counts the peers of each chunk
+// after each offer
+func testTrackerAvailability() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+	conn, err := rpc.DialHTTP("tcp", trackerNodes[0].HostPort)
+	if err != nil {
+		LOGE.Println("Could not connect to tracker: ", err)
+		return false
+	}
+	defer conn.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, _, err := createFile(dir, "data", 550)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 100, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	// Checks that every chunk has peers peers
+	replicated := func(peers int) bool {
+		reply := &trackerproto.AvailabilityReply{}
+		err := conn.Call("RemoteTracker.Availability", &trackerproto.AvailabilityArgs{ID: t.ID}, reply)
+		if err != nil || reply.Status != trackerproto.OK || len(reply.Replication) != 6 {
+			LOGE.Println("Availability: Status not OK")
+			return false
+		}
+		for chunkNum, owners := range reply.Replication {
+			if owners != peers {
+				LOGE.Println("Chunk ", chunkNum, " has ", owners, " peers, not ", peers)
+				return false
+			}
+		}
+		return reply.ChunksWithPeers == 6 && reply.MinReplication == peers
+	}
+	if !replicated(1) {
+		return false
+	}
+	download := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, download); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	if err := clients[1].OfferFile(t, download); err != nil {
+		LOGE.Println("Offer failed: ", err)
+		return false
+	}
+	if !replicated(2) {
+		return false
+	}
+
+	reply := &trackerproto.AvailabilityReply{}
+	err = conn.Call("RemoteTracker.Availability", &trackerproto.AvailabilityArgs{ID: torrentproto.ID{Name: "unknown"}}, reply)
+	if err != nil || reply.Status != trackerproto.FileNotFound {
+		LOGE.Println("Availability: Status not FileNotFound")
+		return false
+	}
+	return true
+}
+
+// Offer a file from one client, and confirm its chunks for made-up peers so
+// that each chunk has one fewer peer than the chunk before it, then download it
+// one chunk at a time with a client which fetches the rarest chunks first, and
+// check that the chunks arrive from the rarest to the most common
+func testRarestFirst() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	hostPort := net.JoinHostPort("localhost", strconv.Itoa(9091+41*(r.Int()%300)+19))
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener:        &nopListener{},
+		HostPort:        hostPort,
+		DownloadWorkers: 1,
+		ChunkOrder:      client.OrderRarestFirst})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	numChunks := 6
+	path, _, err := createFile(dir, "data", 100*numChunks)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 100, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	// Nothing listens on these ports, and the seeder is tried first anyway
+	for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+		for ghost := 1; ghost < numChunks-chunkNum; ghost++ {
+			args := &trackerproto.ConfirmArgs{
+				Chunk:    torrentproto.NewChunkID(t.ID, chunkNum),
+				HostPort: net.JoinHostPort("127.0.0.1", strconv.Itoa(ghost))}
+			reply := &trackerproto.UpdateReply{}
+			if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk failed: ", err)
+				return false
+			}
+		}
+	}
+
+	LOGE.Println("Downloading rarest chunks first")
+	progress, done := c.DownloadFileProgress(t, filepath.Join(dir, "download"))
+	order := make([]int, 0, numChunks)
+	for p := range progress {
+		order = append(order, p.ChunkNum)
+	}
+	if err := <-done; err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	LOGE.Println("Chunks arrived in order ", order)
+	for i, chunkNum := range order {
+		if chunkNum != numChunks-1-i {
+			LOGE.Println("Chunks did not arrive rarest first")
+			return false
+		}
+	}
+	return len(order) == numChunks
+}
+
+// List a peer for every chunk of a file before the peer is up, and check that
+// a client which does not retry fails to download the file, while one which
+// retries downloads it once the peer starts serving
+func testChunkRetries() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	basePort := 9091 + 41*(r.Int()%300)
+	seederHostPort := net.JoinHostPort("localhost", strconv.Itoa(basePort+20))
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener:     &nopListener{},
+		HostPort:     net.JoinHostPort("localhost", strconv.Itoa(basePort+21)),
+		ChunkRetries: 4,
+		RetryDelay:   time.Millisecond * 200})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer c.Close()
+
+	path, data, err := createFile(dir, "data", 500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 100)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	if err := torrent.Register(t); err != nil {
+		LOGE.Println("Could not register torrent: ", err)
+		return false
+	}
+	args := &trackerproto.ConfirmChunksArgs{
+		ID:        t.ID,
+		ChunkNums: []int{0, 1, 2, 3, 4},
+		HostPort:  seederHostPort,
+		Complete:  true}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunks", args, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunks failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading without retries")
+	if err := clients[0].DownloadFile(t, filepath.Join(dir, "once")); err == nil {
+		LOGE.Println("Download succeeded with no peer serving")
+		return false
+	}
+
+	LOGE.Println("Downloading with retries")
+	downloadPath := filepath.Join(dir, "retried")
+	done := make(chan error, 1)
+	go func() {
+		done <- c.DownloadFile(t, downloadPath)
+	}()
+	time.Sleep(time.Millisecond * 500)
+	seeder, err := client.NewClient(map[torrentproto.ID]*clientproto.LocalFile{
+		t.ID: &clientproto.LocalFile{
+			Torrent: t,
+			Path:    path,
+			Chunks:  map[int]struct{}{0: {}, 1: {}, 2: {}, 3: {}, 4: {}}}}, nil, seederHostPort)
+	if err != nil {
+		LOGE.Println("Could not start seeder: ", err)
+		return false
+	}
+	defer seeder.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			LOGE.Println("Download failed: ", err)
+			return false
+		}
+	case <-time.After(time.Second * 10):
+		LOGE.Println("Download did not finish")
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+	return true
+}
+
+// Serve a corrupt copy of a file, and check that a client which downloads
+// chunks from it reports the seeder to the tracker, which drops it
+func testReportBadPeer() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	path, _, err := createFile(dir, "data", 300)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	corruptPath, _, err := createFile(dir, "corrupt", 300)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 100)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	if err := torrent.Register(t); err != nil {
+		LOGE.Println("Could not register torrent: ", err)
+		return false
+	}
+
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	seederHostPort := net.JoinHostPort("localhost", strconv.Itoa(9091+41*(r.Int()%300)+22))
+	seeder, err := client.NewClient(map[torrentproto.ID]*clientproto.LocalFile{
+		t.ID: &clientproto.LocalFile{
+			Torrent: t,
+			Path:    corruptPath,
+			Chunks:  map[int]struct{}{0: {}, 1: {}, 2: {}}}}, nil, seederHostPort)
+	if err != nil {
+		LOGE.Println("Could not start seeder: ", err)
+		return false
+	}
+	defer seeder.Close()
+	args := &trackerproto.ConfirmChunksArgs{
+		ID:        t.ID,
+		ChunkNums: []int{0, 1, 2},
+		HostPort:  seederHostPort,
+		Complete:  true}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunks", args, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunks failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading from the corrupt seeder")
+	if err := clients[0].DownloadFile(t, filepath.Join(dir, "download")); err == nil {
+		LOGE.Println("Download of a corrupt file succeeded")
+		return false
+	}
+
+	// Reports are sent in the background, so give them a moment
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		requestArgs := &trackerproto.RequestArgs{Chunk: torrentproto.NewChunkID(t.ID, 0)}
+		requestReply := &trackerproto.RequestReply{}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.RequestChunk", requestArgs, requestReply); err != nil || requestReply.Status != trackerproto.OK {
+			LOGE.Println("Request Chunk failed: ", err)
+			return false
+		}
+		if len(requestReply.Peers) == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			LOGE.Println("Corrupt seeder is still listed: ", requestReply.Peers)
+			return false
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+// Split a 25 byte file into 10 byte chunks, and check that the final chunk
+// holds only the 5 bytes left over: that it is hashed, read, written and
+// downloaded at that length, and never padded out to the chunk size
+func testShortFinalChunk() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 25)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 10, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	if torrent.NumChunks(t) != 3 || len(t.ChunkHashes) != 3 {
+		LOGE.Println("Wrong number of chunks: ", torrent.NumChunks(t), len(t.ChunkHashes))
+		return false
+	}
+	if start, length, err := torrent.ChunkBounds(t, 2); err != nil || start != 20 || length != 5 {
+		LOGE.Println("Wrong bounds for the final chunk: ", start, length, err)
+		return false
+	}
+	hash := sha1.Sum(data[20:])
+	if t.ChunkHashes[2] != string(hash[:]) {
+		LOGE.Println("Final chunk was not hashed over its 5 bytes")
+		return false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		LOGE.Println("Could not open file: ", err)
+		return false
+	}
+	defer file.Close()
+	if chunk, err := torrent.ReadChunk(t, file, 2); err != nil || !bytes.Equal(chunk, data[20:]) {
+		LOGE.Println("Read of final chunk failed: ", err)
+		return false
+	}
+	padded := make([]byte, 10)
+	copy(padded, data[20:])
+	written, err := os.Create(filepath.Join(dir, "written"))
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	defer written.Close()
+	if err := torrent.WriteChunk(t, written, 2, padded); err == nil {
+		LOGE.Println("Write of padded final chunk succeeded")
+		return false
+	}
+
+	LOGE.Println("Downloading file")
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match: ", len(downloaded), " bytes")
+		return false
+	}
+
+	bad := t
+	bad.ChunkSize = 0
+	if torrent.NumChunks(bad) != 0 {
+		LOGE.Println("Torrent with no chunk size has chunks")
+		return false
+	}
+	return true
+}
+
+// Start a tracker which requires a client token, and check that a client
+// without the token cannot publish a file, while clients with it can publish
+// and download it
+func testTrackerToken() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	basePort := 9091 + 41*(r.Int()%300)
+	tr, err := tracker.NewTrackerServerWithConfig(tracker.TrackerConfig{
+		Port:        basePort + 23,
+		ClientToken: "client token"})
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer tr.Close()
+	trackerNodes := []torrentproto.TrackerNode{{HostPort: net.JoinHostPort("localhost", strconv.Itoa(basePort+23))}}
+
+	clients := make([]client.Client, 3)
+	for i, token := range []string{"", "client token", "client token"} {
+		c, err := client.NewClientWithConfig(client.ClientConfig{
+			Listener:     &nopListener{},
+			HostPort:     net.JoinHostPort("localhost", strconv.Itoa(basePort+24+i)),
+			TrackerToken: token})
+		if err != nil {
+			LOGE.Println("Could not create client: ", err)
+			return false
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	path, data, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	if _, err := clients[0].CreateAndOffer(path, 1000, trackerNodes); err == nil {
+		LOGE.Println("Create And Offer succeeded without the token")
+		return false
+	}
+	t, err := clients[1].CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed with the token: ", err)
+		return false
+	}
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[2].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+
+	// Offering the registered torrent without the token fails too, and the
+	// tracker does not list the client
+	if err := clients[0].OfferFile(t, path); err == nil {
+		LOGE.Println("Offer File succeeded without the token")
+		return false
+	}
+	chunk := torrentproto.NewChunkID(t.ID, 0)
+	if has, err := peerHasChunk(trackerNodes[0].HostPort, chunk, net.JoinHostPort("localhost", strconv.Itoa(basePort+24))); err != nil || has {
+		LOGE.Println("Tracker listed the client without the token: ", err)
+		return false
+	}
+	return true
+}
+
+// Offer and download a file whose torrent lists a tracker node which accepts
+// connections but never answers a ping first, and check that the clients skip
+// it for the working node rather than wait on it
+func testStuckTrackerNode() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	ln, stuckHostPort, err := createStuckTracker(release)
+	if err != nil {
+		LOGE.Println("Could not create stuck tracker: ", err)
+		return false
+	}
+	defer ln.Close()
+
+	clients, _, err := createClients(2)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+
+	path, data, err := createFile(dir, "data", 1000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	// The stuck node has the higher weight, so it is tried first
+	nodes := []torrentproto.TrackerNode{{HostPort: stuckHostPort, Weight: 2}, trackerNodes[0]}
+	t, err := torrent.NewWithChunkSize(path, "data", nodes, 100)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Offering file")
+	if err := clients[0].OfferFile(t, path); err != nil {
+		LOGE.Println("Offer failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Downloading file")
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[1].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+	return true
+}
+
+// Download a file of many chunks from a single peer, and check that the
+// client reuses its connections to the peer rather than dialing it for every
+// chunk
+func testPeerConnectionReuse() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	path, data, err := createFile(dir, "data", 20000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 1000)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	ln, err := createCountingPeer(data, 1000)
+	if err != nil {
+		LOGE.Println("Could not create peer: ", err)
+		return false
+	}
+	defer ln.Close()
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		args := &trackerproto.ConfirmArgs{
+			Chunk:    chunkID,
+			HostPort: ln.Addr().String()}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk failed: ", err)
+			return false
+		}
+	}
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	downloadPath := filepath.Join(dir, "download")
+	if err := clients[0].DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	downloaded, err := ioutil.ReadFile(downloadPath)
+	if err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+
+	// Workers which start at once may each dial before any connection is
+	// pooled, but no more than that
+	if accepted := ln.count(); accepted > client.DEFAULT_DOWNLOAD_WORKERS {
+		LOGE.Println("Peer accepted ", accepted, " connections for ", torrent.NumChunks(t), " chunks")
+		return false
+	}
+	return true
+}
+
+// Offer two files from a client which keeps a state file, remove one of the
+// files, and restart the client from the state file. Check that it serves the
+// file which is left, and has forgotten the other
+func testPersistLocalFiles() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	statePath := filepath.Join(dir, "state")
+	newClient := func() (client.Client, string, error) {
+		hostPort, err := freeHostPort()
+		if err != nil {
+			return nil, "", err
+		}
+		c, err := client.NewClientWithConfig(client.ClientConfig{
+			HostPort:  hostPort,
+			StatePath: statePath})
+		return c, hostPort, err
+	}
+	first, _, err := newClient()
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+
+	torrents := make(map[string]torrentproto.Torrent)
+	var keptData []byte
+	for _, name := range []string{"kept", "gone"} {
+		path, data, err := createFile(dir, name, 2500)
+		if err != nil {
+			LOGE.Println("Could not create file: ", err)
+			return false
+		}
+		t, err := first.CreateAndOffer(path, 1000, trackerNodes)
+		if err != nil {
+			LOGE.Println("Create And Offer failed: ", err)
+			return false
+		}
+		torrents[name] = t
+		if name == "kept" {
+			keptData = data
+		}
+	}
+	first.Close()
+	if err := os.Remove(filepath.Join(dir, "gone")); err != nil {
+		LOGE.Println("Could not remove file: ", err)
+		return false
+	}
+
+	second, hostPort, err := newClient()
+	if err != nil {
+		LOGE.Println("Could not restart client: ", err)
+		return false
+	}
+	defer second.Close()
+	status, err := getStatus(second)
+	if err != nil {
+		LOGE.Println("Could not get status: ", err)
+		return false
+	} else if len(status.Files) != 1 || status.Files[0].Name != "kept" || !status.Files[0].Complete ||
+		status.Files[0].Path != filepath.Join(dir, "kept") {
+		LOGE.Println("Restarted client has the wrong files: ", status.Files)
+		return false
+	}
+
+	peer, err := rpc.DialHTTP("tcp", hostPort)
+	if err != nil {
+		LOGE.Println("Could not connect to restarted client: ", err)
+		return false
+	}
+	defer peer.Close()
+	args := &clientproto.GetArgs{ChunkID: torrentproto.NewChunkID(torrents["kept"].ID, 2)}
+	reply := &clientproto.GetReply{}
+	if err := peer.Call("RemoteClient.GetChunk", args, reply); err != nil || reply.Status != clientproto.OK ||
+		!bytes.Equal(reply.Chunk, keptData[2000:]) {
+		LOGE.Println("Restarted client did not serve its chunk: ", err)
+		return false
+	}
+
+	// A file which is not a state file is refused, rather than ignored
+	if err := ioutil.WriteFile(statePath, []byte("not a state file"), 0644); err != nil {
+		LOGE.Println("Could not write state file: ", err)
+		return false
+	}
+	if c, _, err := newClient(); err == nil {
+		c.Close()
+		LOGE.Println("Client started from a file which is not a state file")
+		return false
+	}
+	return true
+}
+
+// Offer a file of three batches of chunks from a client which keeps a state
+// file, and take a copy of the state file once the tracker has confirmed the
+// first batch, as a crash then would leave it. Check that a client restarted
+// from the copy confirms only the other two batches, and that a client
+// restarted once that has finished confirms nothing
+func testResumeOffer() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	ln, st, trackerNodes, err := createStallingTracker(1)
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer ln.Close()
+
+	numChunks := 2*client.OFFER_BATCH + client.OFFER_BATCH/2
+	path, _, err := createFile(dir, "data", numChunks*10)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, 10)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+
+	newClient := func(statePath string) (client.Client, string, error) {
+		hostPort, err := freeHostPort()
+		if err != nil {
+			return nil, "", err
+		}
+		c, err := client.NewClientWithConfig(client.ClientConfig{
+			HostPort:  hostPort,
+			StatePath: statePath})
+		return c, hostPort, err
+	}
+	statePath := filepath.Join(dir, "state")
+	first, _, err := newClient(statePath)
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer first.Close()
+
+	LOGE.Println("Offering file")
+	offered := make(chan error, 1)
+	go func() {
+		offered <- first.OfferFile(t, path)
+	}()
+	select {
+	case <-st.stalled:
+	case <-time.After(2 * time.Second):
+		LOGE.Println("Client did not confirm a second batch")
+		return false
+	}
+	crashPath := filepath.Join(dir, "crashed")
+	if state, err := ioutil.ReadFile(statePath); err != nil {
+		LOGE.Println("Could not read state file: ", err)
+		return false
+	} else if err := ioutil.WriteFile(crashPath, state, 0644); err != nil {
+		LOGE.Println("Could not copy state file: ", err)
+		return false
+	}
+	close(st.release)
+	if err := <-offered; err != nil {
+		LOGE.Println("Offer failed: ", err)
+		return false
+	}
+
+	LOGE.Println("Restarting client from crashed state")
+	second, hostPort, err := newClient(crashPath)
+	if err != nil {
+		LOGE.Println("Could not restart client: ", err)
+		return false
+	}
+	var resumed []int
+	for deadline := time.Now().Add(2 * time.Second); len(resumed) < numChunks-client.OFFER_BATCH && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		resumed = st.confirmedBy(hostPort)
+	}
+	// Wait for the state file to record that the offer finished
+	time.Sleep(100 * time.Millisecond)
+	second.Close()
+	sort.Ints(resumed)
+	if len(resumed) != numChunks-client.OFFER_BATCH || resumed[0] != client.OFFER_BATCH || resumed[len(resumed)-1] != numChunks-1 {
+		LOGE.Println("Restarted client confirmed the wrong chunks: ", resumed)
+		return false
+	}
+	for i := 1; i < len(resumed); i++ {
+		if resumed[i] == resumed[i-1] {
+			LOGE.Println("Restarted client confirmed chunk ", resumed[i], " twice")
+			return false
+		}
+	}
+
+	LOGE.Println("Restarting client after the offer finished")
+	third, hostPort, err := newClient(crashPath)
+	if err != nil {
+		LOGE.Println("Could not restart client: ", err)
+		return false
+	}
+	defer third.Close()
+	time.Sleep(200 * time.Millisecond)
+	if again := st.confirmedBy(hostPort); len(again) != 0 {
+		LOGE.Println("Finished offer was resumed: ", again)
+		return false
+	}
+	status, err := getStatus(third)
+	if err != nil || len(status.Files) != 1 || !status.Files[0].Complete {
+		LOGE.Println("Restarted client does not have the file: ", err)
+		return false
+	}
+	return true
+}
+
+// Offer a file, then remove it from the client. Check that the client stops
+// serving it, reports the removal to its listener, and is no longer listed by
+// the tracker as a peer for its chunks
+func testRemoveFile() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	recorder := &changeRecorder{}
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		Listener: recorder,
+		HostPort: hostPort})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer c.Close()
+
+	path, _, err := createFile(dir, "data", 2500)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := c.CreateAndOffer(path, 1000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	if err := c.RemoveFile(t.ID); err != nil {
+		LOGE.Println("Remove File failed: ", err)
+		return false
+	} else if recorder.last() != clientproto.LocalFileRemove {
+		LOGE.Println("Listener was not told of the removal")
+		return false
+	} else if _, _, err := c.Progress(t.ID); err == nil {
+		LOGE.Println("Client still has the removed file")
+		return false
+	} else if _, err := os.Stat(path); err != nil {
+		LOGE.Println("Removing the file deleted it from disk")
+		return false
+	}
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		if has, err := peerHasChunk(trackerNodes[0].HostPort, chunkID, hostPort); err != nil || has {
+			LOGE.Println("Tracker still lists the client for chunk ", chunkID.ChunkNum)
+			return false
+		}
+	}
+
+	reply := &clientproto.GetReply{}
+	args := &clientproto.GetArgs{ChunkID: torrentproto.NewChunkID(t.ID, 0)}
+	if err := callTracker(hostPort, "RemoteClient.GetChunk", args, reply); err != nil ||
+		reply.Status != clientproto.ChunkNotFound {
+		LOGE.Println("Client still serves the removed file")
+		return false
+	}
+
+	if err := c.RemoveFile(t.ID); err == nil {
+		LOGE.Println("Removed a file twice")
+		return false
+	}
+	return true
+}
+
+// Serve a file from a client which will not send chunks over 1000 bytes by
+// RPC, and check that a client which streams chunks over 2000 bytes
+// downloads it, while a client which fetches every chunk by RPC cannot
+func testStreamChunks() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	newClient := func(cfg client.ClientConfig) (client.Client, error) {
+		hostPort, err := freeHostPort()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Listener = &nopListener{}
+		cfg.HostPort = hostPort
+		return client.NewClientWithConfig(cfg)
+	}
+	seeder, err := newClient(client.ClientConfig{
+		MaxChunkSize: 1000,
+		ServePolicy:  client.ServeVerify})
+	if err != nil {
+		LOGE.Println("Could not create seeder: ", err)
+		return false
+	}
+	defer seeder.Close()
+	streaming, err := newClient(client.ClientConfig{StreamThreshold: 2000})
+	if err != nil {
+		LOGE.Println("Could not create streaming client: ", err)
+		return false
+	}
+	defer streaming.Close()
+	buffered, err := newClient(client.ClientConfig{})
+	if err != nil {
+		LOGE.Println("Could not create buffered client: ", err)
+		return false
+	}
+	defer buffered.Close()
+
+	// Three chunks of 3000 bytes, which are streamed, and a final chunk of
+	// 1000 bytes, which is not
+	path, data, err := createFile(dir, "data", 10000)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := seeder.CreateAndOffer(path, 3000, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+
+	downloadPath := filepath.Join(dir, "streamed")
+	if err := streaming.DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Streaming download failed: ", err)
+		return false
+	}
+	if downloaded, err := ioutil.ReadFile(downloadPath); err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Streamed file does not match")
+		return false
+	}
+	if err := buffered.DownloadFile(t, filepath.Join(dir, "buffered")); err == nil {
+		LOGE.Println("Seeder sent chunks over its maximum chunk size by RPC")
+		return false
+	}
+	return true
+}
+
+// Stream a file of two large chunks from a peer whose first connection is cut
+// off part way through a chunk. Check that the download completes, and that
+// the chunk is taken up where the stream stopped: only one request asks for
+// the rest of a chunk, and the peer sends little more than the file
+func testResumeStream() bool {
+	const chunkSize = 100000
+	const cut = 60000
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	path, data, err := createFile(dir, "data", 2*chunkSize)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := torrent.NewWithChunkSize(path, "data", trackerNodes, chunkSize)
+	if err != nil {
+		LOGE.Println("Could not create torrent: ", err)
+		return false
+	}
+	reply := &trackerproto.UpdateReply{}
+	if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.CreateEntry", &trackerproto.CreateArgs{Torrent: t}, reply); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry failed: ", err)
+		return false
+	}
+
+	// The seeder is only reachable through the proxy
+	seederHostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	localFile := &clientproto.LocalFile{
+		Torrent: t,
+		Path:    path,
+		Chunks:  map[int]struct{}{0: {}, 1: {}}}
+	seeder, err := client.NewClientWithConfig(client.ClientConfig{
+		LocalFiles: map[torrentproto.ID]*clientproto.LocalFile{t.ID: localFile},
+		HostPort:   seederHostPort})
+	if err != nil {
+		LOGE.Println("Could not create seeder: ", err)
+		return false
+	}
+	defer seeder.Close()
+	ln, proxy, err := startCuttingProxy(seederHostPort, cut)
+	if err != nil {
+		LOGE.Println("Could not start proxy: ", err)
+		return false
+	}
+	defer ln.Close()
+	for _, chunkID := range torrent.AllChunkIDs(t) {
+		args := &trackerproto.ConfirmArgs{
+			Chunk:    chunkID,
+			HostPort: ln.Addr().String()}
+		if err := callTracker(trackerNodes[0].HostPort, "RemoteTracker.ConfirmChunk", args, reply); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk failed: ", err)
+			return false
+		}
+	}
+
+	hostPort, err := freeHostPort()
+	if err != nil {
+		LOGE.Println("Could not find a free port: ", err)
+		return false
+	}
+	c, err := client.NewClientWithConfig(client.ClientConfig{
+		HostPort:        hostPort,
+		StreamThreshold: 1000,
+		DownloadWorkers: 1})
+	if err != nil {
+		LOGE.Println("Could not create client: ", err)
+		return false
+	}
+	defer c.Close()
+
+	LOGE.Println("Downloading file")
+	downloadPath := filepath.Join(dir, "download")
+	if err := c.DownloadFile(t, downloadPath); err != nil {
+		LOGE.Println("Download failed: ", err)
+		return false
+	}
+	if downloaded, err := ioutil.ReadFile(downloadPath); err != nil || !bytes.Equal(downloaded, data) {
+		LOGE.Println("Downloaded file does not match")
+		return false
+	}
+
+	// Offsets outside the chunk are refused
+	for _, offset := range []int{-1, chunkSize} {
+		query := url.Values{}
+		query.Set("name", t.ID.Name)
+		query.Set("hash", hex.EncodeToString([]byte(t.ID.Hash)))
+		query.Set("chunk", "0")
+		query.Set("offset", strconv.Itoa(offset))
+		resp, err := http.Get("http://" + seederHostPort + client.CHUNK_PATH + "?" + query.Encode())
+		if err != nil {
+			LOGE.Println("Could not request chunk: ", err)
+			return false
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+			LOGE.Println("Request from offset ", offset, " was answered with ", resp.Status)
+			return false
+		}
+	}
+
+	proxy.mut.Lock()
+	defer proxy.mut.Unlock()
+	LOGE.Println("Peer sent ", proxy.sent, " bytes over ", proxy.conns, " connections for ", len(proxy.queries), " requests")
+	var resumed []url.Values
+	for _, query := range proxy.queries {
+		if query.Get("offset") != "" {
+			resumed = append(resumed, query)
+		}
+	}
+	if proxy.conns < 2 || len(resumed) != 1 {
+		LOGE.Println("Cut off stream was not taken up where it stopped: ", proxy.queries)
+		return false
+	}
+	// The headers of the three replies are far shorter than 1000 bytes
+	if offset, err := strconv.Atoi(resumed[0].Get("offset")); err != nil || offset <= 0 || offset > cut || offset < cut-1000 {
+		LOGE.Println("Stream was taken up from the wrong offset: ", resumed[0])
+		return false
+	}
+	if proxy.sent > len(data)+1000 {
+		LOGE.Println("Peer sent ", proxy.sent-len(data), " bytes more than the file")
+		return false
+	}
+	return true
+}
+
+// Offer a file of three 10 byte chunks, drop the first and last chunks, and
+// check what reads through a TorrentReaderAt return at and around them
+func testTorrentReaderAt() bool {
+	dir, err := ioutil.TempDir("", "clienttest")
+	if err != nil {
+		LOGE.Println("Could not create directory")
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	dt, trackerNodes, err := createTracker()
+	if err != nil {
+		LOGE.Println("Could not create tracker: ", err)
+		return false
+	}
+	defer dt.Close()
+
+	clients, _, err := createClients(1)
+	if err != nil {
+		LOGE.Println("Could not create clients: ", err)
+		return false
+	}
+	path, data, err := createFile(dir, "data", 30)
+	if err != nil {
+		LOGE.Println("Could not create file: ", err)
+		return false
+	}
+	t, err := clients[0].CreateAndOffer(path, 10, trackerNodes)
+	if err != nil {
+		LOGE.Println("Create And Offer failed: ", err)
+		return false
+	}
+	reader, err := clients[0].TorrentReaderAt(t.ID)
+	if err != nil {
+		LOGE.Println("Torrent Reader At failed: ", err)
+		return false
+	}
+
+	// The client has every chunk, so reads stop only at the end
+	p := make([]byte, 40)
+	if n, err := reader.ReadAt(p, 5); n != 25 || err != io.EOF || !bytes.Equal(p[:n], data[5:]) {
+		LOGE.Println("Read to the end returned ", n, " bytes and ", err)
+		return false
+	}
+
+	// Corrupt the first and last chunks, so that refreshing them drops them
+	corrupt := make([]byte, len(data))
+	copy(corrupt, data)
+	corrupt[0] ^= 0xff
+	corrupt[25] ^= 0xff
+	if err := ioutil.WriteFile(path, corrupt, 0644); err != nil {
+		LOGE.Println("Could not corrupt file: ", err)
+		return false
+	}
+	for _, chunkNum := range []int{0, 2} {
+		clients[0].RefreshChunk(t.ID, chunkNum)
+	}
+
+	checks := []struct {
+		off     int64
+		length  int
+		n       int
+		missing bool
+	}{
+		{off: 0, length: 10, n: 0, missing: true},
+		{off: 5, length: 10, n: 0, missing: true},
+		{off: 10, length: 10, n: 10},
+		{off: 12, length: 5, n: 5},
+		{off: 12, length: 15, n: 8, missing: true},
+		{off: 25, length: 10, n: 0, missing: true},
+	}
+	for _, check := range checks {
+		p := make([]byte, check.length)
+		n, err := reader.ReadAt(p, check.off)
+		if n != check.n || (err != nil) != check.missing {
+			LOGE.Println("Read of ", check.length, " bytes at ", check.off, " returned ", n, " bytes and ", err)
+			return false
+		} else if !bytes.Equal(p[:n], data[check.off:check.off+int64(n)]) {
+			LOGE.Println("Read of ", check.length, " bytes at ", check.off, " returned the wrong bytes")
+			return false
+		}
+	}
+	return true
+}
+
+func main() {
+	pass := 0
+	tests := 0
+
+	tests++
+	LOGE.Println("----------- testOfferDownload")
+	if !testOfferDownload() {
+		LOGE.Println("---------------------- Failed testOfferDownload")
+	} else {
+		pass++
+		LOGE.Println("Passed testOfferDownload")
+	}
+
+	tests++
+	LOGE.Println("----------- testDownloadListedSelf")
+	if !testDownloadListedSelf() {
+		LOGE.Println("---------------------- Failed testDownloadListedSelf")
+	} else {
+		pass++
+		LOGE.Println("Passed testDownloadListedSelf")
+	}
+
+	tests++
+	LOGE.Println("----------- testManyChunks")
+	if !testManyChunks(2000) {
+		LOGE.Println("---------------------- Failed testManyChunks")
+	} else {
+		pass++
+		LOGE.Println("Passed testManyChunks")
+	}
+
+	tests++
+	LOGE.Println("----------- testTwoTrackers")
+	if !testTwoTrackers() {
+		LOGE.Println("---------------------- Failed testTwoTrackers")
+	} else {
+		pass++
+		LOGE.Println("Passed testTwoTrackers")
+	}
+
+	tests++
+	LOGE.Println("----------- testHashAlgos")
+	if !testHashAlgos() {
+		LOGE.Println("---------------------- Failed testHashAlgos")
+	} else {
+		pass++
+		LOGE.Println("Passed testHashAlgos")
+	}
+
+	tests++
+	LOGE.Println("----------- testDownloadUnregistered")
+	if !testDownloadUnregistered() {
+		LOGE.Println("---------------------- Failed testDownloadUnregistered")
+	} else {
+		pass++
+		LOGE.Println("Passed testDownloadUnregistered")
+	}
+
+	tests++
+	LOGE.Println("----------- testFaultyTrackerNode")
+	if !testFaultyTrackerNode() {
+		LOGE.Println("---------------------- Failed testFaultyTrackerNode")
+	} else {
+		pass++
+		LOGE.Println("Passed testFaultyTrackerNode")
+	}
+
+	tests++
+	LOGE.Println("----------- testStatusJSON")
+	if !testStatusJSON() {
+		LOGE.Println("---------------------- Failed testStatusJSON")
+	} else {
+		pass++
+		LOGE.Println("Passed testStatusJSON")
+	}
+
+	tests++
+	LOGE.Println("----------- testDownloadProgress")
+	if !testDownloadProgress() {
+		LOGE.Println("---------------------- Failed testDownloadProgress")
+	} else {
+		pass++
+		LOGE.Println("Passed testDownloadProgress")
+	}
+
+	tests++
+	LOGE.Println("----------- testRefreshChunk")
+	if !testRefreshChunk() {
+		LOGE.Println("---------------------- Failed testRefreshChunk")
+	} else {
+		pass++
+		LOGE.Println("Passed testRefreshChunk")
+	}
+
+	tests++
+	LOGE.Println("----------- testOfferTimeout")
+	if !testOfferTimeout() {
+		LOGE.Println("---------------------- Failed testOfferTimeout")
+	} else {
+		pass++
+		LOGE.Println("Passed testOfferTimeout")
+	}
+
+	tests++
+	LOGE.Println("----------- testPlanDownload")
+	if !testPlanDownload() {
+		LOGE.Println("---------------------- Failed testPlanDownload")
+	} else {
+		pass++
+		LOGE.Println("Passed testPlanDownload")
+	}
+
+	tests++
+	LOGE.Println("----------- testTrackerWeights")
+	if !testTrackerWeights() {
+		LOGE.Println("---------------------- Failed testTrackerWeights")
+	} else {
+		pass++
+		LOGE.Println("Passed testTrackerWeights")
+	}
+
+	tests++
+	LOGE.Println("----------- testChunkBounds")
+	if !testChunkBounds() {
+		LOGE.Println("---------------------- Failed testChunkBounds")
+	} else {
+		pass++
+		LOGE.Println("Passed testChunkBounds")
+	}
+
+	tests++
+	LOGE.Println("----------- testChunkLocation")
+	if !testChunkLocation() {
+		LOGE.Println("---------------------- Failed testChunkLocation")
+	} else {
+		pass++
+		LOGE.Println("Passed testChunkLocation")
+	}
+
+	tests++
+	LOGE.Println("----------- testDetectCorruption")
+	if !testDetectCorruption() {
+		LOGE.Println("---------------------- Failed testDetectCorruption")
+	} else {
+		pass++
+		LOGE.Println("Passed testDetectCorruption")
+	}
+
+	tests++
+	LOGE.Println("----------- testMaxTransfers")
+	if !testMaxTransfers() {
+		LOGE.Println("---------------------- Failed testMaxTransfers")
+	} else {
+		pass++
+		LOGE.Println("Passed testMaxTransfers")
+	}
+
+	tests++
+	LOGE.Println("----------- testTrackerLoadSpread")
+	if !testTrackerLoadSpread() {
+		LOGE.Println("---------------------- Failed testTrackerLoadSpread")
+	} else {
+		pass++
+		LOGE.Println("Passed testTrackerLoadSpread")
+	}
+
+	tests++
+	LOGE.Println("----------- testParallelWrites")
+	if !testParallelWrites() {
+		LOGE.Println("---------------------- Failed testParallelWrites")
+	} else {
+		pass++
+		LOGE.Println("Passed testParallelWrites")
+	}
+
+	tests++
+	LOGE.Println("----------- testPrioritizeChunk")
+	if !testPrioritizeChunk() {
+		LOGE.Println("---------------------- Failed testPrioritizeChunk")
+	} else {
+		pass++
+		LOGE.Println("Passed testPrioritizeChunk")
+	}
+
+	tests++
+	LOGE.Println("----------- testChunkOffsets")
+	if !testChunkOffsets() {
+		LOGE.Println("---------------------- Failed testChunkOffsets")
+	} else {
+		pass++
+		LOGE.Println("Passed testChunkOffsets")
+	}
+
+	tests++
+	LOGE.Println("----------- testCreateAndOffer")
+	if !testCreateAndOffer() {
+		LOGE.Println("---------------------- Failed testCreateAndOffer")
+	} else {
+		pass++
+		LOGE.Println("Passed testCreateAndOffer")
+	}
+
+	tests++
+	LOGE.Println("----------- testResumeOffer")
+	if !testResumeOffer() {
+		LOGE.Println("---------------------- Failed testResumeOffer")
+	} else {
+		pass++
+		LOGE.Println("Passed testResumeOffer")
+	}
+
+	tests++
+	LOGE.Println("----------- testPrefetchWindow")
+	if !testPrefetchWindow() {
+		LOGE.Println("---------------------- Failed testPrefetchWindow")
+	} else {
+		pass++
+		LOGE.Println("Passed testPrefetchWindow")
+	}
+
+	tests++
+	LOGE.Println("----------- testPeerListenerStops")
+	if !testPeerListenerStops() {
+		LOGE.Println("---------------------- Failed testPeerListenerStops")
+	} else {
+		pass++
+		LOGE.Println("Passed testPeerListenerStops")
+	}
+
+	tests++
+	LOGE.Println("----------- testResumeStream")
+	if !testResumeStream() {
+		LOGE.Println("---------------------- Failed testResumeStream")
+	} else {
+		pass++
+		LOGE.Println("Passed testResumeStream")
+	}
+
+	tests++
+	LOGE.Println("----------- testPeerEvents")
+	if !testPeerEvents() {
+		LOGE.Println("---------------------- Failed testPeerEvents")
+	} else {
+		pass++
+		LOGE.Println("Passed testPeerEvents")
+	}
+
+	tests++
+	LOGE.Println("----------- testAllChunkIDs")
+	if !testAllChunkIDs() {
+		LOGE.Println("---------------------- Failed testAllChunkIDs")
+	} else {
+		pass++
+		LOGE.Println("Passed testAllChunkIDs")
+	}
+
+	tests++
+	LOGE.Println("----------- testServableChunks")
+	if !testServableChunks() {
+		LOGE.Println("---------------------- Failed testServableChunks")
+	} else {
+		pass++
+		LOGE.Println("Passed testServableChunks")
+	}
+
+	tests++
+	LOGE.Println("----------- testServePolicies")
+	if !testServePolicies() {
+		LOGE.Println("---------------------- Failed testServePolicies")
+	} else {
+		pass++
+		LOGE.Println("Passed testServePolicies")
+	}
+
+	tests++
+	LOGE.Println("----------- testRepairDownload")
+	if !testRepairDownload() {
+		LOGE.Println("---------------------- Failed testRepairDownload")
+	} else {
+		pass++
+		LOGE.Println("Passed testRepairDownload")
+	}
+
+	tests++
+	LOGE.Println("----------- testCloseMidDownload")
+	if !testCloseMidDownload() {
+		LOGE.Println("---------------------- Failed testCloseMidDownload")
+	} else {
+		pass++
+		LOGE.Println("Passed testCloseMidDownload")
+	}
+
+	tests++
+	LOGE.Println("----------- testClientConfig")
+	if !testClientConfig() {
+		LOGE.Println("---------------------- Failed testClientConfig")
+	} else {
+		pass++
+		LOGE.Println("Passed testClientConfig")
+	}
+
+	tests++
+	LOGE.Println("----------- testTruncatedSeeder")
+	if !testTruncatedSeeder() {
+		LOGE.Println("---------------------- Failed testTruncatedSeeder")
+	} else {
+		pass++
+		LOGE.Println("Passed testTruncatedSeeder")
+	}
+
+	tests++
+	LOGE.Println("----------- testListTorrents")
+	if !testListTorrents() {
+		LOGE.Println("---------------------- Failed testListTorrents")
+	} else {
+		pass++
+		LOGE.Println("Passed testListTorrents")
+	}
+
+	tests++
+	LOGE.Println("----------- testConcurrency")
+	if !testConcurrency() {
+		LOGE.Println("---------------------- Failed testConcurrency")
+	} else {
+		pass++
+		LOGE.Println("Passed testConcurrency")
+	}
+
+	tests++
+	LOGE.Println("----------- testResumeDownload")
+	if !testResumeDownload() {
+		LOGE.Println("---------------------- Failed testResumeDownload")
+	} else {
+		pass++
+		LOGE.Println("Passed testResumeDownload")
+	}
+
+	tests++
+	LOGE.Println("----------- testVerifyOffers")
+	if !testVerifyOffers() {
+		LOGE.Println("---------------------- Failed testVerifyOffers")
+	} else {
+		pass++
+		LOGE.Println("Passed testVerifyOffers")
+	}
+
+	tests++
+	LOGE.Println("----------- testNewTorrent")
+	if !testNewTorrent() {
+		LOGE.Println("---------------------- Failed testNewTorrent")
+	} else {
+		pass++
+		LOGE.Println("Passed testNewTorrent")
+	}
+
+	tests++
+	LOGE.Println("----------- testTorrentFileVersion")
+	if !testTorrentFileVersion() {
+		LOGE.Println("---------------------- Failed testTorrentFileVersion")
+	} else {
+		pass++
+		LOGE.Println("Passed testTorrentFileVersion")
+	}
+
+	tests++
+	LOGE.Println("----------- testAnnounce")
+	if !testAnnounce() {
+		LOGE.Println("---------------------- Failed testAnnounce")
+	} else {
+		pass++
+		LOGE.Println("Passed testAnnounce")
+	}
+
+	tests++
+	LOGE.Println("----------- testAnnounceRateLimit")
+	if !testAnnounceRateLimit() {
+		LOGE.Println("---------------------- Failed testAnnounceRateLimit")
+	} else {
+		pass++
+		LOGE.Println("Passed testAnnounceRateLimit")
+	}
+
+	tests++
+	LOGE.Println("----------- testTrackerFailover")
+	if !testTrackerFailover() {
+		LOGE.Println("---------------------- Failed testTrackerFailover")
+	} else {
+		pass++
+		LOGE.Println("Passed testTrackerFailover")
+	}
+
+	tests++
+	LOGE.Println("----------- testProgress")
+	if !testProgress() {
+		LOGE.Println("---------------------- Failed testProgress")
+	} else {
+		pass++
+		LOGE.Println("Passed testProgress")
+	}
+
+	tests++
+	LOGE.Println("----------- testTrackerAvailability")
+	if !testTrackerAvailability() {
+		LOGE.Println("---------------------- Failed testTrackerAvailability")
+	} else {
+		pass++
+		LOGE.Println("Passed testTrackerAvailability")
+	}
+
+	tests++
+	LOGE.Println("----------- testRarestFirst")
+	if !testRarestFirst() {
+		LOGE.Println("---------------------- Failed testRarestFirst")
+	} else {
+		pass++
+		LOGE.Println("Passed testRarestFirst")
+	}
+
+	tests++
+	LOGE.Println("----------- testChunkRetries")
+	if !testChunkRetries() {
+		LOGE.Println("---------------------- Failed testChunkRetries")
+	} else {
+		pass++
+		LOGE.Println("Passed testChunkRetries")
+	}
+
+	tests++
+	LOGE.Println("----------- testReportBadPeer")
+	if !testReportBadPeer() {
+		LOGE.Println("---------------------- Failed testReportBadPeer")
+	} else {
+		pass++
+		LOGE.Println("Passed testReportBadPeer")
+	}
+
+	tests++
+	LOGE.Println("----------- testShortFinalChunk")
+	if !testShortFinalChunk() {
+		LOGE.Println("---------------------- Failed testShortFinalChunk")
+	} else {
+		pass++
+		LOGE.Println("Passed testShortFinalChunk")
+	}
+
+	tests++
+	LOGE.Println("----------- testTrackerToken")
+	if !testTrackerToken() {
+		LOGE.Println("---------------------- Failed testTrackerToken")
+	} else {
+		pass++
+		LOGE.Println("Passed testTrackerToken")
+	}
+
+	tests++
+	LOGE.Println("----------- testStuckTrackerNode")
+	if !testStuckTrackerNode() {
+		LOGE.Println("---------------------- Failed testStuckTrackerNode")
+	} else {
+		pass++
+		LOGE.Println("Passed testStuckTrackerNode")
+	}
+
+	tests++
+	LOGE.Println("----------- testPeerConnectionReuse")
+	if !testPeerConnectionReuse() {
+		LOGE.Println("---------------------- Failed testPeerConnectionReuse")
+	} else {
+		pass++
+		LOGE.Println("Passed testPeerConnectionReuse")
+	}
+
+	tests++
+	LOGE.Println("----------- testPersistLocalFiles")
+	if !testPersistLocalFiles() {
+		LOGE.Println("---------------------- Failed testPersistLocalFiles")
+	} else {
+		pass++
+		LOGE.Println("Passed testPersistLocalFiles")
+	}
+
+	tests++
+	LOGE.Println("----------- testRemoveFile")
+	if !testRemoveFile() {
+		LOGE.Println("---------------------- Failed testRemoveFile")
+	} else {
+		pass++
+		LOGE.Println("Passed testRemoveFile")
+	}
+	tests++
+	LOGE.Println("----------- testStreamChunks")
+	if !testStreamChunks() {
+		LOGE.Println("---------------------- Failed testStreamChunks")
+	} else {
+		pass++
+		LOGE.Println("Passed testStreamChunks")
+	}
+	tests++
+	LOGE.Println("----------- testTorrentReaderAt")
+	if !testTorrentReaderAt() {
+		LOGE.Println("---------------------- Failed testTorrentReaderAt")
+	} else {
+		pass++
+		LOGE.Println("Passed testTorrentReaderAt")
+	}
+	LOGE.Println("Passed: ", pass, "/", tests)
+}
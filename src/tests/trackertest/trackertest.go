@@ -1,32 +1,58 @@
 package main
 
 import (
+	"client"
+	"client/clientproto"
 	"errors"
 	"log"
 	"math/rand"
-	"io"
 	"net"
+	"net/http"
 	"net/rpc"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"sort"
 	"strconv"
-	"syscall"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"torrent"
 	"torrent/torrentproto"
 	"tracker"
 	"tracker/trackerproto"
 )
 
 type trackerTester struct {
-	cmd *exec.Cmd
-	srv *rpc.Client
-	in io.WriteCloser
+	t     tracker.Tracker
+	srv   *rpc.Client
+	token string // Given with every update
 }
 
 var LOGE = log.New(os.Stderr, "", log.Lshortfile|log.Lmicroseconds)
 
+// The admin key of the trackers in test clusters
+const ADMIN_KEY = "test admin key"
+
+// Ignores changes to a Client's local files
+type nopListener struct{}
+
+func (l *nopListener) OnChange(change *clientproto.LocalFileChange) {}
+
 func createCluster(numNodes int) ([](*trackerTester), error) {
+	return createLimitedCluster(numNodes, 0)
+}
+
+// Creates a cluster whose nodes let each client create at most maxTorrents
+// torrents (0 for no limit)
+func createLimitedCluster(numNodes, maxTorrents int) ([](*trackerTester), error) {
+	return createConfiguredCluster(numNodes, tracker.TrackerConfig{
+		MaxTorrents: maxTorrents,
+		AdminKey:    ADMIN_KEY})
+}
+
+// Creates a cluster whose nodes are configured by cfg, apart from their
+// places in the cluster
+func createConfiguredCluster(numNodes int, cfg tracker.TrackerConfig) ([](*trackerTester), error) {
 	if numNodes <= 0 {
 		return nil, errors.New("numNodes <= 0")
 	}
@@ -34,82 +60,82 @@ func createCluster(numNodes int) ([](*trackerTester), error) {
 
 	basePort := 9091 + 41*(r.Int() % 300)
 	cluster := make([](*trackerTester), numNodes)
-	doneChan := make(chan *trackerTester)
+	doneChan := make(chan error)
 	master := net.JoinHostPort("localhost", strconv.Itoa(basePort))
 	LOGE.Println("Master HostPort: ", master)
 
-	gobin := os.Getenv("GOBIN")
-	if gobin == "" {
-		return cluster, errors.New("GOBIN not set")
-	}
-
-	go func () {
-		// Start the master server
-		masterCmd := exec.Command(filepath.Join(gobin, "tracker_runner"), strconv.Itoa(basePort), strconv.Itoa(numNodes), strconv.Itoa(0))
-		in, err := masterCmd.StdinPipe()
-
-		masterCmd.Start()
-		srv, err := rpc.DialHTTP("tcp", master)
-		for err != nil {
-			srv, err = rpc.DialHTTP("tcp", master)
-		}
-		doneChan <- &trackerTester{
-			cmd: masterCmd,
-			srv: srv,
-			in: in}
-	} ()
-
-	// Spawn the non-master trackers in the cluster
-	for i := 1; i < numNodes; i++ {
+	// Start every tracker in this process.
+	// The master does not return until the other trackers have joined it,
+	// so start them all at once.
+	for i := 0; i < numNodes; i++ {
 		go func (id int) {
-			port := basePort + 17*id
-			trackcmd := exec.Command(filepath.Join(gobin, "tracker_runner"), strconv.Itoa(port),
-					strconv.Itoa(numNodes), strconv.Itoa(id), master)
-			in, err := trackcmd.StdinPipe()
-
-			trackcmd.Start()
-			srv, err := rpc.DialHTTP("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
-			for err != nil {
-				srv, err = rpc.DialHTTP("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
-			}
-			doneChan <- &trackerTester{
-				cmd: trackcmd,
-				srv: srv,
-				in: in}
+			var err error
+			nodeCfg := cfg
+			nodeCfg.NumNodes, nodeCfg.NodeID = numNodes, id
+			if id == 0 {
+				nodeCfg.MasterHostPort, nodeCfg.Port = "", basePort
+			} else {
+				nodeCfg.MasterHostPort, nodeCfg.Port = master, basePort + 17*id
+			}
+			cluster[id], err = createConfiguredTracker(nodeCfg)
+			doneChan <- err
 		} (i)
 	}
 
-	resp := 0
-	for resp < numNodes {
-		cluster[resp] = <-doneChan
-		resp++
+	var err error
+	for resp := 0; resp < numNodes; resp++ {
+		if e := <-doneChan; e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return cluster, err
 	}
 	LOGE.Println("Created Cluster")
 
 	return cluster, nil
 }
 
+// Shuts down every tracker in the cluster.
+// Trackers which failed to start are skipped.
 func closeCluster(cluster [](*trackerTester)) {
 	for _, tracker := range cluster {
-		tracker.in.Close()
-		tracker.cmd.Process.Kill()
+		if tracker != nil {
+			tracker.srv.Close()
+			tracker.t.Shutdown()
+		}
 	}
 }
 
 func createTracker(master string, numNodes, port, nodeID int) (*trackerTester, error) {
-	_, err := tracker.NewTrackerServer(master, numNodes, port, nodeID)
+	return createLimitedTracker(master, numNodes, port, nodeID, 0)
+}
+
+func createLimitedTracker(master string, numNodes, port, nodeID, maxTorrents int) (*trackerTester, error) {
+	return createConfiguredTracker(tracker.TrackerConfig{
+		MasterHostPort: master,
+		NumNodes:       numNodes,
+		Port:           port,
+		NodeID:         nodeID,
+		MaxTorrents:    maxTorrents,
+		AdminKey:       ADMIN_KEY})
+}
+
+func createConfiguredTracker(cfg tracker.TrackerConfig) (*trackerTester, error) {
+	t, err := tracker.NewTrackerServerWithConfig(cfg)
 	if err != nil {
 		LOGE.Println(err.Error())
 		return nil, err
 	}
 
-	srv, err := rpc.DialHTTP("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	srv, err := rpc.DialHTTP("tcp", net.JoinHostPort("localhost", strconv.Itoa(cfg.Port)))
 	if err != nil {
 		LOGE.Println("Could not connect to tracker")
+		t.Shutdown()
 		return nil, err
 	}
 
-	return &trackerTester{srv: srv}, nil
+	return &trackerTester{t: t, srv: srv}, nil
 }
 
 func (t *trackerTester) GetOp(seqNum int) (*trackerproto.GetReply, error) {
@@ -119,10 +145,47 @@ func (t *trackerTester) GetOp(seqNum int) (*trackerproto.GetReply, error) {
 	return reply, err
 }
 
+func (t *trackerTester) GetSnapshot() (*trackerproto.SnapshotReply, error) {
+	reply := &trackerproto.SnapshotReply{}
+	err := t.srv.Call("PaxosTracker.GetSnapshot", &trackerproto.SnapshotArgs{}, reply)
+	return reply, err
+}
+
+func (t *trackerTester) Commit(seqNum int, value trackerproto.Operation) error {
+	args := &trackerproto.CommitArgs{
+		SeqNum: seqNum,
+		Value: value}
+	reply := &trackerproto.CommitReply{}
+	return t.srv.Call("PaxosTracker.Commit", args, reply)
+}
+
 func (t *trackerTester) ConfirmChunk(chunk torrentproto.ChunkID, hostPort string) (*trackerproto.UpdateReply, error) {
 	args := &trackerproto.ConfirmArgs{
 		Chunk: chunk,
-		HostPort: hostPort}
+		HostPort: hostPort,
+		Token: t.token}
+	reply := &trackerproto.UpdateReply{}
+	err := t.srv.Call("RemoteTracker.ConfirmChunk", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) ConfirmChunks(id torrentproto.ID, chunkNums []int, hostPort string) (*trackerproto.UpdateReply, error) {
+	args := &trackerproto.ConfirmChunksArgs{
+		ID: id,
+		ChunkNums: chunkNums,
+		HostPort: hostPort,
+		Token: t.token}
+	reply := &trackerproto.UpdateReply{}
+	err := t.srv.Call("RemoteTracker.ConfirmChunks", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) SeedChunk(chunk torrentproto.ChunkID, hostPort string) (*trackerproto.UpdateReply, error) {
+	args := &trackerproto.ConfirmArgs{
+		Chunk: chunk,
+		HostPort: hostPort,
+		Complete: true,
+		Token: t.token}
 	reply := &trackerproto.UpdateReply{}
 	err := t.srv.Call("RemoteTracker.ConfirmChunk", args, reply)
 	return reply, err
@@ -135,13 +198,117 @@ func (t *trackerTester) RequestChunk(chunk torrentproto.ChunkID) (*trackerproto.
 	return reply, err
 }
 
+func (t *trackerTester) RequestChunkOrdered(chunk torrentproto.ChunkID, order trackerproto.PeerOrder) (*trackerproto.RequestReply, error) {
+	args := &trackerproto.RequestArgs{
+		Chunk: chunk,
+		Order: order}
+	reply := &trackerproto.RequestReply{}
+	err := t.srv.Call("RemoteTracker.RequestChunk", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) RequestChunkCapped(chunk torrentproto.ChunkID, order trackerproto.PeerOrder, maxPeers int) (*trackerproto.RequestReply, error) {
+	args := &trackerproto.RequestArgs{
+		Chunk: chunk,
+		Order: order,
+		MaxPeers: maxPeers}
+	reply := &trackerproto.RequestReply{}
+	err := t.srv.Call("RemoteTracker.RequestChunk", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) PeerHasChunk(chunk torrentproto.ChunkID, hostPort string) (*trackerproto.HasReply, error) {
+	args := &trackerproto.HasArgs{
+		Chunk: chunk,
+		HostPort: hostPort}
+	reply := &trackerproto.HasReply{}
+	err := t.srv.Call("RemoteTracker.PeerHasChunk", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) Availability(id torrentproto.ID) (*trackerproto.AvailabilityReply, error) {
+	args := &trackerproto.AvailabilityArgs{ID: id}
+	reply := &trackerproto.AvailabilityReply{}
+	err := t.srv.Call("RemoteTracker.Availability", args, reply)
+	return reply, err
+}
+
 func (t *trackerTester) CreateEntry(torrent torrentproto.Torrent) (*trackerproto.UpdateReply, error) {
-	args := &trackerproto.CreateArgs{Torrent: torrent}
+	args := &trackerproto.CreateArgs{
+		Torrent: torrent,
+		Token:   t.token}
+	reply := &trackerproto.UpdateReply{}
+	err := t.srv.Call("RemoteTracker.CreateEntry", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) CreateEntryAs(torrent torrentproto.Torrent, hostPort string) (*trackerproto.UpdateReply, error) {
+	args := &trackerproto.CreateArgs{
+		Torrent:  torrent,
+		HostPort: hostPort,
+		Token:    t.token}
 	reply := &trackerproto.UpdateReply{}
 	err := t.srv.Call("RemoteTracker.CreateEntry", args, reply)
 	return reply, err
 }
 
+func (t *trackerTester) DeleteEntry(id torrentproto.ID) (*trackerproto.UpdateReply, error) {
+	args := &trackerproto.DeleteArgs{
+		ID:    id,
+		Token: t.token}
+	reply := &trackerproto.UpdateReply{}
+	err := t.srv.Call("RemoteTracker.DeleteEntry", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) WatchChunk(chunk torrentproto.ChunkID) (*trackerproto.WatchReply, error) {
+	args := &trackerproto.WatchArgs{Chunk: chunk}
+	reply := &trackerproto.WatchReply{}
+	err := t.srv.Call("RemoteTracker.WatchChunk", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) EvictPeer(hostPort, adminKey string) (*trackerproto.UpdateReply, error) {
+	args := &trackerproto.EvictArgs{
+		HostPort: hostPort,
+		AdminKey: adminKey}
+	reply := &trackerproto.UpdateReply{}
+	err := t.srv.Call("RemoteTracker.EvictPeer", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) RemoveNode(hostPort, adminKey string) (*trackerproto.UpdateReply, error) {
+	args := &trackerproto.RemoveNodeArgs{
+		HostPort: hostPort,
+		AdminKey: adminKey}
+	reply := &trackerproto.UpdateReply{}
+	err := t.srv.Call("PaxosTracker.RemoveNode", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) ExportTorrents() (*trackerproto.ExportReply, error) {
+	args := &trackerproto.ExportArgs{}
+	reply := &trackerproto.ExportReply{}
+	err := t.srv.Call("PaxosTracker.ExportTorrents", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) ImportTorrents(torrents []torrentproto.Torrent) (*trackerproto.ImportReply, error) {
+	args := &trackerproto.ImportArgs{
+		Torrents: torrents,
+		Token:    t.token}
+	reply := &trackerproto.ImportReply{}
+	err := t.srv.Call("PaxosTracker.ImportTorrents", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) ListTorrents() (*trackerproto.ListReply, error) {
+	args := &trackerproto.ListArgs{}
+	reply := &trackerproto.ListReply{}
+	err := t.srv.Call("RemoteTracker.ListTorrents", args, reply)
+	return reply, err
+}
+
 func (t *trackerTester) GetTrackers() (*trackerproto.TrackersReply, error) {
 	args := &trackerproto.TrackersArgs{}
 	reply := &trackerproto.TrackersReply{}
@@ -149,15 +316,109 @@ func (t *trackerTester) GetTrackers() (*trackerproto.TrackersReply, error) {
 	return reply, err
 }
 
+func (t *trackerTester) Stats() (*trackerproto.StatsReply, error) {
+	args := &trackerproto.StatsArgs{}
+	reply := &trackerproto.StatsReply{}
+	err := t.srv.Call("RemoteTracker.Stats", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) Ping() (*trackerproto.PingReply, error) {
+	args := &trackerproto.PingArgs{}
+	reply := &trackerproto.PingReply{}
+	err := t.srv.Call("RemoteTracker.Ping", args, reply)
+	return reply, err
+}
+
+func (t *trackerTester) ReportBadChunk(chunk torrentproto.ChunkID, hostPort, reporter string) (*trackerproto.UpdateReply, error) {
+	args := &trackerproto.BadChunkArgs{
+		Chunk:    chunk,
+		HostPort: hostPort,
+		Reporter: reporter,
+		Token:    t.token}
+	reply := &trackerproto.UpdateReply{}
+	err := t.srv.Call("RemoteTracker.ReportBadChunk", args, reply)
+	return reply, err
+}
+
 func (t *trackerTester) ReportMissing(chunk torrentproto.ChunkID, hostPort string) (*trackerproto.UpdateReply, error) {
 	args := &trackerproto.ReportArgs{
 		Chunk: chunk,
-		HostPort: hostPort}
+		HostPort: hostPort,
+		Token: t.token}
 	reply := &trackerproto.UpdateReply{}
 	err := t.srv.Call("RemoteTracker.ReportMissing", args, reply)
 	return reply, err
 }
 
+// sameStrings checks that a and b hold the same strings, in any order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// logsMatch checks that two trackers have committed exactly the same operations
+// It compares the part of the log that both trackers still have
+func logsMatch(a, b *trackerTester) (bool, error) {
+	replyA, err := a.GetOp(0)
+	if err != nil {
+		return false, err
+	}
+	replyB, err := b.GetOp(0)
+	if err != nil {
+		return false, err
+	}
+	LOGE.Println("SeqNum: ", replyA.MaxSeq)
+	if replyA.MaxSeq != replyB.MaxSeq {
+		LOGE.Println("Logs have different lengths: ", replyA.MaxSeq, replyB.MaxSeq)
+		return false, nil
+	}
+
+	start := replyA.MinSeq
+	if replyB.MinSeq > start {
+		start = replyB.MinSeq
+	}
+	for seqNum := start; seqNum < replyA.MaxSeq; seqNum++ {
+		replyA, errA := a.GetOp(seqNum)
+		replyB, errB := b.GetOp(seqNum)
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+		if !opsMatch(replyA.Value, replyB.Value) || replyA.Status != replyB.Status {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// opsMatch checks that two logged operations are the same, including every
+// operation in a batch
+func opsMatch(a, b trackerproto.Operation) bool {
+	if a.OpType != b.OpType || a.Chunk != b.Chunk || a.ClientAddr != b.ClientAddr || a.Time != b.Time || len(a.Batch) != len(b.Batch) {
+		return false
+	}
+	for i := range a.Batch {
+		if !opsMatch(a.Batch[i], b.Batch[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // returns a torrent object with the provided info
 // if trackersGood is false, then it just makes up trackers
 // if trackersGood is true, then it gets the trackers from t
@@ -301,6 +562,57 @@ func createEntryTestOneNode() bool {
 	return true
 }
 
+// Let each client create two torrents, then check that one client is
+// stopped from creating a third, through any node, while another is not
+func testTorrentLimit() bool {
+	cluster, err := createLimitedCluster(3, 2)
+	if err != nil {
+		LOGE.Println("Error creating cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+
+	// A node has committed every op before the last one it answered, so
+	// send the creates which should see earlier ones to the same node
+	creates := []struct {
+		node     int
+		name     string
+		hostPort string
+		status   trackerproto.Status
+	}{
+		{0, "first", "apple", trackerproto.OK},
+		{1, "second", "apple", trackerproto.OK},
+		{1, "third", "apple", trackerproto.TooManyTorrents},
+		{1, "fourth", "banana", trackerproto.OK}}
+	for _, create := range creates {
+		torrent.ID.Name = create.name
+		reply, err := cluster[create.node].CreateEntryAs(torrent, create.hostPort)
+		if err != nil || reply.Status != create.status {
+			LOGE.Println("Create Entry: wrong status for", create.name, "torrent from", create.hostPort)
+			closeCluster(cluster)
+			return false
+		}
+	}
+
+	// The rejected torrent should not exist
+	torrent.ID.Name = "third"
+	request, err := cluster[1].RequestChunk(torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0})
+	if err != nil || request.Status != trackerproto.FileNotFound {
+		LOGE.Println("Request Chunk: Status not FileNotFound")
+		closeCluster(cluster)
+		return false
+	}
+	closeCluster(cluster)
+	return true
+}
+
 // test CreateEntry on three nodes
 func createEntryTestThreeNodes() bool {
 	cluster, err := createCluster(3)
@@ -458,139 +770,268 @@ func testCluster(numNodes int) bool {
 	}
 }
 
-func testStress(total int) bool {
-	cluster, _ := createCluster(3)
-
-	LOGE.Println("Creating Torrent")
-	torrent, _ := newTorrentInfo(cluster[0], true, 3)
-	cluster[0].CreateEntry(torrent)
+// Confirm a chunk from a complete seeder and from a partial holder,
+// then check that RequestChunk tells them apart
+func testSeeders(numNodes int) bool {
+	cluster, err := createCluster(numNodes)
+	if err != nil {
+		LOGE.Println("Error creating cluster")
+		closeCluster(cluster)
+		return false
+	}
 
-	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
-	doneChan := make(chan struct {})
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
 
-	LOGE.Println("Sending Messages")
-	// Have two nodes make a large number of confirms and reports
-	for i := 0; i < total; i++ {
-		go func () {
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			conf, err := cluster[0].ConfirmChunk(chunk, strconv.Itoa(r.Int()))
-			if err != nil {
-				LOGE.Println(err.Error())
-			}
-			if conf.Status != trackerproto.OK {
-				LOGE.Println("Confirm Chunk: Status not OK")
-			}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
 
-			doneChan <- struct{}{}
-		} ()
+	LOGE.Println("Seeding 'banana', confirming 'apple'")
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	reply, err = cluster[0].SeedChunk(chunk, "banana")
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Seed Chunk: Status not OK")
+		closeCluster(cluster)
+		return false
 	}
-	LOGE.Println("Waiting for things to finish")
-	fin := 0
-	for fin < total {
-		<-doneChan
-		fin++
-		if fin % 50 == 0 {
-			LOGE.Println("Finished: ", fin)
-		}
+	reply, err = cluster[0].ConfirmChunk(chunk, "apple")
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		closeCluster(cluster)
+		return false
 	}
-	LOGE.Println("Everything in")
-
-	seqNum := 0
-	ok := true
-	matching := true
-	for matching && ok {
-		reply0, err0 := cluster[0].GetOp(seqNum)
-		reply2, err2 := cluster[1].GetOp(seqNum)
-
-		if err0 != nil || err2 != nil {
-			LOGE.Println("Error getting operation.")
-			closeCluster(cluster)
-			return false
-		}
-		if reply0.Status == trackerproto.OutOfDate {
-			ok = false
-		}
-		val0 := reply0.Value
-		val2 := reply2.Value
-		valsEq := val0.OpType == val2.OpType && val0.Chunk == val2.Chunk && val0.ClientAddr == val2.ClientAddr
-		matching = matching && valsEq && (reply0.Status == reply2.Status)
 
-		seqNum++
+	reqReply, err := cluster[0].RequestChunk(chunk)
+	if err != nil || reqReply.Status != trackerproto.OK {
+		LOGE.Println("Request Chunk: Status not OK")
+		closeCluster(cluster)
+		return false
 	}
-	LOGE.Println("SeqNum: ", seqNum)
 	closeCluster(cluster)
-	return matching
+	if len(reqReply.Peers) != 2 {
+		LOGE.Println("Wrong number of peers")
+		return false
+	}
+	if len(reqReply.Seeders) != 1 || reqReply.Seeders[0] != "banana" {
+		LOGE.Println("Wrong seeders: ", reqReply.Seeders)
+		return false
+	}
+	if len(reqReply.Partial) != 1 || reqReply.Partial[0] != "apple" {
+		LOGE.Println("Wrong partial holders: ", reqReply.Partial)
+		return false
+	}
+	return true
 }
 
-// Test with dualing leaders
-func testDualing(total int) bool {
-	cluster, _ := createCluster(3)
+// Confirm a chunk from one peer, then ask about that peer, a peer without
+// the chunk, and a chunk which is out of range
+func testPeerHasChunk(numNodes int) bool {
+	cluster, err := createCluster(numNodes)
+	if err != nil {
+		LOGE.Println("Error creating cluster")
+		closeCluster(cluster)
+		return false
+	}
 
-	LOGE.Println("Creating Torrent")
-	torrent, _ := newTorrentInfo(cluster[0], true, 3)
-	cluster[0].CreateEntry(torrent)
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
 
-	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
-	doneChan := make(chan int)
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
 
-	LOGE.Println("Sending Messages")
-	// Have two nodes make a large number of confirms and reports
-	for i := 0; i < total; i++ {
-		go func (id int) {
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			conf, err := cluster[id].ConfirmChunk(chunk, strconv.Itoa(r.Int()))
-			if err != nil {
-				LOGE.Println(err.Error())
+	LOGE.Println("Confirming 'apple'")
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 1}
+	reply, err = cluster[0].ConfirmChunk(chunk, "apple")
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	hasReply, err := cluster[0].PeerHasChunk(chunk, "apple")
+	if err != nil || hasReply.Status != trackerproto.OK || !hasReply.Has {
+		LOGE.Println("Peer Has Chunk: 'apple' should have chunk")
+		closeCluster(cluster)
+		return false
+	}
+
+	hasReply, err = cluster[0].PeerHasChunk(chunk, "banana")
+	if err != nil || hasReply.Status != trackerproto.OK || hasReply.Has {
+		LOGE.Println("Peer Has Chunk: 'banana' should not have chunk")
+		closeCluster(cluster)
+		return false
+	}
+
+	chunk.ChunkNum = 3
+	hasReply, err = cluster[0].PeerHasChunk(chunk, "apple")
+	if err != nil || hasReply.Status != trackerproto.OutOfRange {
+		LOGE.Println("Peer Has Chunk: Status not OutOfRange")
+		closeCluster(cluster)
+		return false
+	}
+	closeCluster(cluster)
+	return true
+}
+
+// Create a torrent whose chunks all have peers, one where some chunks have
+// none, and one where no chunk has a peer, then check the availability of each,
+// including how many peers each chunk has (and of a torrent which does not
+// exist)
+func testAvailability(numNodes int) bool {
+	cluster, err := createCluster(numNodes)
+	if err != nil {
+		LOGE.Println("Error creating cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	// Each torrent has 3 chunks, and lists which peers confirm each chunk
+	names := []string{"full", "partial", "empty"}
+	confirms := [][][]string{
+		{{"apple", "banana", "cherry"}, {"apple"}, {"apple", "banana"}},
+		{{"apple", "banana"}, {}, {"banana"}},
+		{{}, {}, {}}}
+	expected := []trackerproto.AvailabilityReply{
+		{Status: trackerproto.OK, ChunksWithPeers: 3, TotalChunks: 3, MinReplication: 1},
+		{Status: trackerproto.OK, ChunksWithPeers: 2, TotalChunks: 3, MinReplication: 0},
+		{Status: trackerproto.OK, ChunksWithPeers: 0, TotalChunks: 3, MinReplication: 0}}
+
+	for i, name := range names {
+		torrent, err := newTorrentInfo(cluster[0], true, 3)
+		if err != nil {
+			LOGE.Println("Could not create torrent")
+			closeCluster(cluster)
+			return false
+		}
+		torrent.ID.Name = name
+
+		reply, err := cluster[0].CreateEntry(torrent)
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry: Status not OK")
+			closeCluster(cluster)
+			return false
+		}
+
+		LOGE.Println("Confirming chunks of", name)
+		for chunkNum, peers := range confirms[i] {
+			chunk := torrentproto.NewChunkID(torrent.ID, chunkNum)
+			for _, peer := range peers {
+				reply, err = cluster[0].ConfirmChunk(chunk, peer)
+				if err != nil || reply.Status != trackerproto.OK {
+					LOGE.Println("Confirm Chunk: Status not OK")
+					closeCluster(cluster)
+					return false
+				}
 			}
-			if conf.Status != trackerproto.OK {
-				LOGE.Println("Confirm Chunk: Status not OK")
+		}
+
+		avail, err := cluster[0].Availability(torrent.ID)
+		if err != nil || len(avail.Replication) != len(confirms[i]) {
+			LOGE.Println("Availability of", name, "is", avail)
+			closeCluster(cluster)
+			return false
+		}
+		for chunkNum, peers := range confirms[i] {
+			if avail.Replication[chunkNum] != len(peers) {
+				LOGE.Println("Replication of", name, "is", avail.Replication)
+				closeCluster(cluster)
+				return false
 			}
+		}
+		if avail.Status != expected[i].Status || avail.ChunksWithPeers != expected[i].ChunksWithPeers ||
+			avail.TotalChunks != expected[i].TotalChunks || avail.MinReplication != expected[i].MinReplication {
+			LOGE.Println("Availability of", name, "is", avail, "expected", expected[i])
+			closeCluster(cluster)
+			return false
+		}
+	}
 
-			doneChan <- id
-		} (i % 2)
+	avail, err := cluster[0].Availability(torrentproto.ID{Name: "missing", Hash: "TestHash"})
+	if err != nil || avail.Status != trackerproto.FileNotFound {
+		LOGE.Println("Availability: Status not FileNotFound")
+		closeCluster(cluster)
+		return false
 	}
-	LOGE.Println("Waiting for things to finish")
-	fin := make([]int, 2)
-	fin[0] = 0
-	fin[1] = 0
-	for fin[0] + fin[1] < total {
-		id := <-doneChan
-		fin[id]++
-		if (fin[0] + fin[1]) % 50 == 0 {
-			LOGE.Println("Finished: ", fin[0], fin[1])
+	closeCluster(cluster)
+	return true
+}
+
+// Start two trackers and a client in this process,
+// then check that each one answers its own RPCs
+func testInProcess() bool {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	basePort := 9091 + 41*(r.Int()%300)
+
+	trackers := make([](*trackerTester), 2)
+	for i := range trackers {
+		t, err := createTracker("", 1, basePort+17*i, 0)
+		if err != nil {
+			LOGE.Println("Could not create tracker ", i)
+			closeCluster(trackers)
+			return false
 		}
+		trackers[i] = t
 	}
-	LOGE.Println("Everything in")
 
-	seqNum := 0
-	ok := true
-	matching := true
-	for matching && ok {
-		reply0, err0 := cluster[0].GetOp(seqNum)
-		reply2, err2 := cluster[1].GetOp(seqNum)
+	clientHostPort := net.JoinHostPort("localhost", strconv.Itoa(basePort+34))
+	localFiles := make(map[torrentproto.ID]*clientproto.LocalFile)
+	if _, err := client.NewClient(localFiles, &nopListener{}, clientHostPort); err != nil {
+		LOGE.Println("Could not create client: ", err)
+		closeCluster(trackers)
+		return false
+	}
 
-		if err0 != nil || err2 != nil {
-			LOGE.Println("Error getting operation.")
-			closeCluster(cluster)
+	for i, t := range trackers {
+		reply, err := t.GetTrackers()
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Get Trackers: Status not OK")
+			closeCluster(trackers)
 			return false
 		}
-		if reply0.Status == trackerproto.OutOfDate {
-			ok = false
+		want := net.JoinHostPort("localhost", strconv.Itoa(basePort+17*i))
+		if len(reply.HostPorts) != 1 || reply.HostPorts[0] != want {
+			LOGE.Println("Tracker ", i, " answered for ", reply.HostPorts)
+			closeCluster(trackers)
+			return false
 		}
-		val0 := reply0.Value
-		val2 := reply2.Value
-		valsEq := val0.OpType == val2.OpType && val0.Chunk == val2.Chunk && val0.ClientAddr == val2.ClientAddr
-		matching = matching && valsEq && (reply0.Status == reply2.Status)
+	}
 
-		seqNum++
+	peer, err := rpc.DialHTTP("tcp", clientHostPort)
+	if err != nil {
+		LOGE.Println("Could not connect to client")
+		closeCluster(trackers)
+		return false
 	}
-	LOGE.Println("SeqNum: ", seqNum)
-	closeCluster(cluster)
-	return matching
+	args := &clientproto.GetArgs{}
+	reply := &clientproto.GetReply{}
+	if err := peer.Call("RemoteClient.GetChunk", args, reply); err != nil || reply.Status != clientproto.ChunkNotFound {
+		LOGE.Println("Get Chunk: Status not ChunkNotFound")
+		closeCluster(trackers)
+		return false
+	}
+	closeCluster(trackers)
+	return true
 }
 
-// Tests that a 3 node cluster can still operate when one node is closed.
-func testClosed() bool {
+// Confirm chunks while one node is stalled, then request peers from it and
+// check that if its answer is out of date, its SeqNum shows it
+func testStaleRequest() bool {
 	cluster, err := createCluster(3)
 	if err != nil {
 		LOGE.Println("Could not create cluster")
@@ -598,8 +1039,6 @@ func testClosed() bool {
 		return false
 	}
 
-	LOGE.Println("Making Torrent")
-	// Now attempt to do something.
 	torrent, err := newTorrentInfo(cluster[0], true, 3)
 	if err != nil {
 		LOGE.Println("Could not create torrent")
@@ -607,117 +1046,197 @@ func testClosed() bool {
 		return false
 	}
 
-	LOGE.Println("Killing Process")
-	// Close one of the nodes
-	if err := cluster[2].cmd.Process.Signal(syscall.SIGKILL); err != nil {
-		LOGE.Println("Could not close node")
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
 		closeCluster(cluster)
 		return false
 	}
 
-	boolChan := make(chan bool)
-	time.AfterFunc(time.Second * time.Duration(10), func () { boolChan <- false})
-
-	go func () {
-		LOGE.Println("Sending Torrent to tracker")
-		reply, err := cluster[0].CreateEntry(torrent)
-		if reply.Status != trackerproto.OK {
-			LOGE.Println("Create Entry: Status not OK")
-			closeCluster(cluster)
-			boolChan <- false
-		}
+	LOGE.Println("Stalling tracker")
+	cluster[2].t.DebugStall(3)
 
-		LOGE.Println("Confirming chunks")
-		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
-		reply, err = cluster[0].ConfirmChunk(chunk, "banana")
-		if err != nil {
-			LOGE.Println("Error confirming chunk")
-			closeCluster(cluster)
-			boolChan <- false
-		}
-		if reply.Status != trackerproto.OK {
+	LOGE.Println("Confirming chunks")
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	total := 20
+	for i := 0; i < total; i++ {
+		reply, err = cluster[0].ConfirmChunk(chunk, "peer"+strconv.Itoa(i))
+		if err != nil || reply.Status != trackerproto.OK {
 			LOGE.Println("Confirm Chunk: Status not OK")
 			closeCluster(cluster)
-			boolChan <- false
+			return false
 		}
-		boolChan <- true
-	} ()
+	}
 
-	result := <-boolChan
+	fresh, err := cluster[0].RequestChunk(chunk)
+	if err != nil || fresh.Status != trackerproto.OK || len(fresh.Peers) != total {
+		LOGE.Println("Request Chunk: wrong peers from up to date tracker")
+		closeCluster(cluster)
+		return false
+	}
+
+	// This call waits for the stall to end
+	LOGE.Println("Call on stalled tracker")
+	stale, err := cluster[2].RequestChunk(chunk)
 	closeCluster(cluster)
-	return result
+	if err != nil || stale.Status != trackerproto.OK {
+		LOGE.Println("Request Chunk: Status not OK")
+		return false
+	}
+	LOGE.Println("SeqNums: ", fresh.SeqNum, stale.SeqNum, " Peers: ", len(fresh.Peers), len(stale.Peers))
+	if stale.SeqNum > fresh.SeqNum {
+		LOGE.Println("Stalled tracker is ahead")
+		return false
+	}
+	if stale.SeqNum == fresh.SeqNum && len(stale.Peers) != len(fresh.Peers) {
+		LOGE.Println("Stalled tracker is out of date, but its SeqNum does not show it")
+		return false
+	}
+	return true
 }
 
-// Tests that a 3 node cluster will NOT operate when two nodes are closed
-func testClosedTwo() bool {
-	cluster, err := createCluster(3)
+// Commit a long run of operations out of order, then fill in the gap before
+// them, and check that the tracker applies the whole run at once
+func testLongLog(total int) bool {
+	cluster, err := createCluster(1)
 	if err != nil {
-		LOGE.Println("Could not create cluster")
+		LOGE.Println("Error creating cluster")
 		closeCluster(cluster)
 		return false
 	}
 
-	LOGE.Println("Making Torrent")
-	// Now attempt to do something.
-	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	torrent, err := newTorrentInfo(cluster[0], true, 1)
 	if err != nil {
 		LOGE.Println("Could not create torrent")
 		closeCluster(cluster)
 		return false
 	}
 
-	LOGE.Println("Sending Torrent to tracker")
 	reply, err := cluster[0].CreateEntry(torrent)
-	if reply.Status != trackerproto.OK {
+	if err != nil || reply.Status != trackerproto.OK {
 		LOGE.Println("Create Entry: Status not OK")
 		closeCluster(cluster)
 		return false
 	}
 
-	// Close two nodes
-	LOGE.Println("Closing Nodes")
-	if err := cluster[1].cmd.Process.Signal(syscall.SIGKILL); err != nil {
-		LOGE.Println("Could not close node 1")
+	getReply, err := cluster[0].GetOp(0)
+	if err != nil {
+		LOGE.Println("Could not get SeqNum")
 		closeCluster(cluster)
 		return false
 	}
-	if err := cluster[2].cmd.Process.Signal(syscall.SIGKILL); err != nil {
-		LOGE.Println("Could not close node 2")
+	start := getReply.MaxSeq
+
+	LOGE.Println("Logging Operations")
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	for i := total; i >= 0; i-- {
+		op := trackerproto.Operation{
+			OpType: trackerproto.Add,
+			Chunk: chunk,
+			ClientAddr: "peer" + strconv.Itoa(i)}
+		if err := cluster[0].Commit(start+i, op); err != nil {
+			LOGE.Println("Commit failed: ", err)
+			closeCluster(cluster)
+			return false
+		}
+	}
+
+	getReply, err = cluster[0].GetOp(0)
+	if err != nil || getReply.MaxSeq != start+total+1 {
+		LOGE.Println("Wrong SeqNum: ", getReply.MaxSeq)
 		closeCluster(cluster)
 		return false
 	}
 
-	boolChan := make(chan bool, 1)
-	time.AfterFunc(time.Second * time.Duration(10), func () { boolChan <- true })
+	hasReply, err := cluster[0].PeerHasChunk(chunk, "peer"+strconv.Itoa(total))
+	closeCluster(cluster)
+	if err != nil || hasReply.Status != trackerproto.OK || !hasReply.Has {
+		LOGE.Println("Peer Has Chunk: last peer should have chunk")
+		return false
+	}
+	return true
+}
 
-	LOGE.Println("Attempting to write")
-	go func(cluster []*trackerTester) {
-		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
-		reply, err = cluster[0].ConfirmChunk(chunk, "banana")
+// Confirm the same run of chunks through a single-node cluster and a
+// three-node cluster, and check that both log and apply every operation, and
+// that the single node, which commits without Paxos, is faster
+func testSingleNodeTiming(total int) bool {
+	// Confirms total chunks through the first node of a new cluster of
+	// numNodes nodes, and returns how long the confirms took
+	timeCommits := func(numNodes int) (time.Duration, bool) {
+		cluster, err := createCluster(numNodes)
+		defer closeCluster(cluster)
+		if err != nil {
+			LOGE.Println("Error creating cluster")
+			return 0, false
+		}
 
-		if err == nil && reply.Status != trackerproto.OK {
+		torrent, err := newTorrentInfo(cluster[0], true, total)
+		if err != nil {
+			LOGE.Println("Could not create torrent")
+			return 0, false
+		}
+		if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
 			LOGE.Println("Create Entry: Status not OK")
-			LOGE.Println(reply.Status)
+			return 0, false
 		}
-		boolChan <- false
-	} (cluster)
+		getReply, err := cluster[0].GetOp(0)
+		if err != nil {
+			LOGE.Println("Could not get SeqNum")
+			return 0, false
+		}
+		first := getReply.MaxSeq
 
-	passed := <-boolChan
-	closeCluster(cluster)
-	return passed
+		start := time.Now()
+		for chunkNum := 0; chunkNum < total; chunkNum++ {
+			chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+			if reply, err := cluster[0].ConfirmChunk(chunk, "peer"); err != nil || reply.Status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk: Status not OK")
+				return 0, false
+			}
+		}
+		elapsed := time.Since(start)
+
+		if getReply, err = cluster[0].GetOp(0); err != nil || getReply.MaxSeq != first+total {
+			LOGE.Println("Wrong SeqNum on", numNodes, "nodes: ", getReply.MaxSeq)
+			return 0, false
+		}
+		for chunkNum := 0; chunkNum < total; chunkNum++ {
+			chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+			if hasReply, err := cluster[0].PeerHasChunk(chunk, "peer"); err != nil || hasReply.Status != trackerproto.OK || !hasReply.Has {
+				LOGE.Println("Peer Has Chunk: peer should have chunk", chunkNum, "on", numNodes, "nodes")
+				return 0, false
+			}
+		}
+		return elapsed, true
+	}
+
+	single, ok := timeCommits(1)
+	if !ok {
+		return false
+	}
+	multi, ok := timeCommits(3)
+	if !ok {
+		return false
+	}
+	LOGE.Println("Committed", total, "operations in", single, "on one node and", multi, "on three")
+	if single >= multi {
+		LOGE.Println("Single node was not faster than Paxos")
+		return false
+	}
+	return true
 }
 
-// Stall one node, then do stuff
-// See if the stalled node can catch-up
-func testStalled() bool {
-	cluster, err := createCluster(3)
+// Request peers from a tracker in this process without RPC,
+// and check that the answer matches the RPC's
+func testRequestChunkLocal() bool {
+	cluster, err := createCluster(1)
 	if err != nil {
-		LOGE.Println("Could not create cluster")
+		LOGE.Println("Error creating cluster")
 		closeCluster(cluster)
 		return false
 	}
 
-	// Now attempt to do something.
 	torrent, err := newTorrentInfo(cluster[0], true, 3)
 	if err != nil {
 		LOGE.Println("Could not create torrent")
@@ -726,83 +1245,3218 @@ func testStalled() bool {
 	}
 
 	reply, err := cluster[0].CreateEntry(torrent)
-	if reply.Status != trackerproto.OK {
+	if err != nil || reply.Status != trackerproto.OK {
 		LOGE.Println("Create Entry: Status not OK")
 		closeCluster(cluster)
 		return false
 	}
 
-	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
-
-	// Stall for 5 seconds
-	LOGE.Println("Stalling tracker")
-	cluster[2].cmd.Process.Signal(syscall.SIGSTOP)
-
-	doneChan := make(chan struct{})
-
-	LOGE.Println("Sending Messages")
-	for i := 0; i < 50; i++ {
-		go func () {
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			conf, err := cluster[0].ConfirmChunk(chunk, strconv.Itoa(r.Int()))
-			if err != nil {
-				LOGE.Println(err.Error())
-			}
-			if conf.Status != trackerproto.OK {
-				LOGE.Println("Confirm Chunk: Status not OK")
-			}
-			doneChan <- struct{}{}
-		} ()
+	LOGE.Println("Confirming 'apple'")
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 1}
+	reply, err = cluster[0].ConfirmChunk(chunk, "apple")
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		closeCluster(cluster)
+		return false
 	}
 
-	LOGE.Println("Waiting for messages to finish")
-	fin := 0
-	for fin < 50 {
-		<-doneChan
-		fin++
+	remote, err := cluster[0].RequestChunk(chunk)
+	if err != nil || remote.Status != trackerproto.OK {
+		LOGE.Println("Request Chunk: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+	local, err := cluster[0].t.RequestChunkLocal(chunk)
+	if err != nil || local.Status != trackerproto.OK {
+		LOGE.Println("Request Chunk Local: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+	if len(local.Peers) != 1 || local.Peers[0] != "apple" || local.ChunkHash != remote.ChunkHash || local.SeqNum != remote.SeqNum {
+		LOGE.Println("Request Chunk Local: answer does not match RPC")
+		closeCluster(cluster)
+		return false
 	}
 
-	LOGE.Println("Resuming Tracker")
-	cluster[2].cmd.Process.Signal(syscall.SIGCONT)
-	LOGE.Println("Call on stalled tracker")
-	reply, err = cluster[2].ConfirmChunk(chunk, "apple")
-	if err != nil {
-		LOGE.Println("Error confirming chunk")
+	chunk.ChunkNum = 3
+	local, err = cluster[0].t.RequestChunkLocal(chunk)
+	if err != nil || local.Status != trackerproto.OutOfRange {
+		LOGE.Println("Request Chunk Local: Status not OutOfRange")
 		closeCluster(cluster)
 		return false
 	}
-	if reply.Status != trackerproto.OK {
-		LOGE.Println("Confirm Chunk: Status not OK")
-		LOGE.Println(reply.Status)
+
+	closeCluster(cluster)
+	if _, err := cluster[0].t.RequestChunkLocal(chunk); err == nil {
+		LOGE.Println("Request Chunk Local: no error after shutdown")
+		return false
+	}
+	return true
+}
+
+// Start a three node cluster in which two slaves have the same node ID.
+// The second should be turned away, and the cluster should still form once
+// a slave with the missing ID joins.
+func testDuplicateNodeID() bool {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	basePort := 9091 + 41*(r.Int()%300)
+	master := net.JoinHostPort("localhost", strconv.Itoa(basePort))
+
+	// The master and the slaves do not return until the cluster forms
+	cluster := make([](*trackerTester), 3)
+	doneChan := make(chan error)
+	go func() {
+		var err error
+		cluster[0], err = createTracker("", 3, basePort, 0)
+		doneChan <- err
+	}()
+
+	// Register the first slave's node ID before it starts, so that it is
+	// sure to be taken before the duplicate registers
+	first := trackerproto.Node{
+		HostPort: net.JoinHostPort("localhost", strconv.Itoa(basePort+17)),
+		NodeID:   1}
+	conn, err := rpc.DialHTTP("tcp", master)
+	for i := 0; err != nil && i < 20; i++ {
+		time.Sleep(time.Millisecond * 100)
+		conn, err = rpc.DialHTTP("tcp", master)
+	}
+	if err != nil {
+		LOGE.Println("Could not connect to master")
+		return false
+	}
+	defer conn.Close()
+	regReply := &trackerproto.RegisterReply{}
+	if err := conn.Call("PaxosTracker.RegisterServer", &trackerproto.RegisterArgs{TrackerInfo: first}, regReply); err != nil || regReply.Status != trackerproto.NotReady {
+		LOGE.Println("Register Server: Status not NotReady")
+		return false
+	}
+
+	LOGE.Println("Registering duplicate node ID")
+	duplicate, err := tracker.NewTrackerServer(master, 3, basePort+34, 1)
+	if err == nil {
+		LOGE.Println("Duplicate node ID was accepted")
+		duplicate.Shutdown()
+		return false
+	}
+	LOGE.Println("Rejected: ", err)
+
+	LOGE.Println("Registering other nodes")
+	go func() {
+		var err error
+		cluster[1], err = createTracker(master, 3, basePort+17, 1)
+		doneChan <- err
+	}()
+	go func() {
+		var err error
+		cluster[2], err = createTracker(master, 3, basePort+51, 2)
+		doneChan <- err
+	}()
+	err = nil
+	for i := 0; i < 3; i++ {
+		if e := <-doneChan; e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		LOGE.Println("Error creating cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	reply, err := cluster[0].GetTrackers()
+	if err != nil || reply.Status != trackerproto.OK || len(reply.HostPorts) != 3 {
+		LOGE.Println("Get Trackers: wrong trackers")
+		closeCluster(cluster)
+		return false
+	}
+	for _, hostPort := range reply.HostPorts {
+		if hostPort == net.JoinHostPort("localhost", strconv.Itoa(basePort+34)) {
+			LOGE.Println("Get Trackers: duplicate node is in the cluster")
+			closeCluster(cluster)
+			return false
+		}
+	}
+	closeCluster(cluster)
+	return true
+}
+
+// Start an observer following a three node cluster.
+// It should serve reads of what the cluster commits, turn away writes,
+// and never vote, so the cluster cannot use it to make up a quorum
+func testObserver() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	trackers, err := cluster[0].GetTrackers()
+	if err != nil || trackers.Status != trackerproto.OK {
+		LOGE.Println("Get Trackers: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+	master := trackers.HostPorts[0]
+	_, portStr, _ := net.SplitHostPort(master)
+	basePort, _ := strconv.Atoi(portStr)
+
+	LOGE.Println("Starting observer")
+	o, err := tracker.NewTrackerServerWithConfig(tracker.TrackerConfig{
+		MasterHostPort: master,
+		Port:           basePort + 29,
+		Observer:       true})
+	if err != nil {
+		LOGE.Println("Could not start observer: ", err)
+		closeCluster(cluster)
+		return false
+	}
+	srv, err := rpc.DialHTTP("tcp", net.JoinHostPort("localhost", strconv.Itoa(basePort+29)))
+	if err != nil {
+		LOGE.Println("Could not connect to observer")
+		o.Shutdown()
+		closeCluster(cluster)
+		return false
+	}
+	observer := &trackerTester{t: o, srv: srv}
+	cluster = append(cluster, observer)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Confirming 'apple'")
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 1}
+	reply, err = cluster[1].ConfirmChunk(chunk, "apple")
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	// The observer polls the cluster, so give it a moment to catch up
+	LOGE.Println("Reading from observer")
+	found := false
+	for i := 0; !found && i < 20; i++ {
+		time.Sleep(time.Millisecond * 100)
+		request, err := observer.RequestChunk(chunk)
+		found = err == nil && request.Status == trackerproto.OK &&
+			len(request.Peers) == 1 && request.Peers[0] == "apple"
+	}
+	if !found {
+		LOGE.Println("Request Chunk: observer never saw 'apple'")
+		closeCluster(cluster)
+		return false
+	}
+
+	reply, err = observer.ConfirmChunk(chunk, "banana")
+	if err != nil || reply.Status != trackerproto.ReadOnly {
+		LOGE.Println("Confirm Chunk: observer did not refuse the write")
+		closeCluster(cluster)
+		return false
+	}
+	prepReply := &trackerproto.PrepareReply{}
+	prepArgs := &trackerproto.PrepareArgs{PaxNum: 1 << 20, SeqNum: 2}
+	if err := observer.srv.Call("PaxosTracker.Prepare", prepArgs, prepReply); err != nil || prepReply.Status != trackerproto.Reject {
+		LOGE.Println("Prepare: observer did not reject")
+		closeCluster(cluster)
+		return false
+	}
+
+	// With two voting nodes gone, the observer must not make up a quorum
+	LOGE.Println("Closing Nodes")
+	cluster[1].t.Shutdown()
+	cluster[2].t.Shutdown()
+
+	doneChan := make(chan bool, 1)
+	go func() {
+		cluster[0].ConfirmChunk(chunk, "banana")
+		doneChan <- true
+	}()
+	passed := true
+	select {
+	case <-doneChan:
+		LOGE.Println("Confirm Chunk: write completed without a quorum")
+		passed = false
+	case <-time.After(time.Second * 2):
+	}
+
+	closeCluster(cluster)
+	return passed
+}
+
+// Shut down one node of a cluster,
+// then check that the client sees which nodes are still up
+func testProbeTrackers() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Error creating cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Shutting down node")
+	cluster[2].t.Shutdown()
+	dead := torrent.TrackerNodes[2].HostPort
+
+	reachable := client.ProbeTrackers(torrent)
+	closeCluster(cluster)
+	if len(reachable) != len(torrent.TrackerNodes) {
+		LOGE.Println("Wrong number of nodes probed: ", reachable)
+		return false
+	}
+	for hostPort, up := range reachable {
+		if up != (hostPort != dead) {
+			LOGE.Println("Wrong reachability for ", hostPort, ": ", up)
+			return false
+		}
+	}
+	return true
+}
+
+func testStress(total int) bool {
+	cluster, _ := createCluster(3)
+
+	LOGE.Println("Creating Torrent")
+	torrent, _ := newTorrentInfo(cluster[0], true, 3)
+	cluster[0].CreateEntry(torrent)
+
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	doneChan := make(chan struct {})
+
+	LOGE.Println("Sending Messages")
+	// Have two nodes make a large number of confirms and reports
+	for i := 0; i < total; i++ {
+		go func () {
+			r := rand.New(rand.NewSource(time.Now().UnixNano()))
+			conf, err := cluster[0].ConfirmChunk(chunk, strconv.Itoa(r.Int()))
+			if err != nil {
+				LOGE.Println(err.Error())
+			}
+			if conf.Status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk: Status not OK")
+			}
+
+			doneChan <- struct{}{}
+		} ()
+	}
+	LOGE.Println("Waiting for things to finish")
+	fin := 0
+	for fin < total {
+		<-doneChan
+		fin++
+		if fin % 50 == 0 {
+			LOGE.Println("Finished: ", fin)
+		}
+	}
+	LOGE.Println("Everything in")
+
+	matching, err := logsMatch(cluster[0], cluster[1])
+	if err != nil {
+		LOGE.Println("Error getting operation.")
+		closeCluster(cluster)
+		return false
+	}
+	closeCluster(cluster)
+	return matching
+}
+
+// Benchmark committing confirms from many clients at once, so that thousands
+// of ops are pending on a node, and check that each commits, and that the
+// time per op does not grow with the number pending, as it would if every
+// commit scanned them all
+func testPendingScale(small, large int) bool {
+	// Confirms total chunks at once through the first node of a new
+	// cluster, and returns how long each took on average
+	timePerOp := func(total int) (time.Duration, bool) {
+		cluster, err := createCluster(3)
+		defer closeCluster(cluster)
+		if err != nil {
+			LOGE.Println("Error creating cluster")
+			return 0, false
+		}
+		torrent, err := newTorrentInfo(cluster[0], true, 1)
+		if err != nil {
+			LOGE.Println("Could not create torrent")
+			return 0, false
+		}
+		if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry: Status not OK")
+			return 0, false
+		}
+
+		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+		var failed int32
+		var wg sync.WaitGroup
+		start := time.Now()
+		for i := 0; i < total; i++ {
+			wg.Add(1)
+			go func(peer string) {
+				defer wg.Done()
+				if reply, err := cluster[0].ConfirmChunk(chunk, peer); err != nil || reply.Status != trackerproto.OK {
+					atomic.AddInt32(&failed, 1)
+				}
+			}("peer" + strconv.Itoa(i))
+		}
+		wg.Wait()
+		elapsed := time.Since(start)
+		if failed > 0 {
+			LOGE.Println("Confirm Chunk: Status not OK for ", failed, " of ", total)
+			return 0, false
+		}
+		if reply, err := cluster[0].RequestChunkCapped(chunk, trackerproto.Sorted, 0); err != nil || reply.Status != trackerproto.OK || len(reply.Peers) != total {
+			LOGE.Println("Request Chunk: ", len(reply.Peers), " peers, not ", total)
+			return 0, false
+		}
+		perOp := elapsed / time.Duration(total)
+		LOGE.Println("Committed ", total, " ops at once in ", elapsed, ", ", perOp, " each")
+		return perOp, true
+	}
+
+	smallPerOp, ok := timePerOp(small)
+	if !ok {
+		return false
+	}
+	largePerOp, ok := timePerOp(large)
+	if !ok {
+		return false
+	}
+	// Scanning every pending op on each commit makes the time per op grow
+	// with the number pending; allow for noise, but not for that.
+	if largePerOp > 3*smallPerOp {
+		LOGE.Println("Time per op grew from ", smallPerOp, " to ", largePerOp)
+		return false
+	}
+	return true
+}
+
+// Test with dualing leaders
+func testDualing(total int) bool {
+	cluster, _ := createCluster(3)
+
+	LOGE.Println("Creating Torrent")
+	torrent, _ := newTorrentInfo(cluster[0], true, 3)
+	cluster[0].CreateEntry(torrent)
+
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	doneChan := make(chan int)
+
+	LOGE.Println("Sending Messages")
+	// Have two nodes make a large number of confirms and reports
+	for i := 0; i < total; i++ {
+		go func (id int) {
+			r := rand.New(rand.NewSource(time.Now().UnixNano()))
+			conf, err := cluster[id].ConfirmChunk(chunk, strconv.Itoa(r.Int()))
+			if err != nil {
+				LOGE.Println(err.Error())
+			}
+			if conf.Status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk: Status not OK")
+			}
+
+			doneChan <- id
+		} (i % 2)
+	}
+	LOGE.Println("Waiting for things to finish")
+	fin := make([]int, 2)
+	fin[0] = 0
+	fin[1] = 0
+	for fin[0] + fin[1] < total {
+		id := <-doneChan
+		fin[id]++
+		if (fin[0] + fin[1]) % 50 == 0 {
+			LOGE.Println("Finished: ", fin[0], fin[1])
+		}
+	}
+	LOGE.Println("Everything in")
+
+	matching, err := logsMatch(cluster[0], cluster[1])
+	if err != nil {
+		LOGE.Println("Error getting operation.")
+		closeCluster(cluster)
+		return false
+	}
+	closeCluster(cluster)
+	return matching
+}
+
+// Tests that a 3 node cluster can still operate when one node is closed.
+// Have clients on every node of a cluster send confirms one after another,
+// and check that no op waits while too many later ops commit ahead of it
+func testFairness(clientsPerNode, opsPerClient int) bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	// An op has waited one round for every op committed while it was pending
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	var committed int64
+	var mostWaited int64
+	var waitMut sync.Mutex
+	var wg sync.WaitGroup
+	ok := true
+
+	LOGE.Println("Sending Messages")
+	for id := range cluster {
+		for c := 0; c < clientsPerNode; c++ {
+			wg.Add(1)
+			go func(id, c int) {
+				defer wg.Done()
+				for i := 0; i < opsPerClient; i++ {
+					start := atomic.LoadInt64(&committed)
+					peer := strconv.Itoa(id) + "-" + strconv.Itoa(c) + "-" + strconv.Itoa(i)
+					conf, err := cluster[id].ConfirmChunk(chunk, peer)
+					waited := atomic.AddInt64(&committed, 1) - start - 1
+
+					waitMut.Lock()
+					if err != nil || conf.Status != trackerproto.OK {
+						LOGE.Println("Confirm Chunk: Status not OK")
+						ok = false
+					}
+					if waited > mostWaited {
+						mostWaited = waited
+					}
+					waitMut.Unlock()
+				}
+			}(id, c)
+		}
+	}
+
+	LOGE.Println("Waiting for things to finish")
+	wg.Wait()
+	closeCluster(cluster)
+
+	// Every client has at most one op pending at once, so a fair tracker
+	// commits each op within a few rounds of every client getting a turn
+	bound := int64(3 * len(cluster) * clientsPerNode)
+	LOGE.Println("Most rounds waited: ", mostWaited, " Bound: ", bound)
+	return ok && mostWaited <= bound
+}
+
+func testClosed() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Making Torrent")
+	// Now attempt to do something.
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Shutting down node")
+	// Close one of the nodes
+	cluster[2].t.Shutdown()
+
+	boolChan := make(chan bool)
+	time.AfterFunc(time.Second * time.Duration(10), func () { boolChan <- false})
+
+	go func () {
+		LOGE.Println("Sending Torrent to tracker")
+		reply, err := cluster[0].CreateEntry(torrent)
+		if reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry: Status not OK")
+			closeCluster(cluster)
+			boolChan <- false
+		}
+
+		LOGE.Println("Confirming chunks")
+		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+		reply, err = cluster[0].ConfirmChunk(chunk, "banana")
+		if err != nil {
+			LOGE.Println("Error confirming chunk")
+			closeCluster(cluster)
+			boolChan <- false
+		}
+		if reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			closeCluster(cluster)
+			boolChan <- false
+		}
+		boolChan <- true
+	} ()
+
+	result := <-boolChan
+	closeCluster(cluster)
+	return result
+}
+
+// Tests that a 3 node cluster will NOT operate when two nodes are closed
+func testClosedTwo() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Making Torrent")
+	// Now attempt to do something.
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Sending Torrent to tracker")
+	reply, err := cluster[0].CreateEntry(torrent)
+	if reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	// Close two nodes
+	LOGE.Println("Closing Nodes")
+	cluster[1].t.Shutdown()
+	cluster[2].t.Shutdown()
+
+	boolChan := make(chan bool, 1)
+	time.AfterFunc(time.Second * time.Duration(10), func () { boolChan <- true })
+
+	LOGE.Println("Attempting to write")
+	go func(cluster []*trackerTester) {
+		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+		reply, err = cluster[0].ConfirmChunk(chunk, "banana")
+
+		if err == nil && reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry: Status not OK")
+			LOGE.Println(reply.Status)
+		}
+		boolChan <- false
+	} (cluster)
+
+	passed := <-boolChan
+	closeCluster(cluster)
+	return passed
+}
+
+// Block a write on a node which cannot reach a quorum, then shut the node down.
+// The write should be answered with ServerClosing, rather than hang
+func testShutdownPending() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Closing Nodes")
+	cluster[1].t.Shutdown()
+	cluster[2].t.Shutdown()
+
+	LOGE.Println("Attempting to write")
+	type result struct {
+		reply *trackerproto.UpdateReply
+		err   error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+		reply, err := cluster[0].ConfirmChunk(chunk, "banana")
+		resultChan <- result{reply, err}
+	}()
+
+	select {
+	case <-resultChan:
+		LOGE.Println("Confirm Chunk: write finished without a quorum")
+		closeCluster(cluster)
+		return false
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	LOGE.Println("Shutting down node")
+	cluster[0].t.Shutdown()
+
+	passed := false
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			LOGE.Println("Confirm Chunk: ", r.err)
+		} else if r.reply.Status != trackerproto.ServerClosing {
+			LOGE.Println("Confirm Chunk: Status not ServerClosing: ", r.reply.Status)
+		} else {
+			passed = true
+		}
+	case <-time.After(time.Second * 3):
+		LOGE.Println("Confirm Chunk: write still blocked after shutdown")
+	}
+
+	closeCluster(cluster)
+	return passed
+}
+
+// Create torrents whose ID is already in use, and check that the digest
+// in the reply tells the same chunks apart from different ones, including
+// when two creates for one ID race
+func testCreateDigest() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	tor, err := newTorrentInfo(cluster[0], true, 6)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+	// The same file, split into half as many chunks
+	halves := tor
+	halves.ChunkSize = 20
+	halves.ChunkHashes = map[int]string{0: "apple", 1: "apple", 2: "apple"}
+	if torrent.Digest(tor) == torrent.Digest(halves) {
+		LOGE.Println("Digests match for different chunks")
+		closeCluster(cluster)
+		return false
+	}
+
+	reply, err := cluster[0].CreateEntry(tor)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	// The same chunks again
+	reply, err = cluster[1].CreateEntry(tor)
+	if err != nil || reply.Status != trackerproto.InvalidID {
+		LOGE.Println("Create Entry: Status not InvalidID")
+		closeCluster(cluster)
+		return false
+	}
+	if reply.Digest != torrent.Digest(tor) {
+		LOGE.Println("Digest does not match the same chunks")
+		closeCluster(cluster)
+		return false
+	}
+
+	// Different chunks
+	reply, err = cluster[2].CreateEntry(halves)
+	if err != nil || reply.Status != trackerproto.InvalidID {
+		LOGE.Println("Create Entry: Status not InvalidID")
+		closeCluster(cluster)
+		return false
+	}
+	if reply.Digest == torrent.Digest(halves) || reply.Digest != torrent.Digest(tor) {
+		LOGE.Println("Digest does not tell different chunks apart")
+		closeCluster(cluster)
+		return false
+	}
+
+	// Two clients race to create a new ID with different chunks, on
+	// different nodes. Exactly one should win, and the other should get
+	// the winner's digest.
+	tor.ID.Name = "RaceName"
+	halves.ID.Name = "RaceName"
+	replies := make([]*trackerproto.UpdateReply, 2)
+	var wg sync.WaitGroup
+	for i, raced := range []torrentproto.Torrent{tor, halves} {
+		wg.Add(1)
+		go func(i int, raced torrentproto.Torrent) {
+			defer wg.Done()
+			replies[i], _ = cluster[i].CreateEntryAs(raced, "client"+strconv.Itoa(i))
+		}(i, raced)
+	}
+	wg.Wait()
+
+	winner, loser := 0, 1
+	if replies[1].Status == trackerproto.OK {
+		winner, loser = 1, 0
+	}
+	digests := []string{torrent.Digest(tor), torrent.Digest(halves)}
+	if replies[winner].Status != trackerproto.OK || replies[loser].Status != trackerproto.InvalidID {
+		LOGE.Println("Racing creates: got statuses ", replies[0].Status, replies[1].Status)
+		closeCluster(cluster)
+		return false
+	}
+	if replies[loser].Digest != digests[winner] {
+		LOGE.Println("Racing creates: loser did not get the winner's digest")
+		closeCluster(cluster)
+		return false
+	}
+
+	closeCluster(cluster)
+	return true
+}
+
+// Ask for the peers of a chunk in sorted order, and check that every call
+// lists them in the same order, while shuffled calls list the same peers
+func testPeerOrder() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	tor, err := newTorrentInfo(cluster[0], true, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(tor)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	chunk := torrentproto.NewChunkID(tor.ID, 0)
+	for i := 0; i < 10; i++ {
+		hostPort := "peer" + strconv.Itoa(i)
+		if i%2 == 0 {
+			reply, err = cluster[0].SeedChunk(chunk, hostPort)
+		} else {
+			reply, err = cluster[0].ConfirmChunk(chunk, hostPort)
+		}
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			closeCluster(cluster)
+			return false
+		}
+	}
+
+	// Every list in the reply, joined so that replies can be compared
+	lists := func(r *trackerproto.RequestReply) string {
+		return strings.Join(r.Peers, ",") + ";" + strings.Join(r.Seeders, ",") + ";" + strings.Join(r.Partial, ",")
+	}
+
+	var first string
+	for i := 0; i < 5; i++ {
+		req, err := cluster[1].RequestChunkOrdered(chunk, trackerproto.Sorted)
+		if err != nil || req.Status != trackerproto.OK || len(req.Peers) != 10 {
+			LOGE.Println("Request Chunk: Status not OK, or peers missing")
+			closeCluster(cluster)
+			return false
+		}
+		if !sort.StringsAreSorted(req.Peers) || !sort.StringsAreSorted(req.Seeders) || !sort.StringsAreSorted(req.Partial) {
+			LOGE.Println("Sorted peers are not sorted: ", req.Peers)
+			closeCluster(cluster)
+			return false
+		}
+		if i == 0 {
+			first = lists(req)
+		} else if lists(req) != first {
+			LOGE.Println("Sorted peers changed order: ", lists(req), " after ", first)
+			closeCluster(cluster)
+			return false
+		}
+	}
+
+	// Shuffled calls list the same peers, in more than one order
+	orders := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		req, err := cluster[1].RequestChunkOrdered(chunk, trackerproto.Shuffled)
+		if err != nil || req.Status != trackerproto.OK {
+			LOGE.Println("Request Chunk: Status not OK")
+			closeCluster(cluster)
+			return false
+		}
+		orders[lists(req)] = true
+		sort.Strings(req.Peers)
+		sort.Strings(req.Seeders)
+		sort.Strings(req.Partial)
+		if lists(req) != first {
+			LOGE.Println("Shuffled peers differ from sorted peers: ", lists(req))
+			closeCluster(cluster)
+			return false
+		}
+	}
+	if len(orders) < 2 {
+		LOGE.Println("Shuffled peers were always in the same order")
+		closeCluster(cluster)
+		return false
+	}
+
+	closeCluster(cluster)
+	return true
+}
+
+// Evict a peer of two torrents, and check that every node drops it from
+// every chunk, while keeping the other peers
+func testEvictPeer() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	var chunks []torrentproto.ChunkID
+	for _, name := range []string{"First", "Second"} {
+		tor, err := newTorrentInfo(cluster[0], true, 2)
+		if err != nil {
+			LOGE.Println("Could not create torrent")
+			closeCluster(cluster)
+			return false
+		}
+		tor.ID.Name = name
+		reply, err := cluster[0].CreateEntry(tor)
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry: Status not OK")
+			closeCluster(cluster)
+			return false
+		}
+		chunks = append(chunks, torrent.AllChunkIDs(tor)...)
+	}
+	for _, chunk := range chunks {
+		for _, peer := range []string{"bad", "good"} {
+			reply, err := cluster[0].SeedChunk(chunk, peer)
+			if err != nil || reply.Status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk: Status not OK")
+				closeCluster(cluster)
+				return false
+			}
+		}
+	}
+
+	LOGE.Println("Evicting without the admin key")
+	reply, err := cluster[1].EvictPeer("bad", "wrong key")
+	if err != nil || reply.Status != trackerproto.NotAuthorized {
+		LOGE.Println("Evict Peer: Status not NotAuthorized")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Evicting with the admin key")
+	reply, err = cluster[1].EvictPeer("bad", ADMIN_KEY)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Evict Peer: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+	if reply.Removed != len(chunks) {
+		LOGE.Println("Evict Peer: removed ", reply.Removed, " chunks, not ", len(chunks))
+		closeCluster(cluster)
+		return false
+	}
+
+	for id, node := range cluster {
+		// An update through this node is committed after the eviction, so
+		// the node has committed the eviction once it returns
+		if reply, err := node.ConfirmChunk(chunks[0], "sync"+strconv.Itoa(id)); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			closeCluster(cluster)
+			return false
+		}
+		for _, chunk := range chunks {
+			req, err := node.RequestChunk(chunk)
+			if err != nil || req.Status != trackerproto.OK {
+				LOGE.Println("Request Chunk: Status not OK")
+				closeCluster(cluster)
+				return false
+			}
+			sort.Strings(req.Seeders)
+			if strings.Contains(","+strings.Join(req.Peers, ",")+",", ",bad,") || strings.Join(req.Seeders, ",") != "good" {
+				LOGE.Println("Node ", id, " has wrong peers for ", chunk, ": ", req.Peers, req.Seeders)
+				closeCluster(cluster)
+				return false
+			}
+		}
+	}
+
+	closeCluster(cluster)
+	return true
+}
+
+// Export the torrents of one cluster and import them into another, then
+// import them again, and check that nothing changes the second time
+func testExportImport() bool {
+	from, err := createCluster(1)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(from)
+		return false
+	}
+	defer closeCluster(from)
+	to, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(to)
+		return false
+	}
+	defer closeCluster(to)
+
+	for _, name := range []string{"A", "B", "C"} {
+		tor, err := newTorrentInfo(from[0], true, 2)
+		if err != nil {
+			LOGE.Println("Could not create torrent")
+			return false
+		}
+		tor.ID.Name = name
+		if reply, err := from[0].CreateEntry(tor); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry: Status not OK")
+			return false
+		}
+	}
+
+	exported, err := from[0].ExportTorrents()
+	if err != nil || exported.Status != trackerproto.OK || len(exported.Torrents) != 3 {
+		LOGE.Println("Export Torrents: Status not OK, or torrents missing")
+		return false
+	}
+
+	// Move all but the last torrent to the new cluster's nodes. The last
+	// still lists the old cluster's node, so is rejected.
+	toNodes, err := newTorrentInfo(to[0], true, 0)
+	if err != nil {
+		LOGE.Println("Could not get new cluster's nodes")
+		return false
+	}
+	torrents := exported.Torrents
+	for i := 0; i < len(torrents)-1; i++ {
+		torrents[i].TrackerNodes = toNodes.TrackerNodes
+	}
+
+	// Imports the torrents through a node, and checks the numbers of them
+	// which are created, skipped and rejected
+	check := func(node *trackerTester, created, existing, rejected int) bool {
+		reply, err := node.ImportTorrents(torrents)
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Import Torrents: Status not OK")
+			return false
+		}
+		if len(reply.Created) != created || len(reply.Existing) != existing || len(reply.Rejected) != rejected {
+			LOGE.Println("Import Torrents: wrong outcome: ", reply)
+			return false
+		}
+		return true
+	}
+
+	LOGE.Println("Importing torrents")
+	if !check(to[1], 2, 0, 1) {
+		return false
+	}
+	LOGE.Println("Importing torrents again")
+	if !check(to[2], 0, 2, 1) {
+		return false
+	}
+
+	imported, err := to[2].ExportTorrents()
+	if err != nil || imported.Status != trackerproto.OK || len(imported.Torrents) != 2 {
+		LOGE.Println("Export Torrents: Status not OK, or wrong torrents")
+		return false
+	}
+	for i, tor := range imported.Torrents {
+		if !torrent.Equal(tor, torrents[i]) {
+			LOGE.Println("Imported torrent ", tor.ID, " does not match ", torrents[i].ID)
+			return false
+		}
+	}
+	return true
+}
+
+// Import more torrents than a client may create into a cluster with a limit,
+// and check that every one is created, and that clients are still limited
+func testImportPastLimit() bool {
+	from, err := createCluster(1)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(from)
+		return false
+	}
+	defer closeCluster(from)
+	to, err := createLimitedCluster(1, 1)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(to)
+		return false
+	}
+	defer closeCluster(to)
+
+	for _, name := range []string{"A", "B", "C"} {
+		tor, err := newTorrentInfo(from[0], true, 2)
+		if err != nil {
+			LOGE.Println("Could not create torrent")
+			return false
+		}
+		tor.ID.Name = name
+		if reply, err := from[0].CreateEntry(tor); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry: Status not OK")
+			return false
+		}
+	}
+	exported, err := from[0].ExportTorrents()
+	if err != nil || exported.Status != trackerproto.OK || len(exported.Torrents) != 3 {
+		LOGE.Println("Export Torrents: Status not OK, or torrents missing")
+		return false
+	}
+
+	toNodes, err := newTorrentInfo(to[0], true, 0)
+	if err != nil {
+		LOGE.Println("Could not get new cluster's nodes")
+		return false
+	}
+	torrents := exported.Torrents
+	for i := range torrents {
+		torrents[i].TrackerNodes = toNodes.TrackerNodes
+	}
+	reply, err := to[0].ImportTorrents(torrents)
+	if err != nil || reply.Status != trackerproto.OK || len(reply.Created) != 3 {
+		LOGE.Println("Import Torrents: not every torrent was created: ", reply)
+		return false
+	}
+
+	// The imports did not use up any client's allowance
+	for _, create := range []struct {
+		name   string
+		status trackerproto.Status
+	}{
+		{"D", trackerproto.OK},
+		{"E", trackerproto.TooManyTorrents}} {
+		toNodes.ID.Name = create.name
+		reply, err := to[0].CreateEntryAs(toNodes, "client")
+		if err != nil || reply.Status != create.status {
+			LOGE.Println("Create Entry: wrong status for", create.name)
+			return false
+		}
+	}
+	return true
+}
+
+// Start the master of a two-node cluster with the given dial retries and
+// backoff, register a second node with it which only starts serving after
+// delay, and return the error the master's start gave
+func staggeredMaster(retries int, backoff, delay time.Duration) error {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	basePort := 9091 + 41*(r.Int()%300)
+	master := net.JoinHostPort("localhost", strconv.Itoa(basePort))
+	late := net.JoinHostPort("localhost", strconv.Itoa(basePort+17))
+
+	type started struct {
+		t   tracker.Tracker
+		err error
+	}
+	doneChan := make(chan started, 1)
+	go func() {
+		t, err := tracker.NewTrackerServerWithConfig(tracker.TrackerConfig{
+			NumNodes:    2,
+			Port:        basePort,
+			DialRetries: retries,
+			DialBackoff: backoff})
+		doneChan <- started{t, err}
+	}()
+
+	conn, err := rpc.DialHTTP("tcp", master)
+	for i := 0; err != nil && i < 20; i++ {
+		time.Sleep(time.Millisecond * 100)
+		conn, err = rpc.DialHTTP("tcp", master)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	regReply := &trackerproto.RegisterReply{}
+	if err := conn.Call("PaxosTracker.RegisterServer", &trackerproto.RegisterArgs{TrackerInfo: trackerproto.Node{HostPort: late, NodeID: 1}}, regReply); err != nil || regReply.Status != trackerproto.OK {
+		return errors.New("Register Server: Status not OK")
+	}
+
+	// The late node only needs to accept RPC connections
+	time.Sleep(delay)
+	ln, err := net.Listen("tcp", late)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, rpc.NewServer())
+	go http.Serve(ln, mux)
+
+	done := <-doneChan
+	if done.err == nil {
+		done.t.Shutdown()
+	}
+	return done.err
+}
+
+// Start the master of a cluster while another node is not yet serving, and
+// check that it waits for the node if its dial retries last long enough, and
+// otherwise fails, naming the node
+func testStaggeredStart() bool {
+	LOGE.Println("Starting master with enough retries")
+	if err := staggeredMaster(5, 100*time.Millisecond, 300*time.Millisecond); err != nil {
+		LOGE.Println("Master did not wait for the late node: ", err)
+		return false
+	}
+
+	LOGE.Println("Starting master with too few retries")
+	err := staggeredMaster(2, 20*time.Millisecond, 300*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "Could not reach tracker nodes") {
+		LOGE.Println("Master did not fail to reach the late node: ", err)
+		return false
+	}
+	LOGE.Println("Rejected: ", err)
+	return true
+}
+
+// Check that a TrackerConfig fills in its defaults, rejects invalid settings,
+// and that a node started with the default cluster size runs on its own
+func testTrackerConfig() bool {
+	cfg, err := tracker.TrackerConfig{}.WithDefaults()
+	if err != nil || cfg.NumNodes != 1 || cfg.Port != tracker.DEFAULT_PORT || cfg.Host != tracker.DEFAULT_HOST || cfg.ListenHost != tracker.DEFAULT_HOST || cfg.NodeID != 0 || cfg.MaxTorrents != 0 ||
+		cfg.MaxChunks != tracker.DEFAULT_MAX_CHUNKS || cfg.BadChunkReports != tracker.DEFAULT_BAD_CHUNK_REPORTS ||
+		cfg.DialRetries != tracker.DEFAULT_DIAL_RETRIES || cfg.DialBackoff != tracker.DEFAULT_DIAL_BACKOFF {
+		LOGE.Println("Defaults not filled in: ", cfg, err)
+		return false
+	}
+
+	invalid := map[string]tracker.TrackerConfig{
+		"negative NumNodes":        tracker.TrackerConfig{NumNodes: -1},
+		"NodeID too large":         tracker.TrackerConfig{NumNodes: 3, NodeID: 3},
+		"negative NodeID":          tracker.TrackerConfig{NumNodes: 3, NodeID: -1},
+		"Port too large":           tracker.TrackerConfig{Port: 65536},
+		"negative MaxTorrents":     tracker.TrackerConfig{MaxTorrents: -1},
+		"negative MaxChunks":       tracker.TrackerConfig{MaxChunks: -1},
+		"negative BadChunkReports": tracker.TrackerConfig{BadChunkReports: -1},
+		"negative MaxUpdateRate":   tracker.TrackerConfig{MaxUpdateRate: -1},
+		"negative DialRetries":     tracker.TrackerConfig{DialRetries: -1},
+		"negative DialBackoff":     tracker.TrackerConfig{DialBackoff: -time.Second},
+		"observer of no master":    tracker.TrackerConfig{Observer: true},
+		"joining with no cluster":  tracker.TrackerConfig{Joining: true},
+		"joining observer":         tracker.TrackerConfig{MasterHostPort: "localhost:9091", Joining: true, Observer: true},
+		"Host with a port":         tracker.TrackerConfig{Host: "localhost:9091"},
+		"bracketed IPv6 Host":      tracker.TrackerConfig{Host: "[::1]"},
+		"ListenHost with a port":   tracker.TrackerConfig{ListenHost: "0.0.0.0:9091"},
+	}
+	for name, cfg := range invalid {
+		if _, err := cfg.WithDefaults(); err == nil {
+			LOGE.Println("Config with ", name, " was accepted")
+			return false
+		}
+		if t, err := tracker.NewTrackerServerWithConfig(cfg); err == nil {
+			LOGE.Println("Tracker with ", name, " was started")
+			t.Shutdown()
+			return false
+		}
+	}
+
+	// An observer ignores NumNodes and NodeID, so they need not be valid
+	observer := tracker.TrackerConfig{MasterHostPort: "localhost:9091", NumNodes: 3, NodeID: 7, Observer: true}
+	if _, err := observer.WithDefaults(); err != nil {
+		LOGE.Println("Observer config was rejected: ", err)
+		return false
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	port := 9091 + 41*(r.Int()%300)
+	node, err := createTracker("", 0, port, 0)
+	if err != nil {
+		LOGE.Println("Could not start tracker with the default cluster size")
+		return false
+	}
+	defer closeCluster([](*trackerTester){node})
+	reply, err := node.GetTrackers()
+	if err != nil || reply.Status != trackerproto.OK || len(reply.HostPorts) != 1 {
+		LOGE.Println("Get Trackers: Status not OK, or wrong trackers")
+		return false
+	}
+	return true
+}
+
+// Compare torrents which differ in one field at a time, and ones which differ
+// only in the order of their tracker nodes, which should still be equal.
+// Also check that IDs have one canonical string form
+func testTorrentEqual() bool {
+	nodes := []torrentproto.TrackerNode{
+		torrentproto.TrackerNode{HostPort: "localhost:9091", Weight: 1},
+		torrentproto.TrackerNode{HostPort: "localhost:9108"},
+		torrentproto.TrackerNode{HostPort: "localhost:9125"}}
+	// Each call makes a new torrent, so that changing one does not change
+	// the others
+	base := func() torrentproto.Torrent {
+		return torrentproto.Torrent{
+			ID:           torrentproto.ID{Name: "file", Hash: "\x01\xab"},
+			ChunkHashes:  map[int]string{0: "zero", 1: "one"},
+			TrackerNodes: append([]torrentproto.TrackerNode{}, nodes...),
+			ChunkSize:    4,
+			FileSize:     6}
+	}
+
+	reordered := base()
+	reordered.TrackerNodes = []torrentproto.TrackerNode{nodes[2], nodes[0], nodes[1]}
+	if !torrent.Equal(base(), base()) || !torrent.Equal(base(), reordered) || !torrent.Equal(reordered, base()) {
+		LOGE.Println("Equal torrents are not equal")
+		return false
+	}
+
+	different := map[string]func(*torrentproto.Torrent){
+		"name":          func(t *torrentproto.Torrent) { t.ID.Name = "other" },
+		"hash":          func(t *torrentproto.Torrent) { t.ID.Hash = "\x01" },
+		"file size":     func(t *torrentproto.Torrent) { t.FileSize = 7 },
+		"chunk size":    func(t *torrentproto.Torrent) { t.ChunkSize = 3 },
+		"hash algo":     func(t *torrentproto.Torrent) { t.HashAlgo = torrentproto.SHA256 },
+		"chunk hash":    func(t *torrentproto.Torrent) { t.ChunkHashes[1] = "two" },
+		"extra chunk":   func(t *torrentproto.Torrent) { t.ChunkHashes[2] = "two" },
+		"missing chunk": func(t *torrentproto.Torrent) { delete(t.ChunkHashes, 1) },
+		"node weight":   func(t *torrentproto.Torrent) { t.TrackerNodes[1].Weight = 2 },
+		"missing node":  func(t *torrentproto.Torrent) { t.TrackerNodes = t.TrackerNodes[1:] },
+		"repeated node": func(t *torrentproto.Torrent) { t.TrackerNodes[2] = t.TrackerNodes[1] },
+	}
+	for name, change := range different {
+		tor := base()
+		change(&tor)
+		if torrent.Equal(base(), tor) || torrent.Equal(tor, base()) {
+			LOGE.Println("Torrents with different ", name, " are equal")
+			return false
+		}
+	}
+
+	id := base().ID
+	if id.String() != "file:01ab" || id.String() != reordered.ID.String() {
+		LOGE.Println("Wrong canonical form: ", id.String())
+		return false
+	}
+	if (torrentproto.ID{Name: "file", Hash: "\x01"}).String() == id.String() {
+		LOGE.Println("Different IDs have the same canonical form")
+		return false
+	}
+	if chunk := torrentproto.NewChunkID(id, 1); chunk.String() != "file:01ab#1" {
+		LOGE.Println("Wrong chunk ID form: ", chunk.String())
+		return false
+	}
+	return true
+}
+
+// Create a torrent of a file of a legal size, but split into so many chunks
+// that the tracker should refuse it, and check that it is not created.
+// A torrent with exactly the most chunks allowed is still created
+func testChunkLimit() bool {
+	// A torrent at the default limit would need a million chunk hashes, so
+	// lower the limit
+	maxChunks := 1000
+	cluster, err := createConfiguredCluster(3, tracker.TrackerConfig{MaxChunks: maxChunks})
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	// A 10 MB file of 1 byte chunks has far more chunks than allowed
+	tiny, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	tiny.ID.Name = "tiny"
+	tiny.ChunkSize = 1
+	tiny.FileSize = 10 * 1000000
+	most, err := newTorrentInfo(cluster[0], true, maxChunks)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	most.ID.Name = "most"
+
+	reply, err := cluster[1].CreateEntry(tiny)
+	if err != nil || reply.Status != trackerproto.TooManyChunks {
+		LOGE.Println("Create Entry: Status not TooManyChunks")
+		return false
+	}
+	requested, err := cluster[2].RequestChunk(torrentproto.NewChunkID(tiny.ID, 0))
+	if err != nil || requested.Status != trackerproto.FileNotFound {
+		LOGE.Println("Request Chunk: rejected torrent was created")
+		return false
+	}
+
+	if reply, err := cluster[1].CreateEntry(most); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK for a torrent at the limit")
+		return false
+	}
+	last := torrentproto.NewChunkID(most.ID, maxChunks-1)
+	if reply, err := cluster[1].ConfirmChunk(last, "apple"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK for the last chunk")
+		return false
+	}
+	return true
+}
+
+// Create a torrent, confirm some of its chunks, and delete it through another
+// node while a client waits on one of its chunks.
+// Every node should forget the torrent and its peers, and the ID should be
+// free to create again
+func testDeleteEntry() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	tor, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(tor); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+	for chunkNum := 0; chunkNum < 2; chunkNum++ {
+		if reply, err := cluster[0].ConfirmChunk(torrentproto.NewChunkID(tor.ID, chunkNum), "apple"); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			return false
+		}
+	}
+
+	LOGE.Println("Watching unconfirmed chunk")
+	watched := make(chan *trackerproto.WatchReply)
+	go func() {
+		reply, err := cluster[2].WatchChunk(torrentproto.NewChunkID(tor.ID, 2))
+		if err != nil {
+			reply = &trackerproto.WatchReply{}
+		}
+		watched <- reply
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	LOGE.Println("Deleting torrent")
+	if reply, err := cluster[1].DeleteEntry(tor.ID); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Delete Entry: Status not OK")
+		return false
+	}
+	select {
+	case reply := <-watched:
+		if reply.Status != trackerproto.FileNotFound {
+			LOGE.Println("Watch Chunk: Status not FileNotFound: ", reply.Status)
+			return false
+		}
+	case <-time.After(5 * time.Second):
+		LOGE.Println("Watch Chunk: still waiting after delete")
+		return false
+	}
+	if reply, err := cluster[0].DeleteEntry(tor.ID); err != nil || reply.Status != trackerproto.FileNotFound {
+		LOGE.Println("Delete Entry: Status not FileNotFound for deleted torrent")
+		return false
+	}
+
+	// Nodes learn of a commit in the background, so give them a moment
+	for _, node := range cluster {
+		for i := 0; ; i++ {
+			reply, err := node.RequestChunk(torrentproto.NewChunkID(tor.ID, 0))
+			if err == nil && reply.Status == trackerproto.FileNotFound {
+				break
+			} else if i == 20 {
+				LOGE.Println("Request Chunk: Status not FileNotFound for deleted torrent")
+				return false
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	LOGE.Println("Creating torrent again")
+	if reply, err := cluster[2].CreateEntry(tor); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK for deleted ID")
+		return false
+	}
+	reply, err := cluster[2].RequestChunk(torrentproto.NewChunkID(tor.ID, 0))
+	if err != nil || reply.Status != trackerproto.OK || len(reply.Peers) != 0 {
+		LOGE.Println("Request Chunk: peers kept from deleted torrent: ", reply.Peers)
+		return false
+	}
+	return true
+}
+
+// Create torrents through one node, and list them through the others,
+// before and after deleting one
+func testListTorrents() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	if reply, err := cluster[1].ListTorrents(); err != nil || reply.Status != trackerproto.OK || len(reply.Torrents) != 0 {
+		LOGE.Println("List Torrents: Status not OK, or torrents listed before any were created")
+		return false
+	}
+
+	// Created out of order, so that the list must sort them
+	torrents := make([]torrentproto.Torrent, 0)
+	for i, name := range []string{"C", "A", "B"} {
+		tor, err := newTorrentInfo(cluster[0], true, i+1)
+		if err != nil {
+			LOGE.Println("Could not create torrent")
+			return false
+		}
+		tor.ID.Name = name
+		if reply, err := cluster[0].CreateEntry(tor); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Create Entry: Status not OK")
+			return false
+		}
+		torrents = append(torrents, tor)
+	}
+	sort.Slice(torrents, func(i, j int) bool {
+		return torrents[i].ID.Less(torrents[j].ID)
+	})
+
+	// Checks that every node lists exactly the given torrents, in order.
+	// Nodes learn of commits in the background, so gives them a moment
+	check := func(torrents []torrentproto.Torrent) bool {
+		for _, node := range cluster {
+			for i := 0; ; i++ {
+				reply, err := node.ListTorrents()
+				if err == nil && reply.Status == trackerproto.OK && len(reply.Torrents) == len(torrents) {
+					for j, summary := range reply.Torrents {
+						if summary.ID != torrents[j].ID || summary.FileSize != torrents[j].FileSize || summary.ChunkSize != torrents[j].ChunkSize {
+							LOGE.Println("List Torrents: wrong torrent ", summary, " at ", j)
+							return false
+						}
+					}
+					break
+				} else if i == 20 {
+					LOGE.Println("List Torrents: Status not OK, or wrong number of torrents")
+					return false
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+		return true
+	}
+
+	LOGE.Println("Listing torrents")
+	if !check(torrents) {
+		return false
+	}
+	LOGE.Println("Listing torrents after delete")
+	if reply, err := cluster[2].DeleteEntry(torrents[1].ID); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Delete Entry: Status not OK")
+		return false
+	}
+	return check([]torrentproto.Torrent{torrents[0], torrents[2]})
+}
+
+// Confirm a chunk from two clients on a cluster whose peers expire, and keep
+// confirming it from only one of them.
+// The other should be dropped on every node, as a peer and as a seeder, and
+// the one left should be dropped once it stops confirming
+func testPeerExpiry() bool {
+	ttl := 600 * time.Millisecond
+	cluster, err := createConfiguredCluster(3, tracker.TrackerConfig{PeerTTL: ttl})
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	tor, err := newTorrentInfo(cluster[0], true, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(tor); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+	chunk := torrentproto.NewChunkID(tor.ID, 0)
+	for _, hostPort := range []string{"apple", "banana"} {
+		if reply, err := cluster[0].SeedChunk(chunk, hostPort); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Seed Chunk: Status not OK")
+			return false
+		}
+	}
+
+	// Waits until every node lists exactly the given peers for the chunk, as
+	// peers and as seeders, or until twice the TTL has passed
+	await := func(peers []string) bool {
+		deadline := time.Now().Add(2 * ttl)
+		for _, node := range cluster {
+			for {
+				reply, err := node.RequestChunk(chunk)
+				if err == nil && reply.Status == trackerproto.OK &&
+					sameStrings(reply.Peers, peers) && sameStrings(reply.Seeders, peers) {
+					break
+				} else if time.Now().After(deadline) {
+					LOGE.Println("Request Chunk: wrong peers: ", reply.Peers, reply.Seeders)
+					return false
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+		return true
+	}
+
+	LOGE.Println("Confirming from one client")
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(ttl / 4):
+				cluster[1].SeedChunk(chunk, "apple")
+			}
+		}
+	}()
+	ok := await([]string{"apple"})
+	close(stop)
+	<-done
+	if !ok {
+		return false
+	}
+
+	LOGE.Println("Confirming from neither client")
+	if !await([]string{}) {
+		return false
+	}
+	for i := 1; i < len(cluster); i++ {
+		if match, err := logsMatch(cluster[0], cluster[i]); err != nil || !match {
+			LOGE.Println("Logs do not match")
+			return false
+		}
+	}
+	return true
+}
+
+// Stall one node, then do stuff
+// See if the stalled node can catch-up
+func testStalled() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	// Now attempt to do something.
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+
+	reply, err := cluster[0].CreateEntry(torrent)
+	if reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+
+	// Stall for 5 seconds
+	LOGE.Println("Stalling tracker")
+	cluster[2].t.DebugStall(5)
+
+	doneChan := make(chan struct{})
+
+	LOGE.Println("Sending Messages")
+	for i := 0; i < 50; i++ {
+		go func () {
+			r := rand.New(rand.NewSource(time.Now().UnixNano()))
+			conf, err := cluster[0].ConfirmChunk(chunk, strconv.Itoa(r.Int()))
+			if err != nil {
+				LOGE.Println(err.Error())
+			}
+			if conf.Status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk: Status not OK")
+			}
+			doneChan <- struct{}{}
+		} ()
+	}
+
+	LOGE.Println("Waiting for messages to finish")
+	fin := 0
+	for fin < 50 {
+		<-doneChan
+		fin++
+	}
+
+	// This call waits for the stall to end
+	LOGE.Println("Call on stalled tracker")
+	reply, err = cluster[2].ConfirmChunk(chunk, "apple")
+	if err != nil {
+		LOGE.Println("Error confirming chunk")
+		closeCluster(cluster)
+		return false
+	}
+	if reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		LOGE.Println(reply.Status)
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Verifying logs")
+	matching, err := logsMatch(cluster[0], cluster[2])
+	if err != nil {
+		LOGE.Println("Error getting operation.")
+		closeCluster(cluster)
+		return false
+	}
+
+	closeCluster(cluster)
+	return matching
+}
+
+// Stall a majority of the cluster while one node proposes,
+// so that its round times out and is restarted
+func testRestarts() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		closeCluster(cluster)
+		return false
+	}
+
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	LOGE.Println("Stalling two trackers")
+	cluster[1].t.DebugStall(5)
+	cluster[2].t.DebugStall(5)
+
+	// This call waits until the stalled trackers come back
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	reply, err = cluster[0].ConfirmChunk(chunk, "banana")
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		closeCluster(cluster)
+		return false
+	}
+
+	stats, err := cluster[0].Stats()
+	closeCluster(cluster)
+	if err != nil || stats.Status != trackerproto.OK {
+		LOGE.Println("Stats: Status not OK")
+		return false
+	}
+	LOGE.Println("Rounds: ", stats.Rounds, " Restarts: ", stats.Restarts,
+		" Mean: ", stats.MeanRoundTime, " Max: ", stats.MaxRoundTime)
+	if stats.Rounds < 2 {
+		LOGE.Println("Expected a round for each operation")
+		return false
+	}
+	if stats.Restarts < 1 {
+		LOGE.Println("Expected the stalled round to restart")
+		return false
+	}
+	return true
+}
+
+// Confirm every chunk of a torrent at once on a 3 node cluster, and check that
+// the confirms are committed together in far fewer Paxos rounds than there are
+// chunks, and that every node agrees on the result
+func testBatching() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	numChunks := 60
+	torrent, err := newTorrentInfo(cluster[0], true, numChunks)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	// Each node only counts the rounds it leads
+	totalRounds := func() (int, bool) {
+		rounds := 0
+		for _, node := range cluster {
+			stats, err := node.Stats()
+			if err != nil || stats.Status != trackerproto.OK {
+				LOGE.Println("Stats: Status not OK")
+				return 0, false
+			}
+			rounds += stats.Rounds
+		}
+		return rounds, true
+	}
+	before, ok := totalRounds()
+	if !ok {
+		return false
+	}
+
+	LOGE.Println("Confirming every chunk at once")
+	var wg sync.WaitGroup
+	var okMut sync.Mutex
+	for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+		wg.Add(1)
+		go func(chunkNum int) {
+			defer wg.Done()
+			chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+			conf, err := cluster[chunkNum%len(cluster)].ConfirmChunk(chunk, "apple")
+			if err != nil || conf.Status != trackerproto.OK {
+				okMut.Lock()
+				ok = false
+				okMut.Unlock()
+			}
+		}(chunkNum)
+	}
+	wg.Wait()
+	if !ok {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		return false
+	}
+
+	after, ok := totalRounds()
+	if !ok {
+		return false
+	}
+	rounds := after - before
+	LOGE.Println("Rounds for ", numChunks, " confirms: ", rounds)
+	if rounds > numChunks/2 {
+		LOGE.Println("Confirms were not batched")
+		return false
+	}
+
+	for id := range cluster {
+		for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+			chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+			if hasReply, err := cluster[id].PeerHasChunk(chunk, "apple"); err != nil || !hasReply.Has {
+				LOGE.Println("Node ", id, " is missing the confirm for chunk ", chunkNum)
+				return false
+			}
+		}
+	}
+	for id := 1; id < len(cluster); id++ {
+		if matching, err := logsMatch(cluster[0], cluster[id]); err != nil || !matching {
+			LOGE.Println("Logs of nodes 0 and ", id, " do not match")
+			return false
+		}
+	}
+	return true
+}
+
+// Confirm every chunk of a torrent in one ConfirmChunks call on a 3 node
+// cluster, and check that every node then lists the peer for every chunk, and
+// that a call with a chunk out of range, or for an unknown torrent, confirms
+// nothing
+func testConfirmChunks() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	numChunks := 20
+	torrent, err := newTorrentInfo(cluster[0], true, numChunks)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	LOGE.Println("Confirming 'banana' with a chunk out of range")
+	reply, err = cluster[1].ConfirmChunks(torrent.ID, []int{0, 1, numChunks}, "banana")
+	if err != nil || reply.Status != trackerproto.OutOfRange {
+		LOGE.Println("Confirm Chunks: Status not OutOfRange")
+		return false
+	}
+
+	LOGE.Println("Confirming every chunk for 'apple'")
+	chunkNums := make([]int, numChunks)
+	for i := range chunkNums {
+		chunkNums[i] = i
+	}
+	reply, err = cluster[1].ConfirmChunks(torrent.ID, chunkNums, "apple")
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunks: Status not OK")
+		return false
+	}
+
+	for id := range cluster {
+		for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+			chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+			if hasReply, err := cluster[id].PeerHasChunk(chunk, "apple"); err != nil || !hasReply.Has {
+				LOGE.Println("Node ", id, " is missing 'apple' for chunk ", chunkNum)
+				return false
+			}
+			if hasReply, err := cluster[id].PeerHasChunk(chunk, "banana"); err != nil || hasReply.Has {
+				LOGE.Println("Node ", id, " lists 'banana' for chunk ", chunkNum)
+				return false
+			}
+		}
+	}
+
+	LOGE.Println("Confirming chunks of an unknown torrent")
+	unknown := torrent.ID
+	unknown.Hash = "unknown"
+	reply, err = cluster[0].ConfirmChunks(unknown, []int{0}, "apple")
+	if err != nil || reply.Status != trackerproto.FileNotFound {
+		LOGE.Println("Confirm Chunks: Status not FileNotFound")
+		return false
+	}
+	return true
+}
+
+// Commit an op through node 0 of a 3 node cluster, so that it holds the lease,
+// then confirm chunks through the other nodes at once, and check that they
+// forward their ops to node 0 rather than leading rounds of their own.
+// Then shut node 0 down, and check that the others take over once its lease
+// is up.
+func testLease() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	numChunks := 40
+	torrent, err := newTorrentInfo(cluster[0], true, numChunks)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	rounds := make([]int, len(cluster))
+	for id, node := range cluster {
+		stats, err := node.Stats()
+		if err != nil || stats.Status != trackerproto.OK {
+			LOGE.Println("Stats: Status not OK")
+			return false
+		}
+		rounds[id] = stats.Rounds
+	}
+
+	LOGE.Println("Confirming chunks through nodes 1 and 2")
+	var wg sync.WaitGroup
+	var okMut sync.Mutex
+	ok := true
+	for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+		wg.Add(1)
+		go func(chunkNum int) {
+			defer wg.Done()
+			chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+			conf, err := cluster[1+chunkNum%2].ConfirmChunk(chunk, "apple")
+			if err != nil || conf.Status != trackerproto.OK {
+				okMut.Lock()
+				ok = false
+				okMut.Unlock()
+			}
+		}(chunkNum)
+	}
+	wg.Wait()
+	if !ok {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		return false
+	}
+
+	for id := 1; id < len(cluster); id++ {
+		stats, err := cluster[id].Stats()
+		if err != nil || stats.Status != trackerproto.OK {
+			LOGE.Println("Stats: Status not OK")
+			return false
+		}
+		LOGE.Println("Node ", id, " led ", stats.Rounds-rounds[id], " rounds, and forwarded ", stats.Forwarded, " ops")
+		if stats.Rounds != rounds[id] || stats.Forwarded == 0 {
+			LOGE.Println("Node ", id, " did not leave the rounds to the lease holder")
+			return false
+		}
+	}
+	for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+		if hasReply, err := cluster[2].PeerHasChunk(chunk, "apple"); err != nil || !hasReply.Has {
+			LOGE.Println("Node 2 is missing the confirm for chunk ", chunkNum)
+			return false
+		}
+	}
+
+	LOGE.Println("Shutting down the lease holder")
+	cluster[0].t.Shutdown()
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	reply, err = cluster[1].ConfirmChunk(chunk, "banana")
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK after the leader shut down")
+		return false
+	}
+	if hasReply, err := cluster[2].PeerHasChunk(chunk, "banana"); err != nil || !hasReply.Has {
+		LOGE.Println("Node 2 is missing the confirm made after the leader shut down")
+		return false
+	}
+	return true
+}
+
+// Commit enough ops on a 3 node cluster which snapshots every few ops that
+// the start of its log is dropped, then check that the snapshot holds the
+// cluster's state, and that an observer which starts from nothing catches up
+// by loading it
+func testSnapshot() bool {
+	cluster, err := createConfiguredCluster(3, tracker.TrackerConfig{SnapshotInterval: 10})
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	numChunks := 35
+	torrent, err := newTorrentInfo(cluster[0], true, numChunks)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	LOGE.Println("Confirming chunks one at a time")
+	for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+		if reply, err := cluster[0].ConfirmChunk(chunk, "apple"); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			return false
+		}
+	}
+
+	snapReply, err := cluster[0].GetSnapshot()
+	if err != nil || snapReply.Status != trackerproto.OK {
+		LOGE.Println("Get Snapshot: Status not OK")
+		return false
+	}
+	snap := snapReply.Snapshot
+	LOGE.Println("Snapshot at SeqNum ", snap.SeqNum)
+	if snap.SeqNum < 30 {
+		LOGE.Println("Snapshot is too old")
+		return false
+	}
+	if _, ok := snap.Torrents[torrent.ID]; !ok {
+		LOGE.Println("Snapshot is missing the torrent")
+		return false
+	}
+	if _, ok := snap.Peers[torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}]["apple"]; !ok {
+		LOGE.Println("Snapshot is missing 'apple' for chunk 0")
+		return false
+	}
+	getReply, err := cluster[0].GetOp(0)
+	if err != nil || getReply.Status != trackerproto.OutOfDate || getReply.MinSeq != snap.SeqNum {
+		LOGE.Println("Log does not start at the snapshot")
+		return false
+	}
+
+	trackers, err := cluster[0].GetTrackers()
+	if err != nil || trackers.Status != trackerproto.OK {
+		LOGE.Println("Get Trackers: Status not OK")
+		return false
+	}
+	master := trackers.HostPorts[0]
+	_, portStr, _ := net.SplitHostPort(master)
+	basePort, _ := strconv.Atoi(portStr)
+
+	LOGE.Println("Starting observer")
+	o, err := tracker.NewTrackerServerWithConfig(tracker.TrackerConfig{
+		MasterHostPort: master,
+		Port:           basePort + 29,
+		Observer:       true})
+	if err != nil {
+		LOGE.Println("Could not start observer: ", err)
+		return false
+	}
+	srv, err := rpc.DialHTTP("tcp", net.JoinHostPort("localhost", strconv.Itoa(basePort+29)))
+	if err != nil {
+		LOGE.Println("Could not connect to observer")
+		o.Shutdown()
+		return false
+	}
+	observer := &trackerTester{t: o, srv: srv}
+	defer closeCluster([](*trackerTester){observer})
+
+	// The observer polls the cluster, so give it a moment to catch up
+	LOGE.Println("Reading from observer")
+	last := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: numChunks - 1}
+	found := false
+	for i := 0; !found && i < 20; i++ {
+		time.Sleep(time.Millisecond * 100)
+		hasReply, err := observer.PeerHasChunk(last, "apple")
+		found = err == nil && hasReply.Status == trackerproto.OK && hasReply.Has
+	}
+	if !found {
+		LOGE.Println("Observer never caught up")
+		return false
+	}
+	for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+		chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: chunkNum}
+		if hasReply, err := observer.PeerHasChunk(chunk, "apple"); err != nil || !hasReply.Has {
+			LOGE.Println("Observer is missing 'apple' for chunk ", chunkNum)
+			return false
+		}
+	}
+	if matching, err := logsMatch(cluster[0], observer); err != nil || !matching {
+		LOGE.Println("Logs of node 0 and the observer do not match")
+		return false
+	}
+	return true
+}
+
+// Give a chunk many peers, some of them seeders, then request it with a cap on
+// the number of peers, and check that each reply lists that many of the
+// chunk's peers, that every peer is listed by some reply, and that sorted
+// replies list the first peers, while uncapped replies list all of them
+func testMaxPeers() bool {
+	cluster, err := createCluster(1)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	numPeers, maxPeers := 20, 5
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	all := make(map[string]bool)
+	sorted := make([]string, 0)
+	for i := 0; i < numPeers; i++ {
+		peer := "peer" + strconv.Itoa(100+i)
+		if i%4 == 0 {
+			reply, err = cluster[0].SeedChunk(chunk, peer)
+		} else {
+			reply, err = cluster[0].ConfirmChunk(chunk, peer)
+		}
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			return false
+		}
+		all[peer] = i%4 == 0
+		sorted = append(sorted, peer)
+	}
+
+	LOGE.Println("Requesting at most ", maxPeers, " peers")
+	listed := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		req, err := cluster[0].RequestChunkCapped(chunk, trackerproto.Shuffled, maxPeers)
+		if err != nil || req.Status != trackerproto.OK {
+			LOGE.Println("Request Chunk: Status not OK")
+			return false
+		}
+		if len(req.Peers) != maxPeers || len(req.Seeders)+len(req.Partial) != maxPeers {
+			LOGE.Println("Request Chunk: wrong number of peers: ", req.Peers, req.Seeders, req.Partial)
+			return false
+		}
+		seen := make(map[string]bool)
+		for _, peer := range req.Peers {
+			if _, ok := all[peer]; !ok || seen[peer] {
+				LOGE.Println("Request Chunk: unknown or repeated peer ", peer)
+				return false
+			}
+			seen[peer] = true
+			listed[peer] = true
+		}
+		for _, peer := range req.Seeders {
+			if !seen[peer] || !all[peer] {
+				LOGE.Println("Request Chunk: ", peer, " is not a listed seeder")
+				return false
+			}
+		}
+		for _, peer := range req.Partial {
+			if !seen[peer] || all[peer] {
+				LOGE.Println("Request Chunk: ", peer, " is not a listed partial peer")
+				return false
+			}
+		}
+	}
+	if len(listed) != numPeers {
+		LOGE.Println("Only ", len(listed), " of ", numPeers, " peers were ever listed")
+		return false
+	}
+
+	req, err := cluster[0].RequestChunkCapped(chunk, trackerproto.Sorted, maxPeers)
+	if err != nil || req.Status != trackerproto.OK || !sameStrings(req.Peers, sorted[:maxPeers]) {
+		LOGE.Println("Request Chunk: sorted peers are not the first ", maxPeers)
+		return false
+	}
+	req, err = cluster[0].RequestChunk(chunk)
+	if err != nil || req.Status != trackerproto.OK || len(req.Peers) != numPeers {
+		LOGE.Println("Request Chunk: uncapped request did not list every peer")
+		return false
+	}
+	return true
+}
+
+// Confirm chunks on a node while it closes, and check that every confirm
+// sent before Close commits, that updates sent after it are refused, and
+// that a node which cannot commit still closes, with an error, once its
+// grace runs out
+func testGracefulClose() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	LOGE.Println("Confirming chunks while closing node 1")
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	numPeers := 20
+	statuses := make(chan trackerproto.Status, numPeers)
+	for i := 0; i < numPeers; i++ {
+		go func(peer string) {
+			reply, err := cluster[1].ConfirmChunk(chunk, peer)
+			if err != nil {
+				statuses <- trackerproto.ServerClosing
+			} else {
+				statuses <- reply.Status
+			}
+		}("peer" + strconv.Itoa(100+i))
+	}
+	time.Sleep(time.Millisecond * 50)
+	if err := cluster[1].t.Close(); err != nil {
+		LOGE.Println("Close: ", err)
+		return false
+	}
+	for i := 0; i < numPeers; i++ {
+		select {
+		case status := <-statuses:
+			if status != trackerproto.OK {
+				LOGE.Println("Confirm Chunk: Status not OK: ", status)
+				return false
+			}
+		case <-time.After(time.Second * 3):
+			LOGE.Println("Confirm Chunk: still blocked after close")
+			return false
+		}
+	}
+	req, err := cluster[0].RequestChunk(chunk)
+	if err != nil || req.Status != trackerproto.OK || len(req.Peers) != numPeers {
+		LOGE.Println("Request Chunk: confirms sent before close were lost")
+		return false
+	}
+	if reply, err := cluster[1].ConfirmChunk(chunk, "banana"); err == nil && reply.Status != trackerproto.ServerClosing {
+		LOGE.Println("Confirm Chunk: closed node took an update")
+		return false
+	}
+
+	LOGE.Println("Closing node 2 with no quorum")
+	cluster[0].t.Shutdown()
+	go cluster[2].ConfirmChunk(chunk, "apple")
+	time.Sleep(time.Millisecond * 100)
+	if err := cluster[2].t.Close(); err == nil {
+		LOGE.Println("Close: no error with an update unfinished")
+		return false
+	}
+	return true
+}
+
+// Report a peer for sending bad copies of a chunk through different nodes, and
+// check that it stays a peer until DEFAULT_BAD_CHUNK_REPORTS distinct clients
+// have reported it, that reports by the peer itself, repeated reports, and
+// reports about clients which are not peers do not count, and that a dropped
+// peer which confirms the chunk again starts afresh
+func testBadChunkReports() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 2)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	reply, err := cluster[0].CreateEntry(torrent)
+	if err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	for _, peer := range []string{"honest", "liar"} {
+		if reply, err := cluster[0].ConfirmChunk(chunk, peer); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			return false
+		}
+	}
+
+	listed := func(node *trackerTester, peer string) bool {
+		req, err := node.RequestChunk(chunk)
+		if err != nil || req.Status != trackerproto.OK {
+			return false
+		}
+		for _, p := range req.Peers {
+			if p == peer {
+				return true
+			}
+		}
+		return false
+	}
+
+	LOGE.Println("Reporting the peer")
+	reports := []struct {
+		node     int
+		peer     string
+		reporter string
+		removed  int
+	}{
+		{0, "liar", "liar", 0},
+		{0, "liar", "r1", 0},
+		{1, "liar", "r1", 0},
+		{1, "nobody", "r2", 0},
+		{1, "liar", "r2", 0},
+		{2, "liar", "r3", 1},
+	}
+	for i, report := range reports {
+		reply, err := cluster[report.node].ReportBadChunk(chunk, report.peer, report.reporter)
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Report Bad Chunk: Status not OK")
+			return false
+		}
+		if reply.Removed != report.removed {
+			LOGE.Println("Report Bad Chunk ", i, ": Removed ", reply.Removed, ", not ", report.removed)
+			return false
+		}
+	}
+	if listed(cluster[2], "liar") || !listed(cluster[2], "honest") {
+		LOGE.Println("Request Chunk: wrong peers after the peer was dropped")
+		return false
+	}
+
+	LOGE.Println("Confirming the chunk again")
+	if reply, err := cluster[0].ConfirmChunk(chunk, "liar"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		return false
+	}
+	if reply, err := cluster[0].ReportBadChunk(chunk, "liar", "r1"); err != nil || reply.Status != trackerproto.OK || reply.Removed != 0 {
+		LOGE.Println("Report Bad Chunk: old reports still counted")
+		return false
+	}
+	if !listed(cluster[0], "liar") {
+		LOGE.Println("Request Chunk: peer was not listed again")
+		return false
+	}
+
+	bad := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 2}
+	if reply, err := cluster[0].ReportBadChunk(bad, "liar", "r1"); err != nil || reply.Status != trackerproto.OutOfRange {
+		LOGE.Println("Report Bad Chunk: Status not OutOfRange")
+		return false
+	}
+	unknown := torrentproto.ChunkID{ID: torrentproto.ID{Name: "unknown"}, ChunkNum: 0}
+	if reply, err := cluster[0].ReportBadChunk(unknown, "liar", "r1"); err != nil || reply.Status != trackerproto.FileNotFound {
+		LOGE.Println("Report Bad Chunk: Status not FileNotFound")
+		return false
+	}
+	return true
+}
+
+// Start a cluster with a client token and a cluster key, and check that
+// updates without the token are refused, that updates with it commit across
+// the cluster, and that the client token cannot be used to forge Paxos
+// messages
+func testTokens() bool {
+	cluster, err := createConfiguredCluster(3, tracker.TrackerConfig{
+		ClientToken: "client token",
+		ClusterKey:  "cluster key"})
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	LOGE.Println("Updating without the token")
+	for _, token := range []string{"", "cluster key", "guess"} {
+		cluster[0].token = token
+		if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.NotAuthorized {
+			LOGE.Println("Create Entry: Status not NotAuthorized with token ", token)
+			return false
+		}
+	}
+
+	LOGE.Println("Updating with the token")
+	for _, node := range cluster {
+		node.token = "client token"
+	}
+	if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	if reply, err := cluster[1].ConfirmChunk(chunk, "banana"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		return false
+	}
+	cluster[2].token = ""
+	if reply, err := cluster[2].ReportMissing(chunk, "banana"); err != nil || reply.Status != trackerproto.NotAuthorized {
+		LOGE.Println("Report Missing: Status not NotAuthorized")
+		return false
+	}
+	if reply, err := cluster[2].ConfirmChunks(torrent.ID, []int{0}, "apple"); err != nil || reply.Status != trackerproto.NotAuthorized {
+		LOGE.Println("Confirm Chunks: Status not NotAuthorized")
+		return false
+	}
+	if reply, err := cluster[2].PeerHasChunk(chunk, "banana"); err != nil || reply.Status != trackerproto.OK || !reply.Has {
+		LOGE.Println("Peer Has Chunk: confirmed peer was lost")
+		return false
+	}
+
+	LOGE.Println("Forging Paxos messages with the client token")
+	forged := trackerproto.Operation{
+		OpType:     trackerproto.Add,
+		Chunk:      chunk,
+		ClientAddr: "forged"}
+	commitArgs := &trackerproto.CommitArgs{
+		SeqNum: 0,
+		Value:  forged,
+		Key:    "client token"}
+	commitReply := &trackerproto.CommitReply{}
+	if err := cluster[2].srv.Call("PaxosTracker.Commit", commitArgs, commitReply); err != nil || commitReply.Status != trackerproto.NotAuthorized {
+		LOGE.Println("Commit: Status not NotAuthorized")
+		return false
+	}
+	prepareArgs := &trackerproto.PrepareArgs{PaxNum: 1 << 20, SeqNum: 0, Key: "client token"}
+	prepareReply := &trackerproto.PrepareReply{}
+	if err := cluster[2].srv.Call("PaxosTracker.Prepare", prepareArgs, prepareReply); err != nil || prepareReply.Status != trackerproto.NotAuthorized {
+		LOGE.Println("Prepare: Status not NotAuthorized")
+		return false
+	}
+	forwardArgs := &trackerproto.ForwardArgs{Ops: []trackerproto.Operation{forged}}
+	forwardReply := &trackerproto.ForwardReply{}
+	if err := cluster[2].srv.Call("PaxosTracker.Forward", forwardArgs, forwardReply); err != nil || forwardReply.Status != trackerproto.NotAuthorized {
+		LOGE.Println("Forward: Status not NotAuthorized")
+		return false
+	}
+
+	// A later update commits, so a forged op would have been applied by now
+	if reply, err := cluster[0].ConfirmChunk(chunk, "cherry"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		return false
+	}
+	for i, node := range cluster {
+		if reply, err := node.PeerHasChunk(chunk, "forged"); err != nil || reply.Status != trackerproto.OK || reply.Has {
+			LOGE.Println("Peer Has Chunk: node ", i, " lists the forged peer")
+			return false
+		}
+	}
+	return true
+}
+
+// Limit each client to a few updates a second, and check that a client which
+// sends more is refused until the second is up, without holding up other
+// clients or reads
+func testRateLimit() bool {
+	maxRate := 5
+	cluster, err := createConfiguredCluster(1, tracker.TrackerConfig{MaxUpdateRate: maxRate})
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	LOGE.Println("Flooding the tracker")
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	start := time.Now()
+	for i := 0; i < maxRate; i++ {
+		var reply *trackerproto.UpdateReply
+		if i%2 == 0 {
+			reply, err = cluster[0].ConfirmChunk(chunk, "flood")
+		} else {
+			reply, err = cluster[0].ConfirmChunks(torrent.ID, []int{0}, "flood")
+		}
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK within the limit")
+			return false
+		}
+	}
+	if reply, err := cluster[0].ReportMissing(chunk, "flood"); err != nil || reply.Status != trackerproto.RateLimited {
+		LOGE.Println("Report Missing: Status not RateLimited past the limit")
+		return false
+	}
+	if reply, err := cluster[0].ConfirmChunk(chunk, "other"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: another client was limited")
+		return false
+	}
+	if reply, err := cluster[0].RequestChunk(chunk); err != nil || reply.Status != trackerproto.OK || len(reply.Peers) != 2 {
+		LOGE.Println("Request Chunk: read was limited, or a limited update was applied")
+		return false
+	}
+	if time.Since(start) >= time.Second {
+		LOGE.Println("Flood took too long to test the limit")
+		return false
+	}
+
+	time.Sleep(time.Second - time.Since(start) + time.Millisecond*100)
+	if reply, err := cluster[0].ConfirmChunk(chunk, "flood"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: still limited after a second")
+		return false
+	}
+	return true
+}
+
+// Grow a running cluster of numNodes nodes by one node.
+// The new node should catch up on what the cluster committed before it
+// joined, be listed by every node, and take part in later commits: once the
+// cluster has four nodes, three are needed for a majority, so with one of
+// the original nodes down, the new node's vote is needed
+func testAddNode(numNodes int) bool {
+	cluster, err := createCluster(numNodes)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer func() { closeCluster(cluster) }()
+
+	trackers, err := cluster[0].GetTrackers()
+	if err != nil || trackers.Status != trackerproto.OK {
+		LOGE.Println("Get Trackers: Status not OK")
+		return false
+	}
+	master := trackers.HostPorts[0]
+	_, portStr, _ := net.SplitHostPort(master)
+	basePort, _ := strconv.Atoi(portStr)
+
+	before, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(before); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+	chunk := torrentproto.ChunkID{ID: before.ID, ChunkNum: 1}
+	if reply, err := cluster[numNodes-1].ConfirmChunk(chunk, "apple"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		return false
+	}
+
+	LOGE.Println("Adding a node")
+	joined, err := createConfiguredTracker(tracker.TrackerConfig{
+		MasterHostPort: master,
+		Port:           basePort + 29,
+		Joining:        true})
+	if err != nil {
+		LOGE.Println("Could not add node: ", err)
+		return false
+	}
+	cluster = append(cluster, joined)
+
+	// Every node lists the new node once it has committed its addition
+	for i, node := range cluster {
+		listed := false
+		for tries := 0; !listed && tries < 20; tries++ {
+			reply, err := node.GetTrackers()
+			listed = err == nil && reply.Status == trackerproto.OK && len(reply.HostPorts) == numNodes+1
+			if !listed {
+				time.Sleep(time.Millisecond * 100)
+			}
+		}
+		if !listed {
+			LOGE.Println("Get Trackers: node ", i, " does not list the new node")
+			return false
+		}
+	}
+
+	if reply, err := joined.RequestChunk(chunk); err != nil || reply.Status != trackerproto.OK ||
+		len(reply.Peers) != 1 || reply.Peers[0] != "apple" {
+		LOGE.Println("Request Chunk: new node did not catch up")
+		return false
+	}
+
+	// Torrents made from now on list the new node too
+	after, err := newTorrentInfo(joined, true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	after.ID.Name = "JoinedName"
+	if reply, err := joined.CreateEntry(after); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: new node could not create a torrent")
+		return false
+	}
+
+	if numNodes >= 3 {
+		LOGE.Println("Shutting down an original node")
+		cluster[1].t.Shutdown()
+	}
+	chunk = torrentproto.ChunkID{ID: after.ID, ChunkNum: 0}
+	if reply, err := cluster[0].ConfirmChunk(chunk, "banana"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK after adding a node")
+		return false
+	}
+	for i, node := range cluster {
+		if numNodes >= 3 && i == 1 {
+			continue
+		}
+		found := false
+		for tries := 0; !found && tries < 20; tries++ {
+			reply, err := node.RequestChunk(chunk)
+			found = err == nil && reply.Status == trackerproto.OK && len(reply.Peers) == 1
+			if !found {
+				time.Sleep(time.Millisecond * 100)
+			}
+		}
+		if !found {
+			LOGE.Println("Request Chunk: node ", i, " never saw 'banana'")
+			return false
+		}
+	}
+	return true
+}
+
+// Remove a dead node from a three node cluster.
+// Until it is removed, the cluster needs both of the other nodes, so removing
+// one of those as well should be refused. Once it is removed, the two nodes
+// left should list each other, commit without it, and still serve the
+// torrents which list it
+func testRemoveNode() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	trackers, err := cluster[0].GetTrackers()
+	if err != nil || trackers.Status != trackerproto.OK {
+		LOGE.Println("Get Trackers: Status not OK")
+		return false
+	}
+	_, portStr, _ := net.SplitHostPort(trackers.HostPorts[0])
+	basePort, _ := strconv.Atoi(portStr)
+	hostPortOf := func(id int) string {
+		return net.JoinHostPort("localhost", strconv.Itoa(basePort+17*id))
+	}
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	LOGE.Println("Killing node 2")
+	cluster[2].t.Shutdown()
+	dead, alive := hostPortOf(2), hostPortOf(1)
+
+	if reply, err := cluster[0].RemoveNode(dead, "wrong key"); err != nil || reply.Status != trackerproto.NotAuthorized {
+		LOGE.Println("Remove Node: Status not NotAuthorized with the wrong key")
+		return false
+	}
+	if reply, err := cluster[0].RemoveNode("localhost:1", ADMIN_KEY); err != nil || reply.Status != trackerproto.UnknownNode {
+		LOGE.Println("Remove Node: Status not UnknownNode for a node not in the cluster")
+		return false
+	}
+	if reply, err := cluster[0].RemoveNode(alive, ADMIN_KEY); err != nil || reply.Status != trackerproto.TooFewNodes {
+		LOGE.Println("Remove Node: Status not TooFewNodes for a node the cluster needs")
+		return false
+	}
+
+	LOGE.Println("Removing node 2")
+	if reply, err := cluster[0].RemoveNode(dead, ADMIN_KEY); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Remove Node: Status not OK")
+		return false
+	}
+	for i := 0; i < 2; i++ {
+		listed := false
+		for tries := 0; !listed && tries < 20; tries++ {
+			reply, err := cluster[i].GetTrackers()
+			listed = err == nil && reply.Status == trackerproto.OK && len(reply.HostPorts) == 2
+			if !listed {
+				time.Sleep(time.Millisecond * 100)
+			}
+		}
+		if !listed {
+			LOGE.Println("Get Trackers: node ", i, " still lists the removed node")
+			return false
+		}
+	}
+
+	// The torrent still lists the removed node, but the others serve it
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	if reply, err := cluster[1].ConfirmChunk(chunk, "apple"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK after removing a node")
+		return false
+	}
+	if reply, err := cluster[0].RequestChunk(chunk); err != nil || reply.Status != trackerproto.OK ||
+		len(reply.Peers) != 1 || reply.Peers[0] != "apple" {
+		LOGE.Println("Request Chunk: torrent listing the removed node was not served")
+		return false
+	}
+
+	// A node which is removed while it is up shuts down, and the last node
+	// cannot be removed
+	LOGE.Println("Removing node 1")
+	if reply, err := cluster[0].RemoveNode(alive, ADMIN_KEY); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Remove Node: could not shrink to one node")
+		return false
+	}
+	stopped := false
+	for tries := 0; !stopped && tries < 20; tries++ {
+		_, err := cluster[1].RequestChunk(chunk)
+		stopped = err != nil
+		if !stopped {
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+	if !stopped {
+		LOGE.Println("Removed node did not shut down")
+		return false
+	}
+	if reply, err := cluster[0].RemoveNode(hostPortOf(0), ADMIN_KEY); err != nil || reply.Status != trackerproto.TooFewNodes {
+		LOGE.Println("Remove Node: Status not TooFewNodes for the last node")
+		return false
+	}
+	if reply, err := cluster[0].ConfirmChunk(chunk, "banana"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK on the last node")
+		return false
+	}
+	return true
+}
+
+// Start a node with an RPC hook, make RPCs which succeed and fail, and check
+// that the hook sees each one's method and status, in order
+func testRPCHook() bool {
+	type call struct {
+		method string
+		status trackerproto.Status
+	}
+	var mut sync.Mutex
+	calls := make([]call, 0)
+	badLatency := false
+	hook := func(method string, latency time.Duration, status trackerproto.Status) {
+		mut.Lock()
+		defer mut.Unlock()
+		badLatency = badLatency || latency < 0
+		calls = append(calls, call{method, status})
+	}
+	cluster, err := createConfiguredCluster(1, tracker.TrackerConfig{Hook: hook})
+	if err != nil {
+		LOGE.Println("Error creating cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	cluster[0].RequestChunk(chunk)
+	cluster[0].CreateEntry(torrent)
+	cluster[0].CreateEntry(torrent)
+	cluster[0].ConfirmChunk(chunk, "peer")
+	cluster[0].ConfirmChunk(torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 1}, "peer")
+
+	// newTorrentInfo asks for the cluster's nodes first
+	expected := []call{
+		{"GetTrackers", trackerproto.OK},
+		{"RequestChunk", trackerproto.FileNotFound},
+		{"CreateEntry", trackerproto.OK},
+		{"CreateEntry", trackerproto.InvalidID},
+		{"ConfirmChunk", trackerproto.OK},
+		{"ConfirmChunk", trackerproto.OutOfRange}}
+	mut.Lock()
+	defer mut.Unlock()
+	if badLatency {
+		LOGE.Println("Hook saw a negative latency")
+		return false
+	}
+	if len(calls) != len(expected) {
+		LOGE.Println("Hook saw the wrong RPCs: ", calls)
+		return false
+	}
+	for i, c := range calls {
+		if c != expected[i] {
+			LOGE.Println("Hook saw ", c, ", not ", expected[i])
+			return false
+		}
+	}
+	return true
+}
+
+// Check that every node of a cluster reports what it has committed: the
+// same SeqNum as the others once they have caught up, the torrent, and one
+// peer entry per chunk per peer, with nothing pending
+func testMetrics() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+	if reply, err := cluster[1].ConfirmChunks(torrent.ID, []int{0, 1, 2}, "apple"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunks: Status not OK")
+		return false
+	}
+	if reply, err := cluster[2].ConfirmChunk(torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}, "banana"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		return false
+	}
+
+	// A node hears of a commit as it happens, so give the others a moment
+	// to get there
+	seqNum := -1
+	for i, node := range cluster {
+		var stats *trackerproto.StatsReply
+		for tries := 0; tries < 20; tries++ {
+			stats, err = node.Stats()
+			if err == nil && stats.PeerEntries == 4 {
+				break
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		if err != nil || stats.Status != trackerproto.OK {
+			LOGE.Println("Stats: Status not OK")
+			return false
+		} else if stats.Torrents != 1 || stats.PeerEntries != 4 || stats.Pending != 0 || stats.CatchingUp {
+			LOGE.Println("Stats: node ", i, " reported ", stats.Torrents, " torrents, ", stats.PeerEntries,
+				" peer entries, ", stats.Pending, " pending, catching up ", stats.CatchingUp)
+			return false
+		} else if stats.SeqNum == 0 || (seqNum != -1 && stats.SeqNum != seqNum) {
+			LOGE.Println("Stats: node ", i, " is at seqNum ", stats.SeqNum, ", not ", seqNum)
+			return false
+		}
+		seqNum = stats.SeqNum
+	}
+	return true
+}
+
+// Check that every node of a cluster answers a ping with the SeqNum it
+// reports in Stats, and the current time
+func testPing() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 2)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+
+	for i, node := range cluster {
+		// A node hears of a commit as it happens, so give the others a
+		// moment to get there
+		var stats *trackerproto.StatsReply
+		for tries := 0; tries < 20; tries++ {
+			stats, err = node.Stats()
+			if err == nil && stats.Torrents == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		if err != nil || stats.Status != trackerproto.OK {
+			LOGE.Println("Stats: Status not OK")
+			return false
+		}
+
+		before := time.Now().UnixNano()
+		reply, err := node.Ping()
+		after := time.Now().UnixNano()
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Ping: Status not OK")
+			return false
+		} else if reply.SeqNum != stats.SeqNum || reply.CatchingUp {
+			LOGE.Println("Ping: node ", i, " is at seqNum ", reply.SeqNum, ", catching up ", reply.CatchingUp,
+				", but Stats said ", stats.SeqNum)
+			return false
+		} else if reply.Time < before || reply.Time > after {
+			LOGE.Println("Ping: node ", i, " answered at ", reply.Time, ", not between ", before, " and ", after)
+			return false
+		}
+	}
+	return true
+}
+
+// Stall one node while the others commit, then check that it keeps answering
+// pings promptly while it catches up, and that it gets all the way there
+func testCatchUpResponsive() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+
+	LOGE.Println("Stalling tracker")
+	cluster[2].t.DebugStall(3)
+	for i := 0; i < 100; i++ {
+		if reply, err := cluster[0].ConfirmChunk(chunk, strconv.Itoa(i)); err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Confirm Chunk: Status not OK")
+			return false
+		}
+	}
+
+	// Wait out the stall, then commit once more, so that the stalled node
+	// hears how far behind it is
+	time.Sleep(time.Second * 3)
+	if reply, err := cluster[0].ConfirmChunk(chunk, "apple"); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Confirm Chunk: Status not OK")
+		return false
+	}
+	stats, err := cluster[0].Stats()
+	if err != nil || stats.Status != trackerproto.OK {
+		LOGE.Println("Stats: Status not OK")
+		return false
+	}
+
+	for tries := 0; tries < 50; tries++ {
+		start := time.Now()
+		reply, err := cluster[2].Ping()
+		if err != nil || reply.Status != trackerproto.OK {
+			LOGE.Println("Ping: Status not OK")
+			return false
+		} else if took := time.Since(start); took > time.Millisecond*500 {
+			LOGE.Println("Ping: took ", took, " while catching up")
+			return false
+		} else if reply.SeqNum >= stats.SeqNum && !reply.CatchingUp {
+			return true
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	LOGE.Println("Stalled tracker never caught up")
+	return false
+}
+
+// Create torrents whose chunk size, file size and chunk hashes do not agree,
+// and check that the tracker refuses each of them, and creates none
+func testInvalidTorrent() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	good, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	noChunkSize := good
+	noChunkSize.ID.Name = "noChunkSize"
+	noChunkSize.ChunkSize = 0
+	negative := good
+	negative.ID.Name = "negative"
+	negative.FileSize = -10
+	// Four chunks' worth of file, but only three hashes
+	tooFewHashes := good
+	tooFewHashes.ID.Name = "tooFewHashes"
+	tooFewHashes.FileSize = 35
+	// Two chunks' worth of file, but three hashes
+	tooManyHashes := good
+	tooManyHashes.ID.Name = "tooManyHashes"
+	tooManyHashes.FileSize = 20
+	// Three hashes, but numbered from 1
+	misnumbered := good
+	misnumbered.ID.Name = "misnumbered"
+	misnumbered.ChunkHashes = map[int]string{1: "banana", 2: "banana", 3: "banana"}
+	// Files which add up to less than the file size
+	shortFiles := good
+	shortFiles.ID.Name = "shortFiles"
+	shortFiles.Files = []torrentproto.File{{Path: "a", Size: 1}, {Path: "b", Size: 1}}
+
+	for _, tor := range []torrentproto.Torrent{noChunkSize, negative, tooFewHashes, tooManyHashes, misnumbered, shortFiles} {
+		reply, err := cluster[1].CreateEntry(tor)
+		if err != nil || reply.Status != trackerproto.InvalidTorrent {
+			LOGE.Println("Create Entry: Status not InvalidTorrent for ", tor.ID.Name)
+			return false
+		}
+		requested, err := cluster[2].RequestChunk(torrentproto.NewChunkID(tor.ID, 0))
+		if err != nil || requested.Status != trackerproto.FileNotFound {
+			LOGE.Println("Request Chunk: invalid torrent ", tor.ID.Name, " was created")
+			return false
+		}
+	}
+
+	if reply, err := cluster[1].CreateEntry(good); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK for a valid torrent")
+		return false
+	}
+	return true
+}
+
+// Commit a run of operations on two nodes of a cluster without the third,
+// then commit one more on the third alone, and check that it fetches the ops
+// it missed from the others, rather than waiting for the next round
+func testCommitGap() bool {
+	cluster, err := createCluster(3)
+	if err != nil {
+		LOGE.Println("Could not create cluster")
+		closeCluster(cluster)
+		return false
+	}
+	defer closeCluster(cluster)
+
+	torrent, err := newTorrentInfo(cluster[0], true, 1)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[0].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK")
+		return false
+	}
+	getReply, err := cluster[2].GetOp(0)
+	if err != nil {
+		LOGE.Println("Could not get SeqNum")
+		return false
+	}
+	start := getReply.MaxSeq
+
+	chunk := torrentproto.ChunkID{ID: torrent.ID, ChunkNum: 0}
+	missed := tracker.COMMIT_GAP + 2
+	for i := 0; i <= missed; i++ {
+		op := trackerproto.Operation{
+			OpType:     trackerproto.Add,
+			Chunk:      chunk,
+			ClientAddr: "peer" + strconv.Itoa(i)}
+		for _, node := range cluster[:2] {
+			if err := node.Commit(start+i, op); err != nil {
+				LOGE.Println("Commit failed: ", err)
+				return false
+			}
+		}
+		if i == missed {
+			if err := cluster[2].Commit(start+i, op); err != nil {
+				LOGE.Println("Commit failed: ", err)
+				return false
+			}
+		}
+	}
+
+	for tries := 0; tries < 20; tries++ {
+		getReply, err := cluster[2].GetOp(0)
+		if err != nil {
+			LOGE.Println("Could not get SeqNum")
+			return false
+		} else if getReply.MaxSeq == start+missed+1 {
+			hasReply, err := cluster[2].PeerHasChunk(chunk, "peer0")
+			if err != nil || hasReply.Status != trackerproto.OK || !hasReply.Has {
+				LOGE.Println("Peer Has Chunk: first missed op was not applied")
+				return false
+			}
+			return true
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	LOGE.Println("Tracker waited on the gap before its last commit")
+	return false
+}
+
+// Start clusters which advertise an IPv4 address, and an IPv6 address if this
+// machine has IPv6 loopback, rather than localhost. Check that every node lists
+// the others at their advertised host:ports, and that the cluster commits
+func testAdvertisedHost() bool {
+	hosts := []string{"127.0.0.1"}
+	if ln, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		ln.Close()
+		hosts = append(hosts, "::1")
+	} else {
+		LOGE.Println("No IPv6 loopback, so only checking IPv4")
+	}
+
+	for _, host := range hosts {
+		if !advertisedHost(host) {
+			return false
+		}
+	}
+	return true
+}
+
+// Starts a cluster which advertises host, and checks it as testAdvertisedHost
+// describes
+func advertisedHost(host string) bool {
+	cluster, err := createConfiguredCluster(3, tracker.TrackerConfig{Host: host})
+	if err != nil {
+		LOGE.Println("Could not create cluster advertising ", host, ": ", err)
 		closeCluster(cluster)
 		return false
 	}
+	defer closeCluster(cluster)
 
-	LOGE.Println("Verifying logs")
-	seqNum := 0
-	ok := true
-	matching := true
-	for matching && ok {
-		reply0, err0 := cluster[0].GetOp(seqNum)
-		reply2, err2 := cluster[2].GetOp(seqNum)
-
-		if err0 != nil || err2 != nil {
-			LOGE.Println("Error getting operation.")
-			closeCluster(cluster)
+	for i, node := range cluster {
+		reply, err := node.GetTrackers()
+		if err != nil || reply.Status != trackerproto.OK || len(reply.HostPorts) != len(cluster) {
+			LOGE.Println("Get Trackers: node ", i, " did not list the cluster")
 			return false
 		}
-		if reply0.Status == trackerproto.OutOfDate {
-			ok = false
+		for _, hostPort := range reply.HostPorts {
+			if listed, _, err := net.SplitHostPort(hostPort); err != nil || listed != host {
+				LOGE.Println("Get Trackers: node ", i, " listed ", hostPort, " rather than ", host)
+				return false
+			}
 		}
-		val0 := reply0.Value
-		val2 := reply2.Value
-		valsEq := val0.OpType == val2.OpType && val0.Chunk == val2.Chunk && val0.ClientAddr == val2.ClientAddr
-		matching = matching && valsEq && (reply0.Status == reply2.Status)
-		seqNum++
 	}
 
-	closeCluster(cluster)
-	return matching
+	torrent, err := newTorrentInfo(cluster[0], true, 3)
+	if err != nil {
+		LOGE.Println("Could not create torrent")
+		return false
+	}
+	if reply, err := cluster[1].CreateEntry(torrent); err != nil || reply.Status != trackerproto.OK {
+		LOGE.Println("Create Entry: Status not OK advertising ", host)
+		return false
+	}
+	return true
 }
 
 func main() {
@@ -845,6 +4499,15 @@ func main() {
 		LOGE.Println("Passed createEntryTestThreeNodes")
 	}
 
+	tests++
+	LOGE.Println("----------- testTorrentLimit")
+	if !testTorrentLimit() {
+		LOGE.Println("---------------------- Failed testTorrentLimit")
+	} else {
+		pass++
+		LOGE.Println("Passed testTorrentLimit")
+	}
+
 	tests++
 	LOGE.Println("----------- testCluster one node")
 	if !testCluster(1) {
@@ -863,6 +4526,96 @@ func main() {
 		LOGE.Println("Passed testCluster three nodes")
 	}
 
+	tests++
+	LOGE.Println("----------- testSeeders")
+	if !testSeeders(3) {
+		LOGE.Println("---------------------- Failed testSeeders")
+	} else {
+		pass++
+		LOGE.Println("Passed testSeeders")
+	}
+
+	tests++
+	LOGE.Println("----------- testPeerHasChunk")
+	if !testPeerHasChunk(3) {
+		LOGE.Println("---------------------- Failed testPeerHasChunk")
+	} else {
+		pass++
+		LOGE.Println("Passed testPeerHasChunk")
+	}
+
+	tests++
+	LOGE.Println("----------- testAvailability")
+	if !testAvailability(3) {
+		LOGE.Println("---------------------- Failed testAvailability")
+	} else {
+		pass++
+		LOGE.Println("Passed testAvailability")
+	}
+
+	tests++
+	LOGE.Println("----------- testInProcess")
+	if !testInProcess() {
+		LOGE.Println("---------------------- Failed testInProcess")
+	} else {
+		pass++
+		LOGE.Println("Passed testInProcess")
+	}
+
+	tests++
+	LOGE.Println("----------- testSingleNodeTiming")
+	if !testSingleNodeTiming(200) {
+		LOGE.Println("---------------------- Failed testSingleNodeTiming")
+	} else {
+		pass++
+		LOGE.Println("Passed testSingleNodeTiming")
+	}
+
+	tests++
+	LOGE.Println("----------- testRequestChunkLocal")
+	if !testRequestChunkLocal() {
+		LOGE.Println("---------------------- Failed testRequestChunkLocal")
+	} else {
+		pass++
+		LOGE.Println("Passed testRequestChunkLocal")
+	}
+
+	tests++
+	LOGE.Println("----------- testDuplicateNodeID")
+	if !testDuplicateNodeID() {
+		LOGE.Println("---------------------- Failed testDuplicateNodeID")
+	} else {
+		pass++
+		LOGE.Println("Passed testDuplicateNodeID")
+	}
+
+	tests++
+	LOGE.Println("----------- testObserver")
+	if !testObserver() {
+		LOGE.Println("---------------------- Failed testObserver")
+	} else {
+		pass++
+		LOGE.Println("Passed testObserver")
+	}
+
+	tests++
+	LOGE.Println("----------- testProbeTrackers")
+	if !testProbeTrackers() {
+		LOGE.Println("---------------------- Failed testProbeTrackers")
+	} else {
+		pass++
+		LOGE.Println("Passed testProbeTrackers")
+	}
+
+	tests++
+	LOGE.Println("----------- testLongLog")
+	if !testLongLog(50000) {
+		LOGE.Println("---------------------- Failed testLongLog")
+	} else {
+		pass++
+		LOGE.Println("Passed testLongLog")
+	}
+
 	tests++
 	LOGE.Println("----------- testStress")
 	if !testStress(100) {
@@ -872,6 +4625,15 @@ func main() {
 		LOGE.Println("Passed testStress")
 	}
 
+	tests++
+	LOGE.Println("----------- testPendingScale")
+	if !testPendingScale(200, 2000) {
+		LOGE.Println("---------------------- Failed testPendingScale")
+	} else {
+		pass++
+		LOGE.Println("Passed testPendingScale")
+	}
+
 	tests++
 	LOGE.Println("----------- testDualing")
 	if !testDualing(500) {
@@ -881,6 +4643,15 @@ func main() {
 		LOGE.Println("Passed testDualing")
 	}
 
+	tests++
+	LOGE.Println("----------- testFairness")
+	if !testFairness(4, 15) {
+		LOGE.Println("---------------------- Failed testFairness")
+	} else {
+		pass++
+		LOGE.Println("Passed testFairness")
+	}
+
 	tests++
 	LOGE.Println("----------- testClosed")
 	if !testClosed() {
@@ -899,6 +4670,141 @@ func main() {
 		LOGE.Println("Passed testClosedTwo")
 	}
 
+	tests++
+	LOGE.Println("----------- testShutdownPending")
+	if !testShutdownPending() {
+		LOGE.Println("---------------------- Failed testShutdownPending")
+	} else {
+		pass++
+		LOGE.Println("Passed testShutdownPending")
+	}
+
+	tests++
+	LOGE.Println("----------- testRestarts")
+	if !testRestarts() {
+		LOGE.Println("---------------------- Failed testRestarts")
+	} else {
+		pass++
+		LOGE.Println("Passed testRestarts")
+	}
+
+	tests++
+	LOGE.Println("----------- testCreateDigest")
+	if !testCreateDigest() {
+		LOGE.Println("---------------------- Failed testCreateDigest")
+	} else {
+		pass++
+		LOGE.Println("Passed testCreateDigest")
+	}
+
+	tests++
+	LOGE.Println("----------- testPeerOrder")
+	if !testPeerOrder() {
+		LOGE.Println("---------------------- Failed testPeerOrder")
+	} else {
+		pass++
+		LOGE.Println("Passed testPeerOrder")
+	}
+
+	tests++
+	LOGE.Println("----------- testEvictPeer")
+	if !testEvictPeer() {
+		LOGE.Println("---------------------- Failed testEvictPeer")
+	} else {
+		pass++
+		LOGE.Println("Passed testEvictPeer")
+	}
+
+	tests++
+	LOGE.Println("----------- testExportImport")
+	if !testExportImport() {
+		LOGE.Println("---------------------- Failed testExportImport")
+	} else {
+		pass++
+		LOGE.Println("Passed testExportImport")
+	}
+
+	tests++
+	LOGE.Println("----------- testImportPastLimit")
+	if !testImportPastLimit() {
+		LOGE.Println("---------------------- Failed testImportPastLimit")
+	} else {
+		pass++
+		LOGE.Println("Passed testImportPastLimit")
+	}
+
+	tests++
+	LOGE.Println("----------- testTrackerConfig")
+	if !testTrackerConfig() {
+		LOGE.Println("---------------------- Failed testTrackerConfig")
+	} else {
+		pass++
+		LOGE.Println("Passed testTrackerConfig")
+	}
+
+	tests++
+	LOGE.Println("----------- testStaggeredStart")
+	if !testStaggeredStart() {
+		LOGE.Println("---------------------- Failed testStaggeredStart")
+	} else {
+		pass++
+		LOGE.Println("Passed testStaggeredStart")
+	}
+
+	tests++
+	LOGE.Println("----------- testTorrentEqual")
+	if !testTorrentEqual() {
+		LOGE.Println("---------------------- Failed testTorrentEqual")
+	} else {
+		pass++
+		LOGE.Println("Passed testTorrentEqual")
+	}
+
+	tests++
+	LOGE.Println("----------- testChunkLimit")
+	if !testChunkLimit() {
+		LOGE.Println("---------------------- Failed testChunkLimit")
+	} else {
+		pass++
+		LOGE.Println("Passed testChunkLimit")
+	}
+
+	tests++
+	LOGE.Println("----------- testDeleteEntry")
+	if !testDeleteEntry() {
+		LOGE.Println("---------------------- Failed testDeleteEntry")
+	} else {
+		pass++
+		LOGE.Println("Passed testDeleteEntry")
+	}
+
+	tests++
+	LOGE.Println("----------- testListTorrents")
+	if !testListTorrents() {
+		LOGE.Println("---------------------- Failed testListTorrents")
+	} else {
+		pass++
+		LOGE.Println("Passed testListTorrents")
+	}
+
+	tests++
+	LOGE.Println("----------- testPeerExpiry")
+	if !testPeerExpiry() {
+		LOGE.Println("---------------------- Failed testPeerExpiry")
+	} else {
+		pass++
+		LOGE.Println("Passed testPeerExpiry")
+	}
+
+	tests++
+	LOGE.Println("----------- testStaleRequest")
+	if !testStaleRequest() {
+		LOGE.Println("---------------------- Failed testStaleRequest")
+	} else {
+		pass++
+		LOGE.Println("Passed testStaleRequest")
+	}
+
 	tests++
 	LOGE.Println("----------- testStalled")
 	if !testStalled() {
@@ -907,5 +4813,174 @@ func main() {
 		pass++
 		LOGE.Println("Passed testStalled")
 	}
+
+	tests++
+	LOGE.Println("----------- testBatching")
+	if !testBatching() {
+		LOGE.Println("---------------------- Failed testBatching")
+	} else {
+		pass++
+		LOGE.Println("Passed testBatching")
+	}
+
+	tests++
+	LOGE.Println("----------- testConfirmChunks")
+	if !testConfirmChunks() {
+		LOGE.Println("---------------------- Failed testConfirmChunks")
+	} else {
+		pass++
+		LOGE.Println("Passed testConfirmChunks")
+	}
+
+	tests++
+	LOGE.Println("----------- testLease")
+	if !testLease() {
+		LOGE.Println("---------------------- Failed testLease")
+	} else {
+		pass++
+		LOGE.Println("Passed testLease")
+	}
+
+	tests++
+	LOGE.Println("----------- testSnapshot")
+	if !testSnapshot() {
+		LOGE.Println("---------------------- Failed testSnapshot")
+	} else {
+		pass++
+		LOGE.Println("Passed testSnapshot")
+	}
+
+	tests++
+	LOGE.Println("----------- testMaxPeers")
+	if !testMaxPeers() {
+		LOGE.Println("---------------------- Failed testMaxPeers")
+	} else {
+		pass++
+		LOGE.Println("Passed testMaxPeers")
+	}
+
+	tests++
+	LOGE.Println("----------- testGracefulClose")
+	if !testGracefulClose() {
+		LOGE.Println("---------------------- Failed testGracefulClose")
+	} else {
+		pass++
+		LOGE.Println("Passed testGracefulClose")
+	}
+
+	tests++
+	LOGE.Println("----------- testBadChunkReports")
+	if !testBadChunkReports() {
+		LOGE.Println("---------------------- Failed testBadChunkReports")
+	} else {
+		pass++
+		LOGE.Println("Passed testBadChunkReports")
+	}
+
+	tests++
+	LOGE.Println("----------- testTokens")
+	if !testTokens() {
+		LOGE.Println("---------------------- Failed testTokens")
+	} else {
+		pass++
+		LOGE.Println("Passed testTokens")
+	}
+
+	tests++
+	LOGE.Println("----------- testRateLimit")
+	if !testRateLimit() {
+		LOGE.Println("---------------------- Failed testRateLimit")
+	} else {
+		pass++
+		LOGE.Println("Passed testRateLimit")
+	}
+
+	tests++
+	LOGE.Println("----------- testAddNode one node")
+	if !testAddNode(1) {
+		LOGE.Println("---------------------- Failed testAddNode one node")
+	} else {
+		pass++
+		LOGE.Println("Passed testAddNode one node")
+	}
+
+	tests++
+	LOGE.Println("----------- testAddNode three nodes")
+	if !testAddNode(3) {
+		LOGE.Println("---------------------- Failed testAddNode three nodes")
+	} else {
+		pass++
+		LOGE.Println("Passed testAddNode three nodes")
+	}
+
+	tests++
+	LOGE.Println("----------- testRemoveNode")
+	if !testRemoveNode() {
+		LOGE.Println("---------------------- Failed testRemoveNode")
+	} else {
+		pass++
+		LOGE.Println("Passed testRemoveNode")
+	}
+
+	tests++
+	LOGE.Println("----------- testMetrics")
+	if !testMetrics() {
+		LOGE.Println("---------------------- Failed testMetrics")
+	} else {
+		pass++
+		LOGE.Println("Passed testMetrics")
+	}
+
+	tests++
+	LOGE.Println("----------- testRPCHook")
+	if !testRPCHook() {
+		LOGE.Println("---------------------- Failed testRPCHook")
+	} else {
+		pass++
+		LOGE.Println("Passed testRPCHook")
+	}
+
+	tests++
+	LOGE.Println("----------- testPing")
+	if !testPing() {
+		LOGE.Println("---------------------- Failed testPing")
+	} else {
+		pass++
+		LOGE.Println("Passed testPing")
+	}
+
+	tests++
+	LOGE.Println("----------- testCatchUpResponsive")
+	if !testCatchUpResponsive() {
+		LOGE.Println("---------------------- Failed testCatchUpResponsive")
+	} else {
+		pass++
+		LOGE.Println("Passed testCatchUpResponsive")
+	}
+
+	tests++
+	LOGE.Println("----------- testInvalidTorrent")
+	if !testInvalidTorrent() {
+		LOGE.Println("---------------------- Failed testInvalidTorrent")
+	} else {
+		pass++
+		LOGE.Println("Passed testInvalidTorrent")
+	}
+	tests++
+	LOGE.Println("----------- testCommitGap")
+	if !testCommitGap() {
+		LOGE.Println("---------------------- Failed testCommitGap")
+	} else {
+		pass++
+		LOGE.Println("Passed testCommitGap")
+	}
+	tests++
+	LOGE.Println("----------- testAdvertisedHost")
+	if !testAdvertisedHost() {
+		LOGE.Println("---------------------- Failed testAdvertisedHost")
+	} else {
+		pass++
+		LOGE.Println("Passed testAdvertisedHost")
+	}
 	LOGE.Println("Passed: ", pass, "/", tests)
 }
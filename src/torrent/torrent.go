@@ -4,9 +4,12 @@ package torrent
 
 import (
     "crypto/sha1"
+    "crypto/sha256"
     "encoding/gob"
     "errors"
     "fmt"
+    "hash"
+    "io"
     "net/rpc"
     "os"
     "strings"
@@ -18,16 +21,42 @@ import (
 const (
     DEFAULT_CHUNK_SIZE int = 1000000 // Number of bytes per chunk
     MODE os.FileMode = 644 // Mode for writing torrent files
+    FILE_VERSION int = 1 // Format of the torrent files written by Save
 )
 
 // New creates a new Torrent for the file at the given path.
 // Gives this Torrent the given human-readable name.
 // Throws an error if no file exists at this path
 func New(path string, name string, trackerNodes []torrentproto.TrackerNode) (torrentproto.Torrent, error) {
+    return NewWithChunkSize(path, name, trackerNodes, DEFAULT_CHUNK_SIZE)
+}
+
+// NewWithChunkSize creates a new Torrent for the file at the given path, like
+// New, but splits the file into chunks of the given number of bytes.
+// Throws an error if no file exists at this path, or if chunkSize is not
+// positive.
+func NewWithChunkSize(path string, name string, trackerNodes []torrentproto.TrackerNode, chunkSize int) (torrentproto.Torrent, error) {
+    return NewWithHashAlgo(path, name, trackerNodes, chunkSize, torrentproto.SHA1)
+}
+
+// NewWithHashAlgo creates a new Torrent for the file at the given path, like
+// NewWithChunkSize, but hashes the file and its chunks with the given
+// algorithm.
+// Throws an error if no file exists at this path, if chunkSize is not
+// positive, or if the algorithm is not known.
+func NewWithHashAlgo(path string, name string, trackerNodes []torrentproto.TrackerNode, chunkSize int, algo torrentproto.HashAlgo) (torrentproto.Torrent, error) {
+    if chunkSize <= 0 {
+        return torrentproto.Torrent{}, errors.New("Chunk size must be positive")
+    }
     t := torrentproto.Torrent {
         TrackerNodes: trackerNodes,
-        ChunkSize: DEFAULT_CHUNK_SIZE,
-        ChunkHashes: make(map[int]string)}
+        ChunkSize: chunkSize,
+        ChunkHashes: make(map[int]string),
+        HashAlgo: algo}
+    h, err := NewHash(t)
+    if err != nil {
+        return torrentproto.Torrent{}, err
+    }
 
     // Attempt to find the file with the given path.
     file, err := os.Open(path)
@@ -35,6 +64,7 @@ func New(path string, name string, trackerNodes []torrentproto.TrackerNode) (tor
         // Failed to read the file at the given path.
         return torrentproto.Torrent{}, err
     }
+    defer file.Close()
     fi, err := file.Stat()
     if err != nil {
         // Failed to get information about the file.
@@ -42,20 +72,17 @@ func New(path string, name string, trackerNodes []torrentproto.TrackerNode) (tor
     }
     t.FileSize = int(fi.Size())
 
-    // Read the file's contents.
-    bytes := make([]byte, t.FileSize)
-    if _, err := file.Read(bytes); err != nil {
-        // Failed to read file contents.
+    // Hash the entire file, streaming it rather than reading it into memory.
+    // Use this to determine the Torrent's ID.
+    hash, err := FileHash(t, file)
+    if err != nil {
         return torrentproto.Torrent{}, err
     }
+    t.ID = torrentproto.ID {Name: name, Hash: hash}
 
-    // Hash the entire file we just read.
-    // Use this to determine the Torrent's ID.
-    h := sha1.New()
-    h.Write(bytes)
-    t.ID = torrentproto.ID {Name: name, Hash: string(h.Sum(nil))}
-
-    // Record hashes for every chunk.
+    // Record hashes for every chunk, reading one chunk at a time.
+    // Every chunk is ChunkSize bytes, except for the last, which holds the
+    // rest of the file.
     for chunkNum := 0; chunkNum < NumChunks(t); chunkNum++ {
         if chunk, err := ReadChunk(t, file, chunkNum); err != nil {
             return torrentproto.Torrent{}, err
@@ -73,29 +100,50 @@ func New(path string, name string, trackerNodes []torrentproto.TrackerNode) (tor
 }
 
 // Load loads a serialized Torrent from the file at the given path.
-// This assumes that the Torrent at the given path was created using ToFile.
+// This assumes that the Torrent at the given path was created using Save.
+// Throws an error if the file was written in a format other than
+// FILE_VERSION, e.g. by a newer version of ByteTorrent.
 func Load(path string) (torrentproto.Torrent, error) {
-    var t torrentproto.Torrent
-    if file, err := os.Open(path); err != nil {
+    file, err := os.Open(path)
+    if err != nil {
         return torrentproto.Torrent{}, err
-    } else if err := gob.NewDecoder(file).Decode(&t); err != nil {
+    }
+    defer file.Close()
+
+    var version int
+    var t torrentproto.Torrent
+    decoder := gob.NewDecoder(file)
+    if err := decoder.Decode(&version); err != nil {
+        // Files from before torrent files were versioned start with the
+        // Torrent itself.
+        return torrentproto.Torrent{}, fmt.Errorf("%s is not a torrent file, or is in an old format: %v", path, err)
+    } else if version != FILE_VERSION {
+        return torrentproto.Torrent{}, fmt.Errorf("%s is in torrent file format %d, not %d", path, version, FILE_VERSION)
+    } else if err := decoder.Decode(&t); err != nil {
         return torrentproto.Torrent{}, err
     } else {
         // Successfully created Torrent from file.
-        return t, nil   
+        return t, nil
     }
 }
 
-// Save serializes a torrent and writes it out to the given file.
+// Save serializes a torrent and writes it out to the given file, preceded by
+// FILE_VERSION so that Load can tell which format it is in.
 func Save(t torrentproto.Torrent, path string) error {
-    if file, err := os.Create(path); err != nil {
+    file, err := os.Create(path)
+    if err != nil {
         return err
-    } else if err := gob.NewEncoder(file).Encode(t); err != nil {
+    }
+    encoder := gob.NewEncoder(file)
+    if err := encoder.Encode(FILE_VERSION); err != nil {
+        file.Close()
+        return err
+    } else if err := encoder.Encode(t); err != nil {
+        file.Close()
         return err
-    } else {
-        // Successfully wrote Torrent to file.
-        return nil
     }
+    // Successfully wrote Torrent to file.
+    return file.Close()
 }
 
 // Register attempts to create an entry for this Torrent on the Tracker
@@ -112,12 +160,18 @@ func Save(t torrentproto.Torrent, path string) error {
 //     ID is uniquely tied to the Torrent on the Tracker with which it is
 //     registered.
 func Register(t torrentproto.Torrent) error {
+    return RegisterWithToken(t, "")
+}
+
+// RegisterWithToken registers the Torrent as Register does, giving the Tracker
+// token, for Trackers which only accept updates from clients with a token.
+func RegisterWithToken(t torrentproto.Torrent, token string) error {
     // Attempt to contact one of the tracker nodes and create an entry for this
     // ID.
     for _, trackerNode := range t.TrackerNodes {
         if conn, err := rpc.DialHTTP("tcp", trackerNode.HostPort); err == nil {
             // We found a live node in the tracker cluster.
-            args := & trackerproto.CreateArgs {Torrent: t}
+            args := & trackerproto.CreateArgs {Torrent: t, Token: token}
             reply :=  & trackerproto.UpdateReply {}
             if err := conn.Call("RemoteTracker.CreateEntry", args, reply); err == nil {
                 // Tracker responded to CreateEntry call. If create was successful,
@@ -136,6 +190,27 @@ func Register(t torrentproto.Torrent) error {
                     // Could not create Torrent on Tracker, because given
                     // tracker nodes do not form a cluster.
                     return errors.New("Invalid trackers")
+
+                case trackerproto.TooManyTorrents:
+                    // Could not create Torrent on Tracker, because it limits
+                    // how many torrents each client may create.
+                    return errors.New("Too many torrents")
+
+                case trackerproto.TooManyChunks:
+                    // Could not create Torrent on Tracker, because it limits
+                    // how many chunks a torrent may have. Recommend a larger
+                    // chunk size.
+                    return errors.New("Too many chunks")
+
+                case trackerproto.InvalidTorrent:
+                    // Could not create Torrent on Tracker, because its
+                    // sizes and chunk hashes do not agree.
+                    return errors.New("Invalid torrent")
+
+                case trackerproto.NotAuthorized:
+                    // Could not create Torrent on Tracker, because it did
+                    // not accept the token.
+                    return errors.New("Not authorized")
                 }
             }
         }
@@ -147,8 +222,13 @@ func Register(t torrentproto.Torrent) error {
 
 // NumChunks returns the number of chunks into which we this Torrent's file is
 // divided.
+// When FileSize is not a multiple of ChunkSize, the final chunk is short, and
+// holds only the FileSize % ChunkSize bytes left over (see ChunkBounds).
+// A Torrent whose ChunkSize is not positive is inconsistent, and has no chunks.
 func NumChunks(t torrentproto.Torrent) int {
-    if t.FileSize % t.ChunkSize == 0 {
+    if t.ChunkSize <= 0 {
+        return 0
+    } else if t.FileSize % t.ChunkSize == 0 {
         return t.FileSize / t.ChunkSize
     } else {
         // Round up.
@@ -156,85 +236,392 @@ func NumChunks(t torrentproto.Torrent) int {
     }
 }
 
+// AllChunkIDs returns the IDs of every chunk of this Torrent, in order of
+// chunk number.
+func AllChunkIDs(t torrentproto.Torrent) []torrentproto.ChunkID {
+    chunkIDs := make([]torrentproto.ChunkID, NumChunks(t))
+    for chunkNum := range chunkIDs {
+        chunkIDs[chunkNum] = torrentproto.NewChunkID(t.ID, chunkNum)
+    }
+    return chunkIDs
+}
+
+// Digest returns a summary of how this Torrent splits its file into chunks:
+// a hash of its file size, chunk size, hash algorithm, chunk hashes and files.
+// Two Torrents with the same ID but different Digests do not describe the same
+// chunks (e.g. they were made with different chunk sizes).
+func Digest(t torrentproto.Torrent) string {
+    h := sha256.New()
+    fmt.Fprintf(h, "%d %d %d", t.FileSize, t.ChunkSize, t.HashAlgo)
+    for chunkNum := 0; chunkNum < len(t.ChunkHashes); chunkNum++ {
+        fmt.Fprintf(h, " %q", t.ChunkHashes[chunkNum])
+    }
+    // A Torrent of one file lists no files, so its Digest is unchanged.
+    for _, f := range t.Files {
+        fmt.Fprintf(h, " %q %d", f.Path, f.Size)
+    }
+    return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// Equal returns whether a and b describe the same torrent: the same ID, file
+// size, chunk size, hash algorithm, chunk hashes and files, registered with
+// the same tracker nodes.
+// The order of the tracker nodes does not matter, only which nodes (and with
+// which weights) are listed.
+func Equal(a, b torrentproto.Torrent) bool {
+    if a.ID != b.ID || a.FileSize != b.FileSize || a.ChunkSize != b.ChunkSize || a.HashAlgo != b.HashAlgo {
+        return false
+    }
+
+    if len(a.ChunkHashes) != len(b.ChunkHashes) || len(a.Files) != len(b.Files) {
+        return false
+    }
+    for i, f := range a.Files {
+        if b.Files[i] != f {
+            return false
+        }
+    }
+    for chunkNum, hash := range a.ChunkHashes {
+        if other, ok := b.ChunkHashes[chunkNum]; !ok || other != hash {
+            return false
+        }
+    }
+
+    // Count each node listed by a, then take away those listed by b.
+    if len(a.TrackerNodes) != len(b.TrackerNodes) {
+        return false
+    }
+    nodes := make(map[torrentproto.TrackerNode]int)
+    for _, node := range a.TrackerNodes {
+        nodes[node]++
+    }
+    for _, node := range b.TrackerNodes {
+        if nodes[node] == 0 {
+            return false
+        }
+        nodes[node]--
+    }
+    return true
+}
+
+// A FileSpan is a range of bytes within one of a Torrent's files.
+type FileSpan struct {
+    File int // Index of the file within the Torrent's Files (0 for a Torrent of one file)
+    Offset int64 // Offset of the first byte of the span within the file
+    Length int // Number of bytes in the span
+}
+
+// ChunkLocation returns the offset and length of the given chunk within the
+// Torrent's data, along with the spans of file data which the chunk covers,
+// in order.
+// Every chunk is ChunkSize bytes long, except for the final chunk, which holds
+// whatever remains of the data and may be shorter.
+// A Torrent of one file covers every chunk with a single span of file 0. The
+// data of a Torrent of several files is its Files laid end to end, so a chunk
+// which straddles the boundary between files is covered by one span in each,
+// and empty files are covered by no spans.
+// Returns a non-nil error if the chunk number is invalid for this Torrent, or
+// its Files do not add up to its FileSize.
+func ChunkLocation(t torrentproto.Torrent, chunkNum int) (int64, int, []FileSpan, error) {
+    start, length, err := ChunkBounds(t, chunkNum)
+    if err != nil {
+        // Bad chunk number.
+        return 0, 0, nil, err
+    }
+    if len(t.Files) == 0 {
+        // The Torrent's data is its one file.
+        spans := []FileSpan{FileSpan{File: 0, Offset: int64(start), Length: length}}
+        return int64(start), length, spans, nil
+    } else if !FilesMatch(t) {
+        return 0, 0, nil, errors.New("Torrent's files do not add up to its file size")
+    }
+
+    // Take the part of each file which the chunk covers, up to the end of the
+    // chunk.
+    spans := make([]FileSpan, 0, 1)
+    chunkStart, chunkEnd := int64(start), int64(start) + int64(length)
+    var fileStart int64
+    for i := 0; i < len(t.Files) && fileStart < chunkEnd; i++ {
+        fileEnd := fileStart + int64(t.Files[i].Size)
+        if from, to := max(chunkStart, fileStart), min(chunkEnd, fileEnd); from < to {
+            spans = append(spans, FileSpan{File: i, Offset: from - fileStart, Length: int(to - from)})
+        }
+        fileStart = fileEnd
+    }
+    return int64(start), length, spans, nil
+}
+
+// FilesMatch returns whether the sizes of the Torrent's Files, if it lists
+// any, are not negative and add up to its FileSize.
+func FilesMatch(t torrentproto.Torrent) bool {
+    if len(t.Files) == 0 {
+        return true
+    }
+    var total int64
+    for _, f := range t.Files {
+        if f.Size < 0 {
+            return false
+        }
+        total += int64(f.Size)
+    }
+    return total == int64(t.FileSize)
+}
+
 // ReadChunk returns the chunk with the given number from this Torrent.
-// If the given number is out of range, it returns a non-nil error.
+// The file holds the Torrent's data, i.e. for a Torrent of several files,
+// their contents laid end to end.
+// It uses only positional reads, so it is safe to call concurrently with
+// other ReadChunk and WriteChunk calls on the same file.
+// If the given number is out of range, or the Torrent places the chunk
+// outside its file, it returns a non-nil error.
 func ReadChunk(t torrentproto.Torrent, file *os.File, chunkNum int) ([]byte, error) {
-    if start, length, err := ChunkBounds(t, chunkNum); err != nil {
+    if offset, length, _, err := ChunkLocation(t, chunkNum); err != nil {
         // Bad chunk number.
         return nil, err
+    } else if err := checkBounds(t, chunkNum, offset, length); err != nil {
+        // The Torrent is inconsistent.
+        return nil, err
     } else {
         bytes := make([]byte, length)
-        if bytesRead, err := file.ReadAt(bytes, int64(start)); err != nil {
+        if bytesRead, err := file.ReadAt(bytes, offset); err != nil {
             // Read failed.
             return nil, err
         } else if bytesRead != length {
             // Read wrong number of bytes.
             return nil, errors.New("Read wrong number of bytes")
-        } else {
-            return bytes, nil
         }
+        return bytes, nil
     }
 }
 
 // WriteChunk writes the given chunk at the position for the given chunk number
-// in the given file.
-// It returns a non-nil error if the write fails.
+// in the given file, which holds the Torrent's data as for ReadChunk.
+// If the file is not big enough to hold the chunk, it is extended.
+// Only positional writes are used, so several goroutines may write different
+// chunks of the same file at once.
+// It returns a non-nil error if the write fails, or if the chunk would not lie
+// within the Torrent's file, so that a bad Torrent cannot extend the file past
+// FileSize.
 func WriteChunk(t torrentproto.Torrent, file *os.File, chunkNum int, chunk []byte) error {
-    start, length, err := ChunkBounds(t, chunkNum)
+    offset, length, _, err := ChunkLocation(t, chunkNum)
     if err != nil {
         // Bad chunk number.
         return err
     }
-
-    // If the file is not big enough for ths requested chunk, but the
-    // torrent is big enough, attempt to extend the file.
-    end := start + length
-    if fi, err := file.Stat(); err != nil {
-        // Failed to get file info.
+    if err := checkBounds(t, chunkNum, offset, length); err != nil {
+        // The Torrent is inconsistent.
         return err
-    } else if fi.Size() < int64(end) {
-        if err := file.Truncate(int64(end)); err != nil {
-            // Failed to extend file.
-            return err
-        }
     }
 
     // Attempt to write to file.
-    if bytesWritten, err := file.WriteAt(chunk, int64(start)); err != nil {
+    // Note that we do not extend the file with Truncate first. Another
+    // goroutine could extend it further in between checking its size and
+    // truncating it, and truncating would then cut off that goroutine's chunk.
+    // Writing past the end of the file extends it anyway.
+    if len(chunk) != length {
+        // Chunk is the wrong size to fill its place in the file.
+        return fmt.Errorf("Chunk %d is %d bytes, but its place in the file holds %d", chunkNum, len(chunk), length)
+    }
+    if bytesWritten, err := file.WriteAt(chunk, offset); err != nil {
         // Could not write to file.
         return err
     } else if bytesWritten != length {
         // Wrote wrong number of bytes.
         return errors.New("Wrote wrong number of bytes")
-    } else {
-        // Write successful.
-        return nil
     }
+
+    // Write successful.
+    return nil
+}
+
+// ChunkReader returns a reader of the chunk with the given number from this
+// Torrent, and the chunk's length, so that a large chunk can be hashed or
+// sent on without holding all of it in memory.
+// Like ReadChunk, it uses only positional reads. If the file has been
+// truncated, the reader ends early.
+// If the given number is out of range, or the Torrent places the chunk
+// outside its file, it returns a non-nil error.
+func ChunkReader(t torrentproto.Torrent, file *os.File, chunkNum int) (io.Reader, int, error) {
+    return ChunkTailReader(t, file, chunkNum, 0)
+}
+
+// ChunkTailReader returns a reader of the tail of the chunk with the given
+// number from this Torrent, i.e. its bytes from skip on, and the tail's
+// length, as ChunkReader does for the whole chunk. This lets a transfer of a
+// chunk which was cut off carry on where it stopped.
+// If skip is not inside the chunk, it returns a non-nil error.
+func ChunkTailReader(t torrentproto.Torrent, file *os.File, chunkNum int, skip int) (io.Reader, int, error) {
+    offset, length, _, err := ChunkLocation(t, chunkNum)
+    if err != nil {
+        // Bad chunk number.
+        return nil, 0, err
+    } else if err := checkBounds(t, chunkNum, offset, length); err != nil {
+        // The Torrent is inconsistent.
+        return nil, 0, err
+    } else if skip < 0 || skip >= length {
+        return nil, 0, fmt.Errorf("Chunk %d has no byte %d", chunkNum, skip)
+    }
+    return io.NewSectionReader(file, offset + int64(skip), int64(length - skip)), length - skip, nil
+}
+
+// WriteChunkFrom writes the chunk with the given number, read from r, at its
+// position in the given file, as WriteChunk does, but copies it a piece at a
+// time rather than taking the whole chunk at once.
+// It returns a non-nil error if r holds more or fewer bytes than the chunk.
+// Bytes read before then have already been written.
+func WriteChunkFrom(t torrentproto.Torrent, file *os.File, chunkNum int, r io.Reader) error {
+    _, err := WriteChunkTailFrom(t, file, chunkNum, 0, r)
+    return err
+}
+
+// WriteChunkTailFrom writes the tail of the chunk with the given number, i.e.
+// its bytes from skip on, read from r, at its position in the given file, as
+// WriteChunkFrom does for the whole chunk.
+// It returns how many bytes of the tail it wrote, and a non-nil error if r
+// holds more or fewer bytes than the tail, or skip is not inside the chunk.
+func WriteChunkTailFrom(t torrentproto.Torrent, file *os.File, chunkNum int, skip int, r io.Reader) (int, error) {
+    offset, length, _, err := ChunkLocation(t, chunkNum)
+    if err != nil {
+        // Bad chunk number.
+        return 0, err
+    }
+    if err := checkBounds(t, chunkNum, offset, length); err != nil {
+        // The Torrent is inconsistent.
+        return 0, err
+    }
+    if skip < 0 || skip >= length {
+        return 0, fmt.Errorf("Chunk %d has no byte %d", chunkNum, skip)
+    }
+
+    w := io.NewOffsetWriter(file, offset + int64(skip))
+    if bytesWritten, err := io.CopyN(w, r, int64(length - skip)); err == io.EOF {
+        // Chunk is too short to fill its place in the file.
+        return int(bytesWritten), fmt.Errorf("Chunk %d ended after %d bytes, but its place in the file holds %d", chunkNum, skip + int(bytesWritten), length)
+    } else if err != nil {
+        // Could not read the chunk, or write it to file.
+        return int(bytesWritten), err
+    }
+    if n, _ := io.ReadFull(r, make([]byte, 1)); n > 0 {
+        // Chunk is too long to fit its place in the file.
+        return length - skip, fmt.Errorf("Chunk %d is longer than the %d bytes its place in the file holds", chunkNum, length)
+    }
+
+    // Write successful.
+    return length - skip, nil
+}
+
+// checkBounds checks that the given chunk, at offset with the given length,
+// lies within the bytes [0, FileSize) of the Torrent's data.
+// ChunkLocation should only return such chunks, but a crafted or inconsistent
+// Torrent (e.g. with a ChunkSize which is not positive) could otherwise make
+// ReadChunk and WriteChunk stray outside the file.
+func checkBounds(t torrentproto.Torrent, chunkNum int, offset int64, length int) error {
+    end := offset + int64(length)
+    if offset < 0 || length <= 0 || end > int64(t.FileSize) {
+        return fmt.Errorf("Chunk %d covers bytes [%d, %d), outside the file's %d bytes", chunkNum, offset, end, t.FileSize)
+    }
+    return nil
+}
+
+// NewHash returns a new hash which uses the Torrent's hash algorithm.
+// Throws an error if the algorithm is not known.
+func NewHash(t torrentproto.Torrent) (hash.Hash, error) {
+    switch t.HashAlgo {
+    case torrentproto.SHA1:
+        return sha1.New(), nil
+    case torrentproto.SHA256:
+        return sha256.New(), nil
+    default:
+        return nil, errors.New("Unknown hash algorithm")
+    }
+}
+
+// FileHash returns the hash of the entire contents of the given file, in the
+// same form as the Hash in the Torrent's ID.
+// The file is streamed through the hash, rather than read into memory.
+func FileHash(t torrentproto.Torrent, file *os.File) (string, error) {
+    fi, err := file.Stat()
+    if err != nil {
+        // Failed to get information about the file.
+        return "", err
+    }
+
+    h, err := NewHash(t)
+    if err != nil {
+        return "", err
+    }
+    if _, err := io.Copy(h, io.NewSectionReader(file, 0, fi.Size())); err != nil {
+        // Failed to read file contents.
+        return "", err
+    }
+    return string(h.Sum(nil)), nil
+}
+
+// VerifyFile checks every chunk of the given file against its hash in the
+// Torrent, and checks that the file is the size the Torrent describes.
+// Chunks are read and hashed one at a time, so large files are not read into
+// memory.
+// Returns a non-nil error describing the first problem found.
+func VerifyFile(t torrentproto.Torrent, file *os.File) error {
+    fi, err := file.Stat()
+    if err != nil {
+        // Failed to get information about the file.
+        return err
+    } else if fi.Size() != int64(t.FileSize) {
+        return fmt.Errorf("File is %d bytes, but the torrent describes %d", fi.Size(), t.FileSize)
+    }
+
+    h, err := NewHash(t)
+    if err != nil {
+        return err
+    }
+    for chunkNum := 0; chunkNum < NumChunks(t); chunkNum++ {
+        chunk, err := ReadChunk(t, file, chunkNum)
+        if err != nil {
+            return err
+        }
+        h.Reset()
+        h.Write(chunk)
+        if string(h.Sum(nil)) != t.ChunkHashes[chunkNum] {
+            return fmt.Errorf("Chunk %d does not match its hash in the torrent", chunkNum)
+        }
+    }
+    return nil
+}
+
+// ChunkOffset returns the offset of the first byte of the given chunk within
+// the Torrent's data.
+// Every chunk but the last is ChunkSize bytes long, so chunks start at
+// multiples of ChunkSize; this is the one place where that is computed.
+// It does not check that the chunk number is valid; use ChunkBounds for that.
+func ChunkOffset(t torrentproto.Torrent, chunkNum int) int64 {
+    return int64(chunkNum) * int64(t.ChunkSize)
 }
 
 // ChunkBounds returns the start and length of the given chunk.
 // Returns a non-nil error if the chunk number is invalid for this Torrent.
 func ChunkBounds(t torrentproto.Torrent, chunkNum int) (int, int, error) {
-    var start, length int
-    start = chunkNum * t.ChunkSize
-    if t.ChunkSize < t.FileSize - start {
+    var length int
+    start := ChunkOffset(t, chunkNum)
+    remaining := int64(t.FileSize) - start
+    if int64(t.ChunkSize) < remaining {
         length = t.ChunkSize
     } else {
-        length = t.FileSize - start
+        length = int(remaining)
     }
 
     // Determine whether we're out of bounds.
-    if start < 0 || t.FileSize <= start {
+    if start < 0 || remaining <= 0 {
         return 0, 0, errors.New("Cannot get chunk: bad chunk number")
     } else {
-        return start, length, nil
+        return int(start), length, nil
     }
 }
 
 // String converts the given torrent to a human-readable string representation.
 func String(t torrentproto.Torrent) string {
     fields := make([]string, 0)
-    fields = append(fields, fmt.Sprintf("ID: {Name: %s, Hash: %s}", t.ID.Name, t.ID.Hash))
+    fields = append(fields, fmt.Sprintf("ID: %s", t.ID))
     fields = append(fields, fmt.Sprintf("File Size: %d", t.FileSize))
     fields = append(fields, fmt.Sprintf("Chunk Size: %d", t.ChunkSize))
 
@@ -252,5 +639,14 @@ func String(t torrentproto.Torrent) string {
     }
     fields = append(fields, strings.Join(trackerNodes, "\n\t"))    
 
+    if len(t.Files) > 0 {
+        files := make([]string, 0)
+        files = append(files, "Files")
+        for _, f := range t.Files {
+            files = append(files, fmt.Sprintf("%s: %d", f.Path, f.Size))
+        }
+        fields = append(fields, strings.Join(files, "\n\t"))
+    }
+
     return strings.Join(fields, "\n")
 }
@@ -2,17 +2,39 @@
 
 package torrentproto
 
+import (
+    "encoding/hex"
+    "fmt"
+)
+
+// The algorithm used to hash a Torrent's file and chunks.
+type HashAlgo int
+
+const (
+    SHA1 HashAlgo = iota // The default, for Torrents which do not name one
+    SHA256
+)
+
 // Information about one node in a tracker.
 type TrackerNode struct {
     HostPort string
+    Weight int // How strongly Clients should prefer this node (e.g. for being
+               // near them); higher is preferred, and 0 is no preference
 }
 
 // A Key which uniquely identifies a Torrent.
 // It has the form <name, file_hash>.
 type ID struct {
     Name string // A human-readable name for this Torrent
-    Hash string // The string representation of the SHA-1
-                // hash of the file associated with the Torrent
+    Hash string // The string representation of the hash of the
+                // file associated with the Torrent
+}
+
+// String returns the canonical form of this ID: its name, then its hash in
+// hex, separated by a colon.
+// Two IDs are equal exactly when their canonical forms are.
+func (id ID) String() string {
+    return id.Name + ":" + hex.EncodeToString([]byte(id.Hash))
 }
 
 // An identifier for a chunk within a torrent.
@@ -21,12 +43,44 @@ type ChunkID struct {
     ChunkNum int
 }
 
+// Less reports whether this ID sorts before other: by Name, then by Hash.
+func (id ID) Less(other ID) bool {
+    if id.Name != other.Name {
+        return id.Name < other.Name
+    }
+    return id.Hash < other.Hash
+}
+
+// String returns the canonical form of this chunk's torrent ID, then its
+// chunk number, separated by a '#'.
+func (c ChunkID) String() string {
+    return fmt.Sprintf("%s#%d", c.ID, c.ChunkNum)
+}
+
+// NewChunkID returns the identifier for the chunk with the given number within
+// the torrent with the given ID.
+func NewChunkID(id ID, chunkNum int) ChunkID {
+    return ChunkID {
+        ID: id,
+        ChunkNum: chunkNum}
+}
+
+// One of the files of a Torrent which holds several files.
+type File struct {
+    Path string // The file's path, relative to the directory holding the files
+    Size int // The file's size in bytes
+}
+
 // A deserialized .torrent file.
 // Contains information about how to fetch 
 type Torrent struct {
     ID
-    ChunkHashes map[int]string // Map from ChunkNums -> string(sha1 hash)
+    ChunkHashes map[int]string // Map from ChunkNums -> string(hash)
     TrackerNodes []TrackerNode // The nodes in the tracker with which this torrent is registered
     ChunkSize int
     FileSize int
+    HashAlgo HashAlgo // The algorithm for the file hash and ChunkHashes
+    Files []File // For a Torrent of several files, the files whose contents,
+                 // laid end to end, make up its FileSize bytes; nil for a
+                 // Torrent of one file
 }
@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// connListener is a net.Listener which remembers the connections it accepts,
+// so that closing it also closes every connection made through it.
+// This lets a TrackerServer cut off clients and other nodes when it shuts
+// down, as if its process had died.
+type connListener struct {
+	net.Listener
+	mut    sync.Mutex
+	conns  map[net.Conn](struct{})
+	closed bool
+}
+
+func newConnListener(ln net.Listener) *connListener {
+	return &connListener{
+		Listener: ln,
+		conns:    make(map[net.Conn](struct{}))}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.closed {
+		// Raced with Close
+		conn.Close()
+		return nil, errors.New("Listener closed")
+	}
+	l.conns[conn] = struct{}{}
+	return &trackedConn{Conn: conn, l: l}, nil
+}
+
+// Close stops accepting connections, and closes every open connection.
+func (l *connListener) Close() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.closed = true
+	for conn, _ := range l.conns {
+		conn.Close()
+	}
+	l.conns = make(map[net.Conn](struct{}))
+	return l.Listener.Close()
+}
+
+// trackedConn forgets itself in its listener when it is closed.
+type trackedConn struct {
+	net.Conn
+	l *connListener
+}
+
+func (c *trackedConn) Close() error {
+	c.l.mut.Lock()
+	delete(c.l.conns, c.Conn)
+	c.l.mut.Unlock()
+	return c.Conn.Close()
+}
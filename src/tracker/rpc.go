@@ -1,39 +1,208 @@
 package tracker
 
 import (
+	"time"
+
 	"tracker/trackerproto"
 )
 
 // These are the functions that Trackers will call on each other
 type PaxosTracker interface {
 	RegisterServer(*trackerproto.RegisterArgs, *trackerproto.RegisterReply) error
+	AddNode(*trackerproto.AddNodeArgs, *trackerproto.AddNodeReply) error
+	RemoveNode(*trackerproto.RemoveNodeArgs, *trackerproto.UpdateReply) error
 	GetOp(*trackerproto.GetArgs, *trackerproto.GetReply) error
+	GetSnapshot(*trackerproto.SnapshotArgs, *trackerproto.SnapshotReply) error
 	Prepare(*trackerproto.PrepareArgs, *trackerproto.PrepareReply) error
 	Accept(*trackerproto.AcceptArgs, *trackerproto.AcceptReply) error
 	Commit(*trackerproto.CommitArgs, *trackerproto.CommitReply) error
+	Forward(*trackerproto.ForwardArgs, *trackerproto.ForwardReply) error
+	ExportTorrents(*trackerproto.ExportArgs, *trackerproto.ExportReply) error
+	ImportTorrents(*trackerproto.ImportArgs, *trackerproto.ImportReply) error
 }
 
 // These are the functions that Clients will call on Trackers
 type RemoteTracker interface {
 	ReportMissing(*trackerproto.ReportArgs, *trackerproto.UpdateReply) error
+	ReportBadChunk(*trackerproto.BadChunkArgs, *trackerproto.UpdateReply) error
 	ConfirmChunk(*trackerproto.ConfirmArgs, *trackerproto.UpdateReply) error
+	ConfirmChunks(*trackerproto.ConfirmChunksArgs, *trackerproto.UpdateReply) error
 	RequestChunk(*trackerproto.RequestArgs, *trackerproto.RequestReply) error
+	PeerHasChunk(*trackerproto.HasArgs, *trackerproto.HasReply) error
+	Availability(*trackerproto.AvailabilityArgs, *trackerproto.AvailabilityReply) error
+	WatchChunk(*trackerproto.WatchArgs, *trackerproto.WatchReply) error
 	CreateEntry(*trackerproto.CreateArgs, *trackerproto.UpdateReply) error
+	DeleteEntry(*trackerproto.DeleteArgs, *trackerproto.UpdateReply) error
+	EvictPeer(*trackerproto.EvictArgs, *trackerproto.UpdateReply) error
 	GetTrackers(*trackerproto.TrackersArgs, *trackerproto.TrackersReply) error
+	ListTorrents(*trackerproto.ListArgs, *trackerproto.ListReply) error
+	Stats(*trackerproto.StatsArgs, *trackerproto.StatsReply) error
+	Ping(*trackerproto.PingArgs, *trackerproto.PingReply) error
 }
 
+// RPCHook observes the RPCs handled by a tracker, e.g. for metrics or tracing.
+// It is called as each RPC returns, with the RPC's name, how long it took to
+// handle, and the status of its reply.
+type RPCHook func(method string, latency time.Duration, status trackerproto.Status)
+
 type WrappedPaxosTracker struct {
 	PaxosTracker
+	hook RPCHook
 }
 
 type WrappedRemoteTracker struct {
 	RemoteTracker
+	hook RPCHook
+}
+
+// WrapPaxos wraps pt so that it handles only the PaxosTracker RPCs.
+// If hook is not nil, it is called for every RPC.
+func WrapPaxos(pt PaxosTracker, hook RPCHook) PaxosTracker {
+	return &WrappedPaxosTracker{pt, hook}
+}
+
+// WrapRemote wraps t so that it handles only the RemoteTracker RPCs.
+// If hook is not nil, it is called for every RPC.
+func WrapRemote(t RemoteTracker, hook RPCHook) RemoteTracker {
+	return &WrappedRemoteTracker{t, hook}
+}
+
+// observe reports an RPC which started at start to hook, if there is one.
+// It should be deferred, so that status is read once the RPC has returned.
+func observe(hook RPCHook, method string, start time.Time, status *trackerproto.Status) {
+	if hook != nil {
+		hook(method, time.Since(start), *status)
+	}
+}
+
+func (w *WrappedPaxosTracker) RegisterServer(args *trackerproto.RegisterArgs, reply *trackerproto.RegisterReply) error {
+	defer observe(w.hook, "RegisterServer", time.Now(), &reply.Status)
+	return w.PaxosTracker.RegisterServer(args, reply)
+}
+
+func (w *WrappedPaxosTracker) AddNode(args *trackerproto.AddNodeArgs, reply *trackerproto.AddNodeReply) error {
+	defer observe(w.hook, "AddNode", time.Now(), &reply.Status)
+	return w.PaxosTracker.AddNode(args, reply)
+}
+
+func (w *WrappedPaxosTracker) RemoveNode(args *trackerproto.RemoveNodeArgs, reply *trackerproto.UpdateReply) error {
+	defer observe(w.hook, "RemoveNode", time.Now(), &reply.Status)
+	return w.PaxosTracker.RemoveNode(args, reply)
+}
+
+func (w *WrappedPaxosTracker) GetOp(args *trackerproto.GetArgs, reply *trackerproto.GetReply) error {
+	defer observe(w.hook, "GetOp", time.Now(), &reply.Status)
+	return w.PaxosTracker.GetOp(args, reply)
+}
+
+func (w *WrappedPaxosTracker) GetSnapshot(args *trackerproto.SnapshotArgs, reply *trackerproto.SnapshotReply) error {
+	defer observe(w.hook, "GetSnapshot", time.Now(), &reply.Status)
+	return w.PaxosTracker.GetSnapshot(args, reply)
+}
+
+func (w *WrappedPaxosTracker) Prepare(args *trackerproto.PrepareArgs, reply *trackerproto.PrepareReply) error {
+	defer observe(w.hook, "Prepare", time.Now(), &reply.Status)
+	return w.PaxosTracker.Prepare(args, reply)
+}
+
+func (w *WrappedPaxosTracker) Accept(args *trackerproto.AcceptArgs, reply *trackerproto.AcceptReply) error {
+	defer observe(w.hook, "Accept", time.Now(), &reply.Status)
+	return w.PaxosTracker.Accept(args, reply)
+}
+
+func (w *WrappedPaxosTracker) Commit(args *trackerproto.CommitArgs, reply *trackerproto.CommitReply) error {
+	// Commits have no status, and always succeed
+	status := trackerproto.OK
+	defer observe(w.hook, "Commit", time.Now(), &status)
+	return w.PaxosTracker.Commit(args, reply)
+}
+
+func (w *WrappedPaxosTracker) Forward(args *trackerproto.ForwardArgs, reply *trackerproto.ForwardReply) error {
+	defer observe(w.hook, "Forward", time.Now(), &reply.Status)
+	return w.PaxosTracker.Forward(args, reply)
+}
+
+func (w *WrappedPaxosTracker) ExportTorrents(args *trackerproto.ExportArgs, reply *trackerproto.ExportReply) error {
+	defer observe(w.hook, "ExportTorrents", time.Now(), &reply.Status)
+	return w.PaxosTracker.ExportTorrents(args, reply)
+}
+
+func (w *WrappedPaxosTracker) ImportTorrents(args *trackerproto.ImportArgs, reply *trackerproto.ImportReply) error {
+	defer observe(w.hook, "ImportTorrents", time.Now(), &reply.Status)
+	return w.PaxosTracker.ImportTorrents(args, reply)
+}
+
+func (w *WrappedRemoteTracker) ReportMissing(args *trackerproto.ReportArgs, reply *trackerproto.UpdateReply) error {
+	defer observe(w.hook, "ReportMissing", time.Now(), &reply.Status)
+	return w.RemoteTracker.ReportMissing(args, reply)
+}
+
+func (w *WrappedRemoteTracker) ReportBadChunk(args *trackerproto.BadChunkArgs, reply *trackerproto.UpdateReply) error {
+	defer observe(w.hook, "ReportBadChunk", time.Now(), &reply.Status)
+	return w.RemoteTracker.ReportBadChunk(args, reply)
+}
+
+func (w *WrappedRemoteTracker) ConfirmChunk(args *trackerproto.ConfirmArgs, reply *trackerproto.UpdateReply) error {
+	defer observe(w.hook, "ConfirmChunk", time.Now(), &reply.Status)
+	return w.RemoteTracker.ConfirmChunk(args, reply)
+}
+
+func (w *WrappedRemoteTracker) ConfirmChunks(args *trackerproto.ConfirmChunksArgs, reply *trackerproto.UpdateReply) error {
+	defer observe(w.hook, "ConfirmChunks", time.Now(), &reply.Status)
+	return w.RemoteTracker.ConfirmChunks(args, reply)
+}
+
+func (w *WrappedRemoteTracker) RequestChunk(args *trackerproto.RequestArgs, reply *trackerproto.RequestReply) error {
+	defer observe(w.hook, "RequestChunk", time.Now(), &reply.Status)
+	return w.RemoteTracker.RequestChunk(args, reply)
+}
+
+func (w *WrappedRemoteTracker) PeerHasChunk(args *trackerproto.HasArgs, reply *trackerproto.HasReply) error {
+	defer observe(w.hook, "PeerHasChunk", time.Now(), &reply.Status)
+	return w.RemoteTracker.PeerHasChunk(args, reply)
+}
+
+func (w *WrappedRemoteTracker) Availability(args *trackerproto.AvailabilityArgs, reply *trackerproto.AvailabilityReply) error {
+	defer observe(w.hook, "Availability", time.Now(), &reply.Status)
+	return w.RemoteTracker.Availability(args, reply)
+}
+
+func (w *WrappedRemoteTracker) WatchChunk(args *trackerproto.WatchArgs, reply *trackerproto.WatchReply) error {
+	defer observe(w.hook, "WatchChunk", time.Now(), &reply.Status)
+	return w.RemoteTracker.WatchChunk(args, reply)
+}
+
+func (w *WrappedRemoteTracker) CreateEntry(args *trackerproto.CreateArgs, reply *trackerproto.UpdateReply) error {
+	defer observe(w.hook, "CreateEntry", time.Now(), &reply.Status)
+	return w.RemoteTracker.CreateEntry(args, reply)
+}
+
+func (w *WrappedRemoteTracker) DeleteEntry(args *trackerproto.DeleteArgs, reply *trackerproto.UpdateReply) error {
+	defer observe(w.hook, "DeleteEntry", time.Now(), &reply.Status)
+	return w.RemoteTracker.DeleteEntry(args, reply)
+}
+
+func (w *WrappedRemoteTracker) EvictPeer(args *trackerproto.EvictArgs, reply *trackerproto.UpdateReply) error {
+	defer observe(w.hook, "EvictPeer", time.Now(), &reply.Status)
+	return w.RemoteTracker.EvictPeer(args, reply)
+}
+
+func (w *WrappedRemoteTracker) GetTrackers(args *trackerproto.TrackersArgs, reply *trackerproto.TrackersReply) error {
+	defer observe(w.hook, "GetTrackers", time.Now(), &reply.Status)
+	return w.RemoteTracker.GetTrackers(args, reply)
+}
+
+func (w *WrappedRemoteTracker) ListTorrents(args *trackerproto.ListArgs, reply *trackerproto.ListReply) error {
+	defer observe(w.hook, "ListTorrents", time.Now(), &reply.Status)
+	return w.RemoteTracker.ListTorrents(args, reply)
 }
 
-func WrapPaxos(pt PaxosTracker) PaxosTracker {
-	return &WrappedPaxosTracker{pt}
+func (w *WrappedRemoteTracker) Stats(args *trackerproto.StatsArgs, reply *trackerproto.StatsReply) error {
+	defer observe(w.hook, "Stats", time.Now(), &reply.Status)
+	return w.RemoteTracker.Stats(args, reply)
 }
 
-func WrapRemote(t RemoteTracker) RemoteTracker {
-	return &WrappedRemoteTracker{t}
+func (w *WrappedRemoteTracker) Ping(args *trackerproto.PingArgs, reply *trackerproto.PingReply) error {
+	defer observe(w.hook, "Ping", time.Now(), &reply.Status)
+	return w.RemoteTracker.Ping(args, reply)
 }
@@ -1,6 +1,9 @@
 package tracker
 
-import "tracker/trackerproto"
+import (
+	"torrent/torrentproto"
+	"tracker/trackerproto"
+)
 
 type Tracker interface {
 	// RegisterServer adds a Tracker to the Paxos cluster.
@@ -8,32 +11,101 @@ type Tracker interface {
 	// Returns status:
 	// - OK: If everything worked
 	// - NotReady: If the cluster is still setting up
+	// - DuplicateID: If a tracker with a different host:port has already
+	//   registered with the same NodeID
+	// - NotAuthorized: If Key does not match this tracker's cluster key
 	RegisterServer(*trackerproto.RegisterArgs, *trackerproto.RegisterReply) error
 
+	// AddNode adds the Tracker at HostPort to the running Paxos cluster, as a
+	// new node, once a majority of the cluster's nodes agree. Every node then
+	// counts the new node in its majorities, and lists it in GetTrackers.
+	// Replies with the new node's NodeID, and a list of all nodes in the
+	// cluster, including the new one, which catches up on the operations
+	// committed before it joined.
+	// Adding a node which is already in the cluster changes nothing.
+	// Blocks until the addition has been committed
+	// Returns status:
+	// - OK: If the node is in the cluster
+	// - ReadOnly: This tracker is an observer
+	// - NotAuthorized: If Key does not match this tracker's cluster key
+	// - ServerClosing: The tracker shut down before the addition was
+	//   committed (the rest of the cluster may still commit it)
+	AddNode(*trackerproto.AddNodeArgs, *trackerproto.AddNodeReply) error
+
+	// RemoveNode removes the Tracker at HostPort from the Paxos cluster for
+	// good, e.g. because it has died, once a majority of the cluster's nodes
+	// agree. Every node then counts its majorities over the nodes which are
+	// left, so a cluster which has lost a node stops needing it.
+	// Torrents which list the removed node are still served by the others.
+	// A node which learns that it has been removed shuts down.
+	// A node is only removed if a majority of the nodes which would be left
+	// answer, so that the cluster can still commit afterwards.
+	// Blocks until the removal has been committed
+	// Returns status:
+	// - OK: If the node has been removed
+	// - UnknownNode: If the node is not in the cluster
+	// - TooFewNodes: If the node is the only one in the cluster, or too few
+	//   of the nodes which would be left are answering to form a majority
+	// - NotAuthorized: If the tracker has no admin key, or AdminKey does not
+	//   match it
+	// - ReadOnly: This tracker is an observer
+	// - ServerClosing: The tracker shut down before the removal was
+	//   committed (the rest of the cluster may still commit it)
+	RemoveNode(*trackerproto.RemoveNodeArgs, *trackerproto.UpdateReply) error
+
 	// GetOp returns the operation processed at the requested SeqNum
+	// Always replies with the range [MinSeq, MaxSeq) of SeqNums available
 	// Returns status:
 	// - OK: If everything worked
 	// - OutOfDate: If the server does not have that SeqNum in the log
 	GetOp(*trackerproto.GetArgs, *trackerproto.GetReply) error
 
 	// Prepare returns:
-	// - <Reject, _, _> : If PaxNum < Highest PaxNum seen
+	// - <Reject, N, _> : If PaxNum < Highest PaxNum seen (N is the highest PaxNum seen),
+	//                    or if this tracker is an observer, which never votes
 	// - <OutOfDate, _, V> : If SeqNum < current SeqNum
         //                       V is the value committed at that point in the sequence
 	// - <OK, N, V> : If PaxNum >= Highest PaxNum seen
 	//                (N,V) is (PaxNum, Value) pair of highest accepted proposal
+	// - <NotAuthorized> : If Key does not match this tracker's cluster key
 	Prepare(*trackerproto.PrepareArgs, *trackerproto.PrepareReply) error
 
 	// Accept returns:
-	// - <Reject> : If PaxNum < Highest PaxNum seen
+	// - <Reject> : If PaxNum < Highest PaxNum seen, or this tracker is an observer
 	// - <OutOfDate> : If SeqNum < current SeqNum
+	// - <NotAuthorized> : If Key does not match this tracker's cluster key
 	// - <OK> : Otherwise (everything went well)
 	Accept(*trackerproto.AcceptArgs, *trackerproto.AcceptReply) error
 
 	// Commits a change to local memory.
-	// Does not return anything
+	// Returns status OK, or NotAuthorized, without committing the change, if
+	// Key does not match this tracker's cluster key
 	Commit(*trackerproto.CommitArgs, *trackerproto.CommitReply) error
 
+	// ExportTorrents returns every torrent registered with the cluster, sorted
+	// by ID, without their peers, e.g. to seed another cluster's catalog.
+	// Returns status OK
+	ExportTorrents(*trackerproto.ExportArgs, *trackerproto.ExportReply) error
+
+	// ImportTorrents creates each of the given torrents, in order, as
+	// CreateEntry does (with no HostPort).
+	// Torrents whose ID is already in use are skipped, so importing the same
+	// torrents again changes nothing. Torrents which do not list exactly this
+	// cluster's nodes, are not valid, or have too many chunks, are rejected,
+	// and the rest are still imported.
+	// The reply lists the IDs of the torrents which were created, skipped and
+	// rejected.
+	// Blocks until every creation has been committed
+	// Returns status:
+	// - OK: If every torrent was created, skipped or rejected
+	// - ReadOnly: This tracker is an observer
+	// - NotAuthorized: The tracker has a client token, and Token does not match it
+	// - ServerClosing: The tracker shut down before every creation was
+	//   committed
+	// On an error, the reply lists what happened to the torrents before the
+	// one which failed, and the rest are not imported.
+	ImportTorrents(*trackerproto.ImportArgs, *trackerproto.ImportReply) error
+
 	// ReportMissing allows the Client to inform the Tracker when it
 	// does not possess a chunk that other Clients think it has.
 	// This function will block until the Paxos ring has acknoledged the change.
@@ -41,37 +113,226 @@ type Tracker interface {
 	// - OK: If everything is good
 	// - FileNotFound: ID is not a valid file
 	// - OutOfRange: The chunk number was to high (or negative)
+	// - ReadOnly: This tracker is an observer
+	// - NotAuthorized: The tracker has a client token, and Token does not match it
+	// - RateLimited: The client has sent more updates in the last second than
+	//   the tracker allows
+	// - ServerClosing: The tracker shut down before the change was committed
+	//   (the rest of the cluster may still commit it)
 	ReportMissing(*trackerproto.ReportArgs, *trackerproto.UpdateReply) error
 
+	// ReportBadChunk allows a Client to inform the Tracker that the peer at
+	// HostPort sent it a copy of a chunk which did not match the chunk's hash.
+	// Once BadChunkReports distinct Clients (see TrackerConfig) have reported
+	// the same peer for the chunk, the peer is dropped from the chunk, as if
+	// it had reported the chunk missing. Reports about a client which is not
+	// a peer for the chunk, or by the peer itself, are ignored.
+	// This function will block until the Paxos ring has acknoledged the report.
+	// Returns status:
+	// - OK: If everything is good (Removed says whether the peer was dropped)
+	// - FileNotFound: ID is not a valid file
+	// - OutOfRange: The chunk number was to high (or negative)
+	// - ReadOnly: This tracker is an observer
+	// - NotAuthorized: The tracker has a client token, and Token does not match it
+	// - RateLimited: The client has sent more updates in the last second than
+	//   the tracker allows
+	// - ServerClosing: The tracker shut down before the report was committed
+	ReportBadChunk(*trackerproto.BadChunkArgs, *trackerproto.UpdateReply) error
+
 	// ConfirmChunk allows the Client to inform the Tracker when it
 	// comes into possession of the a chunk.
+	// If the tracker has a peer TTL (see TrackerConfig.PeerTTL), the Client
+	// stops being a peer for the chunk once the TTL has passed since it last
+	// confirmed it, so a Client must confirm its chunks again to stay listed.
 	// This function will block until the Paxos ring has acknoweledged the change
 	// Returns status:
 	// - OK: If everything is good
 	// - FileNotFound: ID is not a valid file
 	// - OutOfRange: The chunk number was to high (or negative)
+	// - ReadOnly: This tracker is an observer
+	// - NotAuthorized: The tracker has a client token, and Token does not match it
+	// - RateLimited: The client has sent more updates in the last second than
+	//   the tracker allows
+	// - ServerClosing: The tracker shut down before the change was committed
+	//   (the rest of the cluster may still commit it)
 	ConfirmChunk(*trackerproto.ConfirmArgs, *trackerproto.UpdateReply) error
 
+	// ConfirmChunks confirms several chunks of one torrent at once, as
+	// ConfirmChunk does for each, so that a Client offering a file does not
+	// make a call per chunk. The chunks are proposed together, so they are
+	// committed in as few Paxos rounds as possible.
+	// This function will block until every chunk has been committed
+	// Returns status:
+	// - OK: If everything is good
+	// - FileNotFound: ID is not a valid file
+	// - OutOfRange: A chunk number was too high (or negative); none of the
+	//   chunks are confirmed
+	// - ReadOnly: This tracker is an observer
+	// - NotAuthorized: The tracker has a client token, and Token does not match it
+	// - RateLimited: The client has sent more updates in the last second than
+	//   the tracker allows
+	// - ServerClosing: The tracker shut down before every chunk was committed
+	//   (the rest of the cluster may still commit them)
+	ConfirmChunks(*trackerproto.ConfirmChunksArgs, *trackerproto.UpdateReply) error
+
 	// RequestChunk returns a slice of peers with the requested chunk for the file
+	// The peers are shuffled on each call, to spread load across them, unless
+	// the args ask for them Sorted, in which case the same peers are always
+	// listed in the same order.
+	// If the args set MaxPeers, at most that many peers are listed: a random
+	// subset of the chunk's peers when they are shuffled, or the first of
+	// them when they are sorted. Seeders and Partial split the listed peers.
+	// The reply carries the number of operations the node has committed, so a
+	// node which is behind the rest of the cluster may answer with out of date
+	// peers, but it will also answer with a lower SeqNum than the other nodes.
 	// Returns status:
 	// - OK: If everything is good
 	// - FileNotFound: ID is not a valid file
 	// - OutOfRange: The chunk number was too high (or negative)
 	RequestChunk(*trackerproto.RequestArgs, *trackerproto.RequestReply) error
 
+	// RequestChunkLocal answers the same as RequestChunk, for callers in the
+	// same process which hold this Tracker (e.g. a Client embedded with it),
+	// without going through RPC.
+	// Returns an error if the tracker has shut down.
+	RequestChunkLocal(torrentproto.ChunkID) (*trackerproto.RequestReply, error)
+
+	// PeerHasChunk tells whether the peer at the given host:port has the
+	// requested chunk, without listing the other peers which have it.
+	// Returns status:
+	// - OK: If everything is good
+	// - FileNotFound: ID is not a valid file
+	// - OutOfRange: The chunk number was too high (or negative)
+	PeerHasChunk(*trackerproto.HasArgs, *trackerproto.HasReply) error
+
+	// Availability summarises how much of a torrent its peers have: how many
+	// of its chunks have at least one peer, out of how many chunks in total,
+	// and the fewest peers any one chunk has (0 if some chunk has no peer).
+	// It also counts the peers of every chunk, e.g. so that a client can fetch
+	// the rarest chunks first without asking for each chunk's peers.
+	// Returns status:
+	// - OK: If everything is good
+	// - FileNotFound: ID is not a valid file
+	Availability(*trackerproto.AvailabilityArgs, *trackerproto.AvailabilityReply) error
+
+	// WatchChunk waits until a peer confirms that it has the requested chunk,
+	// and replies with that peer.
+	// Blocks until a peer confirms the chunk, or for at most WATCH_TIMEOUT seconds.
+	// Returns status:
+	// - OK: If a peer confirmed the chunk
+	// - FileNotFound: ID is not a valid file
+	// - OutOfRange: The chunk number was too high (or negative)
+	// - Timeout: No peer confirmed the chunk in time
+	// - Busy: The tracker is already handling too many WatchChunk calls
+	WatchChunk(*trackerproto.WatchArgs, *trackerproto.WatchReply) error
+
 	// CreateEntry creates an entry on the tracker for a new torrent.
+	// The torrent must list every node in the cluster, but may list them in
+	// any order, and with any weights.
 	// Blocks until the option has been committed
 	// Returns status:
 	// - OK: If an entry was successfully created for the torrent with the
 	//   given ID
-	// - InvalidID: If there is already a torrent with this ID
+	// - InvalidID: If there is already a torrent with this ID. Digest is set
+	//   to the torrent.Digest of that torrent, so that the caller can tell
+	//   whether it splits the file into the same chunks as the one it gave.
+	//   If several creates for one ID race, the first to commit wins and the
+	//   rest get InvalidID
 	// - InvalidTrackers: If the supplied list of trackers does not match the cluster
+	// - InvalidTorrent: If the torrent has no positive ChunkSize, a negative
+	//   FileSize, Files which do not add up to its FileSize, or does not have
+	//   a hash for exactly each of the chunks its FileSize and ChunkSize split
+	//   it into
+	// - TooManyChunks: If the torrent has more chunks than the tracker allows
+	//   (see TrackerConfig.MaxChunks)
+	// - TooManyTorrents: If the tracker limits how many torrents each client
+	//   may create, and the client at HostPort has reached that limit
+	//   (clients which do not give a HostPort share one limit)
+	// - ReadOnly: This tracker is an observer
+	// - NotAuthorized: The tracker has a client token, and Token does not match it
+	// - RateLimited: The client has sent more updates in the last second than
+	//   the tracker allows
+	// - ServerClosing: The tracker shut down before the change was committed
+	//   (the rest of the cluster may still commit it)
 	CreateEntry(*trackerproto.CreateArgs, *trackerproto.UpdateReply) error
 
+	// DeleteEntry removes the torrent with the given ID from the tracker,
+	// along with the peers of all of its chunks. Anyone waiting on one of its
+	// chunks in WatchChunk gets FileNotFound.
+	// The ID may then be used by CreateEntry again.
+	// Blocks until the operation has been committed
+	// Returns status:
+	// - OK: If the torrent was removed
+	// - FileNotFound: If no torrent has the ID (e.g. another DeleteEntry for
+	//   it was committed first)
+	// - ReadOnly: This tracker is an observer
+	// - NotAuthorized: The tracker has a client token, and Token does not match it
+	// - ServerClosing: The tracker shut down before the change was committed
+	//   (the rest of the cluster may still commit it)
+	DeleteEntry(*trackerproto.DeleteArgs, *trackerproto.UpdateReply) error
+
+	// EvictPeer removes the client at HostPort from every chunk of every
+	// torrent, e.g. to remove a misbehaving client from all swarms at once.
+	// It is an admin RPC, so AdminKey must match the tracker's admin key.
+	// Blocks until the operation has been committed
+	// Reply.Removed is the number of chunks the client was removed from
+	// Returns status:
+	// - OK: If the client is no longer a peer for any chunk
+	// - NotAuthorized: If the tracker has no admin key, or AdminKey does not
+	//   match it
+	// - ReadOnly: This tracker is an observer
+	// - ServerClosing: The tracker shut down before the change was committed
+	//   (the rest of the cluster may still commit it)
+	EvictPeer(*trackerproto.EvictArgs, *trackerproto.UpdateReply) error
+
 	// GetTrackers returns a list of all trackers in the cluster
 	// Returns status OK, unless something went horribly wrong
 	GetTrackers(*trackerproto.TrackersArgs, *trackerproto.TrackersReply) error
 
+	// ListTorrents returns the ID, file size and chunk size of every torrent
+	// registered with the tracker, sorted by ID, so that clients can browse
+	// what is available.
+	// It reads this node's committed state, so may briefly miss a torrent
+	// which another node has just created.
+	// Returns status OK
+	ListTorrents(*trackerproto.ListArgs, *trackerproto.ListReply) error
+
+	// Stats reports how this tracker's Paxos rounds have performed:
+	// how many rounds it has led to a commit, how long they took,
+	// and how many times it restarted a round after timing out
+	// (e.g. because of dueling leaders).
+	// A single-node tracker commits without Paxos, so reports no rounds.
+	// It also reports the state this tracker has committed: how many
+	// operations, torrents and peer entries, how many operations are waiting
+	// to be committed, and whether it is catching up with the rest of the
+	// cluster. A node whose SeqNum lags behind the others' is falling behind.
+	// Returns status OK
+	Stats(*trackerproto.StatsArgs, *trackerproto.StatsReply) error
+
+	// Ping checks that this tracker is alive and handling requests, more
+	// cheaply than Stats. Replies with the number of operations this node
+	// has committed, the time by this node's clock, and whether it is
+	// catching up with the rest of the cluster, so that clients can prefer
+	// a node which is not far behind.
+	// A node which is catching up fetches the operations it missed in the
+	// background, so it answers as usual in the meantime.
+	// Returns status OK
+	Ping(*trackerproto.PingArgs, *trackerproto.PingReply) error
+
+	// Shutdown stops the tracker.
+	// It stops handling RPCs, closes its connections to clients and to other
+	// trackers, and stops listening on its port.
+	// Updates still waiting on a Paxos round are answered with ServerClosing.
+	// Calling Shutdown more than once has no further effect.
+	Shutdown()
+
+	// Close stops the tracker gracefully.
+	// It answers new updates with ServerClosing, waits for those it is already
+	// handling to commit, and then shuts down as Shutdown does.
+	// Returns an error if it gave up waiting on some updates, which are then
+	// answered with ServerClosing.
+	Close() error
+
 	// Lets you stall a tracker
 	// If 0 is passed, the tracker is shut down
 	// Should only be used for testing
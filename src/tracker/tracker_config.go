@@ -0,0 +1,233 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// The port a tracker node listens on if it is not given one
+const DEFAULT_PORT = 9091
+
+// The host a tracker node advertises, and listens on, if it is not given one
+const DEFAULT_HOST = "localhost"
+
+// The most chunks a torrent may have if the tracker is not given a limit.
+// At the default chunk size of 1 MB, this allows files of about 1 TB.
+const DEFAULT_MAX_CHUNKS = 1 << 20
+
+// How many ops a node commits between snapshots if it is not given an interval
+const DEFAULT_SNAPSHOT_INTERVAL = 1000
+
+// How many clients must report a peer for a bad chunk if the tracker is not
+// given a threshold
+const DEFAULT_BAD_CHUNK_REPORTS = 3
+
+// How many times a node dials each other node before giving up on it, if it
+// is not given a number
+const DEFAULT_DIAL_RETRIES = 5
+
+// How long a node waits before redialing another node if it is not given a
+// backoff
+const DEFAULT_DIAL_BACKOFF = 100 * time.Millisecond
+
+// TrackerConfig holds the settings for a tracker node.
+// The zero value of each field is a sensible default, so a config only needs
+// the fields which differ from it: the zero config is a single-node cluster
+// listening on DEFAULT_PORT.
+type TrackerConfig struct {
+	// The host:port of the cluster's master, or "" if this node is the master
+	MasterHostPort string
+
+	// How many nodes are in the Paxos cluster; 0 means 1
+	NumNodes int
+
+	// This node's position in the cluster (each node should have a different
+	// id, 0 <= NodeID < NumNodes)
+	NodeID int
+
+	// The port to start this node on; 0 means DEFAULT_PORT
+	Port int
+
+	// The host name or IP address (IPv4 or IPv6, without brackets) at which
+	// the other nodes, and clients, can reach this node; "" means
+	// DEFAULT_HOST. The node gives the cluster Host and Port as its
+	// host:port, so a cluster which spans machines needs every node's Host
+	// to be reachable from the others.
+	Host string
+
+	// The host or IP address of the interface to listen on. If it and Host
+	// are both "", the node listens on DEFAULT_HOST only; if only it is "",
+	// the node listens on every interface, so that it can be reached at Host.
+	ListenHost string
+
+	// If not nil, called for every RPC this node handles
+	Hook RPCHook
+
+	// The most torrents one client (by host:port) may create; 0 means no limit.
+	// Torrents created by ImportTorrents do not count against any client.
+	MaxTorrents int
+
+	// The most chunks one torrent may have; 0 means DEFAULT_MAX_CHUNKS.
+	// A torrent has FileSize / ChunkSize chunks, rounded up, and the tracker
+	// keeps a set of peers for every chunk which is confirmed, so this bounds
+	// the memory one torrent can take, however small its chunks. It is
+	// separate from any limit on FileSize: a torrent of a small file with
+	// 1 byte chunks would pass a size check, but still have millions of
+	// chunks. To allow files up to some size, set this to that size divided
+	// by the smallest chunk size clients should use.
+	MaxChunks int
+
+	// If true, this node is a read-only observer of the cluster whose master
+	// is at MasterHostPort.
+	// It learns the cluster's size from the master, so NumNodes and NodeID
+	// are ignored.
+	Observer bool
+
+	// If true, this node joins the running cluster which the node at
+	// MasterHostPort is in, growing it by one node, rather than waiting for
+	// NumNodes nodes to form a new cluster. The cluster gives the node its
+	// NodeID, so NumNodes and NodeID are ignored.
+	Joining bool
+
+	// The key admin RPCs (e.g. EvictPeer) must give; "" disables them
+	AdminKey string
+
+	// The token clients must give with every update (CreateEntry,
+	// DeleteEntry, ImportTorrents, ConfirmChunk, ConfirmChunks, ReportMissing
+	// and ReportBadChunk); "" means updates need no token.
+	// Reads, such as RequestChunk, need no token.
+	ClientToken string
+
+	// The key nodes must give each other with the Paxos RPCs which change the
+	// cluster's state (RegisterServer, AddNode, Prepare, Accept, Commit and
+	// Forward); "" means they need no key. Every node in a cluster needs the
+	// same key. It should differ from ClientToken, so that clients cannot
+	// forge Paxos messages.
+	ClusterKey string
+
+	// The most updates one client (by host:port) may send per second; 0
+	// means no limit. Updates past the limit are refused with RateLimited
+	// before they are proposed, so that one client cannot crowd out the
+	// others' Paxos rounds. ConfirmChunk, ConfirmChunks, ReportMissing and
+	// CreateEntry count against the client at HostPort, and ReportBadChunk
+	// against its Reporter; a ConfirmChunks call counts once, however many
+	// chunks it confirms. Reads, and updates which do not name their client
+	// (e.g. ImportTorrents), are not limited.
+	MaxUpdateRate int
+
+	// How long a client stays a peer for a chunk after it last confirmed the
+	// chunk; 0 means peers never expire.
+	// Clients which keep serving a chunk are expected to confirm it again
+	// more often than this, so that dead clients stop being handed out by
+	// RequestChunk. Nodes sweep out expired peers through Paxos, so every
+	// node agrees on which peers are left; a node's sweeps are only as
+	// accurate as its clock, so the TTL should be much longer than the skew
+	// between nodes' clocks.
+	PeerTTL time.Duration
+
+	// How many ops a node commits between snapshots of its state; 0 means
+	// DEFAULT_SNAPSHOT_INTERVAL.
+	// A node only keeps the ops it has committed since its latest snapshot,
+	// so this bounds the size of its log. A node which falls further behind
+	// than that loads another node's snapshot, rather than replaying its ops.
+	SnapshotInterval int
+
+	// How many distinct clients (by host:port) must report that a peer sent
+	// them a copy of a chunk with the wrong hash before the peer is dropped
+	// from the chunk; 0 means DEFAULT_BAD_CHUNK_REPORTS.
+	// Requiring several reporters stops one malicious client from evicting
+	// honest peers. Reports are counted as they commit, so every node in a
+	// cluster should use the same threshold.
+	BadChunkReports int
+
+	// How many times this node dials each other node, once the cluster has
+	// formed or a node has joined it, before giving up on it; 0 means
+	// DEFAULT_DIAL_RETRIES.
+	// The other nodes may still be starting up when the cluster forms, so a
+	// node which cannot reach one of them at first tries again. If one stays
+	// unreachable while this node starts, this node fails to start, naming it.
+	DialRetries int
+
+	// How long this node waits before it first dials a node again; 0 means
+	// DEFAULT_DIAL_BACKOFF. The wait doubles after each failed attempt.
+	DialBackoff time.Duration
+}
+
+// WithDefaults returns cfg with the defaults filled in for its zero fields.
+// Returns an error describing the first invalid field, if there is one.
+func (cfg TrackerConfig) WithDefaults() (TrackerConfig, error) {
+	if cfg.NumNodes == 0 {
+		cfg.NumNodes = 1
+	}
+	if cfg.Port == 0 {
+		cfg.Port = DEFAULT_PORT
+	}
+	if cfg.Host == "" {
+		cfg.Host = DEFAULT_HOST
+		if cfg.ListenHost == "" {
+			cfg.ListenHost = DEFAULT_HOST
+		}
+	}
+	if cfg.MaxChunks == 0 {
+		cfg.MaxChunks = DEFAULT_MAX_CHUNKS
+	}
+	if cfg.SnapshotInterval == 0 {
+		cfg.SnapshotInterval = DEFAULT_SNAPSHOT_INTERVAL
+	}
+	if cfg.BadChunkReports == 0 {
+		cfg.BadChunkReports = DEFAULT_BAD_CHUNK_REPORTS
+	}
+	if cfg.DialRetries == 0 {
+		cfg.DialRetries = DEFAULT_DIAL_RETRIES
+	}
+	if cfg.DialBackoff == 0 {
+		cfg.DialBackoff = DEFAULT_DIAL_BACKOFF
+	}
+
+	if cfg.Observer && cfg.MasterHostPort == "" {
+		return cfg, errors.New("An observer needs a master to follow")
+	} else if cfg.Joining && cfg.MasterHostPort == "" {
+		return cfg, errors.New("A joining node needs a node of the cluster to join through")
+	} else if cfg.Joining && cfg.Observer {
+		return cfg, errors.New("An observer cannot join the cluster")
+	} else if cfg.NumNodes < 0 {
+		return cfg, fmt.Errorf("NumNodes must be at least 1, not %d", cfg.NumNodes)
+	} else if !cfg.Observer && !cfg.Joining && (cfg.NodeID < 0 || cfg.NodeID >= cfg.NumNodes) {
+		return cfg, fmt.Errorf("NodeID %d is out of range for a cluster of %d nodes", cfg.NodeID, cfg.NumNodes)
+	} else if cfg.Port < 0 || cfg.Port > 65535 {
+		return cfg, fmt.Errorf("Port %d is out of range", cfg.Port)
+	} else if !validHost(cfg.Host) {
+		return cfg, fmt.Errorf("Host %q should be a host name or IP address, without a port", cfg.Host)
+	} else if cfg.ListenHost != "" && !validHost(cfg.ListenHost) {
+		return cfg, fmt.Errorf("ListenHost %q should be a host name or IP address, without a port", cfg.ListenHost)
+	} else if cfg.MaxTorrents < 0 {
+		return cfg, fmt.Errorf("MaxTorrents must not be negative, not %d", cfg.MaxTorrents)
+	} else if cfg.PeerTTL < 0 {
+		return cfg, fmt.Errorf("PeerTTL must not be negative, not %v", cfg.PeerTTL)
+	} else if cfg.MaxChunks < 0 {
+		return cfg, fmt.Errorf("MaxChunks must not be negative, not %d", cfg.MaxChunks)
+	} else if cfg.SnapshotInterval < 0 {
+		return cfg, fmt.Errorf("SnapshotInterval must not be negative, not %d", cfg.SnapshotInterval)
+	} else if cfg.MaxUpdateRate < 0 {
+		return cfg, fmt.Errorf("MaxUpdateRate must not be negative, not %d", cfg.MaxUpdateRate)
+	} else if cfg.BadChunkReports < 0 {
+		return cfg, fmt.Errorf("BadChunkReports must not be negative, not %d", cfg.BadChunkReports)
+	} else if cfg.DialRetries < 0 {
+		return cfg, fmt.Errorf("DialRetries must not be negative, not %d", cfg.DialRetries)
+	} else if cfg.DialBackoff < 0 {
+		return cfg, fmt.Errorf("DialBackoff must not be negative, not %v", cfg.DialBackoff)
+	}
+	return cfg, nil
+}
+
+// Returns whether host can be joined with a port into a host:port, i.e. it
+// does not already carry a port, and an IPv6 address is not in brackets
+func validHost(host string) bool {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return false
+	}
+	return !strings.ContainsAny(host, "[]/")
+}
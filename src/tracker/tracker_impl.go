@@ -5,9 +5,20 @@ package tracker
  * Data Storage:
  *   torrents   map[torrentproto.ID]torrentproto.Torrent
  *     - Maps the torrentID to the torrent's information
- *   peers      map[torrentproto.ChunkID](map[string](struct{}))
+ *   peers      map[torrentproto.ChunkID](map[string]int64)
  *     - Maps the chunkID (which includes torrentID and chunkNum)
- *       to a map whose keys are the clients that own that torrent
+ *       to a map whose keys are the clients that own that torrent,
+ *       and whose values are when each client last confirmed the chunk
+ *   seeders    map[torrentproto.ID](map[string](struct{}))
+ *     - Maps the torrentID to a map whose keys are the clients that
+ *       claim to own every chunk of that torrent
+ *   log        map[int]trackerproto.Operation
+ *     - Maps the seqNum to the op committed there, for the ops since the
+ *       latest snapshot (and any committed ahead of this node, which wait
+ *       for the ops before them)
+ *   snapshot   *trackerproto.Snapshot
+ *     - A copy of the state above, taken every snapshotInterval ops, for
+ *       nodes which have fallen behind the start of the log
  *
  * Goroutines:
  *   eventHandler
@@ -15,23 +26,44 @@ package tracker
  *   paxosHandler
  *     - eventHandler sends paxosHandler any pending operations
  *     - broadcasts the paxos messages to the other nodes in the cluster
+ *     - not started for a single-node cluster, where the eventHandler
+ *       commits operations directly
  *
  * Other Notes:
  * - paxosHandler uses exponential back-off to deal with dualing leaders
- * - Upon receiving a paxos message for a "future" seqNum,
- *   the tracker pings the other nodes, asking for any committed actions
- *   that it missed.
+ * - The node which last led a round to a commit holds a lease for
+ *   LEASE_PERIOD, and the other nodes forward their operations to it
+ *   rather than start rounds which would duel with its own
+ * - A round commits a node's pending operations together, as one Batch
+ *   operation of up to MAX_BATCH, rather than one operation per round
+ * - Upon receiving a paxos message for a "future" seqNum (or a commit
+ *   COMMIT_GAP or more ops past its own), the tracker pings the other
+ *   nodes, asking for any committed actions that it missed, or for a
+ *   snapshot if they no longer have them.
  * - Paxos Cluster is initialized using the master/slave model
  *   (as in storage server)
+ * - An observer node is not part of the Paxos Cluster. It asks the master
+ *   for the cluster's nodes, then polls them for committed ops, and only
+ *   answers reads.
+ * - A node can join a running cluster through AddNode, which commits a Join
+ *   op, and a dead node can be removed through RemoveNode, which commits a
+ *   Leave op. Every node changes its list of nodes as these ops commit,
+ *   so each Paxos round counts its majority over the nodes which were in
+ *   the cluster as of the round's seqNum. A new node catches up on the ops
+ *   committed before it joined.
  */
 
 import (
 	"container/list"
+	"errors"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/rpc"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -43,6 +75,51 @@ import (
 // The time between RegisterServer calls from a slave server, in seconds
 const REGISTER_PERIOD = 1
 
+// The longest time that a WatchChunk call will wait, in seconds.
+// net/rpc does not tell us when a caller disconnects, so this is also how
+// long an abandoned watcher can linger.
+const WATCH_TIMEOUT = 30
+
+// The most WatchChunk calls that can be waiting at once
+const MAX_WATCHERS = 1000
+
+// The time between an observer's polls of the cluster for new ops, in milliseconds
+const OBSERVE_PERIOD = 100
+
+// How long a proposer which has a majority of promises waits for the other
+// nodes' promises, in milliseconds.
+// Promises report each node's oldest pending op, so hearing from every node
+// lets the proposer pick the op which has waited longest across the cluster.
+const PREPARE_GRACE = 5
+
+// The most pending ops a node proposes together, as one Batch op.
+// Committing a batch takes one Paxos round however many ops it holds, so a
+// client confirming every chunk of a file does not wait a round per chunk.
+const MAX_BATCH = 100
+
+// How long the node which leads a round to a commit holds the lease, in
+// milliseconds. Each commit renews it, so a node stays leader while it is
+// busy, and the others take over within a lease of it going quiet.
+const LEASE_PERIOD = 500
+
+// How long Shutdown waits for answers to unfinished updates to be written back
+// to their callers, before closing their connections, in milliseconds
+const SHUTDOWN_GRACE = 20
+
+// How long Close waits for the updates a node is handling to commit, before
+// shutting it down anyway, in milliseconds
+const CLOSE_GRACE = 5000
+
+// How long RemoveNode waits for each of the nodes which would be left to
+// answer, in milliseconds
+const PROBE_TIMEOUT = 1000
+
+// How far past its seqNum a commit may land before a node stops waiting for
+// the commits in between to arrive, and fetches them from the other nodes.
+// Commits are broadcast without waiting for one another, so a few can arrive
+// out of order; a gap wider than this means commits were lost.
+const COMMIT_GAP = 3
+
 type PaxosType int
 
 const (
@@ -56,11 +133,26 @@ type Register struct {
 	Reply chan *trackerproto.RegisterReply
 }
 
+type AddNode struct {
+	Args  *trackerproto.AddNodeArgs
+	Reply chan *trackerproto.AddNodeReply
+}
+
+type RemoveNode struct {
+	Args  *trackerproto.RemoveNodeArgs
+	Reply chan *trackerproto.UpdateReply
+}
+
 type Get struct {
 	Args  *trackerproto.GetArgs
 	Reply chan *trackerproto.GetReply
 }
 
+type SnapshotQuery struct {
+	Args  *trackerproto.SnapshotArgs
+	Reply chan *trackerproto.SnapshotReply
+}
+
 type Prepare struct {
 	Args  *trackerproto.PrepareArgs
 	Reply chan *trackerproto.PrepareReply
@@ -81,19 +173,95 @@ type Request struct {
 	Reply chan *trackerproto.RequestReply
 }
 
+type PeerQuery struct {
+	Args  *trackerproto.HasArgs
+	Reply chan *trackerproto.HasReply
+}
+
+type AvailabilityQuery struct {
+	Args  *trackerproto.AvailabilityArgs
+	Reply chan *trackerproto.AvailabilityReply
+}
+
+type Watch struct {
+	Args  *trackerproto.WatchArgs
+	Reply chan *trackerproto.WatchReply
+}
+
 type Confirm struct {
 	Args  *trackerproto.ConfirmArgs
 	Reply chan *trackerproto.UpdateReply
 }
 
+type ConfirmMany struct {
+	Args  *trackerproto.ConfirmChunksArgs
+	Reply chan *trackerproto.UpdateReply
+}
+
 type Report struct {
 	Args  *trackerproto.ReportArgs
 	Reply chan *trackerproto.UpdateReply
 }
 
+type BadChunk struct {
+	Args  *trackerproto.BadChunkArgs
+	Reply chan *trackerproto.UpdateReply
+}
+
+type Evict struct {
+	Args  *trackerproto.EvictArgs
+	Reply chan *trackerproto.UpdateReply
+}
+
+type Export struct {
+	Args  *trackerproto.ExportArgs
+	Reply chan *trackerproto.ExportReply
+}
+
 type Create struct {
 	Args  *trackerproto.CreateArgs
 	Reply chan *trackerproto.UpdateReply
+
+	// Whether the create is part of an import, which does not count against
+	// any client's limit on torrents
+	Import bool
+}
+
+type Delete struct {
+	Args  *trackerproto.DeleteArgs
+	Reply chan *trackerproto.UpdateReply
+}
+
+type StatsQuery struct {
+	Args  *trackerproto.StatsArgs
+	Reply chan *trackerproto.StatsReply
+}
+
+type Ping struct {
+	Args  *trackerproto.PingArgs
+	Reply chan *trackerproto.PingReply
+}
+
+// Fetched is an op, or a snapshot, which the catch-up goroutine has fetched
+// from another node for the eventHandler to commit, or word that it is Done.
+// Reply is told how far along the eventHandler is once it has committed it.
+type Fetched struct {
+	SeqNum   int
+	Value    trackerproto.Operation
+	Snapshot *trackerproto.Snapshot
+	Done     bool
+	Reply    chan *CatchUpState
+}
+
+// CatchUpState is how far along the eventHandler is in catching up.
+type CatchUpState struct {
+	SeqNum int // The seqNum of the next op it needs
+	Target int // The seqNum it is catching up to
+}
+
+type List struct {
+	Args  *trackerproto.ListArgs
+	Reply chan *trackerproto.ListReply
 }
 
 type GetTrackers struct {
@@ -102,8 +270,28 @@ type GetTrackers struct {
 }
 
 type Pending struct {
-	Value trackerproto.Operation
-	Reply chan *trackerproto.UpdateReply
+	Value     trackerproto.Operation
+	Reply     chan *trackerproto.UpdateReply
+	Enqueued  time.Time // When the operation was added to pendingOps
+	Forwarded bool      // Whether another node forwarded the operation here
+}
+
+// Identifies which pending operations a committed operation answers
+type pendingKey struct {
+	OpType     trackerproto.OperationType
+	Chunk      torrentproto.ChunkID
+	ClientAddr string
+	ID         torrentproto.ID
+	Reporter   string
+}
+
+func keyOf(v trackerproto.Operation) pendingKey {
+	return pendingKey{
+		OpType:     v.OpType,
+		Chunk:      v.Chunk,
+		ClientAddr: v.ClientAddr,
+		ID:         v.Torrent.ID,
+		Reporter:   v.Reporter}
 }
 
 type PaxosReply struct {
@@ -112,6 +300,8 @@ type PaxosReply struct {
 	PaxNum    int
 	Value     trackerproto.Operation
 	SeqNum    int
+	Oldest    trackerproto.Operation
+	OldestAge time.Duration
 }
 
 type PaxosBroadcast struct {
@@ -124,42 +314,162 @@ type PaxosBroadcast struct {
 
 type trackerServer struct {
 	// Cluster Set-up
+	// Once the node has started, nodes, numNodes, trackers and nextNodeID
+	// change only as the eventHandler commits Join and Leave ops, and are
+	// guarded by membersMut. The eventHandler reads nodes and numNodes without it.
 	nodes                []trackerproto.Node
 	numNodes             int
 	masterServerHostPort string
-	port                 int
+	hostPort             string // The host:port this node advertises to the rest of the cluster
 	registers            chan *Register
 	nodeID               int
-	trackers             []*rpc.Client
+	trackers             map[int]*rpc.Client // Maps NodeID -> connection, for the nodes we have reached
+	nextNodeID           int                 // The NodeID the next node to join is given
+	membersMut           sync.Mutex
+
+	// The number of ops committed when this node learned the cluster's
+	// nodes; Join and Leave ops before it are already in nodes
+	membersSeq int
+
+	// Whether this node joins a running cluster, rather than forming one
+	joining bool
+
+	// Whether the paxosHandler has been started.
+	// Only used by the eventHandler, once the node has started.
+	paxosStarted bool
+
+	// The most torrents one client may create, or 0 for no limit
+	maxTorrents int
+
+	// The most chunks one torrent may have
+	maxChunks int
+
+	// How long a peer lasts without confirming a chunk again, or 0 if peers
+	// never expire
+	peerTTL time.Duration
+
+	// The key admin RPCs must give, or "" if they are disabled
+	adminKey string
+
+	// How many times to dial another node before giving up on it, and how
+	// long to wait before the first redial
+	dialRetries int
+	dialBackoff time.Duration
+
+	// The most updates one client may send per second, or 0 for no limit,
+	// and each client's updates in the current second, by host:port.
+	// Only used by the eventHandler.
+	maxUpdateRate int
+	rates         map[string]*rateWindow
+
+	// The token clients must give with updates, or "" if they need none
+	clientToken string
+
+	// The key nodes give each other with Paxos RPCs, or "" if they need none
+	clusterKey string
+
+	// How many distinct clients must report a peer for sending a bad copy of
+	// a chunk before the peer is dropped from the chunk
+	badChunkReports int
+
+	// Whether this node only follows the cluster, and serves reads
+	observer  bool
+	following int // The node an observer asks for ops first
 
 	// Channels for rpc calls
-	prepares    chan *Prepare
-	accepts     chan *Accept
-	commits     chan *Commit
-	gets        chan *Get
-	requests    chan *Request
-	confirms    chan *Confirm
-	reports     chan *Report
-	creates     chan *Create
-	getTrackers chan *GetTrackers
-	pending     chan *Pending
-	outOfDate   chan int
+	prepares     chan *Prepare
+	accepts      chan *Accept
+	commits      chan *Commit
+	gets         chan *Get
+	snapshots    chan *SnapshotQuery
+	requests     chan *Request
+	peerQueries  chan *PeerQuery
+	availQueries chan *AvailabilityQuery
+	watches      chan *Watch
+	unwatches    chan *Watch
+	confirms     chan *Confirm
+	confirmManys chan *ConfirmMany
+	reports      chan *Report
+	badChunks    chan *BadChunk
+	creates      chan *Create
+	deletes      chan *Delete
+	exports      chan *Export
+	evicts       chan *Evict
+	getTrackers  chan *GetTrackers
+	addNodes     chan *AddNode
+	removeNodes  chan *RemoveNode
+	lists        chan *List
+	statsQueries chan *StatsQuery
+	pings        chan *Ping
+	pending      chan *Pending
+	outOfDate    chan int
+	fetched      chan *Fetched
 
 	// Paxos Stuff
-	myN      int
+	myN  int
+	accN int
+	accV trackerproto.Operation
+
+	// The highest proposal number this node has promised or accepted, guarded
+	// by paxMut, since the paxosHandler reads it and raises it too
+	paxMut   sync.Mutex
 	highestN int
-	accN     int
-	accV     trackerproto.Operation
 
 	// Sequencing / Logging
 	seqNum int
 	log    map[int]trackerproto.Operation
 
+	// The highest seqNum this node has heard that the cluster has reached.
+	// Only used by the eventHandler.
+	latestSeq int
+
+	// Whether a catch-up goroutine is fetching missed ops, and a channel
+	// which is closed once it stops. Only used by the eventHandler.
+	catchingUp bool
+	caughtUp   chan struct{}
+
+	// The latest snapshot, which the log starts at, and how many ops apart
+	// snapshots are taken
+	snapshot         *trackerproto.Snapshot
+	snapshotInterval int
+
 	// Actual data storage
 	torrents   map[torrentproto.ID]torrentproto.Torrent         // Map the torrentID to the Torrent information
-	peers      map[torrentproto.ChunkID](map[string](struct{})) // Maps chunk info -> list of host:port with that chunk
-	pendingOps *list.List
-	pendingMut *sync.Mutex
+	peers      map[torrentproto.ChunkID](map[string]int64)     // Maps chunk info -> host:port with that chunk -> when it last confirmed the chunk
+	seeders    map[torrentproto.ID](map[string](struct{}))      // Maps torrentID -> list of host:port with every chunk
+	created    map[string]int                                   // Maps client host:port -> number of torrents it created
+	suspects   map[torrentproto.ChunkID](map[string](map[string]struct{})) // Maps chunk info -> peer -> clients which reported it sent a bad copy
+	pendingOps *list.List                                       // Pending operations, in the order to propose them
+	pendingIdx map[pendingKey]([]*list.Element)                 // Maps key -> elements of pendingOps with that key
+	pendingMut *sync.Mutex                                      // Guards pendingOps and pendingIdx
+
+	// Clients waiting for a chunk to gain a peer
+	watchers    map[torrentproto.ChunkID](map[*Watch](struct{}))
+	numWatchers int
+
+	// Paxos round statistics, guarded by statsMut
+	statsMut     sync.Mutex
+	rounds       int
+	restarts     int
+	roundTime    time.Duration // Total over all rounds
+	maxRoundTime time.Duration
+	forwarded    int
+
+	// The node which holds the lease, and when its lease ends, guarded by
+	// leaseMut
+	leaseMut sync.Mutex
+	leader   int
+	leaseEnd time.Time
+
+	// Accepts RPC connections; closed on shutdown
+	ln        *connListener
+	closeOnce sync.Once
+	inFlight  sync.WaitGroup // Update RPCs which have not yet answered
+
+	// Set once the tracker starts closing, after which it takes no new
+	// updates; guarded by closeMut
+	closeMut sync.Mutex
+	closing  bool
 
 	// Used for debugging
 	dbclose    chan struct{}
@@ -168,67 +478,135 @@ type trackerServer struct {
 	dbcontinue chan struct{}
 }
 
+// NewTrackerServer starts a tracker node with the given cluster settings, and
+// defaults for everything else (see TrackerConfig)
 // If masterServerHostPort is "", then this assumes that it is the master server
-// numNodes tells us how many nodes are in the Paxos Cluster
-// nodeID is this node's position in the cluster (each node should have a different id, 0 <= nodeID < numNodes)
-// port is the port to start this server on
 func NewTrackerServer(masterServerHostPort string, numNodes, port, nodeID int) (Tracker, error) {
+	return NewTrackerServerWithConfig(TrackerConfig{
+		MasterHostPort: masterServerHostPort,
+		NumNodes:       numNodes,
+		Port:           port,
+		NodeID:         nodeID})
+}
+
+// NewTrackerServerWithConfig starts a tracker node configured by cfg
+// Returns an error if cfg is not valid (see TrackerConfig.WithDefaults)
+func NewTrackerServerWithConfig(cfg TrackerConfig) (Tracker, error) {
+	cfg, err := cfg.WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+	numNodes, nodeID := cfg.NumNodes, cfg.NodeID
+	if cfg.Observer || cfg.Joining {
+		// Observers are not in the cluster, so do not take any node's place,
+		// and joining nodes are given theirs by the cluster
+		numNodes, nodeID = 0, -1
+	}
 	t := &trackerServer{
-		masterServerHostPort: masterServerHostPort,
+		maxTorrents:          cfg.MaxTorrents,
+		maxChunks:            cfg.MaxChunks,
+		peerTTL:              cfg.PeerTTL,
+		observer:             cfg.Observer,
+		adminKey:             cfg.AdminKey,
+		dialRetries:          cfg.DialRetries,
+		dialBackoff:          cfg.DialBackoff,
+		badChunkReports:      cfg.BadChunkReports,
+		clientToken:          cfg.ClientToken,
+		maxUpdateRate:        cfg.MaxUpdateRate,
+		rates:                make(map[string]*rateWindow),
+		clusterKey:           cfg.ClusterKey,
+		masterServerHostPort: cfg.MasterHostPort,
 		nodeID:               nodeID,
 		nodes:                nil,
 		numNodes:             numNodes,
-		port:                 port,
+		nextNodeID:           numNodes,
+		joining:              cfg.Joining,
+		hostPort:             net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
 		accepts:              make(chan *Accept),
 		commits:              make(chan *Commit),
 		confirms:             make(chan *Confirm),
+		confirmManys:         make(chan *ConfirmMany),
 		gets:                 make(chan *Get),
+		snapshots:            make(chan *SnapshotQuery),
 		prepares:             make(chan *Prepare),
 		registers:            make(chan *Register),
 		reports:              make(chan *Report),
+		badChunks:            make(chan *BadChunk),
 		requests:             make(chan *Request),
+		peerQueries:          make(chan *PeerQuery),
+		availQueries:         make(chan *AvailabilityQuery),
+		watches:              make(chan *Watch),
+		unwatches:            make(chan *Watch),
 		creates:              make(chan *Create),
+		deletes:              make(chan *Delete),
+		exports:              make(chan *Export),
+		evicts:               make(chan *Evict),
 		getTrackers:          make(chan *GetTrackers),
+		addNodes:             make(chan *AddNode),
+		removeNodes:          make(chan *RemoveNode),
+		lists:                make(chan *List),
+		statsQueries:         make(chan *StatsQuery),
+		pings:                make(chan *Ping),
+		fetched:              make(chan *Fetched),
 		pending:              make(chan *Pending),
 		myN:                  nodeID,
 		highestN:             0,
 		accV:                 trackerproto.Operation{OpType: trackerproto.None},
 		seqNum:               0,
 		log:                  make(map[int]trackerproto.Operation),
+		snapshot:             &trackerproto.Snapshot{},
+		snapshotInterval:     cfg.SnapshotInterval,
 		torrents:             make(map[torrentproto.ID]torrentproto.Torrent),
-		peers:                make(map[torrentproto.ChunkID](map[string](struct{}))),
-		trackers:             make([]*rpc.Client, numNodes),
+		peers:                make(map[torrentproto.ChunkID](map[string]int64)),
+		seeders:              make(map[torrentproto.ID](map[string](struct{}))),
+		created:              make(map[string]int),
+		suspects:             make(map[torrentproto.ChunkID](map[string](map[string]struct{}))),
+		trackers:             make(map[int]*rpc.Client),
 		outOfDate:            make(chan int, 1),
 		pendingOps:           list.New(),
+		pendingIdx:           make(map[pendingKey]([]*list.Element)),
 		pendingMut:           &sync.Mutex{},
+		watchers:             make(map[torrentproto.ChunkID](map[*Watch](struct{}))),
 		dbclose:              make(chan struct{}),
 		dbstall:              make(chan int),
 		dbstallall:           make(chan struct{})}
 
+	// Give this TrackerServer its own RPC server and HTTP mux, rather than the
+	// global defaults, so that other servers can run in the same process.
+	srv := rpc.NewServer()
+	mux := http.NewServeMux()
+
 	// Configure this TrackerServer to receive RPCs over HTTP on a
 	// trackerproto.Tracker interface.
-	if regErr := rpc.RegisterName("RemoteTracker", WrapRemote(t)); regErr != nil {
+	if regErr := srv.RegisterName("RemoteTracker", WrapRemote(t, cfg.Hook)); regErr != nil {
 		return nil, regErr
 	}
 
 	// New configure this TrackerServer to receive RPCs over HTTP on a
 	// trackerproto.Paxos interface
-	if regErr := rpc.RegisterName("PaxosTracker", WrapPaxos(t)); regErr != nil {
+	if regErr := srv.RegisterName("PaxosTracker", WrapPaxos(t, cfg.Hook)); regErr != nil {
 		return nil, regErr
 	}
-	rpc.HandleHTTP()
+	mux.Handle(rpc.DefaultRPCPath, srv)
 
 	// Attempt to service connections on the given port.
-	ln, lnErr := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	ln, lnErr := net.Listen("tcp", net.JoinHostPort(cfg.ListenHost, strconv.Itoa(cfg.Port)))
 	if lnErr != nil {
 		return nil, lnErr
 	}
+	t.ln = newConnListener(ln)
 
-	go http.Serve(ln, nil)
+	go http.Serve(t.ln, mux)
 
 	// Wait for all TrackerServers to join the ring.
 	var joinErr error
-	if masterServerHostPort == "" {
+	if cfg.Observer {
+		// This is an observer, which only needs to know the cluster.
+		joinErr = t.observerAwaitJoin()
+	} else if cfg.Joining {
+		// This node is joining a cluster which has already formed.
+		joinErr = t.joiningAwaitJoin()
+	} else if cfg.MasterHostPort == "" {
 		// This is the master StorageServer.
 		joinErr = t.masterAwaitJoin()
 
@@ -240,31 +618,77 @@ func NewTrackerServer(masterServerHostPort string, numNodes, port, nodeID int) (
 	}
 
 	if joinErr != nil {
+		t.ln.Close()
 		return nil, joinErr
 	} else {
 		// We've registered with the master, and gotten a list of all servers.
 		// We need to connect to all of them over rpc,
 		// then add these data points to t.trackers
+		// Other nodes may still be starting up, so retry each dial,
+		// and report every node we could not reach.
+		unreachable := make([]string, 0)
 		for _, node := range t.nodes {
-			trackerproto, err := rpc.DialHTTP("tcp", node.HostPort)
+			conn, err := t.dialNode(node.HostPort)
 			if err != nil {
-				return nil, err
+				unreachable = append(unreachable, node.HostPort+" ("+err.Error()+")")
+				continue
 			}
-			t.trackers[node.NodeID] = trackerproto
+			t.trackers[node.NodeID] = conn
+		}
+		if len(unreachable) > 0 {
+			t.Shutdown()
+			return nil, errors.New("Could not reach tracker nodes: " + strings.Join(unreachable, ", "))
+		}
+	}
+
+	// A joining node starts with none of the cluster's state, so fetch the
+	// ops committed before it joined.
+	var caughtUp chan struct{}
+	if cfg.Joining {
+		t.catchUp(t.membersSeq)
+		if t.catchingUp {
+			caughtUp = t.caughtUp
 		}
 	}
 
+	// Spawn a goroutine to talk to the other Paxos Nodes
+	t.startPaxos()
+
 	// Start this TrackerServer's eventHandler, which will respond to RPCs,
 	// and return it.
 	go t.eventHandler()
 
-	// Spawn a goroutine to talk to the other Paxos Nodes
-	go t.paxosHandler()
+	// The eventHandler commits what the joining node fetches, so only
+	// return once it has, rather than hand back a node with none of the
+	// cluster's state.
+	if caughtUp != nil {
+		select {
+		case <-caughtUp:
+		case <-t.dbclose:
+		}
+	}
 
 	return t, nil
 }
 
+// dialNode connects to the tracker node at hostPort, trying up to t's dial
+// retries times, and backing off exponentially between attempts.
+func (t *trackerServer) dialNode(hostPort string) (*rpc.Client, error) {
+	backoff := t.dialBackoff
+	conn, err := rpc.DialHTTP("tcp", hostPort)
+	for i := 1; err != nil && i < t.dialRetries; i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		conn, err = rpc.DialHTTP("tcp", hostPort)
+	}
+	return conn, err
+}
+
 func (t *trackerServer) RegisterServer(args *trackerproto.RegisterArgs, reply *trackerproto.RegisterReply) error {
+	if !t.inCluster(args.Key) {
+		reply.Status = trackerproto.NotAuthorized
+		return nil
+	}
 	replyChan := make(chan *trackerproto.RegisterReply)
 	register := &Register{
 		Args:  args,
@@ -274,6 +698,106 @@ func (t *trackerServer) RegisterServer(args *trackerproto.RegisterArgs, reply *t
 	return nil
 }
 
+// AddNode adds the node at args.HostPort to the running cluster, through
+// Paxos, and answers once the addition has committed.
+func (t *trackerServer) AddNode(args *trackerproto.AddNodeArgs, reply *trackerproto.AddNodeReply) error {
+	if !t.inCluster(args.Key) {
+		reply.Status = trackerproto.NotAuthorized
+		return nil
+	} else if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	replyChan := make(chan *trackerproto.AddNodeReply, 1)
+	add := &AddNode{
+		Args:  args,
+		Reply: replyChan}
+	select {
+	case t.addNodes <- add:
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	select {
+	case r := <-replyChan:
+		*reply = *r
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
+	return nil
+}
+
+// RemoveNode removes the node at args.HostPort from the cluster, through
+// Paxos, once it has checked that enough of the other nodes answer to carry on
+// without it.
+func (t *trackerServer) RemoveNode(args *trackerproto.RemoveNodeArgs, reply *trackerproto.UpdateReply) error {
+	if t.adminKey == "" || args.AdminKey != t.adminKey {
+		reply.Status = trackerproto.NotAuthorized
+		return nil
+	} else if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	if !t.quorumWithout(args.HostPort) {
+		reply.Status = trackerproto.TooFewNodes
+		return nil
+	}
+	replyChan := make(chan *trackerproto.UpdateReply, 1)
+	remove := &RemoveNode{
+		Args:  args,
+		Reply: replyChan}
+	select {
+	case t.removeNodes <- remove:
+		*reply = *t.awaitUpdate(replyChan)
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
+	return nil
+}
+
+// quorumWithout returns whether a majority of the nodes in the cluster, apart
+// from the one at hostPort, answer within PROBE_TIMEOUT.
+// This node counts as answering.
+func (t *trackerServer) quorumWithout(hostPort string) bool {
+	t.membersMut.Lock()
+	nodes := t.nodes
+	t.membersMut.Unlock()
+
+	left := 0
+	answers := make(chan bool, len(nodes))
+	for _, node := range nodes {
+		if node.HostPort == hostPort {
+			continue
+		}
+		left++
+		if node.NodeID == t.nodeID {
+			answers <- true
+			continue
+		}
+		go func(id int) {
+			// Any op will do, as we only want to know that the node answers
+			args := &trackerproto.GetArgs{SeqNum: -1}
+			answers <- t.call(id, "PaxosTracker.GetOp", args, &trackerproto.GetReply{}) == nil
+		}(node.NodeID)
+	}
+
+	answering := 0
+	timeout := time.After(time.Millisecond * PROBE_TIMEOUT)
+	for i := 0; i < left; i++ {
+		select {
+		case ok := <-answers:
+			if ok {
+				answering++
+			}
+		case <-timeout:
+			return answering > left/2
+		}
+	}
+	return answering > left/2
+}
+
 func (t *trackerServer) GetOp(args *trackerproto.GetArgs, reply *trackerproto.GetReply) error {
 	replyChan := make(chan *trackerproto.GetReply)
 	get := &Get{
@@ -284,7 +808,21 @@ func (t *trackerServer) GetOp(args *trackerproto.GetArgs, reply *trackerproto.Ge
 	return nil
 }
 
+func (t *trackerServer) GetSnapshot(args *trackerproto.SnapshotArgs, reply *trackerproto.SnapshotReply) error {
+	replyChan := make(chan *trackerproto.SnapshotReply)
+	query := &SnapshotQuery{
+		Args:  args,
+		Reply: replyChan}
+	t.snapshots <- query
+	*reply = *(<-replyChan)
+	return nil
+}
+
 func (t *trackerServer) Prepare(args *trackerproto.PrepareArgs, reply *trackerproto.PrepareReply) error {
+	if !t.inCluster(args.Key) {
+		reply.Status = trackerproto.NotAuthorized
+		return nil
+	}
 	replyChan := make(chan *trackerproto.PrepareReply)
 	prepare := &Prepare{
 		Args:  args,
@@ -295,6 +833,10 @@ func (t *trackerServer) Prepare(args *trackerproto.PrepareArgs, reply *trackerpr
 }
 
 func (t *trackerServer) Accept(args *trackerproto.AcceptArgs, reply *trackerproto.AcceptReply) error {
+	if !t.inCluster(args.Key) {
+		reply.Status = trackerproto.NotAuthorized
+		return nil
+	}
 	replyChan := make(chan *trackerproto.AcceptReply)
 	accept := &Accept{
 		Args:  args,
@@ -305,6 +847,10 @@ func (t *trackerServer) Accept(args *trackerproto.AcceptArgs, reply *trackerprot
 }
 
 func (t *trackerServer) Commit(args *trackerproto.CommitArgs, reply *trackerproto.CommitReply) error {
+	if !t.inCluster(args.Key) {
+		reply.Status = trackerproto.NotAuthorized
+		return nil
+	}
 	replyChan := make(chan *trackerproto.CommitReply)
 	commit := &Commit{
 		Args:  args,
@@ -314,36 +860,289 @@ func (t *trackerServer) Commit(args *trackerproto.CommitArgs, reply *trackerprot
 	return nil
 }
 
+// Forward takes operations which another node has forwarded to this one, as
+// the node which holds the lease, and proposes them with this node's own.
+// Their callers wait on the forwarding node, which answers them once they are
+// committed, so the replies here are dropped.
+func (t *trackerServer) Forward(args *trackerproto.ForwardArgs, reply *trackerproto.ForwardReply) error {
+	if !t.inCluster(args.Key) {
+		reply.Status = trackerproto.NotAuthorized
+		return nil
+	} else if ids, _ := t.members(); t.observer || len(ids) == 1 {
+		// Only nodes which run Paxos propose
+		reply.Status = trackerproto.Reject
+		return nil
+	}
+	for _, op := range args.Ops {
+		pending := &Pending{
+			Value:     op,
+			Reply:     make(chan *trackerproto.UpdateReply, 1),
+			Forwarded: true}
+		go func() {
+			select {
+			case t.pending <- pending:
+			case <-t.dbclose:
+			}
+		}()
+	}
+	reply.Status = trackerproto.OK
+	return nil
+}
+
+func (t *trackerServer) ExportTorrents(args *trackerproto.ExportArgs, reply *trackerproto.ExportReply) error {
+	replyChan := make(chan *trackerproto.ExportReply)
+	export := &Export{
+		Args:  args,
+		Reply: replyChan}
+	t.exports <- export
+	*reply = *(<-replyChan)
+	return nil
+}
+
+// Each torrent goes through CreateEntry in turn, so that it is validated and
+// committed like any other create
+func (t *trackerServer) ImportTorrents(args *trackerproto.ImportArgs, reply *trackerproto.ImportReply) error {
+	reply.Status = trackerproto.OK
+	for _, tor := range args.Torrents {
+		created := &trackerproto.UpdateReply{}
+		t.create(&trackerproto.CreateArgs{Torrent: tor, Token: args.Token}, true, created)
+		switch created.Status {
+		case trackerproto.OK:
+			reply.Created = append(reply.Created, tor.ID)
+		case trackerproto.InvalidID:
+			reply.Existing = append(reply.Existing, tor.ID)
+		case trackerproto.InvalidTrackers, trackerproto.TooManyChunks, trackerproto.InvalidTorrent:
+			reply.Rejected = append(reply.Rejected, tor.ID)
+		default:
+			reply.Status = created.Status
+			return nil
+		}
+	}
+	return nil
+}
+
+// t checks the token given with an update
+func (t *trackerServer) authorized(token string) bool {
+	return t.clientToken == "" || token == t.clientToken
+}
+
+// The updates a client has sent since start
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// t counts an update from client, and checks whether client has now sent more
+// updates in the last second than t allows.
+// Each second is counted from a client's first update in it. Updates which do
+// not name their client (e.g. imports) are not limited.
+func (t *trackerServer) rateLimited(client string) bool {
+	if t.maxUpdateRate == 0 || client == "" {
+		return false
+	}
+	now := time.Now()
+	window, ok := t.rates[client]
+	if !ok || now.Sub(window.start) >= time.Second {
+		window = &rateWindow{start: now}
+		t.rates[client] = window
+	}
+	window.count++
+	return window.count > t.maxUpdateRate
+}
+
+// t checks the key given with a Paxos RPC, so that only other nodes of the
+// cluster take part in its rounds
+func (t *trackerServer) inCluster(key string) bool {
+	return t.clusterKey == "" || key == t.clusterKey
+}
+
+// beginUpdate counts an update RPC as in flight, so that Close waits for it.
+// Returns false if the tracker is closing, in which case the update should be
+// answered with ServerClosing.
+func (t *trackerServer) beginUpdate() bool {
+	t.closeMut.Lock()
+	defer t.closeMut.Unlock()
+	if t.closing {
+		return false
+	}
+	t.inFlight.Add(1)
+	return true
+}
+
 func (t *trackerServer) ReportMissing(args *trackerproto.ReportArgs, reply *trackerproto.UpdateReply) error {
-	replyChan := make(chan *trackerproto.UpdateReply)
+	if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	replyChan := make(chan *trackerproto.UpdateReply, 1)
 	report := &Report{
 		Args:  args,
 		Reply: replyChan}
-	t.reports <- report
-	*reply = *(<-replyChan)
+	select {
+	case t.reports <- report:
+		*reply = *t.awaitUpdate(replyChan)
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
+	return nil
+}
+
+func (t *trackerServer) ReportBadChunk(args *trackerproto.BadChunkArgs, reply *trackerproto.UpdateReply) error {
+	if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	replyChan := make(chan *trackerproto.UpdateReply, 1)
+	bad := &BadChunk{
+		Args:  args,
+		Reply: replyChan}
+	select {
+	case t.badChunks <- bad:
+		*reply = *t.awaitUpdate(replyChan)
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
 	return nil
 }
 
 func (t *trackerServer) ConfirmChunk(args *trackerproto.ConfirmArgs, reply *trackerproto.UpdateReply) error {
-	replyChan := make(chan *trackerproto.UpdateReply)
+	if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	replyChan := make(chan *trackerproto.UpdateReply, 1)
 	confirm := &Confirm{
 		Args:  args,
 		Reply: replyChan}
-	t.confirms <- confirm
-	*reply = *(<-replyChan)
+	select {
+	case t.confirms <- confirm:
+		*reply = *t.awaitUpdate(replyChan)
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
+	return nil
+}
+
+func (t *trackerServer) ConfirmChunks(args *trackerproto.ConfirmChunksArgs, reply *trackerproto.UpdateReply) error {
+	if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	replyChan := make(chan *trackerproto.UpdateReply, 1)
+	confirm := &ConfirmMany{
+		Args:  args,
+		Reply: replyChan}
+	select {
+	case t.confirmManys <- confirm:
+		*reply = *t.awaitUpdate(replyChan)
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
+	return nil
+}
+
+func (t *trackerServer) EvictPeer(args *trackerproto.EvictArgs, reply *trackerproto.UpdateReply) error {
+	if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	replyChan := make(chan *trackerproto.UpdateReply, 1)
+	evict := &Evict{
+		Args:  args,
+		Reply: replyChan}
+	select {
+	case t.evicts <- evict:
+		*reply = *t.awaitUpdate(replyChan)
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
 	return nil
 }
 
 func (t *trackerServer) CreateEntry(args *trackerproto.CreateArgs, reply *trackerproto.UpdateReply) error {
-	replyChan := make(chan *trackerproto.UpdateReply)
+	return t.create(args, false, reply)
+}
+
+// create creates the entry for a torrent, as CreateEntry does, for a client or,
+// if imported, for ImportTorrents
+func (t *trackerServer) create(args *trackerproto.CreateArgs, imported bool, reply *trackerproto.UpdateReply) error {
+	if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	replyChan := make(chan *trackerproto.UpdateReply, 1)
 	create := &Create{
+		Args:   args,
+		Reply:  replyChan,
+		Import: imported}
+	select {
+	case t.creates <- create:
+		*reply = *t.awaitUpdate(replyChan)
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
+	return nil
+}
+
+func (t *trackerServer) DeleteEntry(args *trackerproto.DeleteArgs, reply *trackerproto.UpdateReply) error {
+	if !t.beginUpdate() {
+		reply.Status = trackerproto.ServerClosing
+		return nil
+	}
+	defer t.inFlight.Done()
+	replyChan := make(chan *trackerproto.UpdateReply, 1)
+	del := &Delete{
 		Args:  args,
 		Reply: replyChan}
-	t.creates <- create
-	*reply = *(<-replyChan)
+	select {
+	case t.deletes <- del:
+		*reply = *t.awaitUpdate(replyChan)
+	case <-t.dbclose:
+		reply.Status = trackerproto.ServerClosing
+	}
 	return nil
 }
 
+// awaitUpdate waits for the answer to an update, which may take a Paxos round.
+// If the tracker shuts down first, the update is answered with ServerClosing.
+// The rest of the cluster may still commit it, if this node got far enough
+// through a round to pass it on.
+// Update replies are buffered, so the eventHandler and paxosHandler never
+// wait on a caller which has given up.
+func (t *trackerServer) awaitUpdate(replyChan chan *trackerproto.UpdateReply) *trackerproto.UpdateReply {
+	select {
+	case r := <-replyChan:
+		return r
+	case <-t.dbclose:
+		return &trackerproto.UpdateReply{Status: trackerproto.ServerClosing}
+	}
+}
+
+// awaitAll waits for the answers to several updates, then sends reply the
+// first answer which is not OK, or OK if they all are.
+// If the tracker shuts down first, it gives up, as awaitUpdate answers the
+// caller.
+func (t *trackerServer) awaitAll(replies []chan *trackerproto.UpdateReply, reply chan *trackerproto.UpdateReply) {
+	combined := &trackerproto.UpdateReply{Status: trackerproto.OK}
+	for _, replyChan := range replies {
+		select {
+		case r := <-replyChan:
+			if r.Status != trackerproto.OK && combined.Status == trackerproto.OK {
+				combined = r
+			}
+		case <-t.dbclose:
+			return
+		}
+	}
+	reply <- combined
+}
+
 func (t *trackerServer) RequestChunk(args *trackerproto.RequestArgs, reply *trackerproto.RequestReply) error {
 	replyChan := make(chan *trackerproto.RequestReply)
 	request := &Request{
@@ -354,6 +1153,97 @@ func (t *trackerServer) RequestChunk(args *trackerproto.RequestArgs, reply *trac
 	return nil
 }
 
+func (t *trackerServer) RequestChunkLocal(chunk torrentproto.ChunkID) (*trackerproto.RequestReply, error) {
+	replyChan := make(chan *trackerproto.RequestReply)
+	request := &Request{
+		Args:  &trackerproto.RequestArgs{Chunk: chunk},
+		Reply: replyChan}
+	select {
+	case t.requests <- request:
+		return <-replyChan, nil
+	case <-t.dbclose:
+		return nil, errors.New("Tracker has shut down")
+	}
+}
+
+func (t *trackerServer) PeerHasChunk(args *trackerproto.HasArgs, reply *trackerproto.HasReply) error {
+	replyChan := make(chan *trackerproto.HasReply)
+	query := &PeerQuery{
+		Args:  args,
+		Reply: replyChan}
+	t.peerQueries <- query
+	*reply = *(<-replyChan)
+	return nil
+}
+
+func (t *trackerServer) Availability(args *trackerproto.AvailabilityArgs, reply *trackerproto.AvailabilityReply) error {
+	replyChan := make(chan *trackerproto.AvailabilityReply)
+	query := &AvailabilityQuery{
+		Args:  args,
+		Reply: replyChan}
+	t.availQueries <- query
+	*reply = *(<-replyChan)
+	return nil
+}
+
+func (t *trackerServer) WatchChunk(args *trackerproto.WatchArgs, reply *trackerproto.WatchReply) error {
+	// Buffer the reply, so that the eventHandler never waits on a watcher
+	// that has already given up.
+	replyChan := make(chan *trackerproto.WatchReply, 1)
+	watch := &Watch{
+		Args:  args,
+		Reply: replyChan}
+	t.watches <- watch
+	select {
+	case r := <-replyChan:
+		*reply = *r
+	case <-time.After(time.Second * time.Duration(WATCH_TIMEOUT)):
+		// Stop waiting, unless a peer turned up in the meantime
+		t.unwatches <- watch
+		select {
+		case r := <-replyChan:
+			*reply = *r
+		default:
+			*reply = trackerproto.WatchReply{Status: trackerproto.Timeout}
+		}
+	}
+	return nil
+}
+
+func (t *trackerServer) Stats(args *trackerproto.StatsArgs, reply *trackerproto.StatsReply) error {
+	// The committed state belongs to the eventHandler, so ask it for that
+	replyChan := make(chan *trackerproto.StatsReply)
+	query := &StatsQuery{
+		Args:  args,
+		Reply: replyChan}
+	t.statsQueries <- query
+	*reply = *(<-replyChan)
+
+	// The round statistics belong to the paxosHandler, not the
+	// eventHandler, so read them directly
+	t.statsMut.Lock()
+	defer t.statsMut.Unlock()
+	reply.Status = trackerproto.OK
+	reply.Rounds = t.rounds
+	reply.Restarts = t.restarts
+	reply.MaxRoundTime = t.maxRoundTime
+	reply.Forwarded = t.forwarded
+	if t.rounds > 0 {
+		reply.MeanRoundTime = t.roundTime / time.Duration(t.rounds)
+	}
+	return nil
+}
+
+func (t *trackerServer) Ping(args *trackerproto.PingArgs, reply *trackerproto.PingReply) error {
+	replyChan := make(chan *trackerproto.PingReply)
+	ping := &Ping{
+		Args:  args,
+		Reply: replyChan}
+	t.pings <- ping
+	*reply = *(<-replyChan)
+	return nil
+}
+
 func (t *trackerServer) GetTrackers(args *trackerproto.TrackersArgs, reply *trackerproto.TrackersReply) error {
 	replyChan := make(chan *trackerproto.TrackersReply)
 	trackers := &GetTrackers{
@@ -364,22 +1254,30 @@ func (t *trackerServer) GetTrackers(args *trackerproto.TrackersArgs, reply *trac
 	return nil
 }
 
+func (t *trackerServer) ListTorrents(args *trackerproto.ListArgs, reply *trackerproto.ListReply) error {
+	replyChan := make(chan *trackerproto.ListReply)
+	list := &List{
+		Args:  args,
+		Reply: replyChan}
+	t.lists <- list
+	*reply = *(<-replyChan)
+	return nil
+}
+
 // Waits for all slave trackerServers to call the master's RegisterServer RPC.
 func (t *trackerServer) masterAwaitJoin() error {
 	// Initialize the array of Nodes, and create a map of all slaves that have
-	// registered (and another of those who have received an OK) for fast
-	// lookup.
-	nodeIDs := make(map[int]struct{})
+	// registered (to their host:ports), and another of those who have
+	// received an OK, for fast lookup.
+	nodeIDs := make(map[int]string)
 	okIDs := make(map[int]struct{})
 	t.nodes = make([]trackerproto.Node, 0)
 
-	// Count the master server.
-	//
-	// NOTE: We always list the host as localhost.
-	nodeIDs[t.nodeID] = struct{}{}
+	// Count the master server, under the host:port it advertises.
+	nodeIDs[t.nodeID] = t.hostPort
 	okIDs[t.nodeID] = struct{}{}
 	t.nodes = append(t.nodes, trackerproto.Node{
-		HostPort: net.JoinHostPort("localhost", strconv.Itoa(t.port)),
+		HostPort: t.hostPort,
 		NodeID:   t.nodeID})
 
 	// Loop until we've heard from (and replied to) all nodes.
@@ -387,10 +1285,16 @@ func (t *trackerServer) masterAwaitJoin() error {
 		// A node wants to register.
 		register := <-t.registers
 		node := register.Args.TrackerInfo
-		if _, ok := nodeIDs[node.NodeID]; !ok {
+		if seen, ok := nodeIDs[node.NodeID]; !ok {
 			// This is a new nodeId.
-			nodeIDs[node.NodeID] = struct{}{}
+			nodeIDs[node.NodeID] = node.HostPort
 			t.nodes = append(t.nodes, node)
+		} else if seen != node.HostPort {
+			// A different node already has this nodeId, so one of them
+			// is misconfigured. Turn this one away, rather than waiting
+			// forever for a node with the missing nodeId.
+			register.Reply <- &trackerproto.RegisterReply{Status: trackerproto.DuplicateID}
+			continue
 		}
 
 		// Determine the status to return.
@@ -416,9 +1320,9 @@ func (t *trackerServer) masterAwaitJoin() error {
 	return nil
 }
 
-// Waits for the master storageServer to accept a slave's RegisterServer RPC
-// and confirm that all other slaves have joined.
-func (t *trackerServer) slaveAwaitJoin() error {
+// Asks the master trackerServer for the nodes in the cluster, for an observer.
+// The master answers once the cluster has formed.
+func (t *trackerServer) observerAwaitJoin() error {
 	// Connect to the master trackerServer, retrying until we succeed.
 	var conn *rpc.Client
 	for conn == nil {
@@ -427,13 +1331,48 @@ func (t *trackerServer) slaveAwaitJoin() error {
 			time.Sleep(time.Second * time.Duration(REGISTER_PERIOD))
 		}
 	}
+	defer conn.Close()
 
-	// Make RegisterServer RPCs to the master trackerServer until it confirms
-	// that the ring is complete.
-	args := &trackerproto.RegisterArgs{
+	reply := &trackerproto.TrackersReply{}
+	if callErr := conn.Call("RemoteTracker.GetTrackers", &trackerproto.TrackersArgs{}, reply); callErr != nil {
+		return callErr
+	} else if reply.Status != trackerproto.OK {
+		return errors.New("Master could not list the cluster's nodes")
+	}
+
+	// Record which nodes are in the cluster.
+	// Only their host:ports matter to an observer, so number them in order.
+	t.numNodes = len(reply.HostPorts)
+	t.nextNodeID = t.numNodes
+	t.membersSeq = reply.SeqNum
+	t.nodes = make([]trackerproto.Node, t.numNodes)
+	for i, hostPort := range reply.HostPorts {
+		t.nodes[i] = trackerproto.Node{
+			HostPort: hostPort,
+			NodeID:   i}
+	}
+	return nil
+}
+
+// Waits for the master storageServer to accept a slave's RegisterServer RPC
+// and confirm that all other slaves have joined.
+func (t *trackerServer) slaveAwaitJoin() error {
+	// Connect to the master trackerServer, retrying until we succeed.
+	var conn *rpc.Client
+	for conn == nil {
+		if conn, _ = rpc.DialHTTP("tcp", t.masterServerHostPort); conn == nil {
+			// Sleep, and try again later.
+			time.Sleep(time.Second * time.Duration(REGISTER_PERIOD))
+		}
+	}
+
+	// Make RegisterServer RPCs to the master trackerServer until it confirms
+	// that the ring is complete.
+	args := &trackerproto.RegisterArgs{
 		TrackerInfo: trackerproto.Node{
-			HostPort: net.JoinHostPort("localhost", strconv.Itoa(t.port)),
-			NodeID:   t.nodeID}}
+			HostPort: t.hostPort,
+			NodeID:   t.nodeID},
+		Key: t.clusterKey}
 	reply := &trackerproto.RegisterReply{}
 
 	for {
@@ -445,6 +1384,12 @@ func (t *trackerServer) slaveAwaitJoin() error {
 		if reply.Status == trackerproto.OK {
 			// The ring is ready.
 			break
+		} else if reply.Status == trackerproto.DuplicateID {
+			// Another node has registered with our nodeID.
+			return errors.New("Another tracker node has registered with node ID " + strconv.Itoa(t.nodeID))
+		} else if reply.Status == trackerproto.NotAuthorized {
+			// The master has a different cluster key.
+			return errors.New("The master tracker node did not accept this node's cluster key")
 		}
 
 		// Wait for a set period before trying again.
@@ -457,12 +1402,94 @@ func (t *trackerServer) slaveAwaitJoin() error {
 	return nil
 }
 
+// Asks the node at masterServerHostPort to add this node to its running
+// cluster, and waits for the addition to commit.
+// The reply gives this node its NodeID, and lists the cluster's nodes.
+func (t *trackerServer) joiningAwaitJoin() error {
+	// Connect to the cluster's node, retrying until we succeed.
+	var conn *rpc.Client
+	for conn == nil {
+		if conn, _ = rpc.DialHTTP("tcp", t.masterServerHostPort); conn == nil {
+			// Sleep, and try again later.
+			time.Sleep(time.Second * time.Duration(REGISTER_PERIOD))
+		}
+	}
+	defer conn.Close()
+
+	args := &trackerproto.AddNodeArgs{
+		HostPort: t.hostPort,
+		Key:      t.clusterKey}
+	reply := &trackerproto.AddNodeReply{}
+	if callErr := conn.Call("PaxosTracker.AddNode", args, reply); callErr != nil {
+		return callErr
+	} else if reply.Status == trackerproto.NotAuthorized {
+		return errors.New("The cluster did not accept this node's cluster key")
+	} else if reply.Status != trackerproto.OK {
+		return errors.New("The cluster could not add this node")
+	}
+
+	// Record which nodes are in the cluster, and which one we are.
+	t.nodeID = reply.NodeID
+	t.myN = reply.NodeID
+	t.nodes = make([]trackerproto.Node, len(reply.Trackers))
+	copy(t.nodes, reply.Trackers)
+	t.numNodes = len(t.nodes)
+	t.nextNodeID = reply.NextNodeID
+	t.membersSeq = reply.SeqNum
+	// Start above the proposal numbers the cluster has used, so that our
+	// first proposal is not turned away
+	t.promise(reply.PaxNum)
+	return nil
+}
+
 func (t *trackerServer) eventHandler() {
+	// An observer polls the cluster for ops it has not seen
+	var observe <-chan time.Time
+	if t.observer {
+		ticker := time.NewTicker(time.Millisecond * OBSERVE_PERIOD)
+		defer ticker.Stop()
+		observe = ticker.C
+	}
+	// A node of a cluster whose peers expire sweeps them out twice per TTL,
+	// so that a peer lasts at most half as long again as the TTL
+	var expire <-chan time.Time
+	if t.peerTTL > 0 && !t.observer {
+		ticker := time.NewTicker(t.peerTTL / 2)
+		defer ticker.Stop()
+		expire = ticker.C
+	}
+	// A node which limits update rates forgets clients which have gone quiet
+	var forget <-chan time.Time
+	if t.maxUpdateRate > 0 {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		forget = ticker.C
+	}
+
 	for {
 		select {
 		case <-t.dbclose:
 			// Closing (for debugging / testing reasons)
 			return
+		case <-observe:
+			t.follow()
+		case now := <-forget:
+			for client, window := range t.rates {
+				if now.Sub(window.start) >= time.Second {
+					delete(t.rates, client)
+				}
+			}
+		case now := <-expire:
+			// Only propose a sweep if it would remove someone, as each one
+			// takes a Paxos round
+			cutoff := now.Add(-t.peerTTL).UnixNano()
+			if t.hasExpiredPeers(cutoff) {
+				op := trackerproto.Operation{
+					OpType: trackerproto.Expire,
+					Time:   cutoff}
+				// Nobody waits for the sweep, so its reply is dropped
+				t.propose(op, make(chan *trackerproto.UpdateReply, 1))
+			}
 		case <-t.dbstallall:
 			// Stalling (for debugging / testing reasons)
 			// Wait until we receive a signal on t.dbcontinue,
@@ -482,13 +1509,37 @@ func (t *trackerServer) eventHandler() {
 			// t is out of date
 			// Needs to catch up to seqNum
 			t.catchUp(seqNum)
+		case f := <-t.fetched:
+			// The catch-up goroutine has fetched something this node missed.
+			// Commits may have arrived while it was fetching, so only take
+			// what this node still needs.
+			if f.Done {
+				t.catchingUp = false
+				close(t.caughtUp)
+			} else {
+				if f.Snapshot != nil {
+					if f.Snapshot.SeqNum > t.seqNum {
+						t.loadSnapshot(f.Snapshot)
+					}
+				} else if f.SeqNum == t.seqNum {
+					t.logOp(t.seqNum, f.Value)
+					t.commitOp(f.Value)
+				}
+				f.Reply <- &CatchUpState{
+					SeqNum: t.seqNum,
+					Target: t.latestSeq}
+			}
 		case prep := <-t.prepares:
 			// Handle prepare messages
 			reply := &trackerproto.PrepareReply{
 				PaxNum: t.accN,
 				Value:  t.accV,
 				SeqNum: t.seqNum}
-			if prep.Args.SeqNum != t.seqNum {
+			if t.observer {
+				// Observers do not vote
+				reply.Status = trackerproto.Reject
+				prep.Reply <- reply
+			} else if prep.Args.SeqNum != t.seqNum {
 				if prep.Args.SeqNum < t.seqNum {
 					// Other guy is out of date,
 					// Let him know and send the correct value
@@ -503,27 +1554,32 @@ func (t *trackerServer) eventHandler() {
 					// We spawn a goroutine, because we don't want the eventHandler to wait for itself
 					go func() { t.outOfDate <- prep.Args.SeqNum }()
 				}
-			} else if prep.Args.PaxNum < t.highestN {
+			} else if highestN := t.promised(); prep.Args.PaxNum < highestN {
+				// Tell the proposer the number it has to beat
 				reply.Status = trackerproto.Reject
+				reply.PaxNum = highestN
 				prep.Reply <- reply
 			} else {
-				t.highestN = prep.Args.PaxNum
+				t.promise(prep.Args.PaxNum)
 				reply.Status = trackerproto.OK
+				reply.Oldest, reply.OldestAge = t.pendingBatch()
 				prep.Reply <- reply
 			}
 		case acc := <-t.accepts:
 			// Handle accept messages
 			var status trackerproto.Status
-			if acc.Args.SeqNum < t.seqNum {
+			if t.observer {
+				status = trackerproto.Reject
+			} else if acc.Args.SeqNum < t.seqNum {
 				status = trackerproto.OutOfDate
 			} else if acc.Args.SeqNum > t.seqNum {
 				// Spawn a goroutine, lest the eventhandler wait for itself
 				go func() { t.outOfDate <- acc.Args.SeqNum }()
-			} else if acc.Args.PaxNum < t.highestN {
+			} else if acc.Args.PaxNum < t.promised() {
 				status = trackerproto.Reject
 			} else {
 				status = trackerproto.OK
-				t.highestN = acc.Args.PaxNum
+				t.promise(acc.Args.PaxNum)
 				t.accN = acc.Args.PaxNum
 				t.accV = acc.Args.Value
 			}
@@ -531,27 +1587,60 @@ func (t *trackerServer) eventHandler() {
 		case com := <-t.commits:
 			// Handle commit messages
 			v := com.Args.Value
+			if com.Args.Lease > 0 {
+				t.leaseMut.Lock()
+				t.leader = com.Args.Leader
+				t.leaseEnd = time.Now().Add(com.Args.Lease)
+				t.leaseMut.Unlock()
+			}
 			if com.Args.SeqNum == t.seqNum {
 				t.logOp(t.seqNum, v)
 				t.commitOp(v)
 			} else {
 				t.logOp(com.Args.SeqNum, v)
 			}
-			com.Reply <- &trackerproto.CommitReply{}
+			if com.Args.SeqNum >= t.latestSeq {
+				t.latestSeq = com.Args.SeqNum + 1
+			}
+			if com.Args.SeqNum-t.seqNum >= COMMIT_GAP {
+				// This node has missed the commits before this one, and
+				// the next prepare could be a long time coming, so fetch
+				// them now rather than stall. Ops already in the log are
+				// applied as soon as the gap before them is filled.
+				t.catchUp(t.latestSeq)
+			}
+			com.Reply <- &trackerproto.CommitReply{Status: trackerproto.OK}
 		case get := <-t.gets:
 			// Another tracker has requested a previously commited op
+			// The log starts at the latest snapshot
 			s := get.Args.SeqNum
-			if s >= t.seqNum {
-				get.Reply <- &trackerproto.GetReply{Status: trackerproto.OutOfDate}
+			if s < t.snapshot.SeqNum || s >= t.seqNum {
+				get.Reply <- &trackerproto.GetReply{
+					Status: trackerproto.OutOfDate,
+					MinSeq: t.snapshot.SeqNum,
+					MaxSeq: t.seqNum}
 			} else {
 				get.Reply <- &trackerproto.GetReply{
 					Status: trackerproto.OK,
-					Value:  t.log[s]}
+					Value:  t.log[s],
+					MinSeq: t.snapshot.SeqNum,
+					MaxSeq: t.seqNum}
 			}
+		case snap := <-t.snapshots:
+			// Another tracker has fallen behind our log.
+			// Snapshots are never changed once taken, so it is safe to
+			// hand this one out while we carry on
+			snap.Reply <- &trackerproto.SnapshotReply{
+				Status:   trackerproto.OK,
+				Snapshot: *t.snapshot}
 		case rep := <-t.reports:
 			// A client has reported that it does not have a chunk
 			tor, ok := t.torrents[rep.Args.Chunk.ID]
-			if !ok {
+			if !t.authorized(rep.Args.Token) {
+				rep.Reply <- &trackerproto.UpdateReply{Status: trackerproto.NotAuthorized}
+			} else if t.rateLimited(rep.Args.HostPort) {
+				rep.Reply <- &trackerproto.UpdateReply{Status: trackerproto.RateLimited}
+			} else if !ok {
 				// File does not exist
 				rep.Reply <- &trackerproto.UpdateReply{Status: trackerproto.FileNotFound}
 			} else if rep.Args.Chunk.ChunkNum < 0 || rep.Args.Chunk.ChunkNum >= torrent.NumChunks(tor) {
@@ -563,13 +1652,39 @@ func (t *trackerServer) eventHandler() {
 					OpType:     trackerproto.Delete,
 					Chunk:      rep.Args.Chunk,
 					ClientAddr: rep.Args.HostPort}
-				// Spawn a goroutine, because we don't want the eventHandler to wait for anyone
-				go func() { t.pending <- &Pending{Value: op, Reply: rep.Reply} }()
+				t.propose(op, rep.Reply)
+			}
+		case bad := <-t.badChunks:
+			// A client has reported that a peer sent it a bad copy of a chunk
+			tor, ok := t.torrents[bad.Args.Chunk.ID]
+			if !t.authorized(bad.Args.Token) {
+				bad.Reply <- &trackerproto.UpdateReply{Status: trackerproto.NotAuthorized}
+			} else if t.rateLimited(bad.Args.Reporter) {
+				bad.Reply <- &trackerproto.UpdateReply{Status: trackerproto.RateLimited}
+			} else if !ok {
+				// File does not exist
+				bad.Reply <- &trackerproto.UpdateReply{Status: trackerproto.FileNotFound}
+			} else if bad.Args.Chunk.ChunkNum < 0 || bad.Args.Chunk.ChunkNum >= torrent.NumChunks(tor) {
+				// ChunkNum is not right for this file
+				bad.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OutOfRange}
+			} else {
+				// Reports are counted as they commit, so that every node
+				// drops the peer after the same report
+				op := trackerproto.Operation{
+					OpType:     trackerproto.Suspect,
+					Chunk:      bad.Args.Chunk,
+					ClientAddr: bad.Args.HostPort,
+					Reporter:   bad.Args.Reporter}
+				t.propose(op, bad.Reply)
 			}
 		case conf := <-t.confirms:
 			// A client has confirmed that it has a chunk
 			tor, ok := t.torrents[conf.Args.Chunk.ID]
-			if !ok {
+			if !t.authorized(conf.Args.Token) {
+				conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.NotAuthorized}
+			} else if t.rateLimited(conf.Args.HostPort) {
+				conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.RateLimited}
+			} else if !ok {
 				// File does not exist
 				conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.FileNotFound}
 			} else if conf.Args.Chunk.ChunkNum < 0 || conf.Args.Chunk.ChunkNum >= torrent.NumChunks(tor) {
@@ -580,12 +1695,48 @@ func (t *trackerServer) eventHandler() {
 				op := trackerproto.Operation{
 					OpType:     trackerproto.Add,
 					Chunk:      conf.Args.Chunk,
-					ClientAddr: conf.Args.HostPort}
-				// Spawn a goroutine, because the event handler waits for no-man!
-				go func() { t.pending <- &Pending{Value: op, Reply: conf.Reply} }()
+					ClientAddr: conf.Args.HostPort,
+					Complete:   conf.Args.Complete,
+					Time:       time.Now().UnixNano()}
+				t.propose(op, conf.Reply)
+			}
+		case conf := <-t.confirmManys:
+			// A client has confirmed that it has several chunks
+			tor, ok := t.torrents[conf.Args.ID]
+			inRange := true
+			for _, chunkNum := range conf.Args.ChunkNums {
+				inRange = inRange && ok && chunkNum >= 0 && chunkNum < torrent.NumChunks(tor)
+			}
+			if !t.authorized(conf.Args.Token) {
+				conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.NotAuthorized}
+			} else if t.rateLimited(conf.Args.HostPort) {
+				conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.RateLimited}
+			} else if !ok {
+				// File does not exist
+				conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.FileNotFound}
+			} else if !inRange {
+				// Confirm none of the chunks if any is wrong for this file
+				conf.Reply <- &trackerproto.UpdateReply{Status: trackerproto.OutOfRange}
+			} else {
+				// Propose every chunk at once, so that they are batched
+				now := time.Now().UnixNano()
+				replies := make([]chan *trackerproto.UpdateReply, len(conf.Args.ChunkNums))
+				for i, chunkNum := range conf.Args.ChunkNums {
+					op := trackerproto.Operation{
+						OpType:     trackerproto.Add,
+						Chunk:      torrentproto.NewChunkID(conf.Args.ID, chunkNum),
+						ClientAddr: conf.Args.HostPort,
+						Complete:   conf.Args.Complete,
+						Time:       now}
+					replies[i] = make(chan *trackerproto.UpdateReply, 1)
+					t.propose(op, replies[i])
+				}
+				go t.awaitAll(replies, conf.Reply)
 			}
 		case cre := <-t.creates:
-			// First check that all of the suggested nodes are in the cluster
+			// First check that all of the suggested nodes are in the cluster.
+			// Only the set of nodes is checked: their order and weights are
+			// the creator's preferences for clients, and are stored as given.
 			correctTrackers := true
 			for _, tortrack := range cre.Args.Torrent.TrackerNodes {
 				inCluster := false
@@ -608,23 +1759,62 @@ func (t *trackerServer) eventHandler() {
 				correctTrackers = correctTrackers && inCluster
 			}
 
-			if !correctTrackers {
-				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.InvalidTrackers}
-			}
-
 			// A client has requested to create a new file
-			_, ok := t.torrents[cre.Args.Torrent.ID]
-			if !ok {
+			if !t.authorized(cre.Args.Token) {
+				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.NotAuthorized}
+			} else if t.rateLimited(cre.Args.HostPort) {
+				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.RateLimited}
+			} else if !correctTrackers {
+				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.InvalidTrackers}
+			} else if cre.Args.Torrent.ChunkSize <= 0 || cre.Args.Torrent.FileSize < 0 || !torrent.FilesMatch(cre.Args.Torrent) {
+				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.InvalidTorrent}
+			} else if t.tooManyChunks(cre.Args.Torrent) {
+				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.TooManyChunks}
+			} else if !chunksMatch(cre.Args.Torrent) {
+				// Checked after the limit, so that a torrent which is far too
+				// big is told so, rather than that its hashes are missing
+				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.InvalidTorrent}
+			} else if !cre.Import && t.maxTorrents > 0 && t.created[cre.Args.HostPort] >= t.maxTorrents {
+				// This client has created all the torrents it may.
+				// Creates which are still pending are not counted,
+				// so a client may briefly go over the limit.
+				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.TooManyTorrents}
+			} else if _, ok := t.torrents[cre.Args.Torrent.ID]; !ok {
 				// ID not in use,
 				// So make the pending request for this
 				op := trackerproto.Operation{
-					OpType:  trackerproto.Create,
-					Torrent: cre.Args.Torrent}
-				// Spawn a goroutine, because we don't want the eventhandler to block
-				go func() { t.pending <- &Pending{Value: op, Reply: cre.Reply} }()
+					OpType:     trackerproto.Create,
+					ClientAddr: cre.Args.HostPort,
+					Torrent:    cre.Args.Torrent}
+				t.propose(op, cre.Reply)
+			} else {
+				// File already exists, so tell the client that this ID is invalid,
+				// and what the existing torrent holds so it can compare
+				cre.Reply <- &trackerproto.UpdateReply{
+					Status: trackerproto.InvalidID,
+					Digest: torrent.Digest(t.torrents[cre.Args.Torrent.ID])}
+			}
+		case del := <-t.deletes:
+			// A client wants a torrent removed
+			if !t.authorized(del.Args.Token) {
+				del.Reply <- &trackerproto.UpdateReply{Status: trackerproto.NotAuthorized}
+			} else if _, ok := t.torrents[del.Args.ID]; !ok {
+				del.Reply <- &trackerproto.UpdateReply{Status: trackerproto.FileNotFound}
+			} else {
+				op := trackerproto.Operation{
+					OpType:  trackerproto.Remove,
+					Torrent: torrentproto.Torrent{ID: del.Args.ID}}
+				t.propose(op, del.Reply)
+			}
+		case ev := <-t.evicts:
+			// An admin wants a client removed from every chunk
+			if t.adminKey == "" || ev.Args.AdminKey != t.adminKey {
+				ev.Reply <- &trackerproto.UpdateReply{Status: trackerproto.NotAuthorized}
 			} else {
-				// File already exists, so tell the client that this ID is invalid
-				cre.Reply <- &trackerproto.UpdateReply{Status: trackerproto.InvalidID}
+				op := trackerproto.Operation{
+					OpType:     trackerproto.Evict,
+					ClientAddr: ev.Args.HostPort}
+				t.propose(op, ev.Reply)
 			}
 		case req := <-t.requests:
 			// A client has requested a list of users with a certain chunk
@@ -636,16 +1826,145 @@ func (t *trackerServer) eventHandler() {
 				// ChunkNum is not right for this file
 				req.Reply <- &trackerproto.RequestReply{Status: trackerproto.OutOfRange}
 			} else {
-				// Get a list of all peers, then respond
+				// Get a list of all peers, put them in order, and keep as
+				// many as were asked for. Shuffling the whole list first
+				// means that every subset is as likely to be kept.
+				// Then split those that have the whole torrent from those
+				// that have only part of it, and respond
 				peers := make([]string, 0)
 				for k, _ := range t.peers[req.Args.Chunk] {
 					peers = append(peers, k)
 				}
+				arrangePeers(req.Args.Order, peers)
+				if req.Args.MaxPeers > 0 && len(peers) > req.Args.MaxPeers {
+					peers = peers[:req.Args.MaxPeers]
+				}
+				seeders := make([]string, 0)
+				partial := make([]string, 0)
+				for _, k := range peers {
+					if _, ok := t.seeders[req.Args.Chunk.ID][k]; ok {
+						seeders = append(seeders, k)
+					} else {
+						partial = append(partial, k)
+					}
+				}
 				req.Reply <- &trackerproto.RequestReply{
 					Status:    trackerproto.OK,
 					Peers:     peers,
-					ChunkHash: tor.ChunkHashes[req.Args.Chunk.ChunkNum]}
+					Seeders:   seeders,
+					Partial:   partial,
+					ChunkHash: tor.ChunkHashes[req.Args.Chunk.ChunkNum],
+					SeqNum:    t.seqNum}
 			}
+		case q := <-t.peerQueries:
+			// A client wants to know whether one peer has a certain chunk
+			tor, ok := t.torrents[q.Args.Chunk.ID]
+			if !ok {
+				// File does not exist
+				q.Reply <- &trackerproto.HasReply{Status: trackerproto.FileNotFound}
+			} else if q.Args.Chunk.ChunkNum < 0 || q.Args.Chunk.ChunkNum >= torrent.NumChunks(tor) {
+				// ChunkNum is not right for this file
+				q.Reply <- &trackerproto.HasReply{Status: trackerproto.OutOfRange}
+			} else {
+				_, has := t.peers[q.Args.Chunk][q.Args.HostPort]
+				q.Reply <- &trackerproto.HasReply{
+					Status: trackerproto.OK,
+					Has:    has}
+			}
+		case q := <-t.availQueries:
+			// A client wants to know how much of a torrent its peers have
+			tor, ok := t.torrents[q.Args.ID]
+			if !ok {
+				// File does not exist
+				q.Reply <- &trackerproto.AvailabilityReply{Status: trackerproto.FileNotFound}
+			} else {
+				reply := &trackerproto.AvailabilityReply{
+					Status:      trackerproto.OK,
+					TotalChunks: torrent.NumChunks(tor),
+					Replication: make([]int, torrent.NumChunks(tor))}
+				for i, chunkID := range torrent.AllChunkIDs(tor) {
+					owners := len(t.peers[chunkID])
+					reply.Replication[i] = owners
+					if owners > 0 {
+						reply.ChunksWithPeers++
+					}
+					if i == 0 || owners < reply.MinReplication {
+						reply.MinReplication = owners
+					}
+				}
+				q.Reply <- reply
+			}
+		case w := <-t.watches:
+			// A client wants to know when a chunk gains a peer
+			tor, ok := t.torrents[w.Args.Chunk.ID]
+			if !ok {
+				// File does not exist
+				w.Reply <- &trackerproto.WatchReply{Status: trackerproto.FileNotFound}
+			} else if w.Args.Chunk.ChunkNum < 0 || w.Args.Chunk.ChunkNum >= torrent.NumChunks(tor) {
+				// ChunkNum is not right for this file
+				w.Reply <- &trackerproto.WatchReply{Status: trackerproto.OutOfRange}
+			} else if t.numWatchers >= MAX_WATCHERS {
+				w.Reply <- &trackerproto.WatchReply{Status: trackerproto.Busy}
+			} else {
+				// Wait for commitOp to tell the client about a new peer
+				if _, ok := t.watchers[w.Args.Chunk]; !ok {
+					t.watchers[w.Args.Chunk] = make(map[*Watch](struct{}))
+				}
+				t.watchers[w.Args.Chunk][w] = struct{}{}
+				t.numWatchers++
+			}
+		case w := <-t.unwatches:
+			// A client has stopped waiting
+			if _, ok := t.watchers[w.Args.Chunk][w]; ok {
+				delete(t.watchers[w.Args.Chunk], w)
+				t.numWatchers--
+			}
+		case ex := <-t.exports:
+			// An operator wants every torrent, e.g. to copy to another cluster
+			torrents := make([]torrentproto.Torrent, 0, len(t.torrents))
+			for _, tor := range t.torrents {
+				torrents = append(torrents, tor)
+			}
+			sort.Slice(torrents, func(i, j int) bool {
+				return torrents[i].ID.Less(torrents[j].ID)
+			})
+			ex.Reply <- &trackerproto.ExportReply{
+				Status:   trackerproto.OK,
+				Torrents: torrents}
+		case li := <-t.lists:
+			// A client wants to browse the torrents on the tracker
+			summaries := make([]trackerproto.TorrentSummary, 0, len(t.torrents))
+			for _, tor := range t.torrents {
+				summaries = append(summaries, trackerproto.TorrentSummary{
+					ID:        tor.ID,
+					FileSize:  tor.FileSize,
+					ChunkSize: tor.ChunkSize})
+			}
+			sort.Slice(summaries, func(i, j int) bool {
+				return summaries[i].ID.Less(summaries[j].ID)
+			})
+			li.Reply <- &trackerproto.ListReply{
+				Status:   trackerproto.OK,
+				Torrents: summaries}
+		case sq := <-t.statsQueries:
+			// An operator wants to know how far along this node is
+			entries := 0
+			for _, owners := range t.peers {
+				entries += len(owners)
+			}
+			t.pendingMut.Lock()
+			pending := t.pendingOps.Len()
+			t.pendingMut.Unlock()
+			sq.Reply <- &trackerproto.StatsReply{
+				Status:      trackerproto.OK,
+				SeqNum:      t.seqNum,
+				Torrents:    len(t.torrents),
+				PeerEntries: entries,
+				Pending:     pending,
+				CatchingUp:  t.latestSeq > t.seqNum}
+		case p := <-t.pings:
+			// Someone wants to know whether this node is alive
+			p.Reply <- t.pingReply()
 		case gt := <-t.getTrackers:
 			// A client has requested a list of users with a certain chunk
 			hostPorts := make([]string, t.numNodes)
@@ -654,85 +1973,759 @@ func (t *trackerServer) eventHandler() {
 			}
 			gt.Reply <- &trackerproto.TrackersReply{
 				Status:    trackerproto.OK,
-				HostPorts: hostPorts}
+				HostPorts: hostPorts,
+				SeqNum:    t.seqNum}
+		case rm := <-t.removeNodes:
+			// An admin wants a dead node out of the cluster
+			if _, ok := t.member(rm.Args.HostPort); !ok {
+				rm.Reply <- &trackerproto.UpdateReply{Status: trackerproto.UnknownNode}
+			} else if t.numNodes == 1 {
+				rm.Reply <- &trackerproto.UpdateReply{Status: trackerproto.TooFewNodes}
+			} else {
+				op := trackerproto.Operation{
+					OpType:     trackerproto.Leave,
+					ClientAddr: rm.Args.HostPort}
+				t.propose(op, rm.Reply)
+			}
+		case add := <-t.addNodes:
+			// A node wants to join the cluster
+			if node, ok := t.member(add.Args.HostPort); ok {
+				// Its Join has committed (or it was already in the
+				// cluster), so tell it which nodes it has joined
+				nodes := make([]trackerproto.Node, len(t.nodes))
+				copy(nodes, t.nodes)
+				add.Reply <- &trackerproto.AddNodeReply{
+					Status:     trackerproto.OK,
+					NodeID:     node.NodeID,
+					Trackers:   nodes,
+					NextNodeID: t.nextNodeID,
+					SeqNum:     t.seqNum,
+					PaxNum:     t.promised()}
+			} else {
+				op := trackerproto.Operation{
+					OpType:     trackerproto.Join,
+					ClientAddr: add.Args.HostPort}
+				committed := make(chan *trackerproto.UpdateReply, 1)
+				t.propose(op, committed)
+				// Once the Join commits, ask again, to list the nodes as
+				// of the commit
+				go func() {
+					if r := t.awaitUpdate(committed); r.Status != trackerproto.OK {
+						add.Reply <- &trackerproto.AddNodeReply{Status: r.Status}
+						return
+					}
+					select {
+					case t.addNodes <- add:
+					case <-t.dbclose:
+					}
+				}()
+			}
 		}
 	}
 }
 
+// propose gets the operation committed, and replies on reply once it has been.
+// In a multi-node cluster the operation is handed to the paxosHandler.
+// A single node is its own majority, so the operation is committed
+// immediately instead of going through prepare/accept/commit with itself.
+func (t *trackerServer) propose(op trackerproto.Operation, reply chan *trackerproto.UpdateReply) {
+	if t.observer {
+		// Observers only serve reads
+		reply <- &trackerproto.UpdateReply{Status: trackerproto.ReadOnly}
+	} else if t.numNodes == 1 {
+		t.logOp(t.seqNum, op)
+		reply <- t.commitOp(op)
+	} else {
+		// Spawn a goroutine, because we don't want the eventHandler to block
+		go func() {
+			select {
+			case t.pending <- &Pending{Value: op, Reply: reply}:
+			case <-t.dbclose:
+			}
+		}()
+	}
+}
+
+// promised returns the highest proposal number this node has promised or
+// accepted
+func (t *trackerServer) promised() int {
+	t.paxMut.Lock()
+	defer t.paxMut.Unlock()
+	return t.highestN
+}
+
+// promise raises the highest proposal number this node has promised or
+// accepted to n, unless it is already higher
+func (t *trackerServer) promise(n int) {
+	t.paxMut.Lock()
+	defer t.paxMut.Unlock()
+	if n > t.highestN {
+		t.highestN = n
+	}
+}
+
 // Logs the operation at the given seqNum
 func (t *trackerServer) logOp(seqNum int, v trackerproto.Operation) {
 	t.log[seqNum] = v
 }
 
-// t commits the operation to memory, and logs it
-func (t *trackerServer) commitOp(v trackerproto.Operation) {
+// Puts each list of peers in the given order
+func arrangePeers(order trackerproto.PeerOrder, lists ...[]string) {
+	for _, peers := range lists {
+		if order == trackerproto.Sorted {
+			sort.Strings(peers)
+		} else {
+			rand.Shuffle(len(peers), func(i, j int) {
+				peers[i], peers[j] = peers[j], peers[i]
+			})
+		}
+	}
+}
+
+// Returns whether any peer has not confirmed a chunk since cutoff
+func (t *trackerServer) hasExpiredPeers(cutoff int64) bool {
+	for _, owners := range t.peers {
+		for _, confirmed := range owners {
+			if confirmed < cutoff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns whether the torrent has more chunks than this tracker allows, by
+// either its file size and chunk size or its list of chunk hashes.
+// A torrent without a positive chunk size has no bound on its chunks.
+func (t *trackerServer) tooManyChunks(tor torrentproto.Torrent) bool {
+	if tor.ChunkSize <= 0 || len(tor.ChunkHashes) > t.maxChunks {
+		return true
+	}
+	// NumChunks > maxChunks exactly when FileSize > maxChunks * ChunkSize,
+	// which is worked out in 64 bits so that it cannot overflow
+	return int64(tor.FileSize) > int64(t.maxChunks)*int64(tor.ChunkSize)
+}
+
+// Returns whether the torrent has a hash for exactly the chunks its file size
+// and chunk size split it into, numbered from 0, so that every chunk number
+// the tracker accepts for it has a hash.
+func chunksMatch(tor torrentproto.Torrent) bool {
+	numChunks := torrent.NumChunks(tor)
+	if len(tor.ChunkHashes) != numChunks {
+		return false
+	}
+	for chunkNum := 0; chunkNum < numChunks; chunkNum++ {
+		if _, ok := tor.ChunkHashes[chunkNum]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// t commits the operation to memory, along with any operations
+// directly after it which are already in the log
+// Returns the reply to the given operation
+func (t *trackerServer) commitOp(v trackerproto.Operation) *trackerproto.UpdateReply {
+	reply := t.applyOp(v)
+	for {
+
+		// Check if the next thing is in the log already
+		// If it is, then commit it too.
+		next, ok := t.log[t.seqNum]
+		if !ok {
+			return reply
+		}
+		t.applyOp(next)
+	}
+}
+
+// t applies the operation committed at the next seqNum to memory
+// Returns the reply to the operation, which is also sent to any pending
+// operations it answers. A Batch applies each of its operations in turn,
+// answering each one's pending operations, and its own reply is just OK.
+func (t *trackerServer) applyOp(v trackerproto.Operation) *trackerproto.UpdateReply {
 	t.seqNum++
 	t.accN = 0
 	t.accV = trackerproto.Operation{OpType: trackerproto.None}
+	reply := &trackerproto.UpdateReply{Status: trackerproto.OK}
+	if v.OpType != trackerproto.Batch {
+		reply = t.applyChange(v)
+	} else {
+		for _, op := range v.Batch {
+			t.applyChange(op)
+		}
+	}
+	if t.seqNum-t.snapshot.SeqNum >= t.snapshotInterval {
+		t.takeSnapshot()
+	}
+	return reply
+}
+
+// t copies its state into a new snapshot, and drops the ops before it from
+// the log
+func (t *trackerServer) takeSnapshot() {
+	snap := &trackerproto.Snapshot{
+		SeqNum:     t.seqNum,
+		Torrents:   make(map[torrentproto.ID]torrentproto.Torrent),
+		Peers:      make(map[torrentproto.ChunkID](map[string]int64)),
+		Seeders:    make(map[torrentproto.ID][]string),
+		Created:    make(map[string]int),
+		Suspects:   make(map[torrentproto.ChunkID](map[string][]string)),
+		Nodes:      make([]trackerproto.Node, len(t.nodes)),
+		NextNodeID: t.nextNodeID}
+	copy(snap.Nodes, t.nodes)
+	for id, tor := range t.torrents {
+		snap.Torrents[id] = tor
+	}
+	for chunk, owners := range t.peers {
+		snap.Peers[chunk] = make(map[string]int64)
+		for owner, confirmed := range owners {
+			snap.Peers[chunk][owner] = confirmed
+		}
+	}
+	for id, seeders := range t.seeders {
+		for seeder, _ := range seeders {
+			snap.Seeders[id] = append(snap.Seeders[id], seeder)
+		}
+	}
+	for client, n := range t.created {
+		snap.Created[client] = n
+	}
+	for chunk, peers := range t.suspects {
+		snap.Suspects[chunk] = make(map[string][]string)
+		for peer, reporters := range peers {
+			for reporter, _ := range reporters {
+				snap.Suspects[chunk][peer] = append(snap.Suspects[chunk][peer], reporter)
+			}
+		}
+	}
+
+	for seqNum := t.snapshot.SeqNum; seqNum < snap.SeqNum; seqNum++ {
+		delete(t.log, seqNum)
+	}
+	t.snapshot = snap
+}
+
+// t replaces its state with a copy of snap's, which is ahead of it, and
+// skips to the snapshot's seqNum.
+// Pending ops which the skipped ops answered stay pending, and are answered
+// when they are proposed again.
+func (t *trackerServer) loadSnapshot(snap *trackerproto.Snapshot) {
+	t.torrents = make(map[torrentproto.ID]torrentproto.Torrent)
+	t.peers = make(map[torrentproto.ChunkID](map[string]int64))
+	t.seeders = make(map[torrentproto.ID](map[string](struct{})))
+	t.created = make(map[string]int)
+	t.suspects = make(map[torrentproto.ChunkID](map[string](map[string]struct{})))
+	for id, tor := range snap.Torrents {
+		t.torrents[id] = tor
+	}
+	for chunk, owners := range snap.Peers {
+		t.peers[chunk] = make(map[string]int64)
+		for owner, confirmed := range owners {
+			t.peers[chunk][owner] = confirmed
+		}
+	}
+	for id, seeders := range snap.Seeders {
+		t.seeders[id] = make(map[string](struct{}))
+		for _, seeder := range seeders {
+			t.seeders[id][seeder] = struct{}{}
+		}
+	}
+	for client, n := range snap.Created {
+		t.created[client] = n
+	}
+	for chunk, peers := range snap.Suspects {
+		t.suspects[chunk] = make(map[string](map[string]struct{}))
+		for peer, reporters := range peers {
+			t.suspects[chunk][peer] = make(map[string]struct{})
+			for _, reporter := range reporters {
+				t.suspects[chunk][peer][reporter] = struct{}{}
+			}
+		}
+	}
+
+	// Nodes which joined in the skipped ops are in the snapshot's list,
+	// unless we learned of nodes which joined after it some other way
+	if len(snap.Nodes) > 0 && snap.SeqNum >= t.membersSeq {
+		t.setMembers(snap.Nodes, snap.NextNodeID)
+		t.startPaxos()
+	}
+
+	for seqNum, _ := range t.log {
+		if seqNum < snap.SeqNum {
+			delete(t.log, seqNum)
+		}
+	}
+	t.seqNum = snap.SeqNum
+	t.accN = 0
+	t.accV = trackerproto.Operation{OpType: trackerproto.None}
+	t.snapshot = snap
+
+	// Answer anyone waiting on a chunk which gained a peer, or whose torrent
+	// was removed, in the skipped ops
+	for chunk, watchers := range t.watchers {
+		reply := &trackerproto.WatchReply{Status: trackerproto.FileNotFound}
+		if _, ok := t.torrents[chunk.ID]; ok {
+			reply.Status = trackerproto.OK
+			for owner, _ := range t.peers[chunk] {
+				reply.Peer = owner
+				break
+			}
+			if reply.Peer == "" {
+				continue
+			}
+		}
+		for w, _ := range watchers {
+			w.Reply <- reply
+			t.numWatchers--
+		}
+		delete(t.watchers, chunk)
+	}
+}
+
+// t asks the node with the given ID for its latest snapshot, and loads it if
+// it is ahead of t
+func (t *trackerServer) fetchSnapshot(id int) error {
+	snap, err := t.getSnapshot(id)
+	if err != nil {
+		return err
+	}
+	if snap.SeqNum > t.seqNum {
+		t.loadSnapshot(snap)
+	}
+	return nil
+}
+
+// getSnapshot asks the node with the given ID for its latest snapshot
+func (t *trackerServer) getSnapshot(id int) (*trackerproto.Snapshot, error) {
+	reply := &trackerproto.SnapshotReply{}
+	if err := t.call(id, "PaxosTracker.GetSnapshot", &trackerproto.SnapshotArgs{}, reply); err != nil {
+		return nil, err
+	} else if reply.Status != trackerproto.OK {
+		return nil, errors.New("Tracker node could not give a snapshot")
+	}
+	return &reply.Snapshot, nil
+}
+
+// t applies a single operation, which is not a Batch, to memory
+// Returns the reply to the operation, which is also sent to any pending
+// operations it answers
+func (t *trackerServer) applyChange(v trackerproto.Operation) *trackerproto.UpdateReply {
+	reply := &trackerproto.UpdateReply{Status: trackerproto.OK}
 
 	// Now make the change
 	key := v.Chunk
 	m, ok := t.peers[key]
 	if !ok {
-		t.peers[key] = make(map[string](struct{}))
+		t.peers[key] = make(map[string]int64)
 		m = t.peers[key]
 	}
 
 	if v.OpType == trackerproto.Add {
-		m[v.ClientAddr] = struct{}{}
+		m[v.ClientAddr] = v.Time
+		// Tell anyone waiting on this chunk about its new peer
+		for w, _ := range t.watchers[key] {
+			w.Reply <- &trackerproto.WatchReply{
+				Status: trackerproto.OK,
+				Peer:   v.ClientAddr}
+			t.numWatchers--
+		}
+		delete(t.watchers, key)
+		if v.Complete {
+			if _, ok := t.seeders[key.ID]; !ok {
+				t.seeders[key.ID] = make(map[string](struct{}))
+			}
+			t.seeders[key.ID][v.ClientAddr] = struct{}{}
+		}
 	} else if v.OpType == trackerproto.Delete {
 		delete(m, v.ClientAddr)
+		// A client missing a chunk is no longer a complete seeder
+		delete(t.seeders[key.ID], v.ClientAddr)
+		t.clearSuspect(key, v.ClientAddr)
+	} else if v.OpType == trackerproto.Suspect {
+		// Only reports about a current peer count, and a peer cannot report
+		// itself
+		if _, ok := m[v.ClientAddr]; ok && v.Reporter != v.ClientAddr {
+			if _, ok := t.suspects[key]; !ok {
+				t.suspects[key] = make(map[string](map[string]struct{}))
+			}
+			if _, ok := t.suspects[key][v.ClientAddr]; !ok {
+				t.suspects[key][v.ClientAddr] = make(map[string]struct{})
+			}
+			t.suspects[key][v.ClientAddr][v.Reporter] = struct{}{}
+			if len(t.suspects[key][v.ClientAddr]) >= t.badChunkReports {
+				// Enough clients agree, so stop handing the peer out
+				delete(m, v.ClientAddr)
+				delete(t.seeders[key.ID], v.ClientAddr)
+				t.clearSuspect(key, v.ClientAddr)
+				reply.Removed = 1
+			}
+		}
 	} else if v.OpType == trackerproto.Create {
-		t.torrents[v.Torrent.ID] = v.Torrent
+		if existing, ok := t.torrents[v.Torrent.ID]; !ok {
+			t.created[v.ClientAddr]++
+			t.torrents[v.Torrent.ID] = v.Torrent
+		} else {
+			// Another create for this ID was committed first (e.g. two
+			// clients raced to publish it), so keep that torrent
+			reply = &trackerproto.UpdateReply{
+				Status: trackerproto.InvalidID,
+				Digest: torrent.Digest(existing)}
+		}
+	} else if v.OpType == trackerproto.Remove {
+		id := v.Torrent.ID
+		if _, ok := t.torrents[id]; !ok {
+			// Another remove for this ID was committed first
+			reply = &trackerproto.UpdateReply{Status: trackerproto.FileNotFound}
+		} else {
+			delete(t.torrents, id)
+			delete(t.seeders, id)
+			for chunk, _ := range t.peers {
+				if chunk.ID == id {
+					delete(t.peers, chunk)
+					delete(t.suspects, chunk)
+				}
+			}
+			// Nobody will confirm these chunks now
+			for chunk, watchers := range t.watchers {
+				if chunk.ID == id {
+					for w, _ := range watchers {
+						w.Reply <- &trackerproto.WatchReply{Status: trackerproto.FileNotFound}
+						t.numWatchers--
+					}
+					delete(t.watchers, chunk)
+				}
+			}
+		}
+	} else if v.OpType == trackerproto.Expire {
+		// Remove every peer which has not confirmed a chunk since the cutoff
+		for chunk, owners := range t.peers {
+			for owner, confirmed := range owners {
+				if confirmed < v.Time {
+					delete(owners, owner)
+					delete(t.seeders[chunk.ID], owner)
+					t.clearSuspect(chunk, owner)
+					reply.Removed++
+				}
+			}
+		}
+	} else if v.OpType == trackerproto.Join {
+		// Nodes which learned the cluster's nodes after this op already
+		// list the node
+		if _, ok := t.member(v.ClientAddr); !ok && t.seqNum > t.membersSeq {
+			nodes := make([]trackerproto.Node, len(t.nodes), len(t.nodes)+1)
+			copy(nodes, t.nodes)
+			nodes = append(nodes, trackerproto.Node{
+				HostPort: v.ClientAddr,
+				NodeID:   t.nextNodeID})
+			t.setMembers(nodes, t.nextNodeID+1)
+			// A single node runs Paxos once it has another to agree with
+			t.startPaxos()
+		}
+	} else if v.OpType == trackerproto.Leave {
+		// Nodes which learned the cluster's nodes after this op already
+		// leave the node out
+		if node, ok := t.member(v.ClientAddr); !ok {
+			// Another removal of the node was committed first
+			reply.Status = trackerproto.UnknownNode
+		} else if t.numNodes == 1 {
+			// Other removals were committed first, and a cluster needs
+			// a node
+			reply.Status = trackerproto.TooFewNodes
+		} else if t.seqNum > t.membersSeq {
+			nodes := make([]trackerproto.Node, 0, len(t.nodes)-1)
+			for _, n := range t.nodes {
+				if n.HostPort != v.ClientAddr {
+					nodes = append(nodes, n)
+				}
+			}
+			t.setMembers(nodes, t.nextNodeID)
+			if node.NodeID == t.nodeID && !t.observer {
+				// The cluster no longer counts our votes, so stop
+				go t.Shutdown()
+			}
+		}
+	} else if v.OpType == trackerproto.Evict {
+		// Remove the client from every chunk of every torrent
+		for chunk, owners := range t.peers {
+			if _, ok := owners[v.ClientAddr]; ok {
+				delete(owners, v.ClientAddr)
+				t.clearSuspect(chunk, v.ClientAddr)
+				reply.Removed++
+			}
+		}
+		for _, seeders := range t.seeders {
+			delete(seeders, v.ClientAddr)
+		}
 	}
 
-	// Go through the list of ops that we have pending
-	// If this is one of those, then respond
+	// Respond to any ops that we have pending which this one answers
 	t.pendingMut.Lock()
-	for e := t.pendingOps.Front(); e != nil; e = e.Next() {
-		pen := e.Value.(*Pending).Value
-		if pen.OpType == v.OpType && pen.Chunk == v.Chunk && pen.ClientAddr == v.ClientAddr {
-			t.pendingOps.Remove(e)
-			e.Value.(*Pending).Reply <- &trackerproto.UpdateReply{Status: trackerproto.OK}
-		}
+	pkey := keyOf(v)
+	for _, e := range t.pendingIdx[pkey] {
+		t.pendingOps.Remove(e)
+		e.Value.(*Pending).Reply <- reply
 	}
+	delete(t.pendingIdx, pkey)
 	t.pendingMut.Unlock()
+	return reply
+}
 
-	// Check if the next thing is in the log already
-	// If it is, then commit it.
-	if _, ok := t.log[t.seqNum]; ok {
-		t.commitOp(t.log[t.seqNum])
+// t forgets the reports against peer for chunk, once peer is no longer one of
+// chunk's peers, so that it starts afresh if it confirms the chunk again
+func (t *trackerServer) clearSuspect(chunk torrentproto.ChunkID, peer string) {
+	if peers, ok := t.suspects[chunk]; ok {
+		delete(peers, peer)
+		if len(peers) == 0 {
+			delete(t.suspects, chunk)
+		}
 	}
 }
 
-// t contacts other servers in an attempt to catch-up
-// with missed changes
-func (t *trackerServer) catchUp(target int) {
-	current := (t.nodeID + 1) % t.numNodes
-	for t.seqNum < target {
+// member returns the node in the cluster at hostPort, if there is one
+func (t *trackerServer) member(hostPort string) (trackerproto.Node, bool) {
+	for _, node := range t.nodes {
+		if node.HostPort == hostPort {
+			return node, true
+		}
+	}
+	return trackerproto.Node{}, false
+}
+
+// members returns the NodeIDs of the nodes in the cluster, and the NodeID the
+// next node to join will be given, which is higher than any of theirs
+func (t *trackerServer) members() ([]int, int) {
+	t.membersMut.Lock()
+	defer t.membersMut.Unlock()
+	ids := make([]int, len(t.nodes))
+	for i, node := range t.nodes {
+		ids[i] = node.NodeID
+	}
+	return ids, t.nextNodeID
+}
+
+// setMembers makes nodes the cluster's nodes, and nextID the NodeID the next
+// node to join will be given.
+// Connections to nodes which stay in the cluster are kept, and new nodes are
+// dialed in the background, so that the eventHandler does not wait on them.
+func (t *trackerServer) setMembers(nodes []trackerproto.Node, nextID int) {
+	t.membersMut.Lock()
+	defer t.membersMut.Unlock()
+
+	// Nodes are matched by host:port, as an observer numbers them itself
+	conns := make(map[string]*rpc.Client)
+	for _, node := range t.nodes {
+		if conn, ok := t.trackers[node.NodeID]; ok {
+			conns[node.HostPort] = conn
+		}
+	}
+	t.trackers = make(map[int]*rpc.Client)
+	for _, node := range nodes {
+		if conn, ok := conns[node.HostPort]; ok {
+			t.trackers[node.NodeID] = conn
+			delete(conns, node.HostPort)
+		} else {
+			go t.connect(node)
+		}
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	t.nodes = nodes
+	t.numNodes = len(nodes)
+	t.nextNodeID = nextID
+}
+
+// connect dials a node which has joined the cluster, and keeps the connection
+// if the node is still in the cluster once it answers.
+// Until then, the node is treated as not answering.
+func (t *trackerServer) connect(node trackerproto.Node) {
+	conn, err := t.dialNode(node.HostPort)
+	if err != nil {
+		return
+	}
+	t.membersMut.Lock()
+	defer t.membersMut.Unlock()
+	select {
+	case <-t.dbclose:
+		// Shutdown has already closed the other connections
+		conn.Close()
+		return
+	default:
+	}
+	for _, n := range t.nodes {
+		if n == node && t.trackers[n.NodeID] == nil {
+			t.trackers[n.NodeID] = conn
+			return
+		}
+	}
+	conn.Close()
+}
+
+// call makes an RPC to the node with the given NodeID.
+// Returns an error if we have no connection to the node.
+func (t *trackerServer) call(id int, method string, args interface{}, reply interface{}) error {
+	t.membersMut.Lock()
+	conn := t.trackers[id]
+	t.membersMut.Unlock()
+	if conn == nil {
+		return errors.New("Not connected to tracker node " + strconv.Itoa(id))
+	}
+	return conn.Call(method, args, reply)
+}
+
+// startPaxos starts the paxosHandler, once this node is in a cluster which has
+// another node to agree with.
+// A single node commits ops itself, and an observer never proposes.
+func (t *trackerServer) startPaxos() {
+	if !t.paxosStarted && !t.observer && t.numNodes > 1 {
+		t.paxosStarted = true
+		go t.paxosHandler()
+	}
+}
+
+// An observer asks the nodes in the cluster, in turn, for ops committed since
+// its last poll, and commits them.
+// It gives up until the next poll once every node has failed in a row.
+func (t *trackerServer) follow() {
+	for failures := 0; failures < t.numNodes; {
+		// The cluster may have grown since we last moved on
+		t.following %= t.numNodes
+		id := t.nodes[t.following].NodeID
 		args := &trackerproto.GetArgs{SeqNum: t.seqNum}
 		reply := &trackerproto.GetReply{}
-		if err := t.trackers[current].Call("PaxosTracker.GetOp", args, reply); err != nil {
-			// there was an issue, so let's try another server
-			current = (current + 1) % t.numNodes
-			// If we've looped around the entire way and we're not done,
-			// then the given target was probably too ambitious
-			if current == t.nodeID {
-				target = t.seqNum
+		if err := t.call(id, "PaxosTracker.GetOp", args, reply); err != nil {
+			// This node is not answering, so try the next one
+			t.following = (t.following + 1) % t.numNodes
+			failures++
+		} else if reply.Status == trackerproto.OK {
+			t.logOp(t.seqNum, reply.Value)
+			t.commitOp(reply.Value)
+			failures = 0
+		} else if reply.MinSeq > t.seqNum {
+			// This node no longer has the ops we need, so skip to its
+			// snapshot
+			if err := t.fetchSnapshot(id); err != nil {
+				t.following = (t.following + 1) % t.numNodes
+				failures++
 			}
 		} else {
-			if reply.Status == trackerproto.OK {
-				// This increments t.seqNum
-				t.commitOp(reply.Value)
-			} else {
-				// Server didn't have operation, so let's try another server
-				current = (current + 1) % t.numNodes
-				// If we've looped around the entire way and we're not done,
-				// then the given target was probably too ambitious
-				if current == t.nodeID {
-					target = t.seqNum
-				}
+			// We have every op this node has committed
+			return
+		}
+	}
+}
+
+// t starts catching up with the ops committed before target which it has
+// missed, unless it already is.
+// The ops are fetched on another goroutine, and committed by the eventHandler
+// as they arrive, so that t keeps answering RPCs while it catches up.
+// Should only be called by the eventHandler, or before it starts.
+func (t *trackerServer) catchUp(target int) {
+	if target > t.latestSeq {
+		t.latestSeq = target
+	}
+	if t.catchingUp || t.seqNum >= target {
+		// A catch-up which is running reaches for latestSeq
+		return
+	}
+	t.catchingUp = true
+	t.caughtUp = make(chan struct{})
+	go t.fetchMissed(&CatchUpState{
+		SeqNum: t.seqNum,
+		Target: t.latestSeq})
+}
+
+// fetchMissed asks the other nodes in turn, starting with the one after this
+// one, for the ops from state onwards, and hands them to the eventHandler
+// one at a time, so that they are committed in order.
+// It tells the eventHandler when it is done.
+func (t *trackerServer) fetchMissed(state *CatchUpState) {
+	defer func() {
+		select {
+		case t.fetched <- &Fetched{Done: true}:
+		case <-t.dbclose:
+		}
+	}()
+
+	ids, _ := t.members()
+	self := 0
+	for i, id := range ids {
+		if id == t.nodeID {
+			self = i
+		}
+	}
+	// If we've tried every other node and we're not done,
+	// then the target was probably too ambitious
+	for tried := 1; state.SeqNum < state.Target && tried < len(ids); {
+		current := ids[(self+tried)%len(ids)]
+		args := &trackerproto.GetArgs{SeqNum: state.SeqNum}
+		reply := &trackerproto.GetReply{}
+		fetched := &Fetched{Reply: make(chan *CatchUpState)}
+		if err := t.call(current, "PaxosTracker.GetOp", args, reply); err != nil {
+			// there was an issue, so let's try another server
+			tried++
+			continue
+		} else if reply.Status == trackerproto.OK {
+			fetched.SeqNum = state.SeqNum
+			fetched.Value = reply.Value
+		} else if reply.MinSeq > state.SeqNum {
+			// Server no longer has the ops we need, so skip to its
+			// snapshot, or try another server if we cannot
+			snap, err := t.getSnapshot(current)
+			if err != nil {
+				tried++
+				continue
 			}
+			fetched.Snapshot = snap
+		} else {
+			// Server didn't have operation, so let's try another server
+			tried++
+			continue
+		}
+
+		select {
+		case t.fetched <- fetched:
+			state = <-fetched.Reply
+		case <-t.dbclose:
+			return
+		}
+	}
+}
+
+// pingReply describes how far along this node is, for Ping.
+func (t *trackerServer) pingReply() *trackerproto.PingReply {
+	return &trackerproto.PingReply{
+		Status:     trackerproto.OK,
+		SeqNum:     t.seqNum,
+		Time:       time.Now().UnixNano(),
+		CatchingUp: t.latestSeq > t.seqNum}
+}
+
+// leaseHolder returns the node which holds the lease, and how much of the lease
+// is left, or -1 if no node holds it
+func (t *trackerServer) leaseHolder() (int, time.Duration) {
+	t.leaseMut.Lock()
+	defer t.leaseMut.Unlock()
+	left := time.Until(t.leaseEnd)
+	if left <= 0 {
+		return -1, 0
+	}
+	return t.leader, left
+}
+
+// forward sends ops to the leader, for it to propose.
+// The ops stay pending on this node, which answers them when they are
+// committed. If the leader does not take them, failed is told which leader
+// it was.
+func (t *trackerServer) forward(leader int, ops []trackerproto.Operation, failed chan int) {
+	args := &trackerproto.ForwardArgs{Ops: ops, Key: t.clusterKey}
+	reply := &trackerproto.ForwardReply{}
+	if err := t.call(leader, "PaxosTracker.Forward", args, reply); err != nil || reply.Status != trackerproto.OK {
+		select {
+		case failed <- leader:
+		case <-t.dbclose:
 		}
 	}
 }
@@ -743,11 +2736,14 @@ func (t *trackerServer) sendMess(id int, mess *PaxosBroadcast) {
 	if mess.Type == PaxosPrepare {
 		args := &trackerproto.PrepareArgs{
 			PaxNum: reqPaxNum,
-			SeqNum: mess.SeqNum}
+			SeqNum: mess.SeqNum,
+			Key:    t.clusterKey}
 		reply := &trackerproto.PrepareReply{}
-		if err := t.trackers[id].Call("PaxosTracker.Prepare", args, reply); err != nil {
+		if err := t.call(id, "PaxosTracker.Prepare", args, reply); err != nil {
 			// Error: Tell the paxosHandler that we were "rejected"
-			mess.Reply <- &PaxosReply{Status: trackerproto.Reject}
+			mess.Reply <- &PaxosReply{
+				Status:    trackerproto.Reject,
+				ReqPaxNum: reqPaxNum}
 		} else {
 			// Pass the data back to the PaxosHandler
 			mess.Reply <- &PaxosReply{
@@ -755,15 +2751,18 @@ func (t *trackerServer) sendMess(id int, mess *PaxosBroadcast) {
 				ReqPaxNum: reqPaxNum,
 				PaxNum:    reply.PaxNum,
 				Value:     reply.Value,
-				SeqNum:    reply.SeqNum}
+				SeqNum:    reply.SeqNum,
+				Oldest:    reply.Oldest,
+				OldestAge: reply.OldestAge}
 		}
 	} else if mess.Type == PaxosAccept {
 		args := &trackerproto.AcceptArgs{
 			PaxNum: reqPaxNum,
 			SeqNum: mess.SeqNum,
-			Value:  mess.Value}
+			Value:  mess.Value,
+			Key:    t.clusterKey}
 		reply := &trackerproto.AcceptReply{}
-		if err := t.trackers[id].Call("PaxosTracker.Accept", args, reply); err != nil {
+		if err := t.call(id, "PaxosTracker.Accept", args, reply); err != nil {
 			// Error: Tell the paxosHandler that we were "rejected"
 			mess.Reply <- &PaxosReply{Status: trackerproto.Reject}
 		} else {
@@ -775,9 +2774,12 @@ func (t *trackerServer) sendMess(id int, mess *PaxosBroadcast) {
 	} else if mess.Type == PaxosCommit {
 		args := &trackerproto.CommitArgs{
 			SeqNum: mess.SeqNum,
-			Value:  mess.Value}
+			Value:  mess.Value,
+			Leader: t.nodeID,
+			Lease:  time.Millisecond * LEASE_PERIOD,
+			Key:    t.clusterKey}
 		reply := &trackerproto.CommitReply{}
-		t.trackers[id].Call("PaxosTracker.Commit", args, reply)
+		t.call(id, "PaxosTracker.Commit", args, reply)
 
 		// This tells the paxosHandler when this tracker has commited the result
 		if id == t.nodeID {
@@ -790,7 +2792,15 @@ func (t *trackerServer) sendMess(id int, mess *PaxosBroadcast) {
 // This is the function that broadcasts paxos messages and collects replies
 // Most of the paxos-leader logic takes place here
 func (t *trackerServer) paxosHandler() {
-	initPaxos := make(chan struct{}, t.numNodes)
+	// The nodes in the cluster, and the NodeID the next node to join will be
+	// given, as of the current round's seqNum. The cluster only changes as
+	// ops commit, so every node proposing for a seqNum agrees on these, and
+	// counts its majority over the same nodes.
+	ids, span := t.members()
+
+	// Receives true if the round is being restarted because it timed out,
+	// and false if a new round is starting
+	initPaxos := make(chan bool, len(ids))
 
 	// reply channels
 	prepareReply := make(chan *PaxosReply)
@@ -806,9 +2816,70 @@ func (t *trackerServer) paxosHandler() {
 	accN := 0
 	accV := trackerproto.Operation{OpType: trackerproto.None}
 
+	// The oldest pending operation reported by the nodes which have
+	// promised this round, in case we get to choose the value
+	oldest := trackerproto.Operation{OpType: trackerproto.None}
+	var oldestAge time.Duration
+
 	backoff := 2
 	oks := 0
 	var T *time.Timer
+
+	// Fires when the lease of the node we forwarded ops to is up, and tells
+	// us when a leader would not take our ops
+	var leaseCheck <-chan time.Time
+	forwardFailed := make(chan int)
+
+	// When the current round started, for statistics
+	var roundStart time.Time
+
+	// The number of nodes which have answered this round's prepare, and
+	// fires when we should stop waiting for the rest
+	replies := 0
+	var grace <-chan time.Time
+
+	// startAccept ends the prepare phase, once a majority has promised,
+	// and starts the accept phase if there is a value to propose
+	startAccept := func() {
+		T.Stop() // Stop the timer that would tell us to restart Paxos
+		if accV.OpType == trackerproto.None {
+			// If no node had accepted a value, we get to choose.
+			// Propose the ops of the node whose oldest op has waited
+			// longest, whether it is ours or another node's, so that
+			// no node's ops starve while this node keeps winning
+			// rounds.
+			accV = oldest
+			if op, age := t.pendingBatch(); op.OpType != trackerproto.None && age >= oldestAge {
+				accV = op
+			}
+		}
+
+		if accV.OpType != trackerproto.None {
+			// Prepare variables for next phase of paxos
+			oks = 0
+			prepPhase = false
+			accPhase = true
+
+			// Reset timer
+			wait := time.Second * time.Duration(backoff)
+			T = time.AfterFunc(wait, func() { initPaxos <- true })
+
+			// Broadcast accept message
+			for _, id := range ids {
+				mess := &PaxosBroadcast{
+					MyN:    t.myN,
+					Type:   PaxosAccept,
+					Reply:  acceptReply,
+					SeqNum: t.seqNum,
+					Value:  accV}
+				go t.sendMess(id, mess)
+			}
+		} else {
+			prepPhase = false
+			inPaxos = false
+		}
+	}
+
 	for {
 		select {
 		case <-t.dbclose:
@@ -819,11 +2890,25 @@ func (t *trackerServer) paxosHandler() {
 			// Wait until we receive a signal on t.dbcontinue,
 			// then keep going
 			<-t.dbcontinue
-		case <-initPaxos:
+		case restart := <-initPaxos:
+			t.statsMut.Lock()
+			if restart {
+				t.restarts++
+			} else {
+				roundStart = time.Now()
+			}
+			t.statsMut.Unlock()
+
 			// Initialize values
 			inPaxos = true
 			accV = trackerproto.Operation{OpType: trackerproto.None}
-			t.myN = (t.highestN - (t.highestN % t.numNodes)) + (t.numNodes + t.nodeID)
+			oldest = trackerproto.Operation{OpType: trackerproto.None}
+			oldestAge = 0
+			replies = 0
+			grace = nil
+			ids, span = t.members()
+			highestN := t.promised()
+			t.myN = (highestN - (highestN % span)) + (span + t.nodeID)
 			oks = 0
 			prepPhase = true
 			accPhase = false
@@ -831,10 +2916,10 @@ func (t *trackerServer) paxosHandler() {
 			// Set a timer to tell us when to restart the paxos round
 			backoff = 2 * (backoff + t.nodeID)
 			wait := time.Second * time.Duration(backoff)
-			T = time.AfterFunc(wait, func() { initPaxos <- struct{}{} })
+			T = time.AfterFunc(wait, func() { initPaxos <- true })
 
 			// Broadcast the prepare message
-			for id := 0; id < t.numNodes; id++ {
+			for _, id := range ids {
 				mess := &PaxosBroadcast{
 					MyN:    t.myN,
 					Type:   PaxosPrepare,
@@ -844,15 +2929,73 @@ func (t *trackerServer) paxosHandler() {
 			}
 		case op := <-t.pending:
 			t.pendingMut.Lock()
-			t.pendingOps.PushBack(op)
+			op.Enqueued = time.Now()
+			key := keyOf(op.Value)
+			t.pendingIdx[key] = append(t.pendingIdx[key], t.pendingOps.PushBack(op))
 			t.pendingMut.Unlock()
-			if !inPaxos {
+			if inPaxos {
+				break
+			}
+			// Ops which were forwarded here are ours to propose, so that
+			// nodes which disagree about the leader do not pass them back
+			// and forth
+			if leader, left := t.leaseHolder(); !op.Forwarded && leader != -1 && leader != t.nodeID {
+				// Another node is leading, so let it propose the op, and
+				// check that it has once its lease is up
+				t.statsMut.Lock()
+				t.forwarded++
+				t.statsMut.Unlock()
+				go t.forward(leader, []trackerproto.Operation{op.Value}, forwardFailed)
+				if leaseCheck == nil {
+					leaseCheck = time.After(left)
+				}
+			} else {
 				// We don't want to worry about the paxosHandler waiting for itself
-				go func() { initPaxos <- struct{}{} }()
+				go func() { initPaxos <- false }()
+			}
+		case <-leaseCheck:
+			leaseCheck = nil
+			op, _ := t.pendingBatch()
+			if inPaxos || op.OpType == trackerproto.None {
+				// Our ops have all been committed
+				break
+			}
+			if leader, left := t.leaseHolder(); leader != -1 && leader != t.nodeID {
+				// The leader is still committing, but not our ops, so make
+				// sure it has them
+				ops := []trackerproto.Operation{op}
+				if op.OpType == trackerproto.Batch {
+					ops = op.Batch
+				}
+				go t.forward(leader, ops, forwardFailed)
+				leaseCheck = time.After(left)
+			} else {
+				// The lease ran out without a commit, so lead ourselves
+				go func() { initPaxos <- false }()
+			}
+		case leader := <-forwardFailed:
+			// Stop deferring to a leader which would not take our ops
+			t.leaseMut.Lock()
+			if t.leader == leader {
+				t.leaseEnd = time.Time{}
+			}
+			t.leaseMut.Unlock()
+			t.pendingMut.Lock()
+			waiting := t.pendingOps.Len() > 0
+			t.pendingMut.Unlock()
+			if waiting && !inPaxos {
+				go func() { initPaxos <- false }()
 			}
 		case prep := <-prepareReply:
+			if prep.Status == trackerproto.Reject {
+				// Another node may have promised a higher proposal number,
+				// so make sure our next proposal beats it
+				t.promise(prep.PaxNum)
+			}
+
 			// First check that this is a response to the current PaxosMessage
 			if prep.ReqPaxNum == t.myN && prepPhase {
+				replies++
 				if prep.Status == trackerproto.OK {
 					oks++
 					if prep.Value.OpType != trackerproto.None {
@@ -861,6 +3004,10 @@ func (t *trackerServer) paxosHandler() {
 							accV = prep.Value
 						}
 					}
+					if prep.Oldest.OpType != trackerproto.None && prep.OldestAge > oldestAge {
+						oldest = prep.Oldest
+						oldestAge = prep.OldestAge
+					}
 				} else if prep.Status == trackerproto.OutOfDate {
 					// We spawn a goroutine for this,
 					// because we don't want the paxosHandler to block
@@ -868,44 +3015,23 @@ func (t *trackerServer) paxosHandler() {
 					go func() { t.outOfDate <- prep.SeqNum }()
 				}
 
-				if oks > (t.numNodes / 2) {
-					T.Stop() // Stop the timer that would tell us to restart Paxos
-					if accV.OpType == trackerproto.None {
-						// If no node had accepted a value,
-						// check that there's something in our pending list
-						t.pendingMut.Lock()
-						if t.pendingOps.Len() > 0 {
-							e := t.pendingOps.Front()
-							accV = e.Value.(*Pending).Value
-						}
-						t.pendingMut.Unlock()
-					}
-
-					if accV.OpType != trackerproto.None {
-						// Prepare variables for next phase of paxos
-						oks = 0
-						prepPhase = false
-						accPhase = true
-
-						// Reset timer
-						wait := time.Second * time.Duration(backoff)
-						T = time.AfterFunc(wait, func() { initPaxos <- struct{}{} })
-
-						// Broadcast accept message
-						for id := 0; id < t.numNodes; id++ {
-							mess := &PaxosBroadcast{
-								MyN:    t.myN,
-								Type:   PaxosAccept,
-								Reply:  acceptReply,
-								SeqNum: t.seqNum,
-								Value:  accV}
-							go t.sendMess(id, mess)
-						}
-					} else {
-						inPaxos = false
+				if oks > (len(ids) / 2) {
+					if replies == len(ids) {
+						// Every node has answered
+						grace = nil
+						startAccept()
+					} else if grace == nil {
+						// Give the other nodes a moment to answer
+						grace = time.After(time.Millisecond * PREPARE_GRACE)
 					}
 				}
 			}
+		case <-grace:
+			// Stop waiting for the rest of the promises
+			grace = nil
+			if prepPhase {
+				startAccept()
+			}
 		case acc := <-acceptReply:
 			// Received the reply to an accept message
 			if acc.ReqPaxNum == t.myN && accPhase {
@@ -913,14 +3039,14 @@ func (t *trackerServer) paxosHandler() {
 					oks++
 				}
 
-				if oks > (t.numNodes / 2) {
+				if oks > (len(ids) / 2) {
 					T.Stop() // Stop the timer
 					accPhase = false
 					backoff = 2
 					comReply = make(chan *PaxosReply)
 
 					// Broadcast the commit message
-					for id := 0; id < t.numNodes; id++ {
+					for _, id := range ids {
 						mess := &PaxosBroadcast{
 							MyN:    t.myN,
 							Type:   PaxosCommit,
@@ -935,9 +3061,20 @@ func (t *trackerServer) paxosHandler() {
 			// This line says:
 			//  "wait until this tracker has committed before continuing"
 			if com.Status == trackerproto.OK {
+				elapsed := time.Since(roundStart)
+				t.statsMut.Lock()
+				t.rounds++
+				t.roundTime += elapsed
+				if elapsed > t.maxRoundTime {
+					t.maxRoundTime = elapsed
+				}
+				t.statsMut.Unlock()
+
+				// Keep going while we, or the nodes which answered our
+				// prepare, have ops waiting to be proposed
 				t.pendingMut.Lock()
-				if t.pendingOps.Len() > 0 {
-					initPaxos <- struct{}{}
+				if t.pendingOps.Len() > 0 || oldest.OpType != trackerproto.None {
+					initPaxos <- false
 				} else {
 					accV = trackerproto.Operation{OpType: trackerproto.None}
 					inPaxos = false
@@ -948,12 +3085,102 @@ func (t *trackerServer) paxosHandler() {
 	}
 }
 
+// pendingBatch returns the operations in pendingOps which have waited longest,
+// up to MAX_BATCH of them, as one operation to propose, and how long the
+// oldest has waited.
+// A lone operation is returned as it is, and several are wrapped in a Batch.
+// Only the first of several operations which the same commit would answer
+// (see keyOf) is included.
+// If nothing is pending, the operation's OpType is None.
+func (t *trackerServer) pendingBatch() (trackerproto.Operation, time.Duration) {
+	t.pendingMut.Lock()
+	defer t.pendingMut.Unlock()
+	if t.pendingOps.Len() == 0 {
+		return trackerproto.Operation{OpType: trackerproto.None}, 0
+	}
+	front := t.pendingOps.Front().Value.(*Pending)
+	age := time.Since(front.Enqueued)
+	if t.pendingOps.Len() == 1 {
+		return front.Value, age
+	}
+
+	batch := make([]trackerproto.Operation, 0)
+	seen := make(map[pendingKey]struct{})
+	for e := t.pendingOps.Front(); e != nil && len(batch) < MAX_BATCH; e = e.Next() {
+		op := e.Value.(*Pending).Value
+		if _, ok := seen[keyOf(op)]; !ok {
+			seen[keyOf(op)] = struct{}{}
+			batch = append(batch, op)
+		}
+	}
+	if len(batch) == 1 {
+		return batch[0], age
+	}
+	return trackerproto.Operation{OpType: trackerproto.Batch, Batch: batch}, age
+}
+
+// Shutdown stops the tracker, and closes its connections.
+// It is safe to call more than once.
+func (t *trackerServer) Shutdown() {
+	t.closeOnce.Do(func() {
+		// Take no new updates
+		t.closeMut.Lock()
+		t.closing = true
+		t.closeMut.Unlock()
+
+		// Stop the eventHandler and paxosHandler.
+		// Any round in progress is abandoned: nodes which accepted its
+		// value offer it again in the next round's promises, so whichever
+		// node proposes next commits it first.
+		// Updates still waiting on this node are answered with ServerClosing.
+		close(t.dbclose)
+
+		// Let those answers reach their callers before cutting them off
+		t.inFlight.Wait()
+		time.Sleep(time.Millisecond * SHUTDOWN_GRACE)
+
+		// Cut off clients and other nodes, and free the port
+		t.ln.Close()
+		t.membersMut.Lock()
+		for _, conn := range t.trackers {
+			conn.Close()
+		}
+		t.membersMut.Unlock()
+	})
+}
+
+// Close stops the tracker once the updates it is handling have committed.
+// New updates are answered with ServerClosing meanwhile.
+// Returns an error if some were still unfinished after CLOSE_GRACE.
+func (t *trackerServer) Close() error {
+	t.closeMut.Lock()
+	t.closing = true
+	t.closeMut.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		t.inFlight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-t.dbclose:
+		// Already shut down
+	case <-time.After(time.Millisecond * CLOSE_GRACE):
+		err = errors.New("Tracker closed with updates still waiting to commit")
+	}
+	t.Shutdown()
+	return err
+}
+
 // DebugClose is used only in debugging.
 // Lets you tell the tracker to stop doing things for stall-many seconds
 // If stall <= 0, then it just shuts down.
 func (t *trackerServer) DebugStall(stall int) {
 	if stall <= 0 {
-		close(t.dbclose)
+		t.Shutdown()
 	} else {
 		t.dbstall <- stall
 	}
@@ -1,27 +1,50 @@
 package trackerproto
 
-import "torrent/torrentproto"
+import (
+	"time"
+
+	"torrent/torrentproto"
+)
 
 type Status int
 
 const (
-	OK        Status = iota + 1 // RPC was a success
-	Reject                      // Reject a prepare/accept request
-	OutOfDate                   // Message was for committed slot
-	NotReady                    // Trackers are still getting ready
-	FileNotFound                // FileID does not exist
-	OutOfRange                  // Chunk Number out of range for file
-	InvalidID                   // ID is not valid
-	InvalidTrackers             // List of trackers was invalid (for torrent creation)
+	OK              Status = iota + 1 // RPC was a success
+	Reject                            // Reject a prepare/accept request
+	OutOfDate                         // Message was for committed slot
+	NotReady                          // Trackers are still getting ready
+	FileNotFound                      // FileID does not exist
+	OutOfRange                        // Chunk Number out of range for file
+	InvalidID                         // ID is not valid
+	InvalidTrackers                   // List of trackers was invalid (for torrent creation)
+	Timeout                           // Nothing happened before the request expired
+	Busy                              // Tracker has too many requests of this kind in progress
+	DuplicateID                       // Another tracker node has registered with this NodeID
+	TooManyTorrents                   // Client has created as many torrents as the tracker allows
+	ReadOnly                          // Tracker is an observer, which does not accept updates
+	ServerClosing                     // Tracker shut down before it could answer
+	NotAuthorized                     // Admin RPC without the tracker's admin key, update without its client token, or Paxos RPC without its cluster key
+	TooManyChunks                     // Torrent has more chunks than the tracker allows
+	RateLimited                       // Client has sent more updates in the last second than the tracker allows
+	UnknownNode                       // Tracker node is not in the cluster
+	TooFewNodes                       // Removing the tracker node would leave too few nodes answering to form a majority
+	InvalidTorrent                    // Torrent's chunk size, file size, files and chunk hashes do not agree
 )
 
 type OperationType int
 
 const (
-	None   OperationType = iota
+	None OperationType = iota
 	Add
 	Delete
 	Create
+	Evict
+	Remove  // Removes a torrent, unlike Delete, which removes a chunk's peer
+	Expire  // Removes every peer which has not confirmed a chunk since Time
+	Batch   // Applies each op in Batch, in order, in one Paxos instance
+	Suspect // Records that Reporter got a chunk with a bad hash from ClientAddr
+	Join    // Adds the tracker node at ClientAddr to the cluster
+	Leave   // Removes the tracker node at ClientAddr from the cluster
 )
 
 type Operation struct {
@@ -29,6 +52,13 @@ type Operation struct {
 	Chunk      torrentproto.ChunkID // Torrent ID and chunk number
 	ClientAddr string               // The host:port of the client in question
 	Torrent    torrentproto.Torrent // The torrent information (if you're trying to create a torrent)
+	Complete   bool                 // Whether the client has every chunk of the torrent (for Add)
+	// For Add, when the client confirmed the chunk; for Expire, the oldest
+	// confirmation kept (Unix nanoseconds, by the clock of the node which
+	// proposed the op)
+	Time     int64
+	Batch    []Operation // For Batch, the ops to apply (none of which is a Batch)
+	Reporter string      // For Suspect, the host:port of the client which reported ClientAddr
 }
 
 type Node struct {
@@ -38,6 +68,7 @@ type Node struct {
 
 type RegisterArgs struct {
 	TrackerInfo Node
+	Key         string // Must match the tracker's cluster key
 }
 
 type RegisterReply struct {
@@ -45,6 +76,25 @@ type RegisterReply struct {
 	Trackers []Node
 }
 
+type AddNodeArgs struct {
+	HostPort string // The host:port of the tracker node joining the cluster
+	Key      string // Must match the tracker's cluster key
+}
+
+type AddNodeReply struct {
+	Status
+	NodeID     int    // The joining node's NodeID
+	Trackers   []Node // Every node in the cluster, including the joining one
+	NextNodeID int    // The NodeID the next node to join will be given
+	SeqNum     int    // The number of operations committed when Trackers was listed
+	PaxNum     int    // The highest proposal number the answering node has seen
+}
+
+type RemoveNodeArgs struct {
+	HostPort string // The host:port of the tracker node to remove
+	AdminKey string // Must match the tracker's admin key
+}
+
 type GetArgs struct {
 	SeqNum int
 }
@@ -52,24 +102,30 @@ type GetArgs struct {
 type GetReply struct {
 	Status
 	Value  Operation
+	MinSeq int // The first SeqNum this tracker has in its log
+	MaxSeq int // One past the last SeqNum this tracker has committed
 }
 
 type PrepareArgs struct {
 	PaxNum int
 	SeqNum int
+	Key    string // Must match the tracker's cluster key
 }
 
 type PrepareReply struct {
 	Status
-	PaxNum int
-	Value  Operation
-	SeqNum int
+	PaxNum    int
+	Value     Operation
+	SeqNum    int
+	Oldest    Operation     // The oldest operation waiting to be proposed on the replying node
+	OldestAge time.Duration // How long Oldest has been waiting
 }
 
 type AcceptArgs struct {
 	PaxNum int
 	SeqNum int
 	Value  Operation
+	Key    string // Must match the tracker's cluster key
 }
 
 type AcceptReply struct {
@@ -79,38 +135,218 @@ type AcceptReply struct {
 type CommitArgs struct {
 	SeqNum int
 	Value  Operation
+	Leader int           // The node which led the round
+	Lease  time.Duration // How long Leader holds the lease for, from when the commit arrives; 0 for no lease
+	Key    string        // Must match the tracker's cluster key
 }
 
 type CommitReply struct {
+	Status
+}
+
+// Snapshot is a node's state once it has committed every op before SeqNum
+type Snapshot struct {
+	SeqNum     int
+	Torrents   map[torrentproto.ID]torrentproto.Torrent
+	Peers      map[torrentproto.ChunkID](map[string]int64)    // Maps chunk -> peer -> when it last confirmed the chunk
+	Seeders    map[torrentproto.ID][]string                   // Maps torrent -> peers with every chunk
+	Created    map[string]int                                 // Maps client -> number of torrents it created
+	Suspects   map[torrentproto.ChunkID](map[string][]string) // Maps chunk -> peer -> clients which reported it sent a bad copy
+	Nodes      []Node                                         // The nodes in the cluster
+	NextNodeID int                                            // The NodeID the next node to join will be given
+}
+
+type SnapshotArgs struct {
 	// Intentionally Blank
 }
 
+type SnapshotReply struct {
+	Status
+	Snapshot Snapshot
+}
+
+type ForwardArgs struct {
+	Ops []Operation // Operations for the lease holder to propose
+	Key string      // Must match the tracker's cluster key
+}
+
+type ForwardReply struct {
+	Status
+}
+
 type ReportArgs struct {
 	Chunk    torrentproto.ChunkID // Torrent ID and chunk number
 	HostPort string               // host:port of the client
+	Token    string               // Must match the tracker's client token
+}
+
+type BadChunkArgs struct {
+	Chunk    torrentproto.ChunkID // Torrent ID and chunk number
+	HostPort string               // host:port of the peer which sent the chunk
+	Reporter string               // host:port of the client reporting it
+	Token    string               // Must match the tracker's client token
+}
+
+type DeleteArgs struct {
+	ID    torrentproto.ID // ID of the torrent to remove
+	Token string          // Must match the tracker's client token
+}
+
+type EvictArgs struct {
+	HostPort string // host:port of the client to evict
+	AdminKey string // Must match the tracker's admin key
 }
 
 type ConfirmArgs struct {
 	Chunk    torrentproto.ChunkID // Torrent ID and chunk number
 	HostPort string               // host:port of the client
+	Complete bool                 // Whether the client has every chunk of the torrent
+	Token    string               // Must match the tracker's client token
+}
+
+type ConfirmChunksArgs struct {
+	ID        torrentproto.ID // ID of the torrent
+	ChunkNums []int           // The chunks the client has
+	HostPort  string          // host:port of the client
+	Complete  bool            // Whether the client has every chunk of the torrent
+	Token     string          // Must match the tracker's client token
 }
 
+// The order in which RequestChunk lists peers.
+type PeerOrder int
+
+const (
+	Shuffled PeerOrder = iota // A new random order on each call, to spread load
+	Sorted                    // Sorted by host:port, the same on each call
+)
+
 type RequestArgs struct {
-	Chunk torrentproto.ChunkID // Torrent ID and chunk number
+	Chunk    torrentproto.ChunkID // Torrent ID and chunk number
+	Order    PeerOrder            // How to order the peers in the reply
+	MaxPeers int                  // The most peers to list, or 0 (or less) for every peer
 }
 
 type RequestReply struct {
 	Status
-	Peers  []string // A list of host:port of peers with chunk
-	ChunkHash string // The definitive hash for this chunk
+	Peers     []string // A list of host:port of peers with chunk
+	Seeders   []string // The peers in Peers which have every chunk of the torrent
+	Partial   []string // The peers in Peers which have only some chunks of the torrent
+	ChunkHash string   // The definitive hash for this chunk
+	SeqNum    int      // The number of operations the answering node has committed
+}
+
+type HasArgs struct {
+	Chunk    torrentproto.ChunkID // Torrent ID and chunk number
+	HostPort string               // host:port of the peer in question
+}
+
+type HasReply struct {
+	Status
+	Has bool // Whether the peer has the chunk
+}
+
+type AvailabilityArgs struct {
+	ID torrentproto.ID // The torrent in question
+}
+
+type AvailabilityReply struct {
+	Status
+	ChunksWithPeers int   // The number of chunks which at least one peer has
+	TotalChunks     int   // The number of chunks in the torrent
+	MinReplication  int   // The fewest peers any chunk has; 0 if some chunk has none
+	Replication     []int // The number of peers of each chunk, by chunk number
+}
+
+type WatchArgs struct {
+	Chunk torrentproto.ChunkID // Torrent ID and chunk number
+}
+
+type WatchReply struct {
+	Status
+	Peer string // host:port of the peer which confirmed the chunk
+}
+
+type ExportArgs struct {
+	// Intentionally Blank
+}
+
+type ExportReply struct {
+	Status
+	Torrents []torrentproto.Torrent // Every torrent, sorted by ID
+}
+
+type ImportArgs struct {
+	Torrents []torrentproto.Torrent
+	Token    string // Must match the tracker's client token
+}
+
+type ImportReply struct {
+	Status
+	Created  []torrentproto.ID // Torrents which were created
+	Existing []torrentproto.ID // Torrents whose ID was already in use
+	Rejected []torrentproto.ID // Torrents whose trackers are not this cluster
 }
 
 type CreateArgs struct {
-	Torrent torrentproto.Torrent
+	Torrent  torrentproto.Torrent
+	HostPort string // host:port of the client creating the torrent (may be empty)
+	Token    string // Must match the tracker's client token
 }
 
 type UpdateReply struct {
 	Status
+	// For CreateEntry with status InvalidID: the torrent.Digest of the
+	// torrent already registered with the ID
+	Digest string
+	// For EvictPeer: the number of chunks the client was removed from. For an
+	// expiry: the number of peers removed from chunks. For ReportBadChunk: 1
+	// if the report dropped the peer from the chunk, else 0
+	Removed int
+}
+
+type PingArgs struct {
+	// Intentionally Blank
+}
+
+type PingReply struct {
+	Status
+	SeqNum     int   // The number of operations this node has committed
+	Time       int64 // When this node answered, in Unix nanoseconds by its own clock
+	CatchingUp bool  // Whether this node knows the cluster has committed operations which it has not
+}
+
+type StatsArgs struct {
+	// Intentionally Blank
+}
+
+type StatsReply struct {
+	Status
+	Rounds        int           // Paxos rounds this node has led to a commit
+	Restarts      int           // Paxos rounds this node restarted after timing out
+	MeanRoundTime time.Duration // Mean time from starting a round to committing it
+	MaxRoundTime  time.Duration // Longest time from starting a round to committing it
+	Forwarded     int           // Operations this node forwarded to the lease holder, rather than propose
+	SeqNum        int           // The number of operations this node has committed
+	Torrents      int           // The number of torrents registered
+	PeerEntries   int           // The number of peers listed, summed over every chunk
+	Pending       int           // Operations waiting on this node to be committed
+	CatchingUp    bool          // Whether this node knows the cluster has committed operations which it has not
+}
+
+type ListArgs struct {
+	// Intentionally Blank
+}
+
+// What ListTorrents tells about a torrent, without its chunk hashes
+type TorrentSummary struct {
+	ID        torrentproto.ID
+	FileSize  int
+	ChunkSize int
+}
+
+type ListReply struct {
+	Status
+	Torrents []TorrentSummary // Every torrent, sorted by ID
 }
 
 type TrackersArgs struct {
@@ -120,4 +356,5 @@ type TrackersArgs struct {
 type TrackersReply struct {
 	Status    Status
 	HostPorts []string
+	SeqNum    int // The number of operations committed when HostPorts was listed
 }
@@ -0,0 +1,15 @@
+#!/bin/bash
+
+# Exit if GOPATH is not set.
+if [ -z $GOPATH ]; then
+    echo "FAIL: GOPATH environment variable is not set"
+    exit 1
+fi
+
+if [ -z $GOBIN]; then
+    export GOBIN=$GOPATH/bin
+fi
+
+go install tests/clienttest/clienttest.go
+
+$GOBIN/clienttest
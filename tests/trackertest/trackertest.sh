@@ -10,7 +10,6 @@ if [ -z $GOBIN]; then
     export GOBIN=$GOPATH/bin
 fi
 
-go install runners/trackerrunner/tracker_runner.go
 go install tests/trackertest/trackertest.go
 
 $GOBIN/trackertest